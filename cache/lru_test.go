@@ -0,0 +1,31 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord/cache"
+)
+
+func TestLRU(t *testing.T) {
+	c := cache.NewLRU(2)
+	require.NoError(t, c.Set("a", []byte("1"), time.Minute))
+	require.NoError(t, c.Set("b", []byte("2"), time.Minute))
+	_, exists, err := c.Get("a") // a 最近使用，淘汰 b
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.NoError(t, c.Set("c", []byte("3"), time.Minute))
+	_, exists, _ = c.Get("b")
+	require.False(t, exists)
+	value, exists, _ := c.Get("a")
+	require.True(t, exists)
+	require.Equal(t, "1", string(value))
+
+	require.NoError(t, c.Set("d", []byte("4"), -time.Second)) // 已过期
+	_, exists, _ = c.Get("d")
+	require.False(t, exists)
+
+	require.NoError(t, c.Delete("a", "c"))
+	require.Equal(t, 0, c.Len())
+}
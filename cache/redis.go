@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+	"github.com/suifengpiao14/paymentrecord/repository"
+)
+
+// Redis 多实例共享的缓存，支持单机、集群客户端
+type Redis struct {
+	client  redis.Cmdable
+	timeout time.Duration
+}
+
+var _ repository.Cache = (*Redis)(nil)
+
+// RedisTimeout 单次缓存操作超时，超时按未命中处理，避免 redis 故障拖慢支付流程
+var RedisTimeout = 200 * time.Millisecond
+
+func NewRedis(client redis.Cmdable) *Redis {
+	return &Redis{client: client, timeout: RedisTimeout}
+}
+
+func (c *Redis) Get(key string) (value []byte, exists bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	value, err = c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *Redis) Set(key string, value []byte, ttl time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+// Delete 逐个删除，集群模式下 key 可能分布在不同槽位，不能合并为一条 DEL
+func (c *Redis) Delete(keys ...string) (err error) {
+	if len(keys) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	_, err = c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
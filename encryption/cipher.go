@@ -0,0 +1,181 @@
+// Package encryption 敏感字段信封加密：每个值使用随机数据密钥 AES-GCM 加密，数据密钥由 KeyProvider 的主密钥加密后与密文一起存储
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KeyProvider 主密钥提供者，主密钥只用于加解密数据密钥，可对接 KMS
+type KeyProvider interface {
+	// CurrentKeyId 当前用于加密的主密钥，轮换后旧主密钥仍需可用于解密
+	CurrentKeyId() (keyId string, err error)
+	WrapKey(keyId string, dataKey []byte) (wrappedKey []byte, err error)
+	UnwrapKey(keyId string, wrappedKey []byte) (dataKey []byte, err error)
+	// BlindIndexKey 盲索引 HMAC 密钥，更换后需执行密钥轮换任务重建盲索引
+	BlindIndexKey() (key []byte, err error)
+}
+
+// Prefix 密文前缀，格式 enc:v1:<主密钥ID>:<加密的数据密钥>:<nonce+密文>，不带前缀的值视为明文(加密前的存量数据)
+const Prefix = "enc:v1:"
+
+const dataKeySize = 32
+
+var encoding = base64.RawURLEncoding
+
+type Cipher struct {
+	provider KeyProvider
+}
+
+func NewCipher(provider KeyProvider) *Cipher {
+	return &Cipher{provider: provider}
+}
+
+// Encrypt 加密，空值不加密，保持字段默认值语义
+func (c *Cipher) Encrypt(plaintext string) (value string, err error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	keyId, err := c.provider.CurrentKeyId()
+	if err != nil {
+		return "", err
+	}
+	if keyId == "" || strings.Contains(keyId, ":") {
+		err = errors.Errorf("主密钥ID不能为空且不能包含冒号:%s", keyId)
+		return "", err
+	}
+	dataKey := make([]byte, dataKeySize)
+	_, err = rand.Read(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrappedKey, err := c.provider.WrapKey(keyId, dataKey)
+	if err != nil {
+		return "", err
+	}
+	value = Prefix + keyId + ":" + encoding.EncodeToString(wrappedKey) + ":" + encoding.EncodeToString(sealed)
+	return value, nil
+}
+
+// Decrypt 解密，明文(不带前缀)原样返回
+func (c *Cipher) Decrypt(value string) (plaintext string, err error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+	if len(parts) != 3 {
+		err = errors.New("密文格式错误")
+		return "", err
+	}
+	wrappedKey, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.WithMessage(err, "密文格式错误")
+	}
+	sealed, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.WithMessage(err, "密文格式错误")
+	}
+	dataKey, err := c.provider.UnwrapKey(parts[0], wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	b, err := open(dataKey, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// NeedRotate 值是否需要重新加密：非空明文或非当前主密钥加密的密文
+func (c *Cipher) NeedRotate(value string) (ok bool, err error) {
+	if value == "" {
+		return false, nil
+	}
+	keyId, encrypted := KeyId(value)
+	if !encrypted {
+		return true, nil
+	}
+	currentKeyId, err := c.provider.CurrentKeyId()
+	if err != nil {
+		return false, err
+	}
+	return keyId != currentKeyId, nil
+}
+
+// BlindIndex 盲索引，明文的 HMAC-SHA256，用于密文字段的等值查询，空值为空
+func (c *Cipher) BlindIndex(plaintext string) (index string, err error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	key, err := c.provider.BlindIndexKey()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(plaintext))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// KeyId 密文使用的主密钥ID
+func KeyId(value string) (keyId string, encrypted bool) {
+	if !IsEncrypted(value) {
+		return "", false
+	}
+	keyId, _, _ = strings.Cut(strings.TrimPrefix(value, Prefix), ":")
+	return keyId, true
+}
+
+// seal AES-GCM 加密，返回 nonce+密文
+func seal(key []byte, plaintext []byte) (sealed []byte, err error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, sealed []byte) (plaintext []byte, err error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		err = errors.New("密文格式错误")
+		return nil, err
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err = aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "解密失败")
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (aead cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
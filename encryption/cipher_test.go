@@ -0,0 +1,71 @@
+package encryption_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord/encryption"
+)
+
+func newFileKeyProvider(t *testing.T, keyId string) (keys encryption.FileKeys, provider *encryption.FileKeyProvider) {
+	keys, err := encryption.GenerateFileKeys(keyId)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, keys.Save(path))
+	provider, err = encryption.NewFileKeyProvider(path)
+	require.NoError(t, err)
+	return keys, provider
+}
+
+func TestCipher(t *testing.T) {
+	keys, provider := newFileKeyProvider(t, "k1")
+	cipher := encryption.NewCipher(provider)
+
+	value, err := cipher.Encrypt("6222021234567890")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(value, encryption.Prefix+"k1:"))
+	another, err := cipher.Encrypt("6222021234567890")
+	require.NoError(t, err)
+	require.NotEqual(t, value, another) // 每次使用随机数据密钥
+	plaintext, err := cipher.Decrypt(value)
+	require.NoError(t, err)
+	require.Equal(t, "6222021234567890", plaintext)
+
+	empty, err := cipher.Encrypt("")
+	require.NoError(t, err)
+	require.Empty(t, empty)
+	legacy, err := cipher.Decrypt("张三") // 存量明文
+	require.NoError(t, err)
+	require.Equal(t, "张三", legacy)
+	_, err = cipher.Decrypt(value[:len(value)-2] + "xx")
+	require.Error(t, err)
+
+	index, err := cipher.BlindIndex("6222021234567890")
+	require.NoError(t, err)
+	anotherIndex, err := cipher.BlindIndex("6222021234567890")
+	require.NoError(t, err)
+	require.Equal(t, index, anotherIndex)
+	require.Len(t, index, 64)
+
+	// 轮换主密钥后，旧密文仍可解密且需要重新加密
+	require.NoError(t, keys.Rotate("k2"))
+	rotatedProvider, err := encryption.NewFileKeyProviderWithKeys(keys)
+	require.NoError(t, err)
+	rotated := encryption.NewCipher(rotatedProvider)
+	plaintext, err = rotated.Decrypt(value)
+	require.NoError(t, err)
+	require.Equal(t, "6222021234567890", plaintext)
+	needRotate, err := rotated.NeedRotate(value)
+	require.NoError(t, err)
+	require.True(t, needRotate)
+	value, err = rotated.Encrypt(plaintext)
+	require.NoError(t, err)
+	keyId, encrypted := encryption.KeyId(value)
+	require.True(t, encrypted)
+	require.Equal(t, "k2", keyId)
+	needRotate, err = rotated.NeedRotate(value)
+	require.NoError(t, err)
+	require.False(t, needRotate)
+}
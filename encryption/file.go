@@ -0,0 +1,136 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// FileKeys 本地文件存储的主密钥，用于测试及无 KMS 的部署，文件需限制访问权限
+type FileKeys struct {
+	Current       string            `json:"current"`
+	Keys          map[string][]byte `json:"keys"` // 主密钥ID => AES-256 密钥
+	BlindIndexKey []byte            `json:"blindIndexKey"`
+}
+
+// GenerateFileKeys 生成主密钥及盲索引密钥
+func GenerateFileKeys(keyId string) (keys FileKeys, err error) {
+	keys.BlindIndexKey, err = randomKey()
+	if err != nil {
+		return keys, err
+	}
+	err = keys.Rotate(keyId)
+	if err != nil {
+		return keys, err
+	}
+	return keys, nil
+}
+
+// Rotate 生成新主密钥并设为当前密钥，旧主密钥保留用于解密，存量数据通过密钥轮换任务重新加密后才可删除
+func (keys *FileKeys) Rotate(keyId string) (err error) {
+	if _, ok := keys.Keys[keyId]; ok {
+		err = errors.Errorf("主密钥已存在:%s", keyId)
+		return err
+	}
+	key, err := randomKey()
+	if err != nil {
+		return err
+	}
+	if keys.Keys == nil {
+		keys.Keys = make(map[string][]byte)
+	}
+	keys.Keys[keyId] = key
+	keys.Current = keyId
+	return nil
+}
+
+func (keys FileKeys) Save(path string) (err error) {
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+func LoadFileKeys(path string) (keys FileKeys, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return keys, err
+	}
+	err = json.Unmarshal(b, &keys)
+	if err != nil {
+		return keys, errors.WithMessagef(err, "密钥文件格式错误:%s", path)
+	}
+	return keys, nil
+}
+
+type FileKeyProvider struct {
+	keys FileKeys
+}
+
+var _ KeyProvider = (*FileKeyProvider)(nil)
+
+// NewFileKeyProvider 从密钥文件加载主密钥，密钥轮换后需重新加载
+func NewFileKeyProvider(path string) (provider *FileKeyProvider, err error) {
+	keys, err := LoadFileKeys(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileKeyProviderWithKeys(keys)
+}
+
+func NewFileKeyProviderWithKeys(keys FileKeys) (provider *FileKeyProvider, err error) {
+	if _, ok := keys.Keys[keys.Current]; !ok {
+		err = errors.Errorf("当前主密钥不存在:%s", keys.Current)
+		return nil, err
+	}
+	if len(keys.BlindIndexKey) == 0 {
+		err = errors.New("盲索引密钥不能为空")
+		return nil, err
+	}
+	return &FileKeyProvider{keys: keys}, nil
+}
+
+func (p *FileKeyProvider) CurrentKeyId() (keyId string, err error) {
+	return p.keys.Current, nil
+}
+
+func (p *FileKeyProvider) WrapKey(keyId string, dataKey []byte) (wrappedKey []byte, err error) {
+	key, err := p.key(keyId)
+	if err != nil {
+		return nil, err
+	}
+	return seal(key, dataKey)
+}
+
+func (p *FileKeyProvider) UnwrapKey(keyId string, wrappedKey []byte) (dataKey []byte, err error) {
+	key, err := p.key(keyId)
+	if err != nil {
+		return nil, err
+	}
+	return open(key, wrappedKey)
+}
+
+func (p *FileKeyProvider) BlindIndexKey() (key []byte, err error) {
+	return p.keys.BlindIndexKey, nil
+}
+
+func (p *FileKeyProvider) key(keyId string) (key []byte, err error) {
+	key, ok := p.keys.Keys[keyId]
+	if !ok {
+		err = errors.Errorf("主密钥不存在:%s", keyId)
+		return nil, err
+	}
+	return key, nil
+}
+
+func randomKey() (key []byte, err error) {
+	key = make([]byte, dataKeySize)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
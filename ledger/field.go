@@ -0,0 +1,114 @@
+package ledger
+
+import (
+	"github.com/suifengpiao14/commonlanguage"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+func NewId(id int) *sqlbuilder.Field {
+	return commonlanguage.NewId(id).SetMaximum(sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_autoIncrement)
+}
+
+func NewAccountCode(accountCode string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(accountCode, "accountCode", "科目编码", 64)
+}
+
+func NewAccountName(accountName string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(accountName, "accountName", "科目名称", 64)
+}
+
+const (
+	AccountType_asset     = "asset"     // 资产类，余额在借方
+	AccountType_liability = "liability" // 负债类，余额在贷方
+	AccountType_income    = "income"    // 收入类，余额在贷方
+	AccountType_expense   = "expense"   // 费用类，余额在借方
+)
+
+func NewAccountType(accountType string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(accountType, "accountType", "科目类型", 0).AppendEnum(
+		sqlbuilder.Enum{
+			Key:   AccountType_asset,
+			Title: "资产",
+		},
+		sqlbuilder.Enum{
+			Key:   AccountType_liability,
+			Title: "负债",
+		},
+		sqlbuilder.Enum{
+			Key:   AccountType_income,
+			Title: "收入",
+		},
+		sqlbuilder.Enum{
+			Key:   AccountType_expense,
+			Title: "费用",
+		},
+	)
+}
+
+func NewEntryId(entryId string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(entryId, "entryId", "凭证号", 128)
+}
+
+const (
+	BizType_pay    = "pay"    // 支付
+	BizType_refund = "refund" // 退款
+)
+
+func NewBizType(bizType string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(bizType, "bizType", "业务类型", 32).AppendEnum(
+		sqlbuilder.Enum{
+			Key:   BizType_pay,
+			Title: "支付",
+		},
+		sqlbuilder.Enum{
+			Key:   BizType_refund,
+			Title: "退款",
+		},
+	)
+}
+
+func NewBizId(bizId string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(bizId, "bizId", "业务单号(如支付流水号)", 64)
+}
+
+// NewMerchantId 商户(租户)ID，未启用多商户时为空
+func NewMerchantId(merchantId string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(merchantId, "merchantId", "商户ID", 64)
+}
+
+func NewOrderId(orderId string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(orderId, "orderId", "订单号", 64)
+}
+
+const (
+	Direction_debit  = "debit"  // 借
+	Direction_credit = "credit" // 贷
+)
+
+func NewDirection(direction string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(direction, "direction", "借贷方向", 0).AppendEnum(
+		sqlbuilder.Enum{
+			Key:   Direction_debit,
+			Title: "借",
+		},
+		sqlbuilder.Enum{
+			Key:   Direction_credit,
+			Title: "贷",
+		},
+	)
+}
+
+func NewAmount(amount int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(amount, "amount", "金额，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewRemark(remark string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(remark, "remark", "摘要", 0)
+}
+
+func NewPostedAt(postedAt string) *sqlbuilder.Field {
+	f := commonlanguage.NewTime(postedAt).SetName("postedAt").SetTitle("记账时间")
+	return f
+}
+
+var NewCreatedAt = commonlanguage.NewCreatedAt
@@ -0,0 +1,480 @@
+package ledger
+
+import (
+	"context"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+/*
+复式记账：每笔资金变动生成一张凭证(ledger_entry)，凭证下包含至少两条分录(ledger_posting)，借方合计必须等于贷方合计，凭证和分录只增不改。
+科目表各商户共用，凭证和分录按商户隔离
+*/
+
+var table_ledger_account = sqlbuilder.NewTableConfig("ledger_account").AddColumns(
+	sqlbuilder.NewColumn("Fid", sqlbuilder.GetField(NewId)),
+	sqlbuilder.NewColumn("Faccount_code", sqlbuilder.GetField(NewAccountCode)),
+	sqlbuilder.NewColumn("Faccount_name", sqlbuilder.GetField(NewAccountName)),
+	sqlbuilder.NewColumn("Faccount_type", sqlbuilder.GetField(NewAccountType)),
+	sqlbuilder.NewColumn("Fcreated_at", sqlbuilder.GetField(NewCreatedAt)),
+).AddIndexs(
+	sqlbuilder.Index{
+		IsPrimary: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))}
+		},
+	},
+	sqlbuilder.Index{
+		Unique: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewAccountCode))}
+		},
+	},
+).WithComment("会计科目表")
+
+var table_ledger_entry = sqlbuilder.NewTableConfig("ledger_entry").AddColumns(
+	sqlbuilder.NewColumn("Fid", sqlbuilder.GetField(NewId)),
+	sqlbuilder.NewColumn("Fentry_id", sqlbuilder.GetField(NewEntryId)),
+	sqlbuilder.NewColumn("Fmerchant_id", sqlbuilder.GetField(NewMerchantId)),
+	sqlbuilder.NewColumn("Fbiz_type", sqlbuilder.GetField(NewBizType)),
+	sqlbuilder.NewColumn("Fbiz_id", sqlbuilder.GetField(NewBizId)),
+	sqlbuilder.NewColumn("Forder_id", sqlbuilder.GetField(NewOrderId)),
+	sqlbuilder.NewColumn("Fremark", sqlbuilder.GetField(NewRemark)),
+	sqlbuilder.NewColumn("Fposted_at", sqlbuilder.GetField(NewPostedAt)),
+).AddIndexs(
+	sqlbuilder.Index{
+		IsPrimary: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))}
+		},
+	},
+	sqlbuilder.Index{
+		Unique: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewEntryId)),
+			}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewBizId))}
+		},
+	},
+).WithComment("记账凭证表")
+
+var table_ledger_posting = sqlbuilder.NewTableConfig("ledger_posting").AddColumns(
+	sqlbuilder.NewColumn("Fid", sqlbuilder.GetField(NewId)),
+	sqlbuilder.NewColumn("Fentry_id", sqlbuilder.GetField(NewEntryId)),
+	sqlbuilder.NewColumn("Fmerchant_id", sqlbuilder.GetField(NewMerchantId)),
+	sqlbuilder.NewColumn("Faccount_code", sqlbuilder.GetField(NewAccountCode)),
+	sqlbuilder.NewColumn("Forder_id", sqlbuilder.GetField(NewOrderId)),
+	sqlbuilder.NewColumn("Fdirection", sqlbuilder.GetField(NewDirection)),
+	sqlbuilder.NewColumn("Famount", sqlbuilder.GetField(NewAmount)),
+	sqlbuilder.NewColumn("Fposted_at", sqlbuilder.GetField(NewPostedAt)),
+).AddIndexs(
+	sqlbuilder.Index{
+		IsPrimary: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewEntryId))}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewAccountCode)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPostedAt)),
+			}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId)),
+			}
+		},
+	},
+).WithComment("记账分录表")
+
+type AccountModel struct {
+	Id          int64  `gorm:"column:Fid" json:"id"`
+	AccountCode string `gorm:"column:Faccount_code" json:"accountCode"`
+	AccountName string `gorm:"column:Faccount_name" json:"accountName"`
+	AccountType string `gorm:"column:Faccount_type" json:"accountType"`
+	CreatedAt   string `gorm:"column:Fcreated_at" json:"createdAt"`
+}
+
+// IsDebitNormal 借方余额科目(资产、费用)余额=借-贷，其余科目余额=贷-借
+func (m AccountModel) IsDebitNormal() bool {
+	return m.AccountType == AccountType_asset || m.AccountType == AccountType_expense
+}
+
+type EntryModel struct {
+	Id         int64  `gorm:"column:Fid" json:"id"`
+	EntryId    string `gorm:"column:Fentry_id" json:"entryId"`
+	MerchantId string `gorm:"column:Fmerchant_id" json:"merchantId"`
+	BizType    string `gorm:"column:Fbiz_type" json:"bizType"`
+	BizId      string `gorm:"column:Fbiz_id" json:"bizId"`
+	OrderId    string `gorm:"column:Forder_id" json:"orderId"`
+	Remark     string `gorm:"column:Fremark" json:"remark"`
+	PostedAt   string `gorm:"column:Fposted_at" json:"postedAt"`
+}
+
+type PostingModel struct {
+	Id          int64  `gorm:"column:Fid" json:"id"`
+	EntryId     string `gorm:"column:Fentry_id" json:"entryId"`
+	MerchantId  string `gorm:"column:Fmerchant_id" json:"merchantId"`
+	AccountCode string `gorm:"column:Faccount_code" json:"accountCode"`
+	OrderId     string `gorm:"column:Forder_id" json:"orderId"`
+	Direction   string `gorm:"column:Fdirection" json:"direction"`
+	Amount      int    `gorm:"column:Famount" json:"amount"`
+	PostedAt    string `gorm:"column:Fposted_at" json:"postedAt"`
+}
+
+type PostingModels []PostingModel
+
+func (ms PostingModels) DebitAmount() int {
+	total := 0
+	for _, m := range ms {
+		if m.Direction == Direction_debit {
+			total += m.Amount
+		}
+	}
+	return total
+}
+
+func (ms PostingModels) CreditAmount() int {
+	total := 0
+	for _, m := range ms {
+		if m.Direction == Direction_credit {
+			total += m.Amount
+		}
+	}
+	return total
+}
+
+func (ms PostingModels) FilterByAccountCode(accountCode string) (filtered PostingModels) {
+	for _, m := range ms {
+		if m.AccountCode == accountCode {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+type AccountIn struct {
+	AccountCode string `json:"accountCode"`
+	AccountName string `json:"accountName"`
+	AccountType string `json:"accountType"`
+}
+
+func (in AccountIn) Fields() sqlbuilder.Fields {
+	return sqlbuilder.Fields{
+		NewAccountCode(in.AccountCode).SetRequired(true).AppendWhereFn(sqlbuilder.ValueFnForward),
+		NewAccountName(in.AccountName),
+		NewAccountType(in.AccountType).SetRequired(true),
+		NewCreatedAt(time.Now().Format(time.DateTime)),
+	}
+}
+
+type Posting struct {
+	AccountCode string `json:"accountCode"`
+	Direction   string `json:"direction"`
+	Amount      int    `json:"amount"`
+}
+
+type Postings []Posting
+
+// Debit 借记
+func Debit(accountCode string, amount int) Posting {
+	return Posting{AccountCode: accountCode, Direction: Direction_debit, Amount: amount}
+}
+
+// Credit 贷记
+func Credit(accountCode string, amount int) Posting {
+	return Posting{AccountCode: accountCode, Direction: Direction_credit, Amount: amount}
+}
+
+// validate 校验借贷平衡
+func (ps Postings) validate() (err error) {
+	if len(ps) < 2 {
+		err = errors.New("凭证至少需要两条分录")
+		return err
+	}
+	debit, credit := 0, 0
+	for _, p := range ps {
+		if p.AccountCode == "" {
+			err = errors.New("分录科目编码不能为空")
+			return err
+		}
+		if p.Amount <= 0 {
+			err = errors.Errorf("分录金额必须大于0,科目-%s,金额-%d", p.AccountCode, p.Amount)
+			return err
+		}
+		switch p.Direction {
+		case Direction_debit:
+			debit += p.Amount
+		case Direction_credit:
+			credit += p.Amount
+		default:
+			err = errors.Errorf("分录借贷方向有误,科目-%s,方向-%s", p.AccountCode, p.Direction)
+			return err
+		}
+	}
+	if debit != credit {
+		err = errors.Errorf("借贷不平衡,借方合计-%d,贷方合计-%d", debit, credit)
+		return err
+	}
+	return nil
+}
+
+type EntryIn struct {
+	EntryId  string   `json:"entryId"` // 凭证号，同一凭证号重复记账时幂等处理
+	BizType  string   `json:"bizType"`
+	BizId    string   `json:"bizId"`
+	OrderId  string   `json:"orderId"`
+	Remark   string   `json:"remark"`
+	Postings Postings `json:"postings"`
+}
+
+type Ledger struct {
+	accountRepository sqlbuilder.Repository
+	entryRepository   sqlbuilder.Repository
+	postingRepository sqlbuilder.Repository
+	merchantId        string
+}
+
+func NewLedger(handler sqlbuilder.Handler) (ledger Ledger) {
+	ledger = Ledger{
+		accountRepository: sqlbuilder.NewRepository(table_ledger_account.WithHandler(handler)),
+		entryRepository:   sqlbuilder.NewRepository(table_ledger_entry.WithHandler(handler)),
+		postingRepository: sqlbuilder.NewRepository(table_ledger_posting.WithHandler(handler)),
+	}
+	return ledger
+}
+
+// Tables 账本表定义，数据库迁移据此生成建表语句
+func Tables() sqlbuilder.TableConfigs {
+	return sqlbuilder.TableConfigs{table_ledger_account, table_ledger_entry, table_ledger_posting}
+}
+
+func (l Ledger) WithTxHandler(txHandler sqlbuilder.Handler) Ledger {
+	l.accountRepository = l.accountRepository.WithTxHandler(txHandler)
+	l.entryRepository = l.entryRepository.WithTxHandler(txHandler)
+	l.postingRepository = l.postingRepository.WithTxHandler(txHandler)
+	return l
+}
+
+// WithMerchantId 按商户隔离，返回的账本只记账、查询该商户的凭证和分录，默认为空商户
+func (l Ledger) WithMerchantId(merchantId string) Ledger {
+	l.merchantId = merchantId
+	return l
+}
+
+// merchantScope 按商户隔离的查询条件，商户为空时只匹配未指定商户的数据
+func (l Ledger) merchantScope() sqlbuilder.Fields {
+	return sqlbuilder.Fields{
+		NewMerchantId(l.merchantId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	}
+}
+
+// SetAccount 新增或更新科目
+func (l Ledger) SetAccount(ins ...AccountIn) (err error) {
+	for _, in := range ins {
+		_, _, _, err = l.accountRepository.Set(in.Fields())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l Ledger) GetAccountMust(accountCode string) (model AccountModel, err error) {
+	fs := sqlbuilder.Fields{
+		NewAccountCode(accountCode).AppendWhereFn(sqlbuilder.ValueFnForward),
+	}
+	err = l.accountRepository.FirstMustExists(&model, fs)
+	if err != nil {
+		err = errors.WithMessagef(err, "科目-%s", accountCode)
+		return model, err
+	}
+	return model, nil
+}
+
+// Post 记账，借贷必须平衡；凭证号已存在时不重复记账(支持幂等)。需要与业务数据同一事务时，先调用 WithTxHandler
+func (l Ledger) Post(in EntryIn) (err error) {
+	if in.EntryId == "" {
+		err = errors.New("凭证号不能为空")
+		return err
+	}
+	err = in.Postings.validate()
+	if err != nil {
+		err = errors.WithMessagef(err, "凭证号-%s", in.EntryId)
+		return err
+	}
+	exists, err := l.ExistsEntry(in.EntryId)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	for _, p := range in.Postings {
+		_, err = l.GetAccountMust(p.AccountCode)
+		if err != nil {
+			return err
+		}
+	}
+	postedAt := time.Now().Format(time.DateTime)
+	entryFs := sqlbuilder.Fields{
+		NewEntryId(in.EntryId).SetRequired(true),
+		NewMerchantId(l.merchantId),
+		NewBizType(in.BizType).SetRequired(true),
+		NewBizId(in.BizId),
+		NewOrderId(in.OrderId),
+		NewRemark(in.Remark),
+		NewPostedAt(postedAt),
+	}
+	err = l.entryRepository.Insert(entryFs)
+	if err != nil {
+		return err
+	}
+	postingFsList := make([]sqlbuilder.Fields, 0, len(in.Postings))
+	for _, p := range in.Postings {
+		postingFsList = append(postingFsList, sqlbuilder.Fields{
+			NewEntryId(in.EntryId).SetRequired(true),
+			NewMerchantId(l.merchantId),
+			NewAccountCode(p.AccountCode).SetRequired(true),
+			NewOrderId(in.OrderId),
+			NewDirection(p.Direction).SetRequired(true),
+			NewAmount(p.Amount).SetRequired(true),
+			NewPostedAt(postedAt),
+		})
+	}
+	err = l.postingRepository.BatchInsert(postingFsList)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ExistsEntry 凭证号是否已记账
+func (l Ledger) ExistsEntry(entryId string) (exists bool, err error) {
+	fs := l.merchantScope().Add(
+		NewEntryId(entryId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	exists, err = l.entryRepository.Exists(fs)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// accountTotal 科目借、贷方发生额合计
+type accountTotal struct {
+	AccountCode  string `gorm:"column:account_code"`
+	DebitAmount  int    `gorm:"column:debit_amount"`
+	CreditAmount int    `gorm:"column:credit_amount"`
+}
+
+// Balance 获取科目截止某时间点(含)的余额，在数据库中按科目汇总借、贷方发生额，不加载分录
+func (l Ledger) Balance(accountCode string, asOf time.Time) (balance int, err error) {
+	account, err := l.GetAccountMust(accountCode)
+	if err != nil {
+		return 0, err
+	}
+	table := l.postingRepository.GetTable()
+	handler := table.GetHandler()
+	col := func(fieldName string) exp.IdentifierExpression {
+		return goqu.C(table.GetDBNameByFieldNameMust(fieldName))
+	}
+	colAccountCode := col(sqlbuilder.GetFieldName(NewAccountCode))
+	colDirection := col(sqlbuilder.GetFieldName(NewDirection))
+	colAmount := col(sqlbuilder.GetFieldName(NewAmount))
+	sql, _, err := sqlbuilder.Driver(handler.GetDialector()).GoquDialect().From(table.DBName.Name).
+		Select(
+			colAccountCode.As("account_code"),
+			goqu.COALESCE(goqu.SUM(goqu.Case().When(colDirection.Eq(Direction_debit), colAmount).Else(0)), 0).As("debit_amount"),
+			goqu.COALESCE(goqu.SUM(goqu.Case().When(colDirection.Eq(Direction_credit), colAmount).Else(0)), 0).As("credit_amount"),
+		).
+		Where(
+			col(sqlbuilder.GetFieldName(NewMerchantId)).Eq(l.merchantId),
+			colAccountCode.Eq(accountCode),
+			col(sqlbuilder.GetFieldName(NewPostedAt)).Lte(asOf.Format(time.DateTime)),
+		).
+		GroupBy(colAccountCode).
+		ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	var totals []accountTotal
+	err = handler.Query(context.Background(), sql, &totals)
+	if err != nil {
+		return 0, err
+	}
+	for _, total := range totals {
+		if account.IsDebitNormal() {
+			balance += total.DebitAmount - total.CreditAmount
+		} else {
+			balance += total.CreditAmount - total.DebitAmount
+		}
+	}
+	return balance, nil
+}
+
+// OrderBalance 获取科目在某订单上的余额
+func (l Ledger) OrderBalance(accountCode string, orderId string) (balance int, err error) {
+	account, err := l.GetAccountMust(accountCode)
+	if err != nil {
+		return 0, err
+	}
+	postings, err := l.GetPostingsByOrderId(orderId)
+	if err != nil {
+		return 0, err
+	}
+	balance = postings.FilterByAccountCode(accountCode).balance(account)
+	return balance, nil
+}
+
+func (ms PostingModels) balance(account AccountModel) int {
+	if account.IsDebitNormal() {
+		return ms.DebitAmount() - ms.CreditAmount()
+	}
+	return ms.CreditAmount() - ms.DebitAmount()
+}
+
+func (l Ledger) GetPostingsByOrderId(orderId string) (postings PostingModels, err error) {
+	fs := l.merchantScope().Add(
+		NewOrderId(orderId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	err = l.postingRepository.All(&postings, fs)
+	if err != nil {
+		return nil, err
+	}
+	return postings, nil
+}
+
+// CheckOrderBalanced 校验订单下所有分录借贷平衡
+func (l Ledger) CheckOrderBalanced(orderId string) (err error) {
+	postings, err := l.GetPostingsByOrderId(orderId)
+	if err != nil {
+		return err
+	}
+	debit, credit := postings.DebitAmount(), postings.CreditAmount()
+	if debit != credit {
+		err = errors.Errorf("订单分录借贷不平衡,订单ID-%s,借方合计-%d,贷方合计-%d", orderId, debit, credit)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package ledger_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord/ledger"
+	"github.com/suifengpiao14/paymentrecord/sqlite"
+)
+
+var (
+	accountCash = ledger.AccountIn{AccountCode: "cash", AccountName: "现金", AccountType: ledger.AccountType_asset}
+	accountDebt = ledger.AccountIn{AccountCode: "receipts", AccountName: "预收款", AccountType: ledger.AccountType_liability}
+)
+
+func newLedger(t *testing.T) ledger.Ledger {
+	db, handler, err := sqlite.OpenAndMigrate(filepath.Join(t.TempDir(), "ledger.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	l := ledger.NewLedger(handler)
+	err = l.SetAccount(accountCash, accountDebt)
+	require.NoError(t, err)
+	return l
+}
+
+func entryIn(entryId string, orderId string, debit string, credit string, amount int) ledger.EntryIn {
+	return ledger.EntryIn{
+		EntryId:  entryId,
+		BizType:  ledger.BizType_pay,
+		BizId:    entryId,
+		OrderId:  orderId,
+		Postings: ledger.Postings{ledger.Debit(debit, amount), ledger.Credit(credit, amount)},
+	}
+}
+
+func TestPost(t *testing.T) {
+	l := newLedger(t)
+	err := l.Post(entryIn("e1", "o1", accountCash.AccountCode, accountDebt.AccountCode, 5000))
+	require.NoError(t, err)
+	err = l.Post(entryIn("e1", "o1", accountCash.AccountCode, accountDebt.AccountCode, 5000)) // 同一凭证号幂等
+	require.NoError(t, err)
+	exists, err := l.ExistsEntry("e1")
+	require.NoError(t, err)
+	require.True(t, exists)
+	postings, err := l.GetPostingsByOrderId("o1")
+	require.NoError(t, err)
+	require.Len(t, postings, 2)
+
+	err = l.Post(entryIn("e2", "o1", accountDebt.AccountCode, accountCash.AccountCode, 2000))
+	require.NoError(t, err)
+	cash, err := l.OrderBalance(accountCash.AccountCode, "o1")
+	require.NoError(t, err)
+	require.Equal(t, 3000, cash)
+	debt, err := l.Balance(accountDebt.AccountCode, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 3000, debt)
+	err = l.CheckOrderBalanced("o1")
+	require.NoError(t, err)
+}
+
+func TestPostInvalid(t *testing.T) {
+	l := newLedger(t)
+	cases := []struct {
+		name  string
+		entry ledger.EntryIn
+	}{
+		{name: "empty entry id", entry: entryIn("", "o1", accountCash.AccountCode, accountDebt.AccountCode, 100)},
+		{name: "zero amount", entry: entryIn("e1", "o1", accountCash.AccountCode, accountDebt.AccountCode, 0)},
+		{name: "unknown account", entry: entryIn("e1", "o1", "unknown", accountDebt.AccountCode, 100)},
+		{name: "single posting", entry: ledger.EntryIn{EntryId: "e1", BizType: ledger.BizType_pay, Postings: ledger.Postings{ledger.Debit(accountCash.AccountCode, 100)}}},
+		{name: "unbalanced", entry: ledger.EntryIn{EntryId: "e1", BizType: ledger.BizType_pay, Postings: ledger.Postings{
+			ledger.Debit(accountCash.AccountCode, 100),
+			ledger.Credit(accountDebt.AccountCode, 99),
+		}}},
+	}
+	for _, c := range cases {
+		err := l.Post(c.entry)
+		require.Error(t, err, c.name)
+	}
+	exists, err := l.ExistsEntry("e1")
+	require.NoError(t, err)
+	require.False(t, exists)
+}
@@ -0,0 +1,127 @@
+// Package metrics 支付流程 Prometheus 指标，通过 paymentrecord.PayRecordService.SetMetricsHook 接入
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+)
+
+const Namespace = "paymentrecord"
+
+// TimeToPayBuckets 创建到支付成功耗时分桶，单位秒，覆盖扫码即付到次日支付
+var TimeToPayBuckets = []float64{5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200, 86400}
+
+type Prometheus struct {
+	transitions *prometheus.CounterVec
+	timeToPay   *prometheus.HistogramVec
+	errors      *prometheus.CounterVec
+	cache       *prometheus.CounterVec
+}
+
+var _ paymentrecord.MetricsHook = (*Prometheus)(nil)
+var _ repository.CacheMetrics = (*Prometheus)(nil)
+
+// NewPrometheus 创建指标，未注册，需通过 Register 或自行注册 Collectors
+func NewPrometheus() (p *Prometheus) {
+	p = &Prometheus{
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "record_transitions_total",
+			Help:      "支付记录状态变更次数，event 为 actionCreate 时表示创建",
+		}, []string{"event", "pay_agent"}),
+		timeToPay: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "record_time_to_pay_seconds",
+			Help:      "支付记录从创建到支付成功的耗时",
+			Buckets:   TimeToPayBuckets,
+		}, []string{"pay_agent"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "errors_total",
+			Help:      "支付流程操作失败次数",
+		}, []string{"operation", "error_type"}),
+		cache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "cache_lookups_total",
+			Help:      "收款单、支付记录缓存查询次数，命中率为 result=hit 与总数之比",
+		}, []string{"cache", "result"}),
+	}
+	return p
+}
+
+func (p *Prometheus) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{p.transitions, p.timeToPay, p.errors, p.cache}
+}
+
+func (p *Prometheus) Transition(event string, payAgent string) {
+	p.transitions.WithLabelValues(event, payAgent).Inc()
+}
+
+func (p *Prometheus) PaidDuration(payAgent string, duration time.Duration) {
+	p.timeToPay.WithLabelValues(payAgent).Observe(duration.Seconds())
+}
+
+func (p *Prometheus) Error(operation string, errType string) {
+	p.errors.WithLabelValues(operation, errType).Inc()
+}
+
+// CacheLookup 缓存查询，通过 paymentrecord.PayRecordService.SetCache 接入
+func (p *Prometheus) CacheLookup(name string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	p.cache.WithLabelValues(name, result).Inc()
+}
+
+// Register 注册支付流程指标及待支付记录统计，并设置为 service 的指标钩子
+func Register(registerer prometheus.Registerer, service *paymentrecord.PayRecordService) (p *Prometheus, err error) {
+	p = NewPrometheus()
+	collectors := append(p.Collectors(), NewPendingCollector(service))
+	for _, collector := range collectors {
+		err = registerer.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	service.SetMetricsHook(p)
+	return p, nil
+}
+
+// PendingCollector 待支付记录数及金额，采集时实时查询，多实例部署时各实例结果相同，聚合时取 max 而非 sum
+type PendingCollector struct {
+	service *paymentrecord.PayRecordService
+	count   *prometheus.Desc
+	amount  *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*PendingCollector)(nil)
+
+func NewPendingCollector(service *paymentrecord.PayRecordService) (c *PendingCollector) {
+	c = &PendingCollector{
+		service: service,
+		count:   prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", "pending_records"), "待支付记录数", []string{"pay_agent"}, nil),
+		amount:  prometheus.NewDesc(prometheus.BuildFQName(Namespace, "", "pending_amount"), "待支付金额，单位分", []string{"pay_agent"}, nil),
+	}
+	return c
+}
+
+func (c *PendingCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.count
+	ch <- c.amount
+}
+
+func (c *PendingCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.service.GetPendingStats()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.count, err)
+		return
+	}
+	for _, stat := range stats {
+		ch <- prometheus.MustNewConstMetric(c.count, prometheus.GaugeValue, float64(stat.Count), stat.PayAgent)
+		ch <- prometheus.MustNewConstMetric(c.amount, prometheus.GaugeValue, float64(stat.Amount), stat.PayAgent)
+	}
+}
@@ -0,0 +1,87 @@
+package metrics_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/metrics"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/paymentrecord/sqlite"
+)
+
+func newService(t *testing.T) *paymentrecord.PayRecordService {
+	db, handler, err := sqlite.OpenAndMigrate(filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return paymentrecord.NewPayRecordService(handler)
+}
+
+func createIn(payId string, orderId string, payAgent string, amount int) paymentrecord.PayRecordCreateIn {
+	return paymentrecord.PayRecordCreateIn{
+		PayId:       payId,
+		OrderId:     orderId,
+		PayAgent:    payAgent,
+		OrderAmount: amount,
+		PayAmount:   amount,
+		UserId:      "u1",
+	}
+}
+
+func TestRegister(t *testing.T) {
+	s := newService(t)
+	registry := prometheus.NewRegistry()
+	_, err := metrics.Register(registry, s)
+	require.NoError(t, err)
+
+	err = s.Create(createIn("p1", "o1", repository.PayingAgent_Wechat, 2000))
+	require.NoError(t, err)
+	err = s.Create(createIn("p2", "o2", repository.PayingAgent_Wechat, 3000))
+	require.NoError(t, err)
+	err = s.Create(createIn("p3", "o3", repository.PayingAgent_Alipay, 1000))
+	require.NoError(t, err)
+	_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+	require.NoError(t, err)
+	_, err = s.Pay(paymentrecord.PayIn{PayId: "notExists"})
+	require.Error(t, err)
+
+	expected := `
+# HELP paymentrecord_pending_amount 待支付金额，单位分
+# TYPE paymentrecord_pending_amount gauge
+paymentrecord_pending_amount{pay_agent="alipay"} 1000
+paymentrecord_pending_amount{pay_agent="weixin"} 3000
+# HELP paymentrecord_pending_records 待支付记录数
+# TYPE paymentrecord_pending_records gauge
+paymentrecord_pending_records{pay_agent="alipay"} 1
+paymentrecord_pending_records{pay_agent="weixin"} 1
+# HELP paymentrecord_errors_total 支付流程操作失败次数
+# TYPE paymentrecord_errors_total counter
+paymentrecord_errors_total{error_type="notFound",operation="pay"} 1
+`
+	err = testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"paymentrecord_pending_amount", "paymentrecord_pending_records", "paymentrecord_errors_total")
+	require.NoError(t, err)
+
+	count, err := testutil.GatherAndCount(registry, "paymentrecord_record_transitions_total")
+	require.NoError(t, err)
+	require.Equal(t, 3, count) // 微信、支付宝各创建一次，微信支付一次
+	count, err = testutil.GatherAndCount(registry, "paymentrecord_record_time_to_pay_seconds")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+}
+
+func TestPendingCollectorError(t *testing.T) {
+	db, handler, err := sqlite.OpenAndMigrate(filepath.Join(t.TempDir(), "metrics.db"))
+	require.NoError(t, err)
+	s := paymentrecord.NewPayRecordService(handler)
+	db.Close()
+	registry := prometheus.NewRegistry()
+	err = registry.Register(metrics.NewPendingCollector(s))
+	require.NoError(t, err)
+	_, err = registry.Gather()
+	require.Error(t, err) // 查询失败时采集报错，而不是返回空指标
+}
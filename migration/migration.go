@@ -0,0 +1,312 @@
+package migration
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/commonlanguage"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+/*
+CREATE TABLE `schema_migrations` (
+  `Fid` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '主键',
+  `Fversion` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '版本号',
+  `Fname` varchar(128) NOT NULL DEFAULT '' COMMENT '迁移名称',
+  `Fapplied_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '执行时间',
+  PRIMARY KEY (`Fid`),
+  UNIQUE KEY `uk_Fversion` (`Fversion`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='数据库迁移版本';
+*/
+
+func newId(id int) *sqlbuilder.Field {
+	return commonlanguage.NewId(id).SetMaximum(sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_autoIncrement)
+}
+
+func newVersion(version int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(version, "version", "版本号", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func newName(name string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(name, "name", "迁移名称", 128)
+}
+
+func newAppliedAt(appliedAt string) *sqlbuilder.Field {
+	return commonlanguage.NewTime(appliedAt).SetName("appliedAt").SetTitle("执行时间")
+}
+
+var table_schema_migrations = sqlbuilder.NewTableConfig("schema_migrations").AddColumns(
+	sqlbuilder.NewColumn("Fid", sqlbuilder.GetField(newId)),
+	sqlbuilder.NewColumn("Fversion", sqlbuilder.GetField(newVersion)),
+	sqlbuilder.NewColumn("Fname", sqlbuilder.GetField(newName)),
+	sqlbuilder.NewColumn("Fapplied_at", sqlbuilder.GetField(newAppliedAt)),
+).AddIndexs(
+	sqlbuilder.Index{
+		IsPrimary: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(newId))}
+		},
+	},
+	sqlbuilder.Index{
+		Unique: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(newVersion))}
+		},
+	},
+).WithComment("数据库迁移版本")
+
+type SchemaMigrationModel struct {
+	Id        int64  `gorm:"column:Fid" json:"id"`
+	Version   int    `gorm:"column:Fversion" json:"version"`
+	Name      string `gorm:"column:Fname" json:"name"`
+	AppliedAt string `gorm:"column:Fapplied_at" json:"appliedAt"`
+}
+
+type SchemaMigrationModels []SchemaMigrationModel
+
+func (ms SchemaMigrationModels) Versions() (versions []int) {
+	for _, m := range ms {
+		versions = append(versions, m.Version)
+	}
+	return versions
+}
+
+// Migration 一个版本的数据库变更
+type Migration struct {
+	Version    int
+	Name       string
+	Operations []Operation
+}
+
+// UpSQL 升级语句
+func (m Migration) UpSQL(driver sqlbuilder.Driver) (sqls []string, err error) {
+	for _, op := range m.Operations {
+		opSqls, err := op.Up(driver)
+		if err != nil {
+			return nil, err
+		}
+		sqls = append(sqls, opSqls...)
+	}
+	return sqls, nil
+}
+
+// DownSQL 回滚语句，按操作逆序生成
+func (m Migration) DownSQL(driver sqlbuilder.Driver) (sqls []string, err error) {
+	for _, op := range slices.Backward(m.Operations) {
+		opSqls, err := op.Down(driver)
+		if err != nil {
+			return nil, err
+		}
+		sqls = append(sqls, opSqls...)
+	}
+	return sqls, nil
+}
+
+func (m Migration) up(handler sqlbuilder.Handler) (err error) {
+	driver := getDriver(handler)
+	for _, op := range m.Operations {
+		applied, err := op.Applied(handler)
+		if err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+		err = execOperation(handler, op.Up, driver)
+		if err != nil {
+			return errors.WithMessagef(err, "migration up %d_%s", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+func (m Migration) down(handler sqlbuilder.Handler) (err error) {
+	driver := getDriver(handler)
+	for _, op := range slices.Backward(m.Operations) {
+		applied, err := op.Applied(handler)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			continue
+		}
+		err = execOperation(handler, op.Down, driver)
+		if err != nil {
+			return errors.WithMessagef(err, "migration down %d_%s", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+func execOperation(handler sqlbuilder.Handler, fn func(driver sqlbuilder.Driver) (sqls []string, err error), driver sqlbuilder.Driver) (err error) {
+	sqls, err := fn(driver)
+	if err != nil {
+		return err
+	}
+	for _, sql := range sqls {
+		err = handler.Exec(sql)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Migrations []Migration
+
+// Script 生成全部版本的升级或回滚脚本，便于 DBA 审核后手工执行
+func (ms Migrations) Script(driver sqlbuilder.Driver, down bool) (script string, err error) {
+	var sb strings.Builder
+	list := ms
+	if down {
+		list = slices.Clone(ms)
+		slices.Reverse(list)
+	}
+	for _, m := range list {
+		var sqls []string
+		if down {
+			sqls, err = m.DownSQL(driver)
+		} else {
+			sqls, err = m.UpSQL(driver)
+		}
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(fmt.Sprintf("-- %d_%s\n", m.Version, m.Name))
+		for _, sql := range sqls {
+			sb.WriteString(sql)
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String(), nil
+}
+
+// Migrate 依次执行未执行的版本。每个操作执行前检查变更是否已存在，存量库(已通过 CreateTableIfNotExists 建表)也可安全执行
+func (ms Migrations) Migrate(handler sqlbuilder.Handler) (err error) {
+	repo, err := newSchemaMigrationRepository(handler)
+	if err != nil {
+		return err
+	}
+	applied, err := repo.all()
+	if err != nil {
+		return err
+	}
+	versions := applied.Versions()
+	for _, m := range ms.sorted() {
+		if slices.Contains(versions, m.Version) {
+			continue
+		}
+		err = m.up(handler)
+		if err != nil {
+			return err
+		}
+		err = repo.insert(m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback 回滚到指定版本，版本号大于 version 的已执行版本按倒序回滚
+func (ms Migrations) Rollback(handler sqlbuilder.Handler, version int) (err error) {
+	repo, err := newSchemaMigrationRepository(handler)
+	if err != nil {
+		return err
+	}
+	applied, err := repo.all()
+	if err != nil {
+		return err
+	}
+	versions := applied.Versions()
+	sorted := ms.sorted()
+	for _, m := range slices.Backward(sorted) {
+		if m.Version <= version || !slices.Contains(versions, m.Version) {
+			continue
+		}
+		err = m.down(handler)
+		if err != nil {
+			return err
+		}
+		err = repo.delete(m.Version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Applied 获取已执行的版本
+func (ms Migrations) Applied(handler sqlbuilder.Handler) (models SchemaMigrationModels, err error) {
+	repo, err := newSchemaMigrationRepository(handler)
+	if err != nil {
+		return nil, err
+	}
+	return repo.all()
+}
+
+func (ms Migrations) sorted() Migrations {
+	sorted := slices.Clone(ms)
+	slices.SortFunc(sorted, func(a, b Migration) int { return a.Version - b.Version })
+	return sorted
+}
+
+type schemaMigrationRepository struct {
+	repository sqlbuilder.Repository
+}
+
+// newSchemaMigrationRepository 版本表不存在时先创建
+func newSchemaMigrationRepository(handler sqlbuilder.Handler) (repo schemaMigrationRepository, err error) {
+	op := CreateTable(table_schema_migrations)
+	applied, err := op.Applied(handler)
+	if err != nil {
+		return repo, err
+	}
+	if !applied {
+		err = execOperation(handler, op.Up, getDriver(handler))
+		if err != nil {
+			return repo, err
+		}
+	}
+	repo = schemaMigrationRepository{
+		repository: sqlbuilder.NewRepository(table_schema_migrations.WithHandler(handler)),
+	}
+	return repo, nil
+}
+
+func (repo schemaMigrationRepository) all() (models SchemaMigrationModels, err error) {
+	err = repo.repository.All(&models, sqlbuilder.Fields{})
+	if err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+func (repo schemaMigrationRepository) insert(m Migration) (err error) {
+	fs := sqlbuilder.Fields{
+		newVersion(m.Version).SetRequired(true),
+		newName(m.Name),
+		newAppliedAt(time.Now().Format(time.DateTime)),
+	}
+	err = repo.repository.Insert(fs)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// delete 版本表无删除标记字段，直接物理删除
+func (repo schemaMigrationRepository) delete(version int) (err error) {
+	table := repo.repository.GetTable()
+	handler := table.GetHandler()
+	driver := getDriver(handler)
+	sql := fmt.Sprintf("DELETE FROM %s WHERE %s=%d;", quote(driver, table.DBName.Name), quote(driver, table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(newVersion))), version)
+	err = handler.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package migration_test
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord/migration"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+func newSqliteHandler(t *testing.T) sqlbuilder.Handler {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migration.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return sqlbuilder.NewGormHandler(sqlbuilder.DB2Gorm(func() *sql.DB { return db }, nil))
+}
+
+func quote(driver sqlbuilder.Driver, name string) string {
+	if driver == repository.Driver_postgres {
+		return fmt.Sprintf(`"%s"`, name)
+	}
+	return fmt.Sprintf("`%s`", name)
+}
+
+func columnCount(t *testing.T, handler sqlbuilder.Handler, table string, column string) int64 {
+	count, err := handler.Count(fmt.Sprintf("SELECT count(*) FROM pragma_table_info('%s') WHERE name='%s';", table, column))
+	require.NoError(t, err)
+	return count
+}
+
+func TestMigrateExistingDeployment(t *testing.T) {
+	handler := newSqliteHandler(t)
+	// 存量库：支付记录表缺少后续新增的字段
+	err := handler.Exec("CREATE TABLE `pay_record` (`Fid` INTEGER PRIMARY KEY AUTOINCREMENT, `Fpay_id` TEXT NOT NULL DEFAULT '', `Forder_id` TEXT NOT NULL DEFAULT '', `Fpay_amount` INTEGER NOT NULL DEFAULT 0, `Fstate` TEXT NOT NULL DEFAULT '');")
+	require.NoError(t, err)
+
+	err = migration.Migrate(handler)
+	require.NoError(t, err)
+	for _, column := range []string{"Fexpired_at", "Ffailed_at", "Frecipient_account", "Fpayment_name", "Fprovider_trade_no"} {
+		require.Equal(t, int64(1), columnCount(t, handler, "pay_record", column), column)
+	}
+	require.Equal(t, int64(1), columnCount(t, handler, "pay_order", "Forder_type"))
+
+	err = migration.Migrate(handler) // 重复执行无副作用
+	require.NoError(t, err)
+	applied, err := migration.DefaultMigrations.Applied(handler)
+	require.NoError(t, err)
+	require.Len(t, applied, len(migration.DefaultMigrations))
+}
+
+func TestBackfillOrderTotals(t *testing.T) {
+	handler := newSqliteHandler(t)
+	// 存量库：收款单表缺少汇总字段
+	err := handler.Exec("CREATE TABLE `pay_order` (`Fid` INTEGER PRIMARY KEY AUTOINCREMENT, `Forder_id` TEXT NOT NULL DEFAULT '', `Forder_amount` INTEGER NOT NULL DEFAULT 0);")
+	require.NoError(t, err)
+	err = handler.Exec("CREATE TABLE `pay_record` (`Fid` INTEGER PRIMARY KEY AUTOINCREMENT, `Fpay_id` TEXT NOT NULL DEFAULT '', `Forder_id` TEXT NOT NULL DEFAULT '', `Fpay_amount` INTEGER NOT NULL DEFAULT 0, `Fstate` TEXT NOT NULL DEFAULT '');")
+	require.NoError(t, err)
+	err = handler.Exec("INSERT INTO `pay_order` (`Forder_id`,`Forder_amount`) VALUES ('o1',5000),('o2',1000);")
+	require.NoError(t, err)
+	err = handler.Exec("INSERT INTO `pay_record` (`Fpay_id`,`Forder_id`,`Fpay_amount`,`Fstate`) VALUES ('p1','o1',2000,'paid'),('p2','o1',1000,'pending'),('p3','o1',500,'closed');")
+	require.NoError(t, err)
+
+	err = migration.Migrate(handler)
+	require.NoError(t, err)
+	count, err := handler.Count("SELECT count(*) FROM `pay_order` WHERE `Forder_id`='o1' AND `Fpaid_amount`=2000 AND `Fpending_amount`=1000 AND `Frecord_count`=2;")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+	count, err = handler.Count("SELECT count(*) FROM `pay_order` WHERE `Forder_id`='o2' AND `Fpaid_amount`=0 AND `Fpending_amount`=0 AND `Frecord_count`=0;")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+}
+
+func TestRollback(t *testing.T) {
+	handler := newSqliteHandler(t)
+	err := migration.Migrate(handler)
+	require.NoError(t, err)
+
+	err = migration.Rollback(handler, 1)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), columnCount(t, handler, "pay_record", "Fprovider_trade_no"))
+	require.Equal(t, int64(0), columnCount(t, handler, "pay_order", "Fdeadline"))
+	applied, err := migration.DefaultMigrations.Applied(handler)
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, applied.Versions())
+
+	err = migration.Migrate(handler)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), columnCount(t, handler, "pay_record", "Fprovider_trade_no"))
+}
+
+func TestScript(t *testing.T) {
+	for _, driver := range []sqlbuilder.Driver{sqlbuilder.Driver_mysql, sqlbuilder.Driver_sqlite3, repository.Driver_postgres} {
+		up, err := migration.DefaultMigrations.Script(driver, false)
+		require.NoError(t, err)
+		down, err := migration.DefaultMigrations.Script(driver, true)
+		require.NoError(t, err)
+		require.Contains(t, up, "-- 1_create_tables", driver)
+		require.Contains(t, up, "-- 14_pay_record_add_refund_amount", driver)
+		for _, table := range []string{"pay_order", "pay_record", "pay_split", "ledger_posting"} {
+			require.Regexp(t, fmt.Sprintf("CREATE TABLE IF NOT EXISTS [`\"]?%s[`\"]? \\(", table), up, driver)
+		}
+		require.Contains(t, up, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quote(driver, "pay_record"), quote(driver, "Frefund_amount")), driver)
+		// 回滚脚本按版本倒序，版本1的建表语句最后回滚
+		dropLedger := strings.Index(down, fmt.Sprintf("DROP TABLE IF EXISTS %s;", quote(driver, "ledger_posting")))
+		dropOrder := strings.Index(down, fmt.Sprintf("DROP TABLE IF EXISTS %s;", quote(driver, "pay_order")))
+		require.True(t, dropLedger >= 0 && dropOrder > dropLedger, driver)
+		require.Less(t, strings.Index(down, "-- 14_pay_record_add_refund_amount"), strings.Index(down, "-- 1_create_tables"), driver)
+	}
+}
+
+// TestFrozenCreateTables 版本1的建表语句固定，不随表配置中新增的字段变化
+func TestFrozenCreateTables(t *testing.T) {
+	up, err := migration.Migrations{migration.DefaultMigrations[0]}.Script(sqlbuilder.Driver_sqlite3, false)
+	require.NoError(t, err)
+	require.Contains(t, up, `"Fprovider_trade_no" TEXT NOT NULL DEFAULT ''`)
+	for _, column := range []string{"Fmerchant_id", "Fdeleted_at", "Fprovider_fee", "Fpayment_account_bidx"} {
+		require.NotContains(t, up, column)
+	}
+
+	handler := newSqliteHandler(t)
+	err = migration.Migrate(handler)
+	require.NoError(t, err)
+	for _, column := range []string{"Fmerchant_id", "Fdeleted_at", "Fprovider_fee", "Frefund_amount", "Fpayment_account_bidx"} {
+		require.Equal(t, int64(1), columnCount(t, handler, "pay_record", column), column)
+	}
+	for _, column := range []string{"Fmerchant_id", "Fpaid_amount", "Frecord_count", "Fdeleted_at"} {
+		require.Equal(t, int64(1), columnCount(t, handler, "pay_order", column), column)
+	}
+	for _, column := range []string{"Fdeleted_at", "Fprovider_fee", "Fcurrency"} {
+		require.Equal(t, int64(1), columnCount(t, handler, "pay_record_history", column), column)
+	}
+	require.Equal(t, int64(1), columnCount(t, handler, "risk_decision", "Fclient_ip_bidx"))
+}
+
+// TestFrozenLaterCreateTables 后续版本新建的表同样固定建表语句，此后新增的字段只出现在加列的版本中
+func TestFrozenLaterCreateTables(t *testing.T) {
+	frozen := map[int][]string{
+		10: {"Fdeleted_at", "Fprovider_fee", "Fcurrency"},
+		12: {"Fclient_ip_bidx"},
+	}
+	for _, m := range migration.DefaultMigrations {
+		columns, ok := frozen[m.Version]
+		if !ok {
+			continue
+		}
+		for _, driver := range []sqlbuilder.Driver{sqlbuilder.Driver_mysql, sqlbuilder.Driver_sqlite3, repository.Driver_postgres} {
+			up, err := migration.Migrations{m}.Script(driver, false)
+			require.NoError(t, err)
+			for _, column := range columns {
+				require.NotContains(t, up, column, driver)
+			}
+		}
+	}
+}
+
+func TestSqliteColumnDefaults(t *testing.T) {
+	handler := newSqliteHandler(t)
+	err := migration.Migrate(handler)
+	require.NoError(t, err)
+	// 未赋值的字段与 mysql 一致取默认值，而不是 NULL
+	err = handler.Exec("INSERT INTO `pay_record` (`Fpay_id`,`Forder_id`) VALUES ('p1','o1');")
+	require.NoError(t, err)
+	count, err := handler.Count("SELECT count(*) FROM `pay_record` WHERE `Fprovider_trade_no`='' AND `Fpay_amount`=0;")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+	err = handler.Exec("INSERT INTO `pay_record` (`Fpay_id`,`Forder_id`,`Fstate`) VALUES ('p2','o1',NULL);")
+	require.Error(t, err)
+}
+
+func TestPostgresScript(t *testing.T) {
+	up, err := migration.DefaultMigrations.Script(repository.Driver_postgres, false)
+	require.NoError(t, err)
+	for _, mysqlOnly := range []string{"unsigned", "0000-00-00", "AUTO_INCREMENT", "`"} {
+		require.NotContains(t, up, mysqlOnly)
+	}
+	require.Contains(t, up, `"Fid" BIGSERIAL PRIMARY KEY`)
+	require.Contains(t, up, `CREATE INDEX IF NOT EXISTS "ik_pay_record_Fprovider_trade_no" ON "pay_record" ("Fprovider_trade_no");`)
+}
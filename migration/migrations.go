@@ -0,0 +1,224 @@
+package migration
+
+import (
+	"github.com/suifengpiao14/paymentrecord/ledger"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/paymentrecord/risk"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+func mustTable(tables sqlbuilder.TableConfigs, name string) sqlbuilder.TableConfig {
+	table, ok := tables.GetByName(name)
+	if !ok {
+		panic("migration: table not found: " + name)
+	}
+	return *table
+}
+
+// DefaultMigrations 支付单、支付记录等业务表的迁移版本。
+// 建表版本(1、7、10、12)以固定的建表语句建表(表已存在时跳过)，后续版本在此基础上增加字段、索引，存量库中已存在时自动跳过
+var DefaultMigrations = defaultMigrations()
+
+func defaultMigrations() Migrations {
+	tables := append(append(repository.Tables(), ledger.Tables()...), risk.Tables()...)
+	payOrder := mustTable(tables, "pay_order")
+	payRecord := mustTable(tables, "pay_record")
+	adjustment := mustTable(tables, "pay_order_adjustment")
+	overpayment := mustTable(tables, "overpayment")
+	paySplit := mustTable(tables, "pay_split")
+	ledgerEntry := mustTable(tables, "ledger_entry")
+	ledgerPosting := mustTable(tables, "ledger_posting")
+	riskDecision := mustTable(tables, "risk_decision")
+	return Migrations{
+		{
+			Version:    1,
+			Name:       "create_tables",
+			Operations: createTablesV1,
+		},
+		{
+			Version: 2,
+			Name:    "pay_record_add_state_time_columns",
+			Operations: []Operation{
+				AddColumn(payRecord, "Fexpired_at"),
+				AddColumn(payRecord, "Ffailed_at"),
+			},
+		},
+		{
+			Version: 3,
+			Name:    "pay_record_add_recipient_payment_columns",
+			Operations: []Operation{
+				AddColumn(payRecord, "Frecipient_account"),
+				AddColumn(payRecord, "Frecipient_name"),
+				AddColumn(payRecord, "Fpayment_account"),
+				AddColumn(payRecord, "Fpayment_name"),
+			},
+		},
+		{
+			Version: 4,
+			Name:    "pay_order_add_open_order_columns",
+			Operations: []Operation{
+				AddColumn(payOrder, "Forder_type"),
+				AddColumn(payOrder, "Funit_price"),
+				AddColumn(payOrder, "Fcapacity"),
+				AddColumn(payOrder, "Fdeadline"),
+			},
+		},
+		{
+			Version: 5,
+			Name:    "pay_record_add_provider_trade_no",
+			Operations: []Operation{
+				AddColumn(payRecord, "Fprovider_trade_no"),
+				AddIndex(payRecord, "Fprovider_trade_no"),
+			},
+		},
+		{
+			Version: 6,
+			Name:    "pay_record_encrypt_pii_columns",
+			Operations: []Operation{
+				ModifyColumn(payRecord, "Frecipient_account", 64),
+				ModifyColumn(payRecord, "Frecipient_name", 64),
+				ModifyColumn(payRecord, "Fpayment_account", 64),
+				ModifyColumn(payRecord, "Fpayment_name", 64),
+				ModifyColumn(payRecord, "Fclient_ip", 20),
+				AddColumn(payRecord, "Fpayment_account_bidx"),
+				AddIndex(payRecord, "Fpayment_account_bidx"),
+			},
+		},
+		{
+			Version:    7,
+			Name:       "create_pay_id_sequence",
+			Operations: createPayIdSequenceV7,
+		},
+		{
+			Version: 8,
+			Name:    "add_merchant_id",
+			Operations: []Operation{
+				AddColumn(payOrder, "Fmerchant_id"),
+				ChangeUniqueIndex(payOrder, []string{"Forder_id"}, "Fmerchant_id", "Forder_id"),
+				AddColumn(payRecord, "Fmerchant_id"),
+				AddIndex(payRecord, "Fmerchant_id", "Forder_id"),
+				DropIndex(payRecord, "Forder_id"),
+				AddColumn(adjustment, "Fmerchant_id"),
+				AddIndex(adjustment, "Fmerchant_id", "Forder_id"),
+				DropIndex(adjustment, "Forder_id"),
+				AddColumn(overpayment, "Fmerchant_id"),
+				AddIndex(overpayment, "Fmerchant_id", "Forder_id"),
+				DropIndex(overpayment, "Forder_id"),
+			},
+		},
+		{
+			Version: 9,
+			Name:    "pay_order_add_totals",
+			Operations: []Operation{
+				AddColumn(payOrder, "Fpaid_amount"),
+				AddColumn(payOrder, "Fpending_amount"),
+				AddColumn(payOrder, "Frecord_count"),
+				BackfillOrderTotals(payOrder, payRecord),
+			},
+		},
+		{
+			Version:    10,
+			Name:       "create_history_tables",
+			Operations: createHistoryTablesV10,
+		},
+		{
+			Version: 11,
+			Name:    "add_deleted_at",
+			Operations: []Operation{
+				AddColumn(payOrder, "Fdeleted_at"),
+				AddColumn(payRecord, "Fdeleted_at"),
+				AddColumn(mustTable(tables, "pay_order_history"), "Fdeleted_at"),
+				AddColumn(mustTable(tables, "pay_record_history"), "Fdeleted_at"),
+			},
+		},
+		{
+			Version:    12,
+			Name:       "create_risk_decision",
+			Operations: createRiskDecisionV12,
+		},
+		{
+			Version: 13,
+			Name:    "pay_record_add_provider_fee",
+			Operations: []Operation{
+				AddColumn(payRecord, "Fprovider_fee"),
+				AddColumn(payRecord, "Fnet_amount"),
+				AddColumn(mustTable(tables, "pay_record_history"), "Fprovider_fee"),
+				AddColumn(mustTable(tables, "pay_record_history"), "Fnet_amount"),
+				BackfillNetAmount(payRecord),
+				BackfillNetAmount(mustTable(tables, "pay_record_history")),
+			},
+		},
+		{
+			Version: 14,
+			Name:    "pay_record_add_refund_amount",
+			Operations: []Operation{
+				AddColumn(payRecord, "Frefund_amount"),
+				AddColumn(mustTable(tables, "pay_record_history"), "Frefund_amount"),
+			},
+		},
+		{
+			Version: 15,
+			Name:    "pay_split_encrypt_recipient_columns",
+			Operations: []Operation{
+				ModifyColumn(paySplit, "Frecipient_account", 64),
+				ModifyColumn(paySplit, "Frecipient_name", 64),
+				AddColumn(paySplit, "Frecipient_account_bidx"),
+				AddIndex(paySplit, "Frecipient_account_bidx"),
+			},
+		},
+		{
+			Version: 16,
+			Name:    "split_ledger_add_merchant_id",
+			Operations: []Operation{
+				AddColumn(paySplit, "Fmerchant_id"),
+				AddIndex(paySplit, "Fmerchant_id", "Fpay_id"),
+				AddColumn(ledgerEntry, "Fmerchant_id"),
+				ChangeUniqueIndex(ledgerEntry, []string{"Fentry_id"}, "Fmerchant_id", "Fentry_id"),
+				AddColumn(ledgerPosting, "Fmerchant_id"),
+				AddIndex(ledgerPosting, "Fmerchant_id", "Forder_id"),
+				DropIndex(ledgerPosting, "Forder_id"),
+			},
+		},
+		{
+			Version: 17,
+			Name:    "add_delete_reason",
+			Operations: []Operation{
+				AddColumn(payOrder, "Fdelete_reason"),
+				AddColumn(payRecord, "Fdelete_reason"),
+				AddColumn(mustTable(tables, "pay_order_history"), "Fdelete_reason"),
+				AddColumn(mustTable(tables, "pay_record_history"), "Fdelete_reason"),
+			},
+		},
+		{
+			Version: 18,
+			Name:    "risk_decision_add_client_ip_bidx",
+			Operations: []Operation{
+				AddColumn(riskDecision, "Fclient_ip_bidx"),
+				AddIndex(riskDecision, "Fmerchant_id", "Fclient_ip_bidx", "Fcreated_at"),
+			},
+		},
+		{
+			Version: 19,
+			Name:    "pay_record_add_currency",
+			Operations: []Operation{
+				AddColumn(payRecord, "Fcurrency"),
+				AddColumn(mustTable(tables, "pay_record_history"), "Fcurrency"),
+			},
+		},
+		{
+			Version:    20,
+			Name:       "overpayment_add_refund_amount",
+			Operations: []Operation{AddColumn(overpayment, "Frefund_amount")},
+		},
+	}
+}
+
+// Migrate 执行业务表迁移
+func Migrate(handler sqlbuilder.Handler) (err error) {
+	return DefaultMigrations.Migrate(handler)
+}
+
+// Rollback 业务表回滚到指定版本
+func Rollback(handler sqlbuilder.Handler, version int) (err error) {
+	return DefaultMigrations.Rollback(handler, version)
+}
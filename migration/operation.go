@@ -0,0 +1,495 @@
+package migration
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// Operation 迁移操作，升级、回滚语句由 sqlbuilder.TableConfig 生成或直接给出
+type Operation interface {
+	Up(driver sqlbuilder.Driver) (sqls []string, err error)
+	Down(driver sqlbuilder.Driver) (sqls []string, err error)
+	// Applied 变更是否已存在于数据库，存量库已手工建表、加列时据此跳过，保证重复执行安全
+	Applied(handler sqlbuilder.Handler) (applied bool, err error)
+}
+
+// CreateTable 建表
+func CreateTable(table sqlbuilder.TableConfig) Operation {
+	return createTable{table: table}
+}
+
+// CreateTableSQL 按给定的建表语句建表，ddl 为各数据库的建表、建索引语句。
+// 已发布版本的建表语句需固定下来，不能随表配置变化
+func CreateTableSQL(name string, ddl map[sqlbuilder.Driver][]string) Operation {
+	return createTableSQL{name: name, ddl: ddl}
+}
+
+// AddColumn 增加字段，字段定义取自表配置
+func AddColumn(table sqlbuilder.TableConfig, dbName string) Operation {
+	return addColumn{table: table, dbName: dbName}
+}
+
+// AddIndex 增加普通索引
+func AddIndex(table sqlbuilder.TableConfig, dbNames ...string) Operation {
+	return addIndex{table: table, dbNames: dbNames}
+}
+
+// DropIndex 删除普通索引，回滚时重建
+func DropIndex(table sqlbuilder.TableConfig, dbNames ...string) Operation {
+	return dropIndex{addIndex: addIndex{table: table, dbNames: dbNames}}
+}
+
+// ChangeUniqueIndex 唯一索引由 oldDbNames 改为 dbNames(如订单号唯一改为商户内订单号唯一)。
+// sqlite 早期建表时内联的唯一约束无法单独删除，只新增唯一索引，原约束需重建表后才会去除
+func ChangeUniqueIndex(table sqlbuilder.TableConfig, oldDbNames []string, dbNames ...string) Operation {
+	return changeUniqueIndex{table: table, oldDbNames: oldDbNames, dbNames: dbNames}
+}
+
+// ModifyColumn 修改字段定义(如加长)，字段定义取自表配置，oldLength 为修改前的长度，用于回滚。
+// sqlite 不限制 varchar 长度，无需修改
+func ModifyColumn(table sqlbuilder.TableConfig, dbName string, oldLength int) Operation {
+	return modifyColumn{table: table, dbName: dbName, oldLength: oldLength}
+}
+
+// BackfillOrderTotals 按支付记录回填收款单上的汇总(已支付、待支付金额及有效支付记录数)，结果与
+// repository.RepairPayOrderTotals 一致；语句幂等，每次升级都会执行，回滚无需处理(字段随加列操作删除)
+func BackfillOrderTotals(orderTable sqlbuilder.TableConfig, recordTable sqlbuilder.TableConfig) Operation {
+	return backfillOrderTotals{orderTable: orderTable, recordTable: recordTable}
+}
+
+// BackfillNetAmount 加列前已支付的记录没有手续费数据，实收金额按支付金额回填；只处理实收金额为0的记录，语句幂等
+func BackfillNetAmount(recordTable sqlbuilder.TableConfig) Operation {
+	return backfillNetAmount{recordTable: recordTable}
+}
+
+type createTable struct {
+	table sqlbuilder.TableConfig
+}
+
+func (op createTable) Up(driver sqlbuilder.Driver) (sqls []string, err error) {
+	switch driver {
+	case sqlbuilder.Driver_mysql:
+		ddl, err := sqlbuilder.GenerateDDL(driver, op.table)
+		if err != nil {
+			return nil, err
+		}
+		sqls = append(sqls, ddl)
+	case sqlbuilder.Driver_sqlite3:
+		sqls = append(sqls, sqliteCreateTableSQL(op.table)...)
+	case repository.Driver_postgres:
+		sqls = append(sqls, postgresCreateTableSQL(op.table)...)
+	default:
+		return nil, unsupportedDriver(driver)
+	}
+	return sqls, nil
+}
+
+func (op createTable) Down(driver sqlbuilder.Driver) (sqls []string, err error) {
+	sqls = []string{fmt.Sprintf("DROP TABLE IF EXISTS %s;", quote(driver, op.table.DBName.Name))}
+	return sqls, nil
+}
+
+func (op createTable) Applied(handler sqlbuilder.Handler) (applied bool, err error) {
+	return tableExists(handler, op.table.DBName.Name)
+}
+
+func tableExists(handler sqlbuilder.Handler, name string) (applied bool, err error) {
+	driver := getDriver(handler)
+	var sql string
+	switch driver {
+	case sqlbuilder.Driver_mysql:
+		sql = fmt.Sprintf("SELECT count(*) FROM information_schema.tables WHERE table_schema=DATABASE() AND table_name='%s';", name)
+	case sqlbuilder.Driver_sqlite3:
+		sql = fmt.Sprintf("SELECT count(*) FROM sqlite_master WHERE type='table' AND name='%s';", name)
+	case repository.Driver_postgres:
+		sql = fmt.Sprintf("SELECT count(*) FROM information_schema.tables WHERE table_schema=current_schema() AND table_name='%s';", name)
+	default:
+		return false, unsupportedDriver(driver)
+	}
+	return exists(handler, sql)
+}
+
+type createTableSQL struct {
+	name string
+	ddl  map[sqlbuilder.Driver][]string
+}
+
+func (op createTableSQL) Up(driver sqlbuilder.Driver) (sqls []string, err error) {
+	sqls, ok := op.ddl[driver]
+	if !ok {
+		return nil, unsupportedDriver(driver)
+	}
+	return sqls, nil
+}
+
+func (op createTableSQL) Down(driver sqlbuilder.Driver) (sqls []string, err error) {
+	sqls = []string{fmt.Sprintf("DROP TABLE IF EXISTS %s;", quote(driver, op.name))}
+	return sqls, nil
+}
+
+func (op createTableSQL) Applied(handler sqlbuilder.Handler) (applied bool, err error) {
+	return tableExists(handler, op.name)
+}
+
+type addColumn struct {
+	table  sqlbuilder.TableConfig
+	dbName string
+}
+
+func (op addColumn) Up(driver sqlbuilder.Driver) (sqls []string, err error) {
+	col, ok := op.table.Columns.GetByDbName(op.dbName)
+	if !ok {
+		err = errors.Errorf("表%s未定义字段%s", op.table.DBName.Name, op.dbName)
+		return nil, err
+	}
+	col = col.CopyFieldSchemaIfEmpty()
+	var colDDL string
+	switch driver {
+	case sqlbuilder.Driver_mysql:
+		colDDL = sqlbuilder.Column2DDLMysql(col)
+	case sqlbuilder.Driver_sqlite3:
+		colDDL = sqliteColumnDDL(col, true)
+	case repository.Driver_postgres:
+		colDDL = postgresColumnDDL(col)
+	default:
+		return nil, unsupportedDriver(driver)
+	}
+	sqls = []string{fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", quote(driver, op.table.DBName.Name), strings.TrimSpace(colDDL))}
+	if driver == repository.Driver_postgres {
+		if comment := postgresColumnComment(op.table, col); comment != "" {
+			sqls = append(sqls, comment)
+		}
+	}
+	return sqls, nil
+}
+
+func (op addColumn) Down(driver sqlbuilder.Driver) (sqls []string, err error) {
+	sqls = []string{fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", quote(driver, op.table.DBName.Name), quote(driver, op.dbName))}
+	return sqls, nil
+}
+
+func (op addColumn) Applied(handler sqlbuilder.Handler) (applied bool, err error) {
+	driver := getDriver(handler)
+	var sql string
+	switch driver {
+	case sqlbuilder.Driver_mysql:
+		sql = fmt.Sprintf("SELECT count(*) FROM information_schema.columns WHERE table_schema=DATABASE() AND table_name='%s' AND column_name='%s';", op.table.DBName.Name, op.dbName)
+	case sqlbuilder.Driver_sqlite3:
+		sql = fmt.Sprintf("SELECT count(*) FROM pragma_table_info('%s') WHERE name='%s';", op.table.DBName.Name, op.dbName)
+	case repository.Driver_postgres:
+		sql = fmt.Sprintf("SELECT count(*) FROM information_schema.columns WHERE table_schema=current_schema() AND table_name='%s' AND column_name='%s';", op.table.DBName.Name, op.dbName)
+	default:
+		return false, unsupportedDriver(driver)
+	}
+	return exists(handler, sql)
+}
+
+type modifyColumn struct {
+	table     sqlbuilder.TableConfig
+	dbName    string
+	oldLength int
+}
+
+func (op modifyColumn) column() (col sqlbuilder.ColumnConfig, err error) {
+	col, ok := op.table.Columns.GetByDbName(op.dbName)
+	if !ok {
+		err = errors.Errorf("表%s未定义字段%s", op.table.DBName.Name, op.dbName)
+		return col, err
+	}
+	return col.CopyFieldSchemaIfEmpty(), nil
+}
+
+func (op modifyColumn) sql(driver sqlbuilder.Driver, col sqlbuilder.ColumnConfig) (sqls []string, err error) {
+	switch driver {
+	case sqlbuilder.Driver_mysql:
+		sqls = []string{fmt.Sprintf("ALTER TABLE `%s` MODIFY COLUMN %s;", op.table.DBName.Name, strings.TrimSpace(sqlbuilder.Column2DDLMysql(col)))}
+	case sqlbuilder.Driver_sqlite3:
+	case repository.Driver_postgres:
+		sqls = []string{fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE VARCHAR(%d);", quote(driver, op.table.DBName.Name), quote(driver, op.dbName), col.Length)}
+	default:
+		return nil, unsupportedDriver(driver)
+	}
+	return sqls, nil
+}
+
+func (op modifyColumn) Up(driver sqlbuilder.Driver) (sqls []string, err error) {
+	col, err := op.column()
+	if err != nil {
+		return nil, err
+	}
+	return op.sql(driver, col)
+}
+
+func (op modifyColumn) Down(driver sqlbuilder.Driver) (sqls []string, err error) {
+	col, err := op.column()
+	if err != nil {
+		return nil, err
+	}
+	col.Length = op.oldLength
+	return op.sql(driver, col)
+}
+
+// Applied 字段长度已不小于表配置时视为已执行
+func (op modifyColumn) Applied(handler sqlbuilder.Handler) (applied bool, err error) {
+	col, err := op.column()
+	if err != nil {
+		return false, err
+	}
+	driver := getDriver(handler)
+	var sql string
+	switch driver {
+	case sqlbuilder.Driver_mysql:
+		sql = fmt.Sprintf("SELECT count(*) FROM information_schema.columns WHERE table_schema=DATABASE() AND table_name='%s' AND column_name='%s' AND character_maximum_length>=%d;", op.table.DBName.Name, op.dbName, col.Length)
+	case sqlbuilder.Driver_sqlite3:
+		return true, nil
+	case repository.Driver_postgres:
+		sql = fmt.Sprintf("SELECT count(*) FROM information_schema.columns WHERE table_schema=current_schema() AND table_name='%s' AND column_name='%s' AND character_maximum_length>=%d;", op.table.DBName.Name, op.dbName, col.Length)
+	default:
+		return false, unsupportedDriver(driver)
+	}
+	return exists(handler, sql)
+}
+
+type addIndex struct {
+	table   sqlbuilder.TableConfig
+	dbNames []string
+}
+
+// indexName 与 sqlbuilder 建表语句的索引命名保持一致，建表时已创建的索引可被识别。
+// postgres 索引名在 schema 内唯一，不同表的同名字段索引需加表名区分
+func (op addIndex) indexName(driver sqlbuilder.Driver) string {
+	switch driver {
+	case sqlbuilder.Driver_sqlite3:
+		return fmt.Sprintf("idx_%s", strings.Join(op.dbNames, "_"))
+	case repository.Driver_postgres:
+		return fmt.Sprintf("ik_%s_%s", op.table.DBName.Name, strings.Join(op.dbNames, "_"))
+	}
+	return fmt.Sprintf("ik_%s", strings.Join(op.dbNames, "_"))
+}
+
+func (op addIndex) createSQL(driver sqlbuilder.Driver) string {
+	cols := make([]string, 0, len(op.dbNames))
+	for _, dbName := range op.dbNames {
+		cols = append(cols, quote(driver, dbName))
+	}
+	if driver == sqlbuilder.Driver_mysql {
+		return fmt.Sprintf("ALTER TABLE `%s` ADD KEY `%s` (%s);", op.table.DBName.Name, op.indexName(driver), strings.Join(cols, ","))
+	}
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s);", quote(driver, op.indexName(driver)), quote(driver, op.table.DBName.Name), strings.Join(cols, ","))
+}
+
+func (op addIndex) Up(driver sqlbuilder.Driver) (sqls []string, err error) {
+	switch driver {
+	case sqlbuilder.Driver_mysql, sqlbuilder.Driver_sqlite3, repository.Driver_postgres:
+		sqls = []string{op.createSQL(driver)}
+	default:
+		return nil, unsupportedDriver(driver)
+	}
+	return sqls, nil
+}
+
+func (op addIndex) Down(driver sqlbuilder.Driver) (sqls []string, err error) {
+	switch driver {
+	case sqlbuilder.Driver_mysql:
+		sqls = []string{fmt.Sprintf("ALTER TABLE `%s` DROP KEY `%s`;", op.table.DBName.Name, op.indexName(driver))}
+	case sqlbuilder.Driver_sqlite3, repository.Driver_postgres:
+		sqls = []string{fmt.Sprintf("DROP INDEX IF EXISTS %s;", quote(driver, op.indexName(driver)))}
+	default:
+		return nil, unsupportedDriver(driver)
+	}
+	return sqls, nil
+}
+
+func (op addIndex) Applied(handler sqlbuilder.Handler) (applied bool, err error) {
+	driver := getDriver(handler)
+	var sql string
+	switch driver {
+	case sqlbuilder.Driver_mysql:
+		sql = fmt.Sprintf("SELECT count(*) FROM information_schema.statistics WHERE table_schema=DATABASE() AND table_name='%s' AND index_name='%s';", op.table.DBName.Name, op.indexName(driver))
+	case sqlbuilder.Driver_sqlite3:
+		sql = fmt.Sprintf("SELECT count(*) FROM sqlite_master WHERE type='index' AND name='%s';", op.indexName(driver))
+	case repository.Driver_postgres:
+		sql = fmt.Sprintf("SELECT count(*) FROM pg_indexes WHERE schemaname=current_schema() AND indexname='%s';", op.indexName(driver))
+	default:
+		return false, unsupportedDriver(driver)
+	}
+	return exists(handler, sql)
+}
+
+type dropIndex struct {
+	addIndex addIndex
+}
+
+func (op dropIndex) Up(driver sqlbuilder.Driver) (sqls []string, err error) {
+	return op.addIndex.Down(driver)
+}
+
+func (op dropIndex) Down(driver sqlbuilder.Driver) (sqls []string, err error) {
+	return op.addIndex.Up(driver)
+}
+
+func (op dropIndex) Applied(handler sqlbuilder.Handler) (applied bool, err error) {
+	applied, err = op.addIndex.Applied(handler)
+	if err != nil {
+		return false, err
+	}
+	return !applied, nil
+}
+
+type changeUniqueIndex struct {
+	table      sqlbuilder.TableConfig
+	oldDbNames []string
+	dbNames    []string
+}
+
+// uniqueName 与建表语句的唯一索引命名保持一致：mysql 为 uk_字段，sqlite 为 uk_表_字段，postgres 为自动命名的 表_字段_key
+func (op changeUniqueIndex) uniqueName(driver sqlbuilder.Driver, dbNames []string) string {
+	switch driver {
+	case sqlbuilder.Driver_sqlite3:
+		return sqliteUniqueIndexName(op.table, dbNames)
+	case repository.Driver_postgres:
+		return fmt.Sprintf("%s_%s_key", op.table.DBName.Name, strings.Join(dbNames, "_"))
+	}
+	return fmt.Sprintf("uk_%s", strings.Join(dbNames, "_"))
+}
+
+func (op changeUniqueIndex) sql(driver sqlbuilder.Driver, oldDbNames []string, dbNames []string) (sqls []string, err error) {
+	cols := make([]string, 0, len(dbNames))
+	for _, dbName := range dbNames {
+		cols = append(cols, quote(driver, dbName))
+	}
+	table := quote(driver, op.table.DBName.Name)
+	switch driver {
+	case sqlbuilder.Driver_mysql:
+		sqls = []string{fmt.Sprintf("ALTER TABLE %s DROP KEY `%s`, ADD UNIQUE KEY `%s` (%s);", table, op.uniqueName(driver, oldDbNames), op.uniqueName(driver, dbNames), strings.Join(cols, ","))}
+	case sqlbuilder.Driver_sqlite3:
+		sqls = []string{
+			fmt.Sprintf("DROP INDEX IF EXISTS %s;", quote(driver, op.uniqueName(driver, oldDbNames))),
+			sqliteCreateUniqueIndexSQL(op.table, dbNames),
+		}
+	case repository.Driver_postgres:
+		sqls = []string{fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s, ADD CONSTRAINT %s UNIQUE (%s);", table, quote(driver, op.uniqueName(driver, oldDbNames)), quote(driver, op.uniqueName(driver, dbNames)), strings.Join(cols, ","))}
+	default:
+		return nil, unsupportedDriver(driver)
+	}
+	return sqls, nil
+}
+
+func (op changeUniqueIndex) Up(driver sqlbuilder.Driver) (sqls []string, err error) {
+	return op.sql(driver, op.oldDbNames, op.dbNames)
+}
+
+func (op changeUniqueIndex) Down(driver sqlbuilder.Driver) (sqls []string, err error) {
+	return op.sql(driver, op.dbNames, op.oldDbNames)
+}
+
+// Applied sqlite 早期建表时的唯一约束为内联创建，索引名不固定，按索引字段判断
+func (op changeUniqueIndex) Applied(handler sqlbuilder.Handler) (applied bool, err error) {
+	driver := getDriver(handler)
+	name := op.uniqueName(driver, op.dbNames)
+	var sql string
+	switch driver {
+	case sqlbuilder.Driver_mysql:
+		sql = fmt.Sprintf("SELECT count(*) FROM information_schema.statistics WHERE table_schema=DATABASE() AND table_name='%s' AND index_name='%s';", op.table.DBName.Name, name)
+	case sqlbuilder.Driver_sqlite3:
+		sql = fmt.Sprintf("SELECT count(*) FROM pragma_index_list('%s') il WHERE il.\"unique\"=1 AND (SELECT group_concat(name) FROM pragma_index_info(il.name))='%s';", op.table.DBName.Name, strings.Join(op.dbNames, ","))
+	case repository.Driver_postgres:
+		sql = fmt.Sprintf("SELECT count(*) FROM pg_indexes WHERE schemaname=current_schema() AND tablename='%s' AND indexname='%s';", op.table.DBName.Name, name)
+	default:
+		return false, unsupportedDriver(driver)
+	}
+	return exists(handler, sql)
+}
+
+type backfillOrderTotals struct {
+	orderTable  sqlbuilder.TableConfig
+	recordTable sqlbuilder.TableConfig
+}
+
+func (op backfillOrderTotals) Up(driver sqlbuilder.Driver) (sqls []string, err error) {
+	q := func(name string) string { return quote(driver, name) }
+	orderTable, recordTable := q(op.orderTable.DBName.Name), q(op.recordTable.DBName.Name)
+	paid, pending := repository.PayOrderModel_state_paid.String(), repository.PayOrderModel_state_pending.String()
+	subQuery := func(expr string, cond string) string {
+		return fmt.Sprintf("(SELECT %s FROM %s r WHERE r.%s = %s.%s AND r.%s = %s.%s AND %s)",
+			expr, recordTable,
+			q("Fmerchant_id"), orderTable, q("Fmerchant_id"),
+			q("Forder_id"), orderTable, q("Forder_id"),
+			cond,
+		)
+	}
+	sumAmount := fmt.Sprintf("COALESCE(SUM(r.%s), 0)", q("Fpay_amount"))
+	sql := fmt.Sprintf("UPDATE %s SET %s = %s, %s = %s, %s = %s;",
+		orderTable,
+		q("Fpaid_amount"), subQuery(sumAmount, fmt.Sprintf("r.%s = '%s'", q("Fstate"), paid)),
+		q("Fpending_amount"), subQuery(sumAmount, fmt.Sprintf("r.%s = '%s'", q("Fstate"), pending)),
+		q("Frecord_count"), subQuery("COUNT(*)", fmt.Sprintf("r.%s IN ('%s', '%s')", q("Fstate"), pending, paid)),
+	)
+	return []string{sql}, nil
+}
+
+func (op backfillOrderTotals) Down(driver sqlbuilder.Driver) (sqls []string, err error) {
+	return nil, nil
+}
+
+// Applied 回填可重复执行，始终返回 false
+func (op backfillOrderTotals) Applied(handler sqlbuilder.Handler) (applied bool, err error) {
+	return false, nil
+}
+
+type backfillNetAmount struct {
+	recordTable sqlbuilder.TableConfig
+}
+
+func (op backfillNetAmount) Up(driver sqlbuilder.Driver) (sqls []string, err error) {
+	q := func(name string) string { return quote(driver, name) }
+	sql := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s = '%s' AND %s = 0 AND %s = 0;",
+		q(op.recordTable.DBName.Name),
+		q("Fnet_amount"), q("Fpay_amount"),
+		q("Fstate"), repository.PayOrderModel_state_paid.String(),
+		q("Fnet_amount"), q("Fprovider_fee"),
+	)
+	return []string{sql}, nil
+}
+
+func (op backfillNetAmount) Down(driver sqlbuilder.Driver) (sqls []string, err error) {
+	return nil, nil
+}
+
+// Applied 回填可重复执行，始终返回 false
+func (op backfillNetAmount) Applied(handler sqlbuilder.Handler) (applied bool, err error) {
+	return false, nil
+}
+
+// getDriver 获取数据库驱动，sqlite 的不同驱动名统一为 sqlite3
+func getDriver(handler sqlbuilder.Handler) sqlbuilder.Driver {
+	driver := sqlbuilder.Driver(handler.GetDialector())
+	if strings.HasPrefix(string(driver), "sqlite") {
+		driver = sqlbuilder.Driver_sqlite3
+	}
+	return driver
+}
+
+// quote 表名、字段名加引号，postgres 字段名区分大小写(如 Fid)，必须使用双引号
+func quote(driver sqlbuilder.Driver, name string) string {
+	if driver == repository.Driver_postgres {
+		return postgresQuote(name)
+	}
+	return fmt.Sprintf("`%s`", name)
+}
+
+func exists(handler sqlbuilder.Handler, sql string) (ok bool, err error) {
+	count, err := handler.Count(sql)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func unsupportedDriver(driver sqlbuilder.Driver) error {
+	return errors.Errorf("数据库迁移不支持驱动:%s", driver)
+}
@@ -0,0 +1,122 @@
+package migration
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// postgresCreateTableSQL postgres 建表语句。postgres 无 unsigned，无符号整型以 CHECK 约束代替；
+// 时间字段为 timestamp，不支持 mysql 的 '0000-00-00 00:00:00'，未设置时为 NULL；注释通过 COMMENT ON 单独设置
+func postgresCreateTableSQL(table sqlbuilder.TableConfig) (sqls []string) {
+	var primaryCols []string
+	if primary, ok := table.Indexs.GetPrimary(); ok {
+		primaryCols = primary.GetColumnNames(table)
+	}
+	isAutoIncrement := false
+	lines := make([]string, 0, len(table.Columns)+len(table.Indexs))
+	comments := make([]string, 0, len(table.Columns)+1)
+	if table.Comment != "" {
+		comments = append(comments, fmt.Sprintf("COMMENT ON TABLE %s IS %s;", postgresQuote(table.DBName.Name), postgresLiteral(table.Comment)))
+	}
+	for _, col := range table.Columns {
+		col = col.CopyFieldSchemaIfEmpty()
+		if len(primaryCols) == 1 && primaryCols[0] == col.DbName { // 与 sqlbuilder 保持一致，单字段主键为自增主键
+			col.AutoIncrement = true
+			isAutoIncrement = true
+		}
+		lines = append(lines, postgresColumnDDL(col))
+		if comment := postgresColumnComment(table, col); comment != "" {
+			comments = append(comments, comment)
+		}
+	}
+	for _, index := range table.Indexs {
+		if index.IsPrimary && isAutoIncrement { // 自增主键已定义在字段上
+			continue
+		}
+		cols := postgresQuoteAll(index.GetColumnNames(table))
+		switch {
+		case index.IsPrimary:
+			lines = append(lines, fmt.Sprintf("  PRIMARY KEY (%s)", cols))
+		case index.Unique:
+			lines = append(lines, fmt.Sprintf("  UNIQUE (%s)", cols))
+		}
+	}
+	sqls = append(sqls, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n%s\n);", postgresQuote(table.DBName.Name), strings.Join(lines, ",\n")))
+	sqls = append(sqls, comments...)
+	for _, index := range table.Indexs { // 普通索引需单独创建
+		if index.IsPrimary || index.Unique {
+			continue
+		}
+		sqls = append(sqls, addIndex{table: table, dbNames: index.GetColumnNames(table)}.createSQL(repository.Driver_postgres))
+	}
+	return sqls
+}
+
+// postgresColumnDDL 字段定义，除时间字段外均为 NOT NULL 并带默认值，与 mysql 保持一致
+func postgresColumnDDL(col sqlbuilder.ColumnConfig) (ddl string) {
+	name := postgresQuote(col.DbName)
+	if col.AutoIncrement {
+		return fmt.Sprintf("  %s BIGSERIAL PRIMARY KEY", name)
+	}
+	if col.Enums != nil {
+		col.Type = sqlbuilder.SchemaType(col.Enums.Type())
+		maxLength, maximum := col.Enums.MaxLengthMaximum()
+		col.Length = max(maxLength, int(maximum))
+		col.Default = col.Enums.Default().Key
+	}
+	switch {
+	case col.Tags.HastTag(sqlbuilder.Tag_createdAt), col.Tags.HastTag(sqlbuilder.Tag_updatedAt):
+		return fmt.Sprintf("  %s TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP", name)
+	case col.Tags.HastTag(sqlbuilder.Tag_datetime):
+		return fmt.Sprintf("  %s TIMESTAMP(0) NULL", name)
+	}
+	switch col.Type {
+	case sqlbuilder.Schema_Type_int:
+		typ := "INTEGER"
+		if col.Maximum == 0 || col.Maximum > math.MaxInt32 {
+			typ = "BIGINT"
+		}
+		ddl = fmt.Sprintf("  %s %s NOT NULL DEFAULT %d", name, typ, cast.ToInt64(col.Default))
+		if col.Unsigned || col.Tags.HastTag(sqlbuilder.Tag_unsigned) {
+			ddl = fmt.Sprintf("%s CHECK (%s >= 0)", ddl, name)
+		}
+		return ddl
+	default:
+		typ := "TEXT"
+		if col.Length == 0 {
+			col.Length = 255 // 与 mysql 默认长度一致
+		}
+		if col.Length <= math.MaxUint16 {
+			typ = fmt.Sprintf("VARCHAR(%d)", col.Length)
+		}
+		return fmt.Sprintf("  %s %s NOT NULL DEFAULT %s", name, typ, postgresLiteral(cast.ToString(col.Default)))
+	}
+}
+
+func postgresColumnComment(table sqlbuilder.TableConfig, col sqlbuilder.ColumnConfig) (sql string) {
+	if col.Comment == "" {
+		return ""
+	}
+	return fmt.Sprintf("COMMENT ON COLUMN %s.%s IS %s;", postgresQuote(table.DBName.Name), postgresQuote(col.DbName), postgresLiteral(col.Comment))
+}
+
+func postgresQuote(name string) string {
+	return fmt.Sprintf(`"%s"`, strings.ReplaceAll(name, `"`, `""`))
+}
+
+func postgresQuoteAll(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, postgresQuote(name))
+	}
+	return strings.Join(quoted, ",")
+}
+
+func postgresLiteral(val string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(val, "'", "''"))
+}
@@ -0,0 +1,88 @@
+package migration
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// sqliteCreateTableSQL sqlite 建表语句。sqlbuilder 生成的 sqlite 字段均可为空且无默认值，插入时未赋值的字段为 NULL，
+// 与 mysql 中非空且默认空字符串的字段查询结果不一致(如按 Fprovider_trade_no 为空查询时匹配不到)，这里按 mysql 的语义生成字段定义
+func sqliteCreateTableSQL(table sqlbuilder.TableConfig) (sqls []string) {
+	var primaryCols []string
+	if primary, ok := table.Indexs.GetPrimary(); ok {
+		primaryCols = primary.GetColumnNames(table)
+	}
+	isAutoIncrement := false
+	lines := make([]string, 0, len(table.Columns)+len(table.Indexs))
+	for _, col := range table.Columns {
+		col = col.CopyFieldSchemaIfEmpty()
+		if len(primaryCols) == 1 && primaryCols[0] == col.DbName { // 与 sqlbuilder 保持一致，单字段主键为自增主键
+			col.AutoIncrement = true
+			isAutoIncrement = true
+		}
+		lines = append(lines, sqliteColumnDDL(col, false))
+	}
+	for _, index := range table.Indexs {
+		if index.IsPrimary && isAutoIncrement { // 自增主键已定义在字段上
+			continue
+		}
+		if !index.IsPrimary { // 唯一索引单独创建，内联的唯一约束无法删除，迁移时无法调整
+			continue
+		}
+		if ddl := sqlbuilder.Index2DDLSQLitePrimaryAndUniqueIndex(index, table); strings.TrimSpace(ddl) != "" {
+			lines = append(lines, ddl)
+		}
+	}
+	sqls = append(sqls, fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` (\n%s\n);", table.DBName.Name, strings.Join(lines, ",\n")))
+	for _, index := range table.Indexs { // sqlite 普通索引需单独创建
+		switch {
+		case index.IsPrimary:
+		case index.Unique:
+			sqls = append(sqls, sqliteCreateUniqueIndexSQL(table, index.GetColumnNames(table)))
+		default:
+			sqls = append(sqls, addIndex{table: table, dbNames: index.GetColumnNames(table)}.createSQL(sqlbuilder.Driver_sqlite3))
+		}
+	}
+	return sqls
+}
+
+// sqliteUniqueIndexName sqlite 索引名在库内唯一，加表名区分
+func sqliteUniqueIndexName(table sqlbuilder.TableConfig, dbNames []string) string {
+	return fmt.Sprintf("uk_%s_%s", table.DBName.Name, strings.Join(dbNames, "_"))
+}
+
+func sqliteCreateUniqueIndexSQL(table sqlbuilder.TableConfig, dbNames []string) string {
+	return fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS `%s` ON `%s` (`%s`);", sqliteUniqueIndexName(table, dbNames), table.DBName.Name, strings.Join(dbNames, "`,`"))
+}
+
+// sqliteColumnDDL 字段定义，非自增字段均为 NOT NULL 并带默认值。
+// sqlite 加字段时默认值必须为常量，alter 为 true 时创建时间等字段不使用 CURRENT_TIMESTAMP
+func sqliteColumnDDL(col sqlbuilder.ColumnConfig, alter bool) (ddl string) {
+	if col.AutoIncrement {
+		return fmt.Sprintf("  `%s` INTEGER PRIMARY KEY AUTOINCREMENT", col.DbName)
+	}
+	if col.Enums != nil {
+		col.Type = sqlbuilder.SchemaType(col.Enums.Type())
+		col.Default = col.Enums.Default().Key
+	}
+	typ, defaul := "TEXT", ""
+	switch col.Type {
+	case sqlbuilder.Schema_Type_int:
+		typ, defaul = "INTEGER", cast.ToString(cast.ToInt64(col.Default))
+	default:
+		defaul = fmt.Sprintf("'%s'", strings.ReplaceAll(cast.ToString(col.Default), "'", "''"))
+	}
+	if col.Tags.HastTag(sqlbuilder.Tag_createdAt) || col.Tags.HastTag(sqlbuilder.Tag_updatedAt) {
+		typ = "DATETIME"
+		if !alter {
+			defaul = "CURRENT_TIMESTAMP"
+		}
+	} else if col.Tags.HastTag(sqlbuilder.Tag_datetime) {
+		typ = "DATETIME"
+	}
+	ddl = fmt.Sprintf("  `%s` %s NOT NULL DEFAULT %s", col.DbName, typ, defaul)
+	return ddl
+}
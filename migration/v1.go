@@ -0,0 +1,555 @@
+package migration
+
+import (
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// createTablesV1 版本1的建表语句。语句为发布时按表配置生成的结果，此后表结构的变化由后续版本完成，
+// 这里不能再修改，否则新库与存量库执行同一版本得到的表结构不一致
+var createTablesV1 = []Operation{
+	CreateTableSQL("pay_order", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS pay_order (
+  Fid bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Forder_id varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  Forder_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '订单总金额，单位分',
+  Fstate varchar(14) NOT NULL DEFAULT '' COMMENT '支付状态',
+  Fuser_id varchar(64) NOT NULL DEFAULT '' COMMENT '用户ID',
+  Fremark varchar(255) NOT NULL DEFAULT '' COMMENT '支付备注(如失败原因)',
+  Fexpire int(11) unsigned NOT NULL DEFAULT '0' COMMENT '超时时间，单分钟',
+  Forder_type varchar(10) NOT NULL DEFAULT '' COMMENT '订单类型',
+  Funit_price bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '单价，单位分',
+  Fcapacity int(11) unsigned NOT NULL DEFAULT '0' COMMENT '名额上限，0表示不限',
+  Fdeadline datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '截止时间',
+  Fcreated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  Fpaid_at datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '支付时间',
+  Fclosed_at datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '关单时间',
+  PRIMARY KEY (Fid),
+  UNIQUE KEY uk_Forder_id (Forder_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='收款单表';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "pay_order" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Forder_id" TEXT NOT NULL DEFAULT '',
+  "Forder_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fstate" TEXT NOT NULL DEFAULT '',
+  "Fuser_id" TEXT NOT NULL DEFAULT '',
+  "Fremark" TEXT NOT NULL DEFAULT '',
+  "Fexpire" INTEGER NOT NULL DEFAULT 0,
+  "Forder_type" TEXT NOT NULL DEFAULT '',
+  "Funit_price" INTEGER NOT NULL DEFAULT 0,
+  "Fcapacity" INTEGER NOT NULL DEFAULT 0,
+  "Fdeadline" DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00',
+  "Fcreated_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Fpaid_at" DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00',
+  "Fclosed_at" DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00'
+);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "uk_pay_order_Forder_id" ON "pay_order" ("Forder_id");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "pay_order" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Forder_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Forder_amount" >= 0),
+  "Fstate" VARCHAR(14) NOT NULL DEFAULT '',
+  "Fuser_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fremark" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fexpire" BIGINT NOT NULL DEFAULT 0 CHECK ("Fexpire" >= 0),
+  "Forder_type" VARCHAR(10) NOT NULL DEFAULT '',
+  "Funit_price" BIGINT NOT NULL DEFAULT 0 CHECK ("Funit_price" >= 0),
+  "Fcapacity" BIGINT NOT NULL DEFAULT 0 CHECK ("Fcapacity" >= 0),
+  "Fdeadline" TIMESTAMP(0) NULL,
+  "Fcreated_at" TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Fpaid_at" TIMESTAMP(0) NULL,
+  "Fclosed_at" TIMESTAMP(0) NULL,
+  UNIQUE ("Forder_id")
+);`,
+			`COMMENT ON TABLE "pay_order" IS '收款单表';`,
+			`COMMENT ON COLUMN "pay_order"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "pay_order"."Forder_id" IS '订单号';`,
+			`COMMENT ON COLUMN "pay_order"."Forder_amount" IS '订单总金额，单位分';`,
+			`COMMENT ON COLUMN "pay_order"."Fstate" IS '支付状态';`,
+			`COMMENT ON COLUMN "pay_order"."Fuser_id" IS '用户ID';`,
+			`COMMENT ON COLUMN "pay_order"."Fremark" IS '支付备注(如失败原因)';`,
+			`COMMENT ON COLUMN "pay_order"."Fexpire" IS '超时时间，单分钟';`,
+			`COMMENT ON COLUMN "pay_order"."Forder_type" IS '订单类型';`,
+			`COMMENT ON COLUMN "pay_order"."Funit_price" IS '单价，单位分';`,
+			`COMMENT ON COLUMN "pay_order"."Fcapacity" IS '名额上限，0表示不限';`,
+			`COMMENT ON COLUMN "pay_order"."Fdeadline" IS '截止时间';`,
+			`COMMENT ON COLUMN "pay_order"."Fcreated_at" IS '创建时间';`,
+			`COMMENT ON COLUMN "pay_order"."Fpaid_at" IS '支付时间';`,
+			`COMMENT ON COLUMN "pay_order"."Fclosed_at" IS '关单时间';`,
+		},
+	}),
+	CreateTableSQL("pay_record", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS pay_record (
+  Fid bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Fpay_id varchar(64) NOT NULL DEFAULT '' COMMENT '支付流水号',
+  Forder_id varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  Forder_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '订单总金额，单位分',
+  Fpay_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '支付金额，单位分',
+  Fpay_agent varchar(12) NOT NULL DEFAULT '' COMMENT '支付类型',
+  Frecipient_account varchar(64) NOT NULL DEFAULT '' COMMENT '收款人账号',
+  Frecipient_name varchar(64) NOT NULL DEFAULT '' COMMENT '收款人名称',
+  Fpayment_account varchar(64) NOT NULL DEFAULT '' COMMENT '付款人账号',
+  Fpayment_name varchar(64) NOT NULL DEFAULT '' COMMENT '付款人名称',
+  Fstate varchar(14) NOT NULL DEFAULT '' COMMENT '支付状态',
+  Fuser_id varchar(64) NOT NULL DEFAULT '' COMMENT '用户ID',
+  Fclient_ip varchar(20) NOT NULL DEFAULT '' COMMENT '客户端IP地址',
+  Fpay_url varchar(255) NOT NULL DEFAULT '' COMMENT '支付链接地址',
+  Freturn_url varchar(255) NOT NULL DEFAULT '' COMMENT '支付完成后的回调地址',
+  Fnotify_url varchar(255) NOT NULL DEFAULT '' COMMENT '支付完成后的通知地址',
+  Fpay_param varchar(255) NOT NULL DEFAULT '' COMMENT '支付参数，json格式',
+  Fremark varchar(255) NOT NULL DEFAULT '' COMMENT '支付备注(如失败原因)',
+  Fexpire int(11) unsigned NOT NULL DEFAULT '0' COMMENT '超时时间，单分钟',
+  Fcreated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  Fpaid_at datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '支付时间',
+  Fclosed_at datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '关单时间',
+  Fexpired_at datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '过期时间',
+  Ffailed_at datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '失败时间',
+  Fprovider_trade_no varchar(64) NOT NULL DEFAULT '' COMMENT '支付渠道交易号',
+  PRIMARY KEY (Fid),
+  UNIQUE KEY uk_Fpay_id (Fpay_id),
+  KEY ik_Forder_id (Forder_id),
+  KEY ik_Fprovider_trade_no (Fprovider_trade_no)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='收款记录表';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "pay_record" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Fpay_id" TEXT NOT NULL DEFAULT '',
+  "Forder_id" TEXT NOT NULL DEFAULT '',
+  "Forder_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fpay_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fpay_agent" TEXT NOT NULL DEFAULT '',
+  "Frecipient_account" TEXT NOT NULL DEFAULT '',
+  "Frecipient_name" TEXT NOT NULL DEFAULT '',
+  "Fpayment_account" TEXT NOT NULL DEFAULT '',
+  "Fpayment_name" TEXT NOT NULL DEFAULT '',
+  "Fstate" TEXT NOT NULL DEFAULT '',
+  "Fuser_id" TEXT NOT NULL DEFAULT '',
+  "Fclient_ip" TEXT NOT NULL DEFAULT '',
+  "Fpay_url" TEXT NOT NULL DEFAULT '',
+  "Freturn_url" TEXT NOT NULL DEFAULT '',
+  "Fnotify_url" TEXT NOT NULL DEFAULT '',
+  "Fpay_param" TEXT NOT NULL DEFAULT '',
+  "Fremark" TEXT NOT NULL DEFAULT '',
+  "Fexpire" INTEGER NOT NULL DEFAULT 0,
+  "Fcreated_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Fpaid_at" DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00',
+  "Fclosed_at" DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00',
+  "Fexpired_at" DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00',
+  "Ffailed_at" DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00',
+  "Fprovider_trade_no" TEXT NOT NULL DEFAULT ''
+);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "uk_pay_record_Fpay_id" ON "pay_record" ("Fpay_id");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Forder_id" ON "pay_record" ("Forder_id");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Fprovider_trade_no" ON "pay_record" ("Fprovider_trade_no");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "pay_record" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Fpay_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Forder_amount" >= 0),
+  "Fpay_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Fpay_amount" >= 0),
+  "Fpay_agent" VARCHAR(12) NOT NULL DEFAULT '',
+  "Frecipient_account" VARCHAR(64) NOT NULL DEFAULT '',
+  "Frecipient_name" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fpayment_account" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fpayment_name" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fstate" VARCHAR(14) NOT NULL DEFAULT '',
+  "Fuser_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fclient_ip" VARCHAR(20) NOT NULL DEFAULT '',
+  "Fpay_url" VARCHAR(255) NOT NULL DEFAULT '',
+  "Freturn_url" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fnotify_url" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fpay_param" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fremark" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fexpire" BIGINT NOT NULL DEFAULT 0 CHECK ("Fexpire" >= 0),
+  "Fcreated_at" TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Fpaid_at" TIMESTAMP(0) NULL,
+  "Fclosed_at" TIMESTAMP(0) NULL,
+  "Fexpired_at" TIMESTAMP(0) NULL,
+  "Ffailed_at" TIMESTAMP(0) NULL,
+  "Fprovider_trade_no" VARCHAR(64) NOT NULL DEFAULT '',
+  UNIQUE ("Fpay_id")
+);`,
+			`COMMENT ON TABLE "pay_record" IS '收款记录表';`,
+			`COMMENT ON COLUMN "pay_record"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "pay_record"."Fpay_id" IS '支付流水号';`,
+			`COMMENT ON COLUMN "pay_record"."Forder_id" IS '订单号';`,
+			`COMMENT ON COLUMN "pay_record"."Forder_amount" IS '订单总金额，单位分';`,
+			`COMMENT ON COLUMN "pay_record"."Fpay_amount" IS '支付金额，单位分';`,
+			`COMMENT ON COLUMN "pay_record"."Fpay_agent" IS '支付类型';`,
+			`COMMENT ON COLUMN "pay_record"."Frecipient_account" IS '收款人账号';`,
+			`COMMENT ON COLUMN "pay_record"."Frecipient_name" IS '收款人名称';`,
+			`COMMENT ON COLUMN "pay_record"."Fpayment_account" IS '付款人账号';`,
+			`COMMENT ON COLUMN "pay_record"."Fpayment_name" IS '付款人名称';`,
+			`COMMENT ON COLUMN "pay_record"."Fstate" IS '支付状态';`,
+			`COMMENT ON COLUMN "pay_record"."Fuser_id" IS '用户ID';`,
+			`COMMENT ON COLUMN "pay_record"."Fclient_ip" IS '客户端IP地址';`,
+			`COMMENT ON COLUMN "pay_record"."Fpay_url" IS '支付链接地址';`,
+			`COMMENT ON COLUMN "pay_record"."Freturn_url" IS '支付完成后的回调地址';`,
+			`COMMENT ON COLUMN "pay_record"."Fnotify_url" IS '支付完成后的通知地址';`,
+			`COMMENT ON COLUMN "pay_record"."Fpay_param" IS '支付参数，json格式';`,
+			`COMMENT ON COLUMN "pay_record"."Fremark" IS '支付备注(如失败原因)';`,
+			`COMMENT ON COLUMN "pay_record"."Fexpire" IS '超时时间，单分钟';`,
+			`COMMENT ON COLUMN "pay_record"."Fcreated_at" IS '创建时间';`,
+			`COMMENT ON COLUMN "pay_record"."Fpaid_at" IS '支付时间';`,
+			`COMMENT ON COLUMN "pay_record"."Fclosed_at" IS '关单时间';`,
+			`COMMENT ON COLUMN "pay_record"."Fexpired_at" IS '过期时间';`,
+			`COMMENT ON COLUMN "pay_record"."Ffailed_at" IS '失败时间';`,
+			`COMMENT ON COLUMN "pay_record"."Fprovider_trade_no" IS '支付渠道交易号';`,
+			`CREATE INDEX IF NOT EXISTS "ik_pay_record_Forder_id" ON "pay_record" ("Forder_id");`,
+			`CREATE INDEX IF NOT EXISTS "ik_pay_record_Fprovider_trade_no" ON "pay_record" ("Fprovider_trade_no");`,
+		},
+	}),
+	CreateTableSQL("pay_split", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS pay_split (
+  Fid bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Fsplit_id varchar(80) NOT NULL DEFAULT '' COMMENT '分账流水号',
+  Fpay_id varchar(64) NOT NULL DEFAULT '' COMMENT '支付流水号',
+  Forder_id varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  Frecipient_account varchar(64) NOT NULL DEFAULT '' COMMENT '收款人账号',
+  Frecipient_name varchar(64) NOT NULL DEFAULT '' COMMENT '收款人名称',
+  Fsplit_role varchar(32) NOT NULL DEFAULT '' COMMENT '分账角色(如卖家、平台服务费、物流)',
+  Fsplit_rate smallint(6) unsigned NOT NULL DEFAULT '0' COMMENT '分账比例，万分比，0表示按固定金额分账',
+  Fsplit_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '分账金额，单位分',
+  Frefund_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '已退款金额，单位分',
+  Fstate varchar(20) NOT NULL DEFAULT '' COMMENT '分账状态',
+  Fcreated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  Fsettled_at datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '结算时间',
+  PRIMARY KEY (Fid),
+  UNIQUE KEY uk_Fsplit_id (Fsplit_id),
+  KEY ik_Fpay_id (Fpay_id),
+  KEY ik_Frecipient_account (Frecipient_account)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='支付分账表';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "pay_split" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Fsplit_id" TEXT NOT NULL DEFAULT '',
+  "Fpay_id" TEXT NOT NULL DEFAULT '',
+  "Forder_id" TEXT NOT NULL DEFAULT '',
+  "Frecipient_account" TEXT NOT NULL DEFAULT '',
+  "Frecipient_name" TEXT NOT NULL DEFAULT '',
+  "Fsplit_role" TEXT NOT NULL DEFAULT '',
+  "Fsplit_rate" INTEGER NOT NULL DEFAULT 0,
+  "Fsplit_amount" INTEGER NOT NULL DEFAULT 0,
+  "Frefund_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fstate" TEXT NOT NULL DEFAULT '',
+  "Fcreated_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Fsettled_at" DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00'
+);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "uk_pay_split_Fsplit_id" ON "pay_split" ("Fsplit_id");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Fpay_id" ON "pay_split" ("Fpay_id");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Frecipient_account" ON "pay_split" ("Frecipient_account");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "pay_split" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Fsplit_id" VARCHAR(80) NOT NULL DEFAULT '',
+  "Fpay_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Frecipient_account" VARCHAR(64) NOT NULL DEFAULT '',
+  "Frecipient_name" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fsplit_role" VARCHAR(32) NOT NULL DEFAULT '',
+  "Fsplit_rate" INTEGER NOT NULL DEFAULT 0 CHECK ("Fsplit_rate" >= 0),
+  "Fsplit_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Fsplit_amount" >= 0),
+  "Frefund_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Frefund_amount" >= 0),
+  "Fstate" VARCHAR(20) NOT NULL DEFAULT '',
+  "Fcreated_at" TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Fsettled_at" TIMESTAMP(0) NULL,
+  UNIQUE ("Fsplit_id")
+);`,
+			`COMMENT ON TABLE "pay_split" IS '支付分账表';`,
+			`COMMENT ON COLUMN "pay_split"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "pay_split"."Fsplit_id" IS '分账流水号';`,
+			`COMMENT ON COLUMN "pay_split"."Fpay_id" IS '支付流水号';`,
+			`COMMENT ON COLUMN "pay_split"."Forder_id" IS '订单号';`,
+			`COMMENT ON COLUMN "pay_split"."Frecipient_account" IS '收款人账号';`,
+			`COMMENT ON COLUMN "pay_split"."Frecipient_name" IS '收款人名称';`,
+			`COMMENT ON COLUMN "pay_split"."Fsplit_role" IS '分账角色(如卖家、平台服务费、物流)';`,
+			`COMMENT ON COLUMN "pay_split"."Fsplit_rate" IS '分账比例，万分比，0表示按固定金额分账';`,
+			`COMMENT ON COLUMN "pay_split"."Fsplit_amount" IS '分账金额，单位分';`,
+			`COMMENT ON COLUMN "pay_split"."Frefund_amount" IS '已退款金额，单位分';`,
+			`COMMENT ON COLUMN "pay_split"."Fstate" IS '分账状态';`,
+			`COMMENT ON COLUMN "pay_split"."Fcreated_at" IS '创建时间';`,
+			`COMMENT ON COLUMN "pay_split"."Fsettled_at" IS '结算时间';`,
+			`CREATE INDEX IF NOT EXISTS "ik_pay_split_Fpay_id" ON "pay_split" ("Fpay_id");`,
+			`CREATE INDEX IF NOT EXISTS "ik_pay_split_Frecipient_account" ON "pay_split" ("Frecipient_account");`,
+		},
+	}),
+	CreateTableSQL("pay_order_adjustment", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS pay_order_adjustment (
+  Fid bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Forder_id varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  Fold_order_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '调整前订单金额，单位分',
+  Forder_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '订单总金额，单位分',
+  Fpaid_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '已支付金额，单位分',
+  Frefund_due_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '应退款金额，单位分',
+  Fclosed_pay_ids varchar(255) NOT NULL DEFAULT '' COMMENT '调整时关闭的支付流水号，逗号分隔',
+  Fremark varchar(255) NOT NULL DEFAULT '' COMMENT '支付备注(如失败原因)',
+  Fcreated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  PRIMARY KEY (Fid),
+  KEY ik_Forder_id (Forder_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='订单金额调整记录';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "pay_order_adjustment" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Forder_id" TEXT NOT NULL DEFAULT '',
+  "Fold_order_amount" INTEGER NOT NULL DEFAULT 0,
+  "Forder_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fpaid_amount" INTEGER NOT NULL DEFAULT 0,
+  "Frefund_due_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fclosed_pay_ids" TEXT NOT NULL DEFAULT '',
+  "Fremark" TEXT NOT NULL DEFAULT '',
+  "Fcreated_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`,
+			`CREATE INDEX IF NOT EXISTS "idx_Forder_id" ON "pay_order_adjustment" ("Forder_id");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "pay_order_adjustment" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Forder_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fold_order_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Fold_order_amount" >= 0),
+  "Forder_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Forder_amount" >= 0),
+  "Fpaid_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Fpaid_amount" >= 0),
+  "Frefund_due_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Frefund_due_amount" >= 0),
+  "Fclosed_pay_ids" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fremark" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fcreated_at" TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`,
+			`COMMENT ON TABLE "pay_order_adjustment" IS '订单金额调整记录';`,
+			`COMMENT ON COLUMN "pay_order_adjustment"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "pay_order_adjustment"."Forder_id" IS '订单号';`,
+			`COMMENT ON COLUMN "pay_order_adjustment"."Fold_order_amount" IS '调整前订单金额，单位分';`,
+			`COMMENT ON COLUMN "pay_order_adjustment"."Forder_amount" IS '订单总金额，单位分';`,
+			`COMMENT ON COLUMN "pay_order_adjustment"."Fpaid_amount" IS '已支付金额，单位分';`,
+			`COMMENT ON COLUMN "pay_order_adjustment"."Frefund_due_amount" IS '应退款金额，单位分';`,
+			`COMMENT ON COLUMN "pay_order_adjustment"."Fclosed_pay_ids" IS '调整时关闭的支付流水号，逗号分隔';`,
+			`COMMENT ON COLUMN "pay_order_adjustment"."Fremark" IS '支付备注(如失败原因)';`,
+			`COMMENT ON COLUMN "pay_order_adjustment"."Fcreated_at" IS '创建时间';`,
+			`CREATE INDEX IF NOT EXISTS "ik_pay_order_adjustment_Forder_id" ON "pay_order_adjustment" ("Forder_id");`,
+		},
+	}),
+	CreateTableSQL("overpayment", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS overpayment (
+  Fid bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Fpay_id varchar(64) NOT NULL DEFAULT '' COMMENT '支付流水号',
+  Forder_id varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  Foverpayment_type varchar(18) NOT NULL DEFAULT '' COMMENT '超付类型',
+  Fprovider_trade_no varchar(64) NOT NULL DEFAULT '' COMMENT '支付渠道交易号',
+  Forder_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '订单总金额，单位分',
+  Fpaid_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '已支付金额，单位分',
+  Foverpaid_amount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '超付金额，单位分',
+  Fstate varchar(16) NOT NULL DEFAULT '' COMMENT '处理状态',
+  Fremark varchar(255) NOT NULL DEFAULT '' COMMENT '支付备注(如失败原因)',
+  Fcreated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  Frefunded_at datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '退款时间',
+  PRIMARY KEY (Fid),
+  KEY ik_Forder_id (Forder_id),
+  KEY ik_Fpay_id (Fpay_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='超付记录';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "overpayment" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Fpay_id" TEXT NOT NULL DEFAULT '',
+  "Forder_id" TEXT NOT NULL DEFAULT '',
+  "Foverpayment_type" TEXT NOT NULL DEFAULT '',
+  "Fprovider_trade_no" TEXT NOT NULL DEFAULT '',
+  "Forder_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fpaid_amount" INTEGER NOT NULL DEFAULT 0,
+  "Foverpaid_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fstate" TEXT NOT NULL DEFAULT '',
+  "Fremark" TEXT NOT NULL DEFAULT '',
+  "Fcreated_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Frefunded_at" DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00'
+);`,
+			`CREATE INDEX IF NOT EXISTS "idx_Forder_id" ON "overpayment" ("Forder_id");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Fpay_id" ON "overpayment" ("Fpay_id");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "overpayment" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Fpay_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Foverpayment_type" VARCHAR(18) NOT NULL DEFAULT '',
+  "Fprovider_trade_no" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Forder_amount" >= 0),
+  "Fpaid_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Fpaid_amount" >= 0),
+  "Foverpaid_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Foverpaid_amount" >= 0),
+  "Fstate" VARCHAR(16) NOT NULL DEFAULT '',
+  "Fremark" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fcreated_at" TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Frefunded_at" TIMESTAMP(0) NULL
+);`,
+			`COMMENT ON TABLE "overpayment" IS '超付记录';`,
+			`COMMENT ON COLUMN "overpayment"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "overpayment"."Fpay_id" IS '支付流水号';`,
+			`COMMENT ON COLUMN "overpayment"."Forder_id" IS '订单号';`,
+			`COMMENT ON COLUMN "overpayment"."Foverpayment_type" IS '超付类型';`,
+			`COMMENT ON COLUMN "overpayment"."Fprovider_trade_no" IS '支付渠道交易号';`,
+			`COMMENT ON COLUMN "overpayment"."Forder_amount" IS '订单总金额，单位分';`,
+			`COMMENT ON COLUMN "overpayment"."Fpaid_amount" IS '已支付金额，单位分';`,
+			`COMMENT ON COLUMN "overpayment"."Foverpaid_amount" IS '超付金额，单位分';`,
+			`COMMENT ON COLUMN "overpayment"."Fstate" IS '处理状态';`,
+			`COMMENT ON COLUMN "overpayment"."Fremark" IS '支付备注(如失败原因)';`,
+			`COMMENT ON COLUMN "overpayment"."Fcreated_at" IS '创建时间';`,
+			`COMMENT ON COLUMN "overpayment"."Frefunded_at" IS '退款时间';`,
+			`CREATE INDEX IF NOT EXISTS "ik_overpayment_Forder_id" ON "overpayment" ("Forder_id");`,
+			`CREATE INDEX IF NOT EXISTS "ik_overpayment_Fpay_id" ON "overpayment" ("Fpay_id");`,
+		},
+	}),
+	CreateTableSQL("ledger_account", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS ledger_account (
+  Fid bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Faccount_code varchar(64) NOT NULL DEFAULT '' COMMENT '科目编码',
+  Faccount_name varchar(64) NOT NULL DEFAULT '' COMMENT '科目名称',
+  Faccount_type varchar(18) NOT NULL DEFAULT '' COMMENT '科目类型',
+  Fcreated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  PRIMARY KEY (Fid),
+  UNIQUE KEY uk_Faccount_code (Faccount_code)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='会计科目表';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "ledger_account" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Faccount_code" TEXT NOT NULL DEFAULT '',
+  "Faccount_name" TEXT NOT NULL DEFAULT '',
+  "Faccount_type" TEXT NOT NULL DEFAULT '',
+  "Fcreated_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "uk_ledger_account_Faccount_code" ON "ledger_account" ("Faccount_code");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "ledger_account" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Faccount_code" VARCHAR(64) NOT NULL DEFAULT '',
+  "Faccount_name" VARCHAR(64) NOT NULL DEFAULT '',
+  "Faccount_type" VARCHAR(18) NOT NULL DEFAULT '',
+  "Fcreated_at" TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE ("Faccount_code")
+);`,
+			`COMMENT ON TABLE "ledger_account" IS '会计科目表';`,
+			`COMMENT ON COLUMN "ledger_account"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "ledger_account"."Faccount_code" IS '科目编码';`,
+			`COMMENT ON COLUMN "ledger_account"."Faccount_name" IS '科目名称';`,
+			`COMMENT ON COLUMN "ledger_account"."Faccount_type" IS '科目类型';`,
+			`COMMENT ON COLUMN "ledger_account"."Fcreated_at" IS '创建时间';`,
+		},
+	}),
+	CreateTableSQL("ledger_entry", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS ledger_entry (
+  Fid bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Fentry_id varchar(128) NOT NULL DEFAULT '' COMMENT '凭证号',
+  Fbiz_type varchar(12) NOT NULL DEFAULT '' COMMENT '业务类型',
+  Fbiz_id varchar(64) NOT NULL DEFAULT '' COMMENT '业务单号(如支付流水号)',
+  Forder_id varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  Fremark varchar(255) NOT NULL DEFAULT '' COMMENT '摘要',
+  Fposted_at datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '记账时间',
+  PRIMARY KEY (Fid),
+  UNIQUE KEY uk_Fentry_id (Fentry_id),
+  KEY ik_Fbiz_id (Fbiz_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='记账凭证表';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "ledger_entry" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Fentry_id" TEXT NOT NULL DEFAULT '',
+  "Fbiz_type" TEXT NOT NULL DEFAULT '',
+  "Fbiz_id" TEXT NOT NULL DEFAULT '',
+  "Forder_id" TEXT NOT NULL DEFAULT '',
+  "Fremark" TEXT NOT NULL DEFAULT '',
+  "Fposted_at" DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00'
+);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "uk_ledger_entry_Fentry_id" ON "ledger_entry" ("Fentry_id");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Fbiz_id" ON "ledger_entry" ("Fbiz_id");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "ledger_entry" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Fentry_id" VARCHAR(128) NOT NULL DEFAULT '',
+  "Fbiz_type" VARCHAR(12) NOT NULL DEFAULT '',
+  "Fbiz_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fremark" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fposted_at" TIMESTAMP(0) NULL,
+  UNIQUE ("Fentry_id")
+);`,
+			`COMMENT ON TABLE "ledger_entry" IS '记账凭证表';`,
+			`COMMENT ON COLUMN "ledger_entry"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "ledger_entry"."Fentry_id" IS '凭证号';`,
+			`COMMENT ON COLUMN "ledger_entry"."Fbiz_type" IS '业务类型';`,
+			`COMMENT ON COLUMN "ledger_entry"."Fbiz_id" IS '业务单号(如支付流水号)';`,
+			`COMMENT ON COLUMN "ledger_entry"."Forder_id" IS '订单号';`,
+			`COMMENT ON COLUMN "ledger_entry"."Fremark" IS '摘要';`,
+			`COMMENT ON COLUMN "ledger_entry"."Fposted_at" IS '记账时间';`,
+			`CREATE INDEX IF NOT EXISTS "ik_ledger_entry_Fbiz_id" ON "ledger_entry" ("Fbiz_id");`,
+		},
+	}),
+	CreateTableSQL("ledger_posting", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS ledger_posting (
+  Fid bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Fentry_id varchar(128) NOT NULL DEFAULT '' COMMENT '凭证号',
+  Faccount_code varchar(64) NOT NULL DEFAULT '' COMMENT '科目编码',
+  Forder_id varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  Fdirection varchar(12) NOT NULL DEFAULT '' COMMENT '借贷方向',
+  Famount bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '金额，单位分',
+  Fposted_at datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '记账时间',
+  PRIMARY KEY (Fid),
+  KEY ik_Fentry_id (Fentry_id),
+  KEY ik_Faccount_code_Fposted_at (Faccount_code,Fposted_at),
+  KEY ik_Forder_id (Forder_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT='记账分录表';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "ledger_posting" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Fentry_id" TEXT NOT NULL DEFAULT '',
+  "Faccount_code" TEXT NOT NULL DEFAULT '',
+  "Forder_id" TEXT NOT NULL DEFAULT '',
+  "Fdirection" TEXT NOT NULL DEFAULT '',
+  "Famount" INTEGER NOT NULL DEFAULT 0,
+  "Fposted_at" DATETIME NOT NULL DEFAULT '0000-00-00 00:00:00'
+);`,
+			`CREATE INDEX IF NOT EXISTS "idx_Fentry_id" ON "ledger_posting" ("Fentry_id");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Faccount_code_Fposted_at" ON "ledger_posting" ("Faccount_code","Fposted_at");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Forder_id" ON "ledger_posting" ("Forder_id");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "ledger_posting" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Fentry_id" VARCHAR(128) NOT NULL DEFAULT '',
+  "Faccount_code" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fdirection" VARCHAR(12) NOT NULL DEFAULT '',
+  "Famount" BIGINT NOT NULL DEFAULT 0 CHECK ("Famount" >= 0),
+  "Fposted_at" TIMESTAMP(0) NULL
+);`,
+			`COMMENT ON TABLE "ledger_posting" IS '记账分录表';`,
+			`COMMENT ON COLUMN "ledger_posting"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "ledger_posting"."Fentry_id" IS '凭证号';`,
+			`COMMENT ON COLUMN "ledger_posting"."Faccount_code" IS '科目编码';`,
+			`COMMENT ON COLUMN "ledger_posting"."Forder_id" IS '订单号';`,
+			`COMMENT ON COLUMN "ledger_posting"."Fdirection" IS '借贷方向';`,
+			`COMMENT ON COLUMN "ledger_posting"."Famount" IS '金额，单位分';`,
+			`COMMENT ON COLUMN "ledger_posting"."Fposted_at" IS '记账时间';`,
+			`CREATE INDEX IF NOT EXISTS "ik_ledger_posting_Fentry_id" ON "ledger_posting" ("Fentry_id");`,
+			`CREATE INDEX IF NOT EXISTS "ik_ledger_posting_Faccount_code_Fposted_at" ON "ledger_posting" ("Faccount_code","Fposted_at");`,
+			`CREATE INDEX IF NOT EXISTS "ik_ledger_posting_Forder_id" ON "ledger_posting" ("Forder_id");`,
+		},
+	}),
+}
@@ -0,0 +1,237 @@
+package migration
+
+import (
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// createHistoryTablesV10 版本10的归档表建表语句，固定为发布时的表结构，此后新增的字段由后续版本加列
+var createHistoryTablesV10 = []Operation{
+	CreateTableSQL("pay_order_history", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS pay_order_history (
+  Fid bigint(11) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Fmerchant_id char(64) NOT NULL DEFAULT '' COMMENT '商户ID',
+  Forder_id char(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  Forder_amount bigint(11) unsigned NOT NULL DEFAULT '0' COMMENT '订单总金额，单位分',
+  Fstate char(14) NOT NULL DEFAULT '' COMMENT '支付状态(pending-未支付,paid-已支付,expired-已过期,failed-支付失败,closed-已关闭,unknown-未知状态)',
+  Fuser_id char(64) NOT NULL DEFAULT '' COMMENT '用户ID',
+  Fremark varchar(255) NOT NULL DEFAULT '' COMMENT '支付备注(如失败原因)',
+  Fexpire int(11) unsigned NOT NULL DEFAULT '0' COMMENT '超时时间，单分钟',
+  Forder_type char(10) NOT NULL DEFAULT '' COMMENT '订单类型(fixed-固定金额,open-开放式)',
+  Funit_price bigint(11) unsigned NOT NULL DEFAULT '0' COMMENT '单价，单位分',
+  Fcapacity int(11) unsigned NOT NULL DEFAULT '0' COMMENT '名额上限，0表示不限',
+  Fdeadline varchar(255) NOT NULL DEFAULT '' COMMENT '截止时间',
+  Fpaid_amount bigint(11) unsigned NOT NULL DEFAULT '0' COMMENT '已支付金额，单位分',
+  Fpending_amount bigint(11) unsigned NOT NULL DEFAULT '0' COMMENT '待支付金额，单位分',
+  Frecord_count int(11) unsigned NOT NULL DEFAULT '0' COMMENT '有效支付记录数',
+  Fcreated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '时间',
+  Fpaid_at varchar(255) NOT NULL DEFAULT '' COMMENT '支付时间',
+  Fclosed_at varchar(255) NOT NULL DEFAULT '' COMMENT '关单时间',
+  PRIMARY KEY (Fid),
+  UNIQUE KEY uk_Fmerchant_id_Forder_id (Fmerchant_id,Forder_id)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='收款单归档表';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "pay_order_history" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Fmerchant_id" TEXT NOT NULL DEFAULT '',
+  "Forder_id" TEXT NOT NULL DEFAULT '',
+  "Forder_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fstate" TEXT NOT NULL DEFAULT '',
+  "Fuser_id" TEXT NOT NULL DEFAULT '',
+  "Fremark" TEXT NOT NULL DEFAULT '',
+  "Fexpire" INTEGER NOT NULL DEFAULT 0,
+  "Forder_type" TEXT NOT NULL DEFAULT '',
+  "Funit_price" INTEGER NOT NULL DEFAULT 0,
+  "Fcapacity" INTEGER NOT NULL DEFAULT 0,
+  "Fdeadline" TEXT NOT NULL DEFAULT '',
+  "Fpaid_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fpending_amount" INTEGER NOT NULL DEFAULT 0,
+  "Frecord_count" INTEGER NOT NULL DEFAULT 0,
+  "Fcreated_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Fpaid_at" TEXT NOT NULL DEFAULT '',
+  "Fclosed_at" TEXT NOT NULL DEFAULT ''
+);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "uk_pay_order_history_Fmerchant_id_Forder_id" ON "pay_order_history" ("Fmerchant_id","Forder_id");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "pay_order_history" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Fmerchant_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Forder_amount" >= 0),
+  "Fstate" VARCHAR(14) NOT NULL DEFAULT '',
+  "Fuser_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fremark" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fexpire" BIGINT NOT NULL DEFAULT 0 CHECK ("Fexpire" >= 0),
+  "Forder_type" VARCHAR(10) NOT NULL DEFAULT '',
+  "Funit_price" BIGINT NOT NULL DEFAULT 0 CHECK ("Funit_price" >= 0),
+  "Fcapacity" BIGINT NOT NULL DEFAULT 0 CHECK ("Fcapacity" >= 0),
+  "Fdeadline" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fpaid_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Fpaid_amount" >= 0),
+  "Fpending_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Fpending_amount" >= 0),
+  "Frecord_count" BIGINT NOT NULL DEFAULT 0 CHECK ("Frecord_count" >= 0),
+  "Fcreated_at" TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Fpaid_at" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fclosed_at" VARCHAR(255) NOT NULL DEFAULT '',
+  UNIQUE ("Fmerchant_id","Forder_id")
+);`,
+			`COMMENT ON TABLE "pay_order_history" IS '收款单归档表';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fmerchant_id" IS '商户ID';`,
+			`COMMENT ON COLUMN "pay_order_history"."Forder_id" IS '订单号';`,
+			`COMMENT ON COLUMN "pay_order_history"."Forder_amount" IS '订单总金额，单位分';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fstate" IS '支付状态';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fuser_id" IS '用户ID';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fremark" IS '支付备注(如失败原因)';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fexpire" IS '超时时间，单分钟';`,
+			`COMMENT ON COLUMN "pay_order_history"."Forder_type" IS '订单类型';`,
+			`COMMENT ON COLUMN "pay_order_history"."Funit_price" IS '单价，单位分';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fcapacity" IS '名额上限，0表示不限';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fdeadline" IS '截止时间';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fpaid_amount" IS '已支付金额，单位分';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fpending_amount" IS '待支付金额，单位分';`,
+			`COMMENT ON COLUMN "pay_order_history"."Frecord_count" IS '有效支付记录数';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fcreated_at" IS '时间';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fpaid_at" IS '支付时间';`,
+			`COMMENT ON COLUMN "pay_order_history"."Fclosed_at" IS '关单时间';`,
+		},
+	}),
+	CreateTableSQL("pay_record_history", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS pay_record_history (
+  Fid bigint(11) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Fmerchant_id char(64) NOT NULL DEFAULT '' COMMENT '商户ID',
+  Fpay_id char(64) NOT NULL DEFAULT '' COMMENT '支付流水号',
+  Forder_id char(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  Forder_amount bigint(11) unsigned NOT NULL DEFAULT '0' COMMENT '订单总金额，单位分',
+  Fpay_amount bigint(11) unsigned NOT NULL DEFAULT '0' COMMENT '支付金额，单位分',
+  Fpay_agent char(12) NOT NULL DEFAULT '' COMMENT '支付类型(weixin-微信,alipay-支付宝,coupon-优惠券)',
+  Frecipient_account varchar(255) NOT NULL DEFAULT '' COMMENT '收款人账号',
+  Frecipient_name varchar(255) NOT NULL DEFAULT '' COMMENT '收款人名称',
+  Fpayment_account varchar(255) NOT NULL DEFAULT '' COMMENT '付款人账号',
+  Fpayment_name varchar(255) NOT NULL DEFAULT '' COMMENT '付款人名称',
+  Fstate char(14) NOT NULL DEFAULT '' COMMENT '支付状态(pending-未支付,paid-已支付,expired-已过期,failed-支付失败,closed-已关闭,unknown-未知状态)',
+  Fuser_id char(64) NOT NULL DEFAULT '' COMMENT '用户ID',
+  Fclient_ip varchar(255) NOT NULL DEFAULT '' COMMENT '客户端IP地址',
+  Fpay_url varchar(255) NOT NULL DEFAULT '' COMMENT '支付链接地址',
+  Freturn_url varchar(255) NOT NULL DEFAULT '' COMMENT '支付完成后的回调地址',
+  Fnotify_url varchar(255) NOT NULL DEFAULT '' COMMENT '支付完成后的通知地址',
+  Fpay_param varchar(255) NOT NULL DEFAULT '' COMMENT '支付参数，json格式',
+  Fremark varchar(255) NOT NULL DEFAULT '' COMMENT '支付备注(如失败原因)',
+  Fexpire int(11) unsigned NOT NULL DEFAULT '0' COMMENT '超时时间，单分钟',
+  Fcreated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '时间',
+  Fpaid_at varchar(255) NOT NULL DEFAULT '' COMMENT '支付时间',
+  Fclosed_at varchar(255) NOT NULL DEFAULT '' COMMENT '关单时间',
+  Fexpired_at varchar(255) NOT NULL DEFAULT '' COMMENT '过期时间',
+  Ffailed_at varchar(255) NOT NULL DEFAULT '' COMMENT '失败时间',
+  Fprovider_trade_no char(64) NOT NULL DEFAULT '' COMMENT '支付渠道交易号',
+  Fpayment_account_bidx char(64) NOT NULL DEFAULT '' COMMENT '付款人账号盲索引',
+  PRIMARY KEY (Fid),
+  UNIQUE KEY uk_Fpay_id (Fpay_id),
+  KEY ik_Fmerchant_id_Forder_id (Fmerchant_id,Forder_id),
+  KEY ik_Fprovider_trade_no (Fprovider_trade_no),
+  KEY ik_Fpayment_account_bidx (Fpayment_account_bidx)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='收款记录归档表';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "pay_record_history" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Fmerchant_id" TEXT NOT NULL DEFAULT '',
+  "Fpay_id" TEXT NOT NULL DEFAULT '',
+  "Forder_id" TEXT NOT NULL DEFAULT '',
+  "Forder_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fpay_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fpay_agent" TEXT NOT NULL DEFAULT '',
+  "Frecipient_account" TEXT NOT NULL DEFAULT '',
+  "Frecipient_name" TEXT NOT NULL DEFAULT '',
+  "Fpayment_account" TEXT NOT NULL DEFAULT '',
+  "Fpayment_name" TEXT NOT NULL DEFAULT '',
+  "Fstate" TEXT NOT NULL DEFAULT '',
+  "Fuser_id" TEXT NOT NULL DEFAULT '',
+  "Fclient_ip" TEXT NOT NULL DEFAULT '',
+  "Fpay_url" TEXT NOT NULL DEFAULT '',
+  "Freturn_url" TEXT NOT NULL DEFAULT '',
+  "Fnotify_url" TEXT NOT NULL DEFAULT '',
+  "Fpay_param" TEXT NOT NULL DEFAULT '',
+  "Fremark" TEXT NOT NULL DEFAULT '',
+  "Fexpire" INTEGER NOT NULL DEFAULT 0,
+  "Fcreated_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Fpaid_at" TEXT NOT NULL DEFAULT '',
+  "Fclosed_at" TEXT NOT NULL DEFAULT '',
+  "Fexpired_at" TEXT NOT NULL DEFAULT '',
+  "Ffailed_at" TEXT NOT NULL DEFAULT '',
+  "Fprovider_trade_no" TEXT NOT NULL DEFAULT '',
+  "Fpayment_account_bidx" TEXT NOT NULL DEFAULT ''
+);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "uk_pay_record_history_Fpay_id" ON "pay_record_history" ("Fpay_id");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Fmerchant_id_Forder_id" ON "pay_record_history" ("Fmerchant_id","Forder_id");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Fprovider_trade_no" ON "pay_record_history" ("Fprovider_trade_no");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Fpayment_account_bidx" ON "pay_record_history" ("Fpayment_account_bidx");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "pay_record_history" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Fmerchant_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fpay_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Forder_amount" >= 0),
+  "Fpay_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Fpay_amount" >= 0),
+  "Fpay_agent" VARCHAR(12) NOT NULL DEFAULT '',
+  "Frecipient_account" VARCHAR(255) NOT NULL DEFAULT '',
+  "Frecipient_name" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fpayment_account" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fpayment_name" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fstate" VARCHAR(14) NOT NULL DEFAULT '',
+  "Fuser_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fclient_ip" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fpay_url" VARCHAR(255) NOT NULL DEFAULT '',
+  "Freturn_url" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fnotify_url" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fpay_param" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fremark" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fexpire" BIGINT NOT NULL DEFAULT 0 CHECK ("Fexpire" >= 0),
+  "Fcreated_at" TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "Fpaid_at" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fclosed_at" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fexpired_at" VARCHAR(255) NOT NULL DEFAULT '',
+  "Ffailed_at" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fprovider_trade_no" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fpayment_account_bidx" VARCHAR(64) NOT NULL DEFAULT '',
+  UNIQUE ("Fpay_id")
+);`,
+			`COMMENT ON TABLE "pay_record_history" IS '收款记录归档表';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fmerchant_id" IS '商户ID';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fpay_id" IS '支付流水号';`,
+			`COMMENT ON COLUMN "pay_record_history"."Forder_id" IS '订单号';`,
+			`COMMENT ON COLUMN "pay_record_history"."Forder_amount" IS '订单总金额，单位分';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fpay_amount" IS '支付金额，单位分';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fpay_agent" IS '支付类型';`,
+			`COMMENT ON COLUMN "pay_record_history"."Frecipient_account" IS '收款人账号';`,
+			`COMMENT ON COLUMN "pay_record_history"."Frecipient_name" IS '收款人名称';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fpayment_account" IS '付款人账号';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fpayment_name" IS '付款人名称';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fstate" IS '支付状态';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fuser_id" IS '用户ID';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fclient_ip" IS '客户端IP地址';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fpay_url" IS '支付链接地址';`,
+			`COMMENT ON COLUMN "pay_record_history"."Freturn_url" IS '支付完成后的回调地址';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fnotify_url" IS '支付完成后的通知地址';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fpay_param" IS '支付参数，json格式';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fremark" IS '支付备注(如失败原因)';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fexpire" IS '超时时间，单分钟';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fcreated_at" IS '时间';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fpaid_at" IS '支付时间';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fclosed_at" IS '关单时间';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fexpired_at" IS '过期时间';`,
+			`COMMENT ON COLUMN "pay_record_history"."Ffailed_at" IS '失败时间';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fprovider_trade_no" IS '支付渠道交易号';`,
+			`COMMENT ON COLUMN "pay_record_history"."Fpayment_account_bidx" IS '付款人账号盲索引';`,
+			`CREATE INDEX IF NOT EXISTS "ik_pay_record_history_Fmerchant_id_Forder_id" ON "pay_record_history" ("Fmerchant_id","Forder_id");`,
+			`CREATE INDEX IF NOT EXISTS "ik_pay_record_history_Fprovider_trade_no" ON "pay_record_history" ("Fprovider_trade_no");`,
+			`CREATE INDEX IF NOT EXISTS "ik_pay_record_history_Fpayment_account_bidx" ON "pay_record_history" ("Fpayment_account_bidx");`,
+		},
+	}),
+}
@@ -0,0 +1,83 @@
+package migration
+
+import (
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// createRiskDecisionV12 版本12的风控决策表建表语句，固定为发布时的表结构，盲索引字段由版本18增加
+var createRiskDecisionV12 = []Operation{
+	CreateTableSQL("risk_decision", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS risk_decision (
+  Fid bigint(11) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Fmerchant_id char(64) NOT NULL DEFAULT '' COMMENT '商户ID',
+  Fstage char(12) NOT NULL DEFAULT '' COMMENT '风控环节(create-创建支付,pay-支付回调)',
+  Fpay_id char(64) NOT NULL DEFAULT '' COMMENT '支付流水号',
+  Forder_id char(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  Fuser_id char(64) NOT NULL DEFAULT '' COMMENT '用户ID',
+  Fclient_ip char(64) NOT NULL DEFAULT '' COMMENT '客户端IP地址',
+  Fpay_amount bigint(11) unsigned NOT NULL DEFAULT '0' COMMENT '支付金额，单位分',
+  Fdecision char(12) NOT NULL DEFAULT '' COMMENT '风控决策(allow-放行,review-待复核,deny-拒绝)',
+  Frule char(64) NOT NULL DEFAULT '' COMMENT '命中规则',
+  Freason varchar(255) NOT NULL DEFAULT '' COMMENT '决策原因',
+  Fcreated_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '时间',
+  PRIMARY KEY (Fid),
+  KEY ik_Fmerchant_id_Fuser_id_Fcreated_at (Fmerchant_id,Fuser_id,Fcreated_at),
+  KEY ik_Fmerchant_id_Fclient_ip_Fcreated_at (Fmerchant_id,Fclient_ip,Fcreated_at),
+  KEY ik_Fpay_id (Fpay_id)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='风控决策记录';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "risk_decision" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Fmerchant_id" TEXT NOT NULL DEFAULT '',
+  "Fstage" TEXT NOT NULL DEFAULT '',
+  "Fpay_id" TEXT NOT NULL DEFAULT '',
+  "Forder_id" TEXT NOT NULL DEFAULT '',
+  "Fuser_id" TEXT NOT NULL DEFAULT '',
+  "Fclient_ip" TEXT NOT NULL DEFAULT '',
+  "Fpay_amount" INTEGER NOT NULL DEFAULT 0,
+  "Fdecision" TEXT NOT NULL DEFAULT '',
+  "Frule" TEXT NOT NULL DEFAULT '',
+  "Freason" TEXT NOT NULL DEFAULT '',
+  "Fcreated_at" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`,
+			`CREATE INDEX IF NOT EXISTS "idx_Fmerchant_id_Fuser_id_Fcreated_at" ON "risk_decision" ("Fmerchant_id","Fuser_id","Fcreated_at");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Fmerchant_id_Fclient_ip_Fcreated_at" ON "risk_decision" ("Fmerchant_id","Fclient_ip","Fcreated_at");`,
+			`CREATE INDEX IF NOT EXISTS "idx_Fpay_id" ON "risk_decision" ("Fpay_id");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "risk_decision" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Fmerchant_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fstage" VARCHAR(12) NOT NULL DEFAULT '',
+  "Fpay_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Forder_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fuser_id" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fclient_ip" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fpay_amount" BIGINT NOT NULL DEFAULT 0 CHECK ("Fpay_amount" >= 0),
+  "Fdecision" VARCHAR(12) NOT NULL DEFAULT '',
+  "Frule" VARCHAR(64) NOT NULL DEFAULT '',
+  "Freason" VARCHAR(255) NOT NULL DEFAULT '',
+  "Fcreated_at" TIMESTAMP(0) NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`,
+			`COMMENT ON TABLE "risk_decision" IS '风控决策记录';`,
+			`COMMENT ON COLUMN "risk_decision"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "risk_decision"."Fmerchant_id" IS '商户ID';`,
+			`COMMENT ON COLUMN "risk_decision"."Fstage" IS '风控环节';`,
+			`COMMENT ON COLUMN "risk_decision"."Fpay_id" IS '支付流水号';`,
+			`COMMENT ON COLUMN "risk_decision"."Forder_id" IS '订单号';`,
+			`COMMENT ON COLUMN "risk_decision"."Fuser_id" IS '用户ID';`,
+			`COMMENT ON COLUMN "risk_decision"."Fclient_ip" IS '客户端IP地址';`,
+			`COMMENT ON COLUMN "risk_decision"."Fpay_amount" IS '支付金额，单位分';`,
+			`COMMENT ON COLUMN "risk_decision"."Fdecision" IS '风控决策';`,
+			`COMMENT ON COLUMN "risk_decision"."Frule" IS '命中规则';`,
+			`COMMENT ON COLUMN "risk_decision"."Freason" IS '决策原因';`,
+			`COMMENT ON COLUMN "risk_decision"."Fcreated_at" IS '时间';`,
+			`CREATE INDEX IF NOT EXISTS "ik_risk_decision_Fmerchant_id_Fuser_id_Fcreated_at" ON "risk_decision" ("Fmerchant_id","Fuser_id","Fcreated_at");`,
+			`CREATE INDEX IF NOT EXISTS "ik_risk_decision_Fmerchant_id_Fclient_ip_Fcreated_at" ON "risk_decision" ("Fmerchant_id","Fclient_ip","Fcreated_at");`,
+			`CREATE INDEX IF NOT EXISTS "ik_risk_decision_Fpay_id" ON "risk_decision" ("Fpay_id");`,
+		},
+	}),
+}
@@ -0,0 +1,41 @@
+package migration
+
+import (
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// createPayIdSequenceV7 版本7的支付流水号序列表建表语句，固定为发布时的表结构，不随表配置变化
+var createPayIdSequenceV7 = []Operation{
+	CreateTableSQL("pay_id_sequence", map[sqlbuilder.Driver][]string{
+		sqlbuilder.Driver_mysql: {
+			`CREATE TABLE IF NOT EXISTS pay_id_sequence (
+  Fid bigint(11) unsigned NOT NULL AUTO_INCREMENT COMMENT 'ID',
+  Fsequence_name char(64) NOT NULL DEFAULT '' COMMENT '序列名称',
+  Fsequence_value bigint(11) unsigned NOT NULL DEFAULT '0' COMMENT '已分配的最大序号',
+  PRIMARY KEY (Fid),
+  UNIQUE KEY uk_Fsequence_name (Fsequence_name)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci COMMENT='支付流水号序列';`,
+		},
+		sqlbuilder.Driver_sqlite3: {
+			`CREATE TABLE IF NOT EXISTS "pay_id_sequence" (
+  "Fid" INTEGER PRIMARY KEY AUTOINCREMENT,
+  "Fsequence_name" TEXT NOT NULL DEFAULT '',
+  "Fsequence_value" INTEGER NOT NULL DEFAULT 0
+);`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS "uk_pay_id_sequence_Fsequence_name" ON "pay_id_sequence" ("Fsequence_name");`,
+		},
+		repository.Driver_postgres: {
+			`CREATE TABLE IF NOT EXISTS "pay_id_sequence" (
+  "Fid" BIGSERIAL PRIMARY KEY,
+  "Fsequence_name" VARCHAR(64) NOT NULL DEFAULT '',
+  "Fsequence_value" BIGINT NOT NULL DEFAULT 0 CHECK ("Fsequence_value" >= 0),
+  UNIQUE ("Fsequence_name")
+);`,
+			`COMMENT ON TABLE "pay_id_sequence" IS '支付流水号序列';`,
+			`COMMENT ON COLUMN "pay_id_sequence"."Fid" IS 'ID';`,
+			`COMMENT ON COLUMN "pay_id_sequence"."Fsequence_name" IS '序列名称';`,
+			`COMMENT ON COLUMN "pay_id_sequence"."Fsequence_value" IS '已分配的最大序号';`,
+		},
+	}),
+}
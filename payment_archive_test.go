@@ -0,0 +1,95 @@
+package paymentrecord_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/cache"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+func tableCount(t *testing.T, handler sqlbuilder.Handler, table string, orderId string) int64 {
+	sql, _, err := sqlbuilder.Driver(handler.GetDialector()).GoquDialect().From(table).Select(goqu.COUNT("*")).Where(goqu.C("Forder_id").Eq(orderId)).ToSQL()
+	require.NoError(t, err)
+	count, err := handler.Count(sql)
+	require.NoError(t, err)
+	return count
+}
+
+func TestArchivePayOrders(t *testing.T) {
+	handler := newSqliteHandler(t)
+	s := paymentrecord.NewPayRecordService(handler)
+	err := s.Create(newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 3000))
+	require.NoError(t, err)
+	err = s.CloseByOrderId(paymentrecord.CloseByOrderIdIn{OrderId: "o1", Reason: "用户取消"})
+	require.NoError(t, err)
+	err = s.Create(newCreateIn("p3", "o2", 5000, 5000)) // 未结束的订单不归档
+	require.NoError(t, err)
+
+	archived, err := repository.ArchivePayOrders(handler, nil, time.Now().Add(-time.Hour), 0) // 未超过保留期
+	require.NoError(t, err)
+	require.Equal(t, 0, archived)
+	archived, err = repository.ArchivePayOrders(handler, nil, time.Now().Add(time.Minute), 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, archived)
+
+	require.Equal(t, int64(0), tableCount(t, handler, "pay_record", "o1"))
+	require.Equal(t, int64(0), tableCount(t, handler, "pay_order", "o1"))
+	require.Equal(t, int64(2), tableCount(t, handler, "pay_record_history", "o1"))
+	require.Equal(t, int64(1), tableCount(t, handler, "pay_order_history", "o1"))
+	require.Equal(t, int64(1), tableCount(t, handler, "pay_record", "o2"))
+
+	record, err := s.Get("p1") // 读取时回落到归档表
+	require.NoError(t, err)
+	require.Equal(t, "o1", record.OrderId)
+	_, err = s.Get("p4")
+	require.ErrorIs(t, err, sqlbuilder.ErrNotFound)
+	payRecords, err := s.GetAllPayRecordByConditon(sqlbuilder.Fields{repository.NewOrderId("o1").AppendWhereFn(sqlbuilder.ValueFnForward)})
+	require.NoError(t, err)
+	require.Empty(t, payRecords)
+
+	archived, err = repository.ArchivePayOrders(handler, nil, time.Now().Add(time.Minute), 0) // 重复执行无副作用
+	require.NoError(t, err)
+	require.Equal(t, 0, archived)
+}
+
+func TestArchivedOrderIsFrozen(t *testing.T) {
+	handler := newSqliteHandler(t)
+	lru := cache.NewLRU(100)
+	s := paymentrecord.NewPayRecordService(handler).SetCache(lru, time.Minute, nil)
+	err := s.Create(newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 3000))
+	require.NoError(t, err)
+	err = s.Fail(paymentrecord.FailIn{PayId: "p2", Reason: "余额不足"})
+	require.NoError(t, err)
+	err = s.Create(newCreateIn("p3", "o1", 5000, 3000))
+	require.NoError(t, err)
+	_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+	require.NoError(t, err)
+	_, err = s.Pay(paymentrecord.PayIn{PayId: "p3"})
+	require.NoError(t, err)
+	requireRecordState(t, s, "p1", repository.PayOrderModel_state_paid) // 写入缓存
+
+	archived, err := repository.ArchivePayOrders(handler, lru, time.Now().Add(time.Minute), 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, archived)
+	require.Zero(t, lru.Len()) // 归档后删除收款单及支付记录缓存
+	payRecords, err := s.GetAllPayRecordByConditon(sqlbuilder.Fields{repository.NewOrderId("o1").AppendWhereFn(sqlbuilder.ValueFnForward)})
+	require.NoError(t, err)
+	require.Empty(t, payRecords)
+
+	_, err = s.Pay(paymentrecord.PayIn{PayId: "p2"}) // 归档后收到已失败支付记录的迟到回调
+	require.ErrorIs(t, err, repository.ErrArchived)
+	require.Equal(t, paymentrecord.ErrorType_archived, paymentrecord.ErrorType(err))
+	err = s.Close(paymentrecord.CloseIn{PayId: "p1", Reason: "关闭"})
+	require.ErrorIs(t, err, repository.ErrArchived)
+	_, err = s.Pay(paymentrecord.PayIn{PayId: "p4"})
+	require.ErrorIs(t, err, sqlbuilder.ErrNotFound)
+
+	err = s.Create(newCreateIn("p5", "o1", 5000, 5000)) // 已归档的订单号不能再次使用
+	require.ErrorIs(t, err, repository.ErrArchived)
+	require.Equal(t, int64(0), tableCount(t, handler, "pay_record", "o1"))
+}
@@ -0,0 +1,15 @@
+package paymentrecord
+
+import (
+	"time"
+
+	"github.com/suifengpiao14/paymentrecord/repository"
+)
+
+// SetCache 按支付流水号、订单号查询收款单及支付记录时先读缓存，写入、状态变更后(事务内为提交后)删除缓存。
+// 缓存实现见 cache 包，metrics 为空时不采集命中率，Prometheus 实现见 metrics 包
+func (s *PayRecordService) SetCache(cache repository.Cache, ttl time.Duration, metrics repository.CacheMetrics) *PayRecordService {
+	s.orderRepository = repository.NewCachedPayOrderRepository(cache, ttl, metrics, s.orderRepository).WithMerchantId(s.merchantId)
+	s.recordRepository = repository.NewCachedPayRecordRepository(cache, ttl, metrics, s.recordRepository).WithMerchantId(s.merchantId).WithCipher(s.cipher)
+	return s
+}
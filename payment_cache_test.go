@@ -0,0 +1,56 @@
+package paymentrecord_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/cache"
+	"github.com/suifengpiao14/paymentrecord/repository"
+)
+
+type recordCacheMetrics struct {
+	hits, misses map[string]int
+}
+
+func (m *recordCacheMetrics) CacheLookup(name string, hit bool) {
+	if hit {
+		m.hits[name]++
+		return
+	}
+	m.misses[name]++
+}
+
+func TestCache(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		m := &recordCacheMetrics{hits: map[string]int{}, misses: map[string]int{}}
+		lru := cache.NewLRU(100)
+		s.SetCache(lru, time.Minute, m)
+		err := s.Create(newCreateIn("p1", "o1", 5000, 2000))
+		require.NoError(t, err)
+		records, err := s.GetOrderPayInfo("o1")
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		_, err = s.GetOrderPayInfo("o1")
+		require.NoError(t, err)
+		require.Equal(t, 1, m.hits[repository.CacheName_pay_record_order])
+
+		err = s.Create(newCreateIn("p2", "o1", 5000, 3000)) // 事务提交后删除订单下支付记录缓存
+		require.NoError(t, err)
+		records, err = s.GetOrderPayInfo("o1")
+		require.NoError(t, err)
+		require.Len(t, records, 2)
+
+		requireRecordState(t, s, "p1", repository.PayOrderModel_state_pending)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+		requireRecordState(t, s, "p1", repository.PayOrderModel_state_paid)
+
+		err = s.Create(newCreateIn("p3", "o2", 5000, 1000), newCreateIn("p3", "o2", 5000, 1000)) // 回滚时不写入缓存
+		require.Error(t, err)
+		_, err = s.Get("p3")
+		require.Error(t, err)
+		require.Positive(t, lru.Len())
+	})
+}
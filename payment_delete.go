@@ -0,0 +1,114 @@
+package paymentrecord
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+type DeleteIn struct {
+	OrderId string `json:"orderId" validate:"required"`
+	Reason  string `json:"reason" validate:"required"` // 删除原因，记录在收款单及支付记录的删除原因字段，不覆盖备注
+}
+
+// Delete 软删除收款单及其支付记录(如测试单、误建的订单)，删除后查询、支付、关闭等均视为不存在，可通过 Restore 恢复。
+// 有待支付记录的收款单需先关闭，避免删除后仍收到支付回调
+func (s PayRecordService) Delete(in DeleteIn) (err error) {
+	defer s.logMutation(Operation_delete, time.Now(), in, nil, &err, s.orderState(in.OrderId))
+	defer func() {
+		s.observeError(Operation_delete, err)
+	}()
+	s, span := s.startSpan("Delete", repository.Attr_order_id.String(in.OrderId))
+	defer func() { repository.EndSpan(span, err) }()
+	if in.Reason == "" {
+		err = errors.Errorf("删除原因不能为空,订单ID-%s", in.OrderId)
+		return err
+	}
+	err = s.orderRepository.TransactionForMutiTable(func(txHandler sqlbuilder.Handler) (err error) {
+		payOrder, exists, err := s.orderRepository.WithTxHandler(txHandler).LockByOrderId(in.OrderId) // 与支付记录创建、回调串行
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sqlbuilder.ErrNotFound
+		}
+		if payOrder.PendingAmount > 0 {
+			err = errors.Errorf("订单有待支付记录，请先关闭,订单ID-%s", in.OrderId)
+			return err
+		}
+		err = s.recordRepository.WithTxHandler(txHandler).DeleteByOrderId(in.OrderId, in.Reason)
+		if err != nil {
+			return err
+		}
+		err = s.orderRepository.WithTxHandler(txHandler).Delete(in.OrderId, in.Reason)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+type RestoreIn struct {
+	OrderId string `json:"orderId" validate:"required"`
+}
+
+// Restore 恢复软删除的收款单及其支付记录
+func (s PayRecordService) Restore(in RestoreIn) (err error) {
+	defer s.logMutation(Operation_restore, time.Now(), in, nil, &err, s.orderState(in.OrderId))
+	defer func() {
+		s.observeError(Operation_restore, err)
+	}()
+	s, span := s.startSpan("Restore", repository.Attr_order_id.String(in.OrderId))
+	defer func() { repository.EndSpan(span, err) }()
+	_, exists, err := s.orderRepository.GetDeleted(in.OrderId)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sqlbuilder.ErrNotFound
+	}
+	err = s.orderRepository.TransactionForMutiTable(func(txHandler sqlbuilder.Handler) (err error) {
+		err = s.orderRepository.WithTxHandler(txHandler).Restore(in.OrderId)
+		if err != nil {
+			return err
+		}
+		err = s.recordRepository.WithTxHandler(txHandler).RestoreByOrderId(in.OrderId)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeletedOrder 已删除的收款单及其支付记录
+type DeletedOrder struct {
+	Order   repository.PayOrderModel   `json:"order"`
+	Records repository.PayRecordModels `json:"records"`
+}
+
+// GetDeletedOrder 管理后台查询已删除的收款单，未删除或不存在时返回 sqlbuilder.ErrNotFound
+func (s PayRecordService) GetDeletedOrder(orderId string) (deleted DeletedOrder, err error) {
+	payOrder, exists, err := s.orderRepository.GetDeleted(orderId)
+	if err != nil {
+		return deleted, err
+	}
+	if !exists {
+		return deleted, sqlbuilder.ErrNotFound
+	}
+	records, err := s.recordRepository.GetDeletedByOrderId(orderId)
+	if err != nil {
+		return deleted, err
+	}
+	deleted = DeletedOrder{Order: payOrder, Records: records}
+	return deleted, nil
+}
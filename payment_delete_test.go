@@ -0,0 +1,62 @@
+package paymentrecord_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+func TestDelete(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 3000))
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+
+		err = s.Delete(paymentrecord.DeleteIn{OrderId: "o1", Reason: "测试单"}) // 有待支付记录
+		require.Error(t, err)
+		err = s.Close(paymentrecord.CloseIn{PayId: "p2", Reason: "用户取消"})
+		require.NoError(t, err)
+		err = s.Delete(paymentrecord.DeleteIn{OrderId: "o1"}) // 缺少删除原因
+		require.Error(t, err)
+		err = s.Delete(paymentrecord.DeleteIn{OrderId: "o1", Reason: "测试单"})
+		require.NoError(t, err)
+		err = s.Delete(paymentrecord.DeleteIn{OrderId: "o1", Reason: "测试单"})
+		require.ErrorIs(t, err, sqlbuilder.ErrNotFound)
+
+		_, err = s.Get("p1")
+		require.ErrorIs(t, err, sqlbuilder.ErrNotFound)
+		payRecords, err := s.GetOrderPayInfo("o1")
+		require.NoError(t, err)
+		require.Empty(t, payRecords)
+		err = s.Create(newCreateIn("p3", "o1", 5000, 1000)) // 已删除的订单不能继续创建支付记录
+		require.ErrorIs(t, err, repository.ErrDeleted)
+
+		deleted, err := s.GetDeletedOrder("o1")
+		require.NoError(t, err)
+		require.Equal(t, "测试单", deleted.Order.DeleteReason)
+		require.NotEmpty(t, deleted.Order.DeletedAt)
+		require.Len(t, deleted.Records, 2)
+		for _, record := range deleted.Records {
+			require.Equal(t, "测试单", record.DeleteReason)
+		}
+
+		err = s.Restore(paymentrecord.RestoreIn{OrderId: "o1"})
+		require.NoError(t, err)
+		_, err = s.GetDeletedOrder("o1")
+		require.ErrorIs(t, err, sqlbuilder.ErrNotFound)
+		record, err := s.Get("p1")
+		require.NoError(t, err)
+		require.Equal(t, "o1", record.OrderId)
+		require.Empty(t, record.DeleteReason)
+		record, err = s.Get("p2")
+		require.NoError(t, err)
+		require.Equal(t, "用户取消", record.Remark) // 删除、恢复不覆盖备注
+		requireRestAmount(t, s, "o1", 3000)
+		err = s.Restore(paymentrecord.RestoreIn{OrderId: "o1"})
+		require.ErrorIs(t, err, sqlbuilder.ErrNotFound)
+	})
+}
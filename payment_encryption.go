@@ -0,0 +1,14 @@
+package paymentrecord
+
+import (
+	"github.com/suifengpiao14/paymentrecord/encryption"
+)
+
+// SetFieldCipher 支付记录的收付款人账号、名称，客户端IP及分账的收款人账号、名称按 cipher 加密存储，nil 时明文存储。
+// 读取时兼容明文，启用加密后可通过 repository.RotatePayRecordKeys、repository.RotatePaySplitKeys 加密存量数据
+func (s *PayRecordService) SetFieldCipher(cipher *encryption.Cipher) *PayRecordService {
+	s.cipher = cipher
+	s.recordRepository = s.recordRepository.WithCipher(cipher)
+	s.splitRepository = s.splitRepository.WithCipher(cipher)
+	return s
+}
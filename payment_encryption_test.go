@@ -0,0 +1,135 @@
+package paymentrecord_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/encryption"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// rawRecord 绕过仓库直接读取数据库中的值
+func rawRecord(t *testing.T, handler sqlbuilder.Handler, payId string) (model repository.PayRecordModel) {
+	sql, _, err := sqlbuilder.Driver(handler.GetDialector()).GoquDialect().From("pay_record").Where(goqu.C("Fpay_id").Eq(payId)).ToSQL()
+	require.NoError(t, err)
+	exists, err := handler.First(context.Background(), sql, &model)
+	require.NoError(t, err)
+	require.True(t, exists, payId)
+	return model
+}
+
+func newCipher(t *testing.T, keys encryption.FileKeys) *encryption.Cipher {
+	provider, err := encryption.NewFileKeyProviderWithKeys(keys)
+	require.NoError(t, err)
+	return encryption.NewCipher(provider)
+}
+
+func TestFieldEncryption(t *testing.T) {
+	const account = "6222021234567890"
+	for _, backend := range backends {
+		if backend.inMemory { // 内存仓库不落库，不加密
+			continue
+		}
+		t.Run(backend.name, func(t *testing.T) {
+			handler := backend.newHandler(t)
+			s := backend.newService(handler)
+			legacy := newCreateIn("p1", "o1", 5000, 2000) // 启用加密前写入的明文
+			legacy.PaymentAccount = account
+			require.NoError(t, s.Create(legacy))
+
+			keys, err := encryption.GenerateFileKeys("k1")
+			require.NoError(t, err)
+			s.SetFieldCipher(newCipher(t, keys))
+			in := newCreateIn("p2", "o1", 5000, 3000)
+			in.PaymentAccount = account
+			in.PaymentName = "张三"
+			require.NoError(t, s.Create(in))
+
+			raw := rawRecord(t, handler, "p2")
+			for _, value := range []string{raw.PaymentAccount, raw.PaymentName, raw.ClientIp} {
+				require.True(t, encryption.IsEncrypted(value), value)
+			}
+			require.NotEmpty(t, raw.PaymentAccountBlindIndex)
+			record, err := s.Get("p2")
+			require.NoError(t, err)
+			require.Equal(t, account, record.PaymentAccount)
+			require.Equal(t, "张三", record.PaymentName)
+			require.Equal(t, "127.0.0.1", record.ClientIp)
+			records, err := s.GetByPaymentAccount(account)
+			require.NoError(t, err)
+			require.Len(t, records, 1) // 存量明文尚未建立盲索引
+
+			require.NoError(t, keys.Rotate("k2"))
+			cipher := newCipher(t, keys)
+			s.SetFieldCipher(cipher)
+			rotated, err := repository.RotatePayRecordKeys(handler, cipher, 1)
+			require.NoError(t, err)
+			require.Equal(t, 2, rotated)
+			for _, payId := range []string{"p1", "p2"} {
+				keyId, encrypted := encryption.KeyId(rawRecord(t, handler, payId).PaymentAccount)
+				require.True(t, encrypted, payId)
+				require.Equal(t, "k2", keyId, payId)
+			}
+			records, err = s.GetByPaymentAccount(account)
+			require.NoError(t, err)
+			require.Len(t, records, 2)
+			require.Equal(t, account, records[0].PaymentAccount)
+
+			rotated, err = repository.RotatePayRecordKeys(handler, cipher, 1) // 重复执行无需更新
+			require.NoError(t, err)
+			require.Equal(t, 0, rotated)
+		})
+	}
+}
+
+func TestSplitEncryption(t *testing.T) {
+	const account = "seller_001"
+	for _, backend := range backends {
+		if backend.inMemory {
+			continue
+		}
+		t.Run(backend.name, func(t *testing.T) {
+			handler := backend.newHandler(t)
+			s := backend.newService(handler)
+			legacy := newCreateIn("p1", "o1", 1000, 1000) // 启用加密前写入的明文
+			legacy.Splits = paymentrecord.PaySplitIns{{RecipientAccount: account, RecipientName: "卖家", Amount: 1000}}
+			require.NoError(t, s.Create(legacy))
+
+			keys, err := encryption.GenerateFileKeys("k1")
+			require.NoError(t, err)
+			cipher := newCipher(t, keys)
+			s.SetFieldCipher(cipher)
+			in := newCreateIn("p2", "o2", 1000, 1000)
+			in.Splits = paymentrecord.PaySplitIns{{RecipientAccount: account, RecipientName: "卖家", Amount: 1000}}
+			require.NoError(t, s.Create(in))
+
+			splits, err := s.GetSplits("p2")
+			require.NoError(t, err)
+			require.Len(t, splits, 1)
+			require.Equal(t, account, splits[0].RecipientAccount)
+			require.Equal(t, "卖家", splits[0].RecipientName)
+			plain := backend.newService(handler) // 加密按服务设置，未设置的服务读取到密文
+			splits, err = plain.GetSplits("p2")
+			require.NoError(t, err)
+			require.True(t, encryption.IsEncrypted(splits[0].RecipientAccount))
+			require.True(t, encryption.IsEncrypted(splits[0].RecipientName))
+
+			splitLedger, err := s.GetSplitLedger(account)
+			require.NoError(t, err)
+			require.Len(t, splitLedger.Splits, 1) // 存量明文尚未建立盲索引
+			rotated, err := repository.RotatePaySplitKeys(handler, cipher, 1)
+			require.NoError(t, err)
+			require.Equal(t, 1, rotated)
+			splitLedger, err = s.GetSplitLedger(account)
+			require.NoError(t, err)
+			require.Len(t, splitLedger.Splits, 2)
+			for _, split := range splitLedger.Splits {
+				require.Equal(t, account, split.RecipientAccount)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package paymentrecord_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/xuri/excelize/v2"
+)
+
+func TestExportPayRecords(t *testing.T) {
+	handler := newSqliteHandler(t)
+	s := paymentrecord.NewPayRecordService(handler)
+	alipay := newCreateIn("p2", "o1", 5000, 3000)
+	alipay.PayAgent = repository.PayingAgent_Alipay
+	alipay.PaymentName = "=HYPERLINK(\"http://evil\")"
+	err := s.Create(newCreateIn("p1", "o1", 5000, 2000), alipay)
+	require.NoError(t, err)
+	err = s.Create(newCreateIn("p3", "o2", 5000, 5000))
+	require.NoError(t, err)
+	err = s.CloseByOrderId(paymentrecord.CloseByOrderIdIn{OrderId: "o2", Reason: "测试单"})
+	require.NoError(t, err)
+	err = s.Delete(paymentrecord.DeleteIn{OrderId: "o2", Reason: "测试单"}) // 已删除的不导出
+	require.NoError(t, err)
+
+	columns := []string{"payNo", "payAgent", "state", "paymentAmount"}
+	var buf bytes.Buffer
+	exported, err := repository.ExportPayRecords(handler, nil, repository.ExportIn{Columns: columns, BatchSize: 1}, &buf)
+	require.NoError(t, err)
+	require.Equal(t, 2, exported)
+	rows, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(buf.String(), "\xEF\xBB\xBF"))).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"支付流水号", "支付类型", "支付状态", "支付金额，单位分"},
+		{"p1", "微信", "未支付", "2000"},
+		{"p2", "支付宝", "未支付", "3000"},
+	}, rows)
+
+	buf.Reset()
+	exported, err = repository.ExportPayRecords(handler, nil, repository.ExportIn{Columns: columns, PayAgents: []string{repository.PayingAgent_Alipay}, Format: repository.ExportFormat_xlsx}, &buf)
+	require.NoError(t, err)
+	require.Equal(t, 1, exported)
+	file, err := excelize.OpenReader(&buf)
+	require.NoError(t, err)
+	defer file.Close()
+	rows, err = file.GetRows(file.GetSheetName(0))
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"支付流水号", "支付类型", "支付状态", "支付金额，单位分"},
+		{"p2", "支付宝", "未支付", "3000"},
+	}, rows)
+
+	buf.Reset()
+	_, err = repository.ExportPayRecords(handler, nil, repository.ExportIn{Columns: []string{"notExists"}}, &buf)
+	require.Error(t, err)
+	exported, err = repository.ExportPayRecords(handler, nil, repository.ExportIn{}, &buf) // 默认导出全部字段
+	require.NoError(t, err)
+	require.Equal(t, 2, exported)
+	require.NotContains(t, buf.String(), "盲索引")
+
+	columns = []string{"payNo", "paymentName"}
+	buf.Reset()
+	_, err = repository.ExportPayRecords(handler, nil, repository.ExportIn{Columns: columns, PayAgents: []string{repository.PayingAgent_Alipay}}, &buf)
+	require.NoError(t, err)
+	rows, err = csv.NewReader(strings.NewReader(strings.TrimPrefix(buf.String(), "\xEF\xBB\xBF"))).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, `'=HYPERLINK("http://evil")`, rows[1][1]) // 公式前置单引号，按文本输出
+	buf.Reset()
+	_, err = repository.ExportPayRecords(handler, nil, repository.ExportIn{Columns: columns, PayAgents: []string{repository.PayingAgent_Alipay}, Format: repository.ExportFormat_xlsx}, &buf)
+	require.NoError(t, err)
+	file, err = excelize.OpenReader(&buf)
+	require.NoError(t, err)
+	defer file.Close()
+	rows, err = file.GetRows(file.GetSheetName(0))
+	require.NoError(t, err)
+	require.Equal(t, `'=HYPERLINK("http://evil")`, rows[1][1])
+}
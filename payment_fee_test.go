@@ -0,0 +1,100 @@
+package paymentrecord_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+)
+
+func TestFeeScheduleCompute(t *testing.T) {
+	tiered := repository.FeeSchedule{
+		Tiers: []repository.FeeTier{
+			{UpTo: 10000, Rate: 100},
+			{UpTo: 100000, Rate: 60, Fixed: 10},
+			{Rate: 30},
+		},
+		Min: 5,
+		Max: 2000,
+	}
+	cases := []struct {
+		name      string
+		schedule  repository.FeeSchedule
+		payAmount int
+		fee       int
+	}{
+		{name: "rate", schedule: repository.FeeSchedule{Rate: 60}, payAmount: 10050, fee: 60},
+		{name: "fixed", schedule: repository.FeeSchedule{Fixed: 30}, payAmount: 10000, fee: 30},
+		{name: "not exceed pay amount", schedule: repository.FeeSchedule{Fixed: 30}, payAmount: 20, fee: 20},
+		{name: "tier min", schedule: tiered, payAmount: 100, fee: 5},
+		{name: "tier 1", schedule: tiered, payAmount: 10000, fee: 100},
+		{name: "tier 2", schedule: tiered, payAmount: 50000, fee: 310},
+		{name: "tier 3", schedule: tiered, payAmount: 200000, fee: 600},
+		{name: "tier max", schedule: tiered, payAmount: 1000000, fee: 2000},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.fee, c.schedule.Compute(c.payAmount), c.name)
+	}
+
+	err := repository.NewPayAgentRegistry().Register(repository.PayAgentConfig{
+		Key: "badfee",
+		Fee: repository.FeeSchedule{Tiers: []repository.FeeTier{{Rate: 100}, {UpTo: 100, Rate: 200}}},
+	})
+	require.Error(t, err)
+}
+
+func TestProviderFee(t *testing.T) {
+	payAgents := repository.NewPayAgentRegistry(repository.PayAgentConfig{Key: "feepay", Title: "手续费测试", Fee: repository.FeeSchedule{Rate: 60, Min: 1}})
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		s.SetPayAgents(payAgents)
+		newIn := func(payId string, payAmount int) paymentrecord.PayRecordCreateIn {
+			in := newCreateIn(payId, "o1", 30000, payAmount)
+			in.PayAgent = "feepay"
+			return in
+		}
+		require.NoError(t, s.Create(newIn("p1", 10000), newIn("p2", 10000), newIn("p3", 10000)))
+
+		_, err := s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+		record, err := s.Get("p1")
+		require.NoError(t, err)
+		require.Equal(t, 60, record.ProviderFee)
+		require.Equal(t, 9940, record.NetAmount)
+
+		// 渠道返回的实际手续费优先
+		reported := 55
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p2", ProviderFee: &reported})
+		require.NoError(t, err)
+		record, err = s.Get("p2")
+		require.NoError(t, err)
+		require.Equal(t, 55, record.ProviderFee)
+		require.Equal(t, 9945, record.NetAmount)
+
+		// 重复回调不重算，带实际手续费时更新
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+		record, err = s.Get("p1")
+		require.NoError(t, err)
+		require.Equal(t, 60, record.ProviderFee)
+		reported = 58
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1", ProviderFee: &reported})
+		require.NoError(t, err)
+		record, err = s.Get("p1")
+		require.NoError(t, err)
+		require.Equal(t, 58, record.ProviderFee)
+		require.Equal(t, 9942, record.NetAmount)
+
+		invalid := 20000
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p3", ProviderFee: &invalid})
+		require.Error(t, err)
+		requireRecordState(t, s, "p3", repository.PayOrderModel_state_pending)
+
+		// 重复回调带的手续费有误时忽略，回调照常成功
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1", ProviderFee: &invalid})
+		require.NoError(t, err)
+		record, err = s.Get("p1")
+		require.NoError(t, err)
+		require.Equal(t, 58, record.ProviderFee)
+	})
+}
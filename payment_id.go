@@ -0,0 +1,201 @@
+package paymentrecord
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	mathrand "math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// IdGenerator 支付流水号生成器，生成的流水号需全局唯一(Fpay_id 唯一索引)，长度不超过64
+type IdGenerator interface {
+	NextId() (id string, err error)
+}
+
+// SetIdGenerator 设置支付流水号生成器，创建支付记录未传 PayId 时使用，默认为 NewRandomIdGenerator("")
+func (s *PayRecordService) SetIdGenerator(generator IdGenerator) *PayRecordService {
+	s.idGenerator = generator
+	return s
+}
+
+var defaultIdGenerator = NewRandomIdGenerator("")
+
+// NewPayId 生成支付流水号，调用方需在创建支付记录前拿到流水号(如拼接支付参数)时使用
+func (s PayRecordService) NewPayId() (payId string, err error) {
+	generator := s.idGenerator
+	if generator == nil {
+		generator = defaultIdGenerator
+	}
+	payId, err = generator.NextId()
+	if err != nil {
+		return "", errors.WithMessage(err, "生成支付流水号失败")
+	}
+	return payId, nil
+}
+
+// fillPayIds 为未传 PayId 的支付记录生成流水号
+func (s PayRecordService) fillPayIds(ins []PayRecordCreateIn) (err error) {
+	for i := range ins {
+		if ins[i].PayId != "" {
+			continue
+		}
+		ins[i].PayId, err = s.NewPayId()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RandomIdGenerator 前缀 + 时间(YYYYMMDDHHMMSS) + 12位加密随机数，每秒 10^12 种组合，无需协调
+type RandomIdGenerator struct {
+	Prefix string // 前缀，如商户编码
+}
+
+func NewRandomIdGenerator(prefix string) *RandomIdGenerator {
+	return &RandomIdGenerator{Prefix: prefix}
+}
+
+var randomIdMax = big.NewInt(1_000_000_000_000)
+
+func (g *RandomIdGenerator) NextId() (id string, err error) {
+	n, err := rand.Int(rand.Reader, randomIdMax)
+	if err != nil {
+		return "", err
+	}
+	id = fmt.Sprintf("%s%s%012d", g.Prefix, time.Now().Format("20060102150405"), n.Int64())
+	return id, nil
+}
+
+// pseudoRandomId 格式同 RandomIdGenerator，使用伪随机数，系统随机源不可用时兜底
+func pseudoRandomId(prefix string) string {
+	return fmt.Sprintf("%s%s%012d", prefix, time.Now().Format("20060102150405"), mathrand.Int64N(randomIdMax.Int64()))
+}
+
+const (
+	snowflakeWorkerBits   = 10
+	snowflakeSequenceBits = 12
+	SnowflakeMaxWorkerId  = 1<<snowflakeWorkerBits - 1
+	snowflakeMaxSequence  = 1<<snowflakeSequenceBits - 1
+)
+
+// SnowflakeEpoch 雪花算法起始时间，41位毫秒时间戳可使用约69年
+var SnowflakeEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeIdGenerator 前缀 + 雪花算法ID(41位毫秒时间戳 + 10位机器ID + 12位序号)，趋势递增，
+// 每个实例需配置不同的机器ID，单实例每毫秒最多4096个
+type SnowflakeIdGenerator struct {
+	Prefix    string
+	workerId  int64
+	mu        sync.Mutex
+	lastMilli int64
+	sequence  int64
+}
+
+func NewSnowflakeIdGenerator(prefix string, workerId int64) (generator *SnowflakeIdGenerator, err error) {
+	if workerId < 0 || workerId > SnowflakeMaxWorkerId {
+		err = errors.Errorf("机器ID范围0-%d,收到:%d", SnowflakeMaxWorkerId, workerId)
+		return nil, err
+	}
+	return &SnowflakeIdGenerator{Prefix: prefix, workerId: workerId}, nil
+}
+
+func (g *SnowflakeIdGenerator) NextId() (id string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	milli := time.Since(SnowflakeEpoch).Milliseconds()
+	if milli < g.lastMilli { // 时钟回拨时沿用上次的时间戳，避免生成重复ID
+		milli = g.lastMilli
+	}
+	if milli == g.lastMilli {
+		g.sequence = (g.sequence + 1) & snowflakeMaxSequence
+		if g.sequence == 0 { // 当前毫秒序号用尽，借用下一毫秒
+			milli++
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastMilli = milli
+	value := milli<<(snowflakeWorkerBits+snowflakeSequenceBits) | g.workerId<<snowflakeSequenceBits | g.sequence
+	return fmt.Sprintf("%s%d", g.Prefix, value), nil
+}
+
+// UlidIdGenerator 前缀 + ULID(26位，毫秒时间戳 + 80位随机数)，同一毫秒内单调递增
+type UlidIdGenerator struct {
+	Prefix  string
+	mu      sync.Mutex
+	entropy io.Reader
+}
+
+func NewUlidIdGenerator(prefix string) *UlidIdGenerator {
+	return &UlidIdGenerator{Prefix: prefix, entropy: ulid.Monotonic(rand.Reader, 0)}
+}
+
+func (g *UlidIdGenerator) NextId() (id string, err error) {
+	g.mu.Lock() // 单调随机源非并发安全
+	defer g.mu.Unlock()
+	value, err := ulid.New(ulid.Now(), g.entropy)
+	if err != nil {
+		return "", err
+	}
+	return g.Prefix + value.String(), nil
+}
+
+// SequenceIdGenerator 前缀 + 日期(YYYYMMDD) + 12位数据库序号，按号段从 pay_id_sequence 表批量分配，
+// 多实例共享同一序列(以前缀区分)，实例重启时未用完的号段作废，序号递增但不连续
+type SequenceIdGenerator struct {
+	Prefix     string
+	step       int
+	repository repository.PayIdSequenceRepository
+	mu         sync.Mutex
+	next       int64
+	max        int64
+}
+
+// NewSequenceIdGenerator step 为每次从数据库分配的序号个数，不大于0时默认100
+func NewSequenceIdGenerator(handler sqlbuilder.Handler, prefix string, step int) *SequenceIdGenerator {
+	if step <= 0 {
+		step = 100
+	}
+	return &SequenceIdGenerator{
+		Prefix:     prefix,
+		step:       step,
+		repository: repository.NewPayIdSequenceRepository(handler),
+	}
+}
+
+// SequenceName_default 未设置前缀时的序列名称
+const SequenceName_default = "pay_id"
+
+func (g *SequenceIdGenerator) sequenceName() string {
+	name := strings.TrimSpace(g.Prefix)
+	if name == "" {
+		return SequenceName_default
+	}
+	return name
+}
+
+func (g *SequenceIdGenerator) NextId() (id string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.next == 0 || g.next > g.max {
+		maxValue, err := g.repository.Allocate(g.sequenceName(), g.step)
+		if err != nil {
+			return "", err
+		}
+		g.next, g.max = maxValue-int64(g.step)+1, maxValue
+	}
+	value := g.next
+	g.next++
+	id = fmt.Sprintf("%s%s%012d", g.Prefix, time.Now().Format("20060102"), value)
+	return id, nil
+}
@@ -0,0 +1,76 @@
+package paymentrecord_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+)
+
+func TestIdGenerators(t *testing.T) {
+	handler := newSqliteHandler(t)
+	snowflake, err := paymentrecord.NewSnowflakeIdGenerator("M01", 1)
+	require.NoError(t, err)
+	_, err = paymentrecord.NewSnowflakeIdGenerator("M01", paymentrecord.SnowflakeMaxWorkerId+1)
+	require.Error(t, err)
+	generators := map[string]paymentrecord.IdGenerator{
+		"random":    paymentrecord.NewRandomIdGenerator("M01"),
+		"snowflake": snowflake,
+		"ulid":      paymentrecord.NewUlidIdGenerator("M01"),
+		"sequence":  paymentrecord.NewSequenceIdGenerator(handler, "M01", 10),
+	}
+	for name, generator := range generators {
+		t.Run(name, func(t *testing.T) {
+			const goroutines, perGoroutine = 8, 200
+			var mu sync.Mutex
+			ids := make(map[string]struct{})
+			var errs []error
+			var wg sync.WaitGroup
+			for i := 0; i < goroutines; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < perGoroutine; j++ {
+						id, err := generator.NextId()
+						mu.Lock()
+						if err != nil {
+							errs = append(errs, err)
+						} else {
+							ids[id] = struct{}{}
+						}
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			require.Empty(t, errs) // require 只能在测试 goroutine 中调用
+			require.Len(t, ids, goroutines*perGoroutine)
+			for id := range ids {
+				require.True(t, strings.HasPrefix(id, "M01"), id)
+				require.LessOrEqual(t, len(id), 64, id)
+			}
+		})
+	}
+
+	// 多实例共享同一序列，号段不重叠
+	another := paymentrecord.NewSequenceIdGenerator(handler, "M01", 10)
+	id, err := another.NextId()
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(id, "000000001601"), id)
+}
+
+func TestCreateGeneratesPayId(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		s.SetIdGenerator(paymentrecord.NewUlidIdGenerator("M01"))
+		payIds, err := s.CreateWithResult(newCreateIn("", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 3000))
+		require.NoError(t, err)
+		require.Len(t, payIds, 2)
+		require.True(t, strings.HasPrefix(payIds[0], "M01"), payIds[0])
+		require.Equal(t, "p2", payIds[1])
+		record, err := s.Get(payIds[0])
+		require.NoError(t, err)
+		require.Equal(t, 2000, record.PayAmount)
+	})
+}
@@ -0,0 +1,160 @@
+package paymentrecord
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/paymentrecord/ledger"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+const (
+	LedgerAccount_order_receipts = "order_receipts" // 订单预收款(负债)，余额即订单已收未退金额
+)
+
+var ledgerAccountOrderReceipts = ledger.AccountIn{
+	AccountCode: LedgerAccount_order_receipts,
+	AccountName: "订单预收款",
+	AccountType: ledger.AccountType_liability,
+}
+
+// payAgentLedgerAccount 支付方式对应的资金科目，配置了抵扣科目(PayAgentConfig.DeductionAccountCode)的支付方式记入该费用科目，
+// 其余记入支付机构待清算资金
+func (s PayRecordService) payAgentLedgerAccount(payAgent string) ledger.AccountIn {
+	if agent, ok := s.payAgents.Get(payAgent); ok && agent.DeductionAccountCode != "" {
+		accountName := agent.DeductionAccountName
+		if accountName == "" {
+			accountName = fmt.Sprintf("%s抵扣", agent.Title)
+		}
+		return ledger.AccountIn{
+			AccountCode: agent.DeductionAccountCode,
+			AccountName: accountName,
+			AccountType: ledger.AccountType_expense,
+		}
+	}
+	return ledger.AccountIn{
+		AccountCode: fmt.Sprintf("clearing_%s", payAgent),
+		AccountName: fmt.Sprintf("待清算资金(%s)", payAgent),
+		AccountType: ledger.AccountType_asset,
+	}
+}
+
+// postPayLedger 支付完成记账：借 支付方式资金科目，贷 订单预收款
+func (s PayRecordService) postPayLedger(tx sqlbuilder.Handler, record repository.PayRecordModel) (err error) {
+	if s.handler == nil { // 未配置数据库时不记账
+		return nil
+	}
+	agentAccount := s.payAgentLedgerAccount(record.PayAgent)
+	txLedger := s.ledger.WithTxHandler(tx)
+	err = txLedger.SetAccount(agentAccount, ledgerAccountOrderReceipts)
+	if err != nil {
+		return err
+	}
+	entryIn := ledger.EntryIn{
+		EntryId: fmt.Sprintf("%s_%s", ledger.BizType_pay, record.PayId),
+		BizType: ledger.BizType_pay,
+		BizId:   record.PayId,
+		OrderId: record.OrderId,
+		Remark:  fmt.Sprintf("支付(%s)", record.PayAgent),
+		Postings: ledger.Postings{
+			ledger.Debit(agentAccount.AccountCode, record.PayAmount),
+			ledger.Credit(ledgerAccountOrderReceipts.AccountCode, record.PayAmount),
+		},
+	}
+	err = txLedger.Post(entryIn)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// refundEntryId 退款凭证号，由退款单号生成，同一退款重复记账时幂等
+func refundEntryId(refundId string) string {
+	return fmt.Sprintf("%s_%s", ledger.BizType_refund, refundId)
+}
+
+// postRefundLedger 退款记账：借 订单预收款，贷 支付方式资金科目
+func (s PayRecordService) postRefundLedger(tx sqlbuilder.Handler, record repository.PayRecordModel, refundId string, refundAmount int) (err error) {
+	agentAccount := s.payAgentLedgerAccount(record.PayAgent)
+	txLedger := s.ledger.WithTxHandler(tx)
+	err = txLedger.SetAccount(agentAccount, ledgerAccountOrderReceipts)
+	if err != nil {
+		return err
+	}
+	entryIn := ledger.EntryIn{
+		EntryId: refundEntryId(refundId),
+		BizType: ledger.BizType_refund,
+		BizId:   record.PayId,
+		OrderId: record.OrderId,
+		Remark:  fmt.Sprintf("退款(%s)", record.PayAgent),
+		Postings: ledger.Postings{
+			ledger.Debit(ledgerAccountOrderReceipts.AccountCode, refundAmount),
+			ledger.Credit(agentAccount.AccountCode, refundAmount),
+		},
+	}
+	err = txLedger.Post(entryIn)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetLedgerBalance 获取科目截止某时间点的余额
+func (s PayRecordService) GetLedgerBalance(accountCode string, asOf time.Time) (balance int, err error) {
+	err = s.requireDatabase()
+	if err != nil {
+		return 0, err
+	}
+	return s.ledger.Balance(accountCode, asOf)
+}
+
+type LedgerCheckResult struct {
+	OrderId      string `json:"orderId"`
+	RecordAmount int    `json:"recordAmount"` // 支付记录统计的已收未退金额
+	LedgerAmount int    `json:"ledgerAmount"` // 账本订单预收款余额
+}
+
+// CheckLedger 校验订单账本与支付记录一致：分录借贷平衡，且订单预收款余额等于已支付金额减去已退款金额
+func (s PayRecordService) CheckLedger(orderId string) (result LedgerCheckResult, err error) {
+	result.OrderId = orderId
+	err = s.requireDatabase()
+	if err != nil {
+		return result, err
+	}
+	err = s.ledger.CheckOrderBalanced(orderId)
+	if err != nil {
+		return result, err
+	}
+	records, err := s.recordRepository.GetByOrderId(orderId)
+	if err != nil {
+		return result, err
+	}
+	paidRecords := records.FilterByStatePaid()
+	refundAmount := 0
+	for _, record := range paidRecords {
+		splits, err := s.splitRepository.GetByPayId(record.PayId)
+		if err != nil {
+			return result, err
+		}
+		if len(splits) > 0 { // 有分账的支付记录，退款均分摊在分账上
+			refundAmount += splits.RefundAmount()
+			continue
+		}
+		refundAmount += record.RefundAmount
+	}
+	result.RecordAmount = paidRecords.TotalAmount() - refundAmount
+	result.LedgerAmount, err = s.ledger.OrderBalance(LedgerAccount_order_receipts, orderId)
+	if errors.Is(err, sqlbuilder.ErrNotFound) { // 尚未记账
+		err = nil
+	}
+	if err != nil {
+		return result, err
+	}
+	if result.RecordAmount != result.LedgerAmount {
+		err = errors.Errorf("账本与支付记录不一致,订单ID-%s,支付记录金额-%d,账本金额-%d", orderId, result.RecordAmount, result.LedgerAmount)
+		return result, err
+	}
+	return result, nil
+}
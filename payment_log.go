@@ -0,0 +1,171 @@
+package paymentrecord
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/suifengpiao14/paymentrecord/repository"
+)
+
+// SetLogger 设置日志，创建、支付、关闭、过期、失败、关闭订单等变更操作各记录一条日志，未设置时不记录
+func (s *PayRecordService) SetLogger(logger *slog.Logger) *PayRecordService {
+	s.logger = logger
+	return s
+}
+
+// SetLogLevel 设置操作成功时的日志级别(默认 Info)，operation 取值见 Operation_xxx；失败时固定为 Error
+func (s *PayRecordService) SetLogLevel(operation string, level slog.Level) *PayRecordService {
+	if s.logLevels == nil {
+		s.logLevels = make(map[string]slog.Level)
+	}
+	s.logLevels[operation] = level
+	return s
+}
+
+// logMutation 记录变更操作，在方法入口 defer 调用，err 指向方法返回的错误，state 在操作结束后获取变更后的状态
+func (s PayRecordService) logMutation(operation string, start time.Time, in any, out any, err *error, state func() string) {
+	if s.logger == nil {
+		return
+	}
+	level, ok := s.logLevels[operation]
+	if !ok {
+		level = slog.LevelInfo
+	}
+	if *err != nil {
+		level = slog.LevelError
+	}
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !s.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("operation", operation),
+		slog.Any("input", in),
+		slog.String("state", state()),
+		slog.Duration("duration", time.Since(start)),
+	}
+	if out != nil {
+		attrs = append(attrs, slog.Any("output", out))
+	}
+	if *err != nil {
+		attrs = append(attrs, slog.String("error", (*err).Error()))
+	}
+	s.logger.LogAttrs(ctx, level, "paymentrecord."+operation, attrs...)
+}
+
+// logError 记录不影响操作结果的错误，如风控决策记录失败、重复回调带的手续费有误
+func (s PayRecordService) logError(operation string, payId string, err error) {
+	if s.logger == nil {
+		return
+	}
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s.logger.LogAttrs(ctx, slog.LevelError, "paymentrecord."+operation,
+		slog.String("operation", operation),
+		slog.String("payId", payId),
+		slog.String("error", err.Error()),
+	)
+}
+
+// recordState 获取支付记录当前状态，记录不存在时为空
+func (s PayRecordService) recordState(payId string) func() string {
+	return func() string {
+		record, exists, err := s.recordRepository.GetByPayId(payId)
+		if err != nil || !exists {
+			return ""
+		}
+		return record.State
+	}
+}
+
+// orderState 获取收款单当前状态，收款单不存在时为空
+func (s PayRecordService) orderState(orderId string) func() string {
+	return func() string {
+		payOrder, exists, err := s.orderRepository.GetByOrderId(orderId)
+		if err != nil || !exists {
+			return ""
+		}
+		return payOrder.State
+	}
+}
+
+// createdState 创建成功的支付记录均为待支付
+func createdState(err *error) func() string {
+	return func() string {
+		if *err != nil {
+			return ""
+		}
+		return repository.PayOrderModel_state_pending.String()
+	}
+}
+
+// Mask 账号、姓名脱敏，保留首尾各四分之一(至少首字符)
+func Mask(value string) string {
+	runes := []rune(value)
+	n := len(runes)
+	if n == 0 {
+		return ""
+	}
+	if n <= 2 {
+		return string(runes[:1]) + strings.Repeat("*", n-1)
+	}
+	keep := max(n/4, 1)
+	return string(runes[:keep]) + strings.Repeat("*", n-2*keep) + string(runes[n-keep:])
+}
+
+// masked 脱敏后的副本，用于记录日志
+func (in PayRecordCreateIn) masked() PayRecordCreateIn {
+	in.RecipientAccount = Mask(in.RecipientAccount)
+	in.RecipientName = Mask(in.RecipientName)
+	in.PaymentAccount = Mask(in.PaymentAccount)
+	in.PaymentName = Mask(in.PaymentName)
+	splits := make(PaySplitIns, 0, len(in.Splits))
+	for _, split := range in.Splits {
+		split.RecipientAccount = Mask(split.RecipientAccount)
+		split.RecipientName = Mask(split.RecipientName)
+		splits = append(splits, split)
+	}
+	in.Splits = splits
+	return in
+}
+
+func maskCreateIns(ins []PayRecordCreateIn) []PayRecordCreateIn {
+	masked := make([]PayRecordCreateIn, 0, len(ins))
+	for _, in := range ins {
+		masked = append(masked, in.masked())
+	}
+	return masked
+}
+
+// LogValue ExtraFields 不记录日志
+func (in PayIn) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("payId", in.PayId),
+		slog.String("providerTradeNo", in.ProviderTradeNo),
+		slog.Bool("closeRestPending", in.CloseRestPending),
+		slog.Bool("autoRefundOverpayment", in.AutoRefundOverpayment),
+	)
+}
+
+func (in CloseIn) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("payId", in.PayId), slog.String("reason", in.Reason))
+}
+
+func (in ExpireIn) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("payId", in.PayId), slog.String("reason", in.Reason))
+}
+
+func (in FailIn) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("payId", in.PayId), slog.String("reason", in.Reason))
+}
+
+func (in CloseByOrderIdIn) LogValue() slog.Value {
+	return slog.GroupValue(slog.String("orderId", in.OrderId), slog.String("reason", in.Reason))
+}
@@ -0,0 +1,73 @@
+package paymentrecord_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+)
+
+func readLogEntries(t *testing.T, buf *bytes.Buffer) (entries []map[string]any) {
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := make(map[string]any)
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	buf.Reset()
+	return entries
+}
+
+func TestLogger(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		buf := &bytes.Buffer{}
+		s.SetLogger(slog.New(slog.NewJSONHandler(buf, nil)))
+		in := newCreateIn("p1", "o1", 5000, 2000)
+		in.PaymentAccount = "6222021234567890"
+		in.PaymentName = "张三"
+		err := s.Create(in, newCreateIn("p2", "o1", 5000, 3000))
+		require.NoError(t, err)
+		require.NotContains(t, buf.String(), "6222021234567890")
+		entries := readLogEntries(t, buf)
+		require.Len(t, entries, 1)
+		require.Equal(t, "paymentrecord.create", entries[0]["msg"])
+		require.Equal(t, repository.PayOrderModel_state_pending.String(), entries[0]["state"])
+		require.Contains(t, entries[0], "duration")
+		logged := entries[0]["input"].([]any)[0].(map[string]any)
+		require.Equal(t, "6222********7890", logged["paymentAccount"])
+		require.Equal(t, "张*", logged["paymentName"])
+
+		err = s.Close(paymentrecord.CloseIn{PayId: "p2", Reason: "用户取消"})
+		require.NoError(t, err)
+		entries = readLogEntries(t, buf)
+		require.Len(t, entries, 1)
+		require.Equal(t, slog.LevelInfo.String(), entries[0]["level"])
+		require.Equal(t, map[string]any{"payId": "p2", "reason": "用户取消"}, entries[0]["input"])
+		require.Equal(t, repository.PayOrderModel_state_closed.String(), entries[0]["state"])
+
+		s.SetLogLevel(paymentrecord.Operation_pay, slog.LevelDebug) // 低于 handler 级别，成功时不输出
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+		require.Empty(t, readLogEntries(t, buf))
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "notExists"})
+		require.Error(t, err)
+		entries = readLogEntries(t, buf)
+		require.Len(t, entries, 1)
+		require.Equal(t, slog.LevelError.String(), entries[0]["level"])
+		require.Equal(t, err.Error(), entries[0]["error"])
+	})
+}
+
+func TestMask(t *testing.T) {
+	require.Equal(t, "", paymentrecord.Mask(""))
+	require.Equal(t, "张*", paymentrecord.Mask("张三"))
+	require.Equal(t, "张*丰", paymentrecord.Mask("张三丰"))
+	require.Equal(t, "6222********7890", paymentrecord.Mask("6222021234567890"))
+}
@@ -0,0 +1,70 @@
+package paymentrecord_test
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+func TestMemoryStoreRollback(t *testing.T) {
+	store := repository.NewMemoryStore(nil)
+	orderRepository, recordRepository := store.PayOrderRepository(), store.PayRecordRepository()
+	err := recordRepository.Create(repository.PayRecordCreateIn{
+		PayId:       "p0",
+		OrderId:     "o0",
+		OrderAmount: 1000,
+		PayAmount:   1000,
+		PayAgent:    repository.PayingAgent_Wechat,
+		UserId:      "u1",
+		State:       string(repository.PayOrderModel_state_pending),
+	})
+	require.NoError(t, err)
+
+	errRollback := errors.New("rollback")
+	err = orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+		err = orderRepository.WithTxHandler(tx).Set(repository.PayOrderSetIn{OrderId: "o1", OrderAmount: 1000, UserId: "u1"})
+		require.NoError(t, err)
+		err = recordRepository.WithTxHandler(tx).TransformByIdentity(repository.Action_pay_record_Pay, "p0")
+		require.NoError(t, err)
+		// 其它事务在本事务回滚前提交
+		err = orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+			return orderRepository.WithTxHandler(tx).Set(repository.PayOrderSetIn{OrderId: "o2", OrderAmount: 2000, UserId: "u1"})
+		})
+		require.NoError(t, err)
+		return errRollback
+	})
+	require.ErrorIs(t, err, errRollback)
+
+	_, exists, err := orderRepository.GetByOrderId("o1")
+	require.NoError(t, err)
+	require.False(t, exists)
+	_, exists, err = orderRepository.GetByOrderId("o2") // 已提交的事务不受影响
+	require.NoError(t, err)
+	require.True(t, exists)
+	record, err := recordRepository.GetByPayIdMust("p0")
+	require.NoError(t, err)
+	require.Equal(t, string(repository.PayOrderModel_state_pending), record.State)
+}
+
+func TestMemoryWithoutDatabase(t *testing.T) {
+	store := repository.NewMemoryStore(nil)
+	s := paymentrecord.NewPayRecordServiceWithRepository(nil, store.PayOrderRepository(), store.PayRecordRepository())
+	err := s.Create(newCreateIn("p1", "o1", 1000, 1000))
+	require.NoError(t, err)
+	isOrderPayFinished, err := s.Pay(paymentrecord.PayIn{PayId: "p1", ProviderTradeNo: "t1"})
+	require.NoError(t, err)
+	require.True(t, isOrderPayFinished)
+
+	in := newCreateIn("p2", "o2", 1000, 1000)
+	in.Splits = paymentrecord.PaySplitIns{{RecipientAccount: "seller_001", SplitRole: "seller", Amount: 1000}}
+	err = s.Create(in)
+	require.ErrorIs(t, err, paymentrecord.ErrDatabaseRequired)
+	_, err = s.RefundSplit(paymentrecord.RefundSplitIn{RefundId: "r1", PayId: "p1", RefundAmount: 100})
+	require.ErrorIs(t, err, paymentrecord.ErrDatabaseRequired)
+	_, err = s.CheckLedger("o1")
+	require.ErrorIs(t, err, paymentrecord.ErrDatabaseRequired)
+}
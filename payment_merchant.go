@@ -0,0 +1,30 @@
+package paymentrecord
+
+import (
+	"github.com/pkg/errors"
+)
+
+// WithMerchantId 返回按商户隔离的服务，只读写、变更该商户的收款单、支付记录、分账及账本。
+// 未调用时为空商户，即未启用多商户时的数据
+func (s PayRecordService) WithMerchantId(merchantId string) *PayRecordService {
+	s.merchantId = merchantId
+	s.orderRepository = s.orderRepository.WithMerchantId(merchantId)
+	s.recordRepository = s.recordRepository.WithMerchantId(merchantId)
+	s.adjustRepository = s.adjustRepository.WithMerchantId(merchantId)
+	s.overpaymentRepository = s.overpaymentRepository.WithMerchantId(merchantId)
+	s.splitRepository = s.splitRepository.WithMerchantId(merchantId)
+	s.ledger = s.ledger.WithMerchantId(merchantId)
+	return &s
+}
+
+// forMerchant 入参指定商户时切换到该商户，已按商户隔离的服务不允许操作其它商户
+func (s PayRecordService) forMerchant(merchantId string) (scoped PayRecordService, err error) {
+	if merchantId == "" || merchantId == s.merchantId {
+		return s, nil
+	}
+	if s.merchantId != "" {
+		err = errors.Errorf("商户不一致,当前商户-%s,收到商户-%s", s.merchantId, merchantId)
+		return s, err
+	}
+	return *s.WithMerchantId(merchantId), nil
+}
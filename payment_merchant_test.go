@@ -0,0 +1,126 @@
+package paymentrecord_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+)
+
+func TestMerchantIsolation(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		m1, m2 := s.WithMerchantId("m1"), s.WithMerchantId("m2")
+		err := m1.Create(newCreateIn("p1", "o1", 5000, 5000))
+		require.NoError(t, err)
+		err = m2.Create(newCreateIn("p2", "o1", 3000, 3000)) // 订单号在商户内唯一
+		require.NoError(t, err)
+
+		_, err = m2.Get("p1")
+		require.Error(t, err)
+		_, err = s.Get("p1") // 未指定商户时只能访问空商户的数据
+		require.Error(t, err)
+		_, err = m2.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.Error(t, err)
+		err = m2.Close(paymentrecord.CloseIn{PayId: "p1", Reason: "其它商户关闭"})
+		require.Error(t, err)
+		requireRecordState(t, m1, "p1", repository.PayOrderModel_state_pending)
+
+		payRecords, err := m2.GetOrderPayInfo("o1")
+		require.NoError(t, err)
+		require.Len(t, payRecords, 1)
+		require.Equal(t, "p2", payRecords[0].PayId)
+		require.Equal(t, "m2", payRecords[0].MerchantId)
+
+		_, err = m1.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+		isPaid, err := m1.IsPaid("o1")
+		require.NoError(t, err)
+		require.True(t, isPaid)
+		isPaid, err = m2.IsPaid("o1")
+		require.NoError(t, err)
+		require.False(t, isPaid)
+
+		err = m2.CloseByOrderId(paymentrecord.CloseByOrderIdIn{OrderId: "o1", Reason: "测试关闭"})
+		require.NoError(t, err)
+		requireRecordState(t, m2, "p2", repository.PayOrderModel_state_closed)
+		requireRecordState(t, m1, "p1", repository.PayOrderModel_state_paid)
+	})
+}
+
+func TestMerchantIdInput(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		in := newCreateIn("p1", "o1", 5000, 5000)
+		in.MerchantId = "m1"
+		err := s.Create(in) // 入参指定商户
+		require.NoError(t, err)
+		record, err := s.WithMerchantId("m1").Get("p1")
+		require.NoError(t, err)
+		require.Equal(t, "m1", record.MerchantId)
+
+		in = newCreateIn("p2", "o2", 5000, 5000)
+		in.MerchantId = "m1"
+		err = s.WithMerchantId("m2").Create(in) // 已按商户隔离的服务不能写入其它商户
+		require.Error(t, err)
+
+		in2 := newCreateIn("p3", "o3", 5000, 2000)
+		in2.MerchantId = "m2"
+		err = s.Create(newCreateIn("p4", "o3", 5000, 3000), in2) // 同批支付记录须属于同一商户
+		require.Error(t, err)
+	})
+}
+
+func TestMerchantSplitLedgerIsolation(t *testing.T) {
+	eachDatabaseBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		m1, m2 := s.WithMerchantId("m1"), s.WithMerchantId("m2")
+		in1 := newCreateIn("p1", "o1", 5000, 5000)
+		in1.Splits = paymentrecord.PaySplitIns{{RecipientAccount: "seller_001", Rate: 10000}}
+		err := m1.Create(in1)
+		require.NoError(t, err)
+		in2 := newCreateIn("p2", "o1", 3000, 3000)
+		in2.Splits = paymentrecord.PaySplitIns{{RecipientAccount: "seller_001", Rate: 10000}}
+		err = m2.Create(in2)
+		require.NoError(t, err)
+		_, err = m1.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+
+		splits, err := m2.GetSplits("p1")
+		require.NoError(t, err)
+		require.Empty(t, splits)
+		err = m2.SettleSplit(paymentrecord.SettleSplitIn{SplitId: "p1_1"}) // 不能结算其它商户的分账
+		require.Error(t, err)
+		splitLedger, err := m2.GetSplitLedger("seller_001")
+		require.NoError(t, err)
+		require.Len(t, splitLedger.Splits, 1)
+		require.Equal(t, 3000, splitLedger.PendingAmount)
+		require.Zero(t, splitLedger.SettleableAmount)
+		err = m1.SettleSplit(paymentrecord.SettleSplitIn{SplitId: "p1_1"})
+		require.NoError(t, err)
+
+		asOf := time.Now().Add(time.Minute)
+		balance, err := m2.GetLedgerBalance(paymentrecord.LedgerAccount_order_receipts, asOf)
+		require.NoError(t, err)
+		require.Zero(t, balance)
+		balance, err = m1.GetLedgerBalance(paymentrecord.LedgerAccount_order_receipts, asOf)
+		require.NoError(t, err)
+		require.Equal(t, 5000, balance)
+		result, err := m2.CheckLedger("o1")
+		require.NoError(t, err)
+		require.Equal(t, paymentrecord.LedgerCheckResult{OrderId: "o1"}, result)
+
+		// 退款单号在商户内唯一，不同商户可重复
+		_, err = m2.Pay(paymentrecord.PayIn{PayId: "p2"})
+		require.NoError(t, err)
+		_, err = m1.RefundSplit(paymentrecord.RefundSplitIn{RefundId: "r1", PayId: "p1", RefundAmount: 1000})
+		require.NoError(t, err)
+		_, err = m2.RefundSplit(paymentrecord.RefundSplitIn{RefundId: "r1", PayId: "p2", RefundAmount: 500})
+		require.NoError(t, err)
+		result, err = m1.CheckLedger("o1")
+		require.NoError(t, err)
+		require.Equal(t, paymentrecord.LedgerCheckResult{OrderId: "o1", RecordAmount: 4000, LedgerAmount: 4000}, result)
+		result, err = m2.CheckLedger("o1")
+		require.NoError(t, err)
+		require.Equal(t, paymentrecord.LedgerCheckResult{OrderId: "o1", RecordAmount: 2500, LedgerAmount: 2500}, result)
+	})
+}
@@ -0,0 +1,90 @@
+package paymentrecord
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// MetricsHook 支付流程指标钩子，未设置时不采集，Prometheus 实现见 metrics 包
+type MetricsHook interface {
+	// Transition 支付记录状态变更(含创建)，event 为状态机事件或 MetricsEvent_create
+	Transition(event string, payAgent string)
+	// PaidDuration 支付记录从创建到支付成功的耗时
+	PaidDuration(payAgent string, duration time.Duration)
+	// Error 操作失败，errType 取值见 ErrorType
+	Error(operation string, errType string)
+}
+
+const MetricsEvent_create = "actionCreate" // 创建支付记录，状态机无对应事件
+
+const (
+	Operation_create         = "create"
+	Operation_pay            = "pay"
+	Operation_close          = "close"
+	Operation_expire         = "expire"
+	Operation_fail           = "fail"
+	Operation_close_by_order = "closeByOrder"
+	Operation_adjust_amount  = "adjustAmount"
+	Operation_delete         = "delete"
+	Operation_restore        = "restore"
+)
+
+const (
+	ErrorType_not_found          = "notFound"
+	ErrorType_duplicate_callback = "duplicateCallback"
+	ErrorType_archived           = "archived"
+	ErrorType_other              = "other"
+)
+
+// ErrorType 错误分类，作为指标标签需保持取值有限
+func ErrorType(err error) string {
+	switch {
+	case errors.Is(err, sqlbuilder.ErrNotFound):
+		return ErrorType_not_found
+	case errors.Is(err, ErrDuplicateProviderTradeNo):
+		return ErrorType_duplicate_callback
+	case errors.Is(err, repository.ErrArchived):
+		return ErrorType_archived
+	default:
+		return ErrorType_other
+	}
+}
+
+// SetMetricsHook 设置指标钩子
+func (s *PayRecordService) SetMetricsHook(hook MetricsHook) *PayRecordService {
+	s.metricsHook = hook
+	return s
+}
+
+func (s PayRecordService) observeTransition(event string, payAgent string) {
+	if s.metricsHook == nil {
+		return
+	}
+	s.metricsHook.Transition(event, payAgent)
+}
+
+func (s PayRecordService) observePaid(record repository.PayRecordModel, paidAt time.Time) {
+	if s.metricsHook == nil {
+		return
+	}
+	createdAt, err := repository.ParseDateTime(record.CreatedAt)
+	if err != nil {
+		return
+	}
+	s.metricsHook.PaidDuration(record.PayAgent, paidAt.Sub(createdAt))
+}
+
+func (s PayRecordService) observeError(operation string, err error) {
+	if s.metricsHook == nil || err == nil {
+		return
+	}
+	s.metricsHook.Error(operation, ErrorType(err))
+}
+
+// GetPendingStats 按支付方式统计待支付记录数及金额，供指标采集
+func (s PayRecordService) GetPendingStats() (stats repository.PendingStats, err error) {
+	return s.recordRepository.GetPendingStats()
+}
@@ -0,0 +1,77 @@
+package paymentrecord_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+)
+
+type recordMetrics struct {
+	transitions map[string]int // event/payAgent
+	paid        []time.Duration
+	errors      map[string]int // operation/errType
+}
+
+func newRecordMetrics() *recordMetrics {
+	return &recordMetrics{transitions: map[string]int{}, errors: map[string]int{}}
+}
+
+func (m *recordMetrics) Transition(event string, payAgent string) {
+	m.transitions[fmt.Sprintf("%s/%s", event, payAgent)]++
+}
+
+func (m *recordMetrics) PaidDuration(payAgent string, duration time.Duration) {
+	m.paid = append(m.paid, duration)
+}
+
+func (m *recordMetrics) Error(operation string, errType string) {
+	m.errors[fmt.Sprintf("%s/%s", operation, errType)]++
+}
+
+func TestMetricsHook(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		m := newRecordMetrics()
+		s.SetMetricsHook(m)
+		agent := repository.PayingAgent_Wechat
+		err := s.Create(newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 3000))
+		require.NoError(t, err)
+		err = s.Create(newCreateIn("p3", "o1", 5000, 1000))
+		require.Error(t, err) // 超出订单金额
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+		err = s.Close(paymentrecord.CloseIn{PayId: "p2", Reason: "用户取消"})
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "notExists"})
+		require.Error(t, err)
+
+		require.Equal(t, 2, m.transitions[fmt.Sprintf("%s/%s", paymentrecord.MetricsEvent_create, agent)])
+		require.Equal(t, 1, m.transitions[fmt.Sprintf("%s/%s", repository.Action_pay_record_Pay, agent)])
+		require.Equal(t, 1, m.transitions[fmt.Sprintf("%s/%s", repository.Action_pay_record_Close, agent)])
+		require.Len(t, m.paid, 1)
+		require.GreaterOrEqual(t, m.paid[0], time.Duration(0))
+		require.Equal(t, 1, m.errors[fmt.Sprintf("%s/%s", paymentrecord.Operation_create, paymentrecord.ErrorType_other)])
+		require.Equal(t, 1, m.errors[fmt.Sprintf("%s/%s", paymentrecord.Operation_pay, paymentrecord.ErrorType_not_found)])
+	})
+}
+
+func TestGetPendingStats(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		alipay := newCreateIn("p3", "o2", 1000, 1000)
+		alipay.PayAgent = repository.PayingAgent_Alipay
+		require.NoError(t, s.Create(newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 3000)))
+		require.NoError(t, s.Create(alipay))
+		_, err := s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+
+		stats, err := s.GetPendingStats()
+		require.NoError(t, err)
+		require.ElementsMatch(t, repository.PendingStats{
+			{PayAgent: repository.PayingAgent_Wechat, Count: 1, Amount: 3000},
+			{PayAgent: repository.PayingAgent_Alipay, Count: 1, Amount: 1000},
+		}, stats)
+	})
+}
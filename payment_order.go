@@ -1,18 +1,44 @@
 package paymentrecord
 
 import (
+	"context"
+	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/paymentrecord/encryption"
+	"github.com/suifengpiao14/paymentrecord/ledger"
 	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/paymentrecord/risk"
 	"github.com/suifengpiao14/sqlbuilder"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type _PayOrderService struct {
-	orderRepository  repository.PayOrderRepository
-	recordRepository repository.PayRecordRepository
+	orderRepository       repository.PayOrderRepository
+	recordRepository      repository.PayRecordRepository
+	splitRepository       repository.PaySplitRepository
+	ledger                ledger.Ledger
+	adjustRepository      repository.PayOrderAdjustmentRepository
+	gatewayCloseHook      GatewayCloseHook
+	overpaymentRepository repository.OverpaymentRepository
+	gatewayRefundHook     GatewayRefundHook
+	metricsHook           MetricsHook
+	tracer                trace.Tracer
+	ctx                   context.Context // 链路追踪上下文，见 WithContext
+	logger                *slog.Logger
+	logLevels             map[string]slog.Level
+	idGenerator           IdGenerator
+	merchantId            string // 所属商户，见 WithMerchantId
+	riskEngine            *risk.Engine
+	handler               sqlbuilder.Handler           // 分账、账本、超付及调账记录所在的库，为空时(只使用内存仓库)不记录
+	cipher                *encryption.Cipher           // 敏感字段加密，见 SetFieldCipher
+	payAgents             *repository.PayAgentRegistry // 支付方式注册表，见 SetPayAgents
 }
 
 type PayOrderSetIn struct {
+	MerchantId  string            `json:"merchantId"` // 商户ID，为空时为服务所属商户
 	OrderId     string            `json:"orderId"`
 	OrderAmount int               `json:"orderAmount"`
 	UserId      string            `json:"userId"`
@@ -21,6 +47,10 @@ type PayOrderSetIn struct {
 }
 
 func (s _PayOrderService) Set(in PayOrderSetIn) (err error) {
+	scoped, err := PayRecordService(s).forMerchant(in.MerchantId)
+	if err != nil {
+		return err
+	}
 	payOrderSetIn := repository.PayOrderSetIn{
 		OrderId:     in.OrderId,
 		OrderAmount: in.OrderAmount,
@@ -28,62 +58,106 @@ func (s _PayOrderService) Set(in PayOrderSetIn) (err error) {
 		Remark:      in.Remark,
 		ExtraFields: in.ExtraFields,
 	}
-	err = s.orderRepository.Set(payOrderSetIn)
+	err = scoped.orderRepository.Set(payOrderSetIn)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-type CloseByOrderIdIn struct {
-	OrderId     string `json:"orderId" validate:"required"`
-	Reason      string `json:"reason"`
-	ExtraFields sqlbuilder.Fields
+type OpenOrderSetIn struct {
+	MerchantId  string            `json:"merchantId"` // 商户ID，为空时为服务所属商户
+	OrderId     string            `json:"orderId"`
+	UnitPrice   int               `json:"unitPrice"` // 单价，单位分
+	Capacity    int               `json:"capacity"`  // 名额上限，0表示不限
+	Deadline    string            `json:"deadline"`  // 截止时间，格式 2006-01-02 15:04:05，空表示不限
+	UserId      string            `json:"userId"`
+	Remark      string            `json:"remark"`
+	ExtraFields sqlbuilder.Fields `json:"extraFields"`
 }
 
-func (s _PayOrderService) Close(in CloseByOrderIdIn) (err error) {
-	orderId := in.OrderId
-
-	orderStateMichine := s.orderRepository.GetStateMachine()
-	payOrderStateModel, err := orderStateMichine.GetStateByIdentity(orderId)
+// SetOpen 创建开放式订单(如活动报名)，每个人收费金额固定，人数不固定
+func (s _PayOrderService) SetOpen(in OpenOrderSetIn) (err error) {
+	scoped, err := PayRecordService(s).forMerchant(in.MerchantId)
 	if err != nil {
 		return err
 	}
-	// 验证支付单是否可以关闭
-	err = orderStateMichine.CanAsErr(payOrderStateModel.State, repository.Action_pay_order_Close)
-	if err != nil {
-		return err
-	}
-
-	//验证支付单下的所有支付记录是否可以关闭
-	recordStateModels, err := orderStateMichine.GetAll(sqlbuilder.Fields{
-		repository.NewOrderId(orderId),
-	})
-	if err != nil {
-		return err
-	}
-	for _, recordStateModel := range recordStateModels {
-		err = orderStateMichine.CanAsErr(recordStateModel.State, repository.Action_pay_record_Close)
+	if in.Deadline != "" {
+		_, err = time.ParseInLocation(time.DateTime, in.Deadline, time.Local)
 		if err != nil {
+			err = errors.WithMessagef(err, "截止时间格式有误:%s", in.Deadline)
 			return err
 		}
 	}
+	payOrderSetIn := repository.PayOrderSetIn{
+		OrderId:     in.OrderId,
+		OrderType:   repository.OrderType_open,
+		UnitPrice:   in.UnitPrice,
+		Capacity:    in.Capacity,
+		Deadline:    in.Deadline,
+		UserId:      in.UserId,
+		Remark:      in.Remark,
+		ExtraFields: in.ExtraFields,
+	}
+	err = scoped.orderRepository.Set(payOrderSetIn)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+type CloseByOrderIdIn struct {
+	OrderId     string `json:"orderId" validate:"required"`
+	Reason      string `json:"reason"`
+	ExtraFields sqlbuilder.Fields
+}
+
+func (s _PayOrderService) Close(in CloseByOrderIdIn) (err error) {
+	orderId := in.OrderId
 	stateCloseExtraFs := sqlbuilder.Fields{
 		repository.NewClosedAt(time.Now().Format(time.DateTime)),
 		repository.NewRemark(in.Reason),
 	}
 	stateCloseExtraFs = stateCloseExtraFs.Add(in.ExtraFields...)
-	err = orderStateMichine.Transaction(func(txHandler sqlbuilder.Handler) (err error) {
-		txOrderStateMachine := orderStateMichine.WithTxHandler(txHandler)
+	var records repository.PayRecordModels
+	err = s.orderRepository.TransactionForMutiTable(func(txHandler sqlbuilder.Handler) (err error) {
+		// 锁定后再读取订单及支付记录，与支付回调串行，基于最新状态关闭，汇总不会重复累加
+		payOrder, exists, err := s.orderRepository.WithTxHandler(txHandler).LockByOrderId(orderId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sqlbuilder.ErrNotFound
+		}
+		// 验证支付单是否可以关闭
+		err = s.orderRepository.CanAsErr(payOrder.State, repository.Action_pay_order_Close)
+		if err != nil {
+			return err
+		}
+		//验证支付单下的所有支付记录是否可以关闭
+		allRecords, err := s.recordRepository.WithTxHandler(txHandler).GetByOrderId(orderId)
+		if err != nil {
+			return err
+		}
+		records = nil
+		for _, record := range allRecords {
+			if payOrder.IsOpen() && record.State != repository.PayOrderModel_state_pending.String() { // 开放式订单(报名截止)只关闭待支付记录，已报名支付的记录保留
+				continue
+			}
+			err = s.recordRepository.CanAsErr(record.State, repository.Action_pay_record_Close)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
 		//关闭订单
-		err = txOrderStateMachine.Transform(repository.Action_pay_order_Close, payOrderStateModel.State, payOrderStateModel.Identity, stateCloseExtraFs...)
+		err = s.orderRepository.WithTxHandler(txHandler).Transform(repository.Action_pay_order_Close, payOrder.State, payOrder.OrderId, stateCloseExtraFs...)
 		if err != nil {
 			return err
 		}
-		txRecordStateMachine := s.recordRepository.GetStateMachine().WithTxHandler(txHandler)
 		//关闭订单下的所有支付记录
-		for _, recordStateModel := range recordStateModels {
-			err = txRecordStateMachine.Transform(repository.Action_pay_record_Close, recordStateModel.State, recordStateModel.Identity, stateCloseExtraFs...)
+		for _, record := range records {
+			err = PayRecordService(s).transformRecord(txHandler, repository.Action_pay_record_Close, record, stateCloseExtraFs...)
 			if err != nil {
 				return err
 			}
@@ -93,5 +167,146 @@ func (s _PayOrderService) Close(in CloseByOrderIdIn) (err error) {
 	if err != nil {
 		return err
 	}
+	for _, record := range records {
+		PayRecordService(s).observeTransition(repository.Action_pay_record_Close, record.PayAgent)
+	}
 	return nil
 }
+
+type AdjustOrderAmountIn struct {
+	OrderId     string `json:"orderId" validate:"required"`
+	OrderAmount int    `json:"orderAmount" validate:"required"` // 调整后的订单金额，单位分
+	Reason      string `json:"reason" validate:"required"`
+}
+
+type AdjustOrderAmountOut struct {
+	OrderId            string   `json:"orderId"`
+	OldOrderAmount     int      `json:"oldOrderAmount"`
+	OrderAmount        int      `json:"orderAmount"`
+	PaidAmount         int      `json:"paidAmount"`
+	RefundDueAmount    int      `json:"refundDueAmount"` // 已支付金额超出调整后订单金额的部分，需要退款
+	ClosedPayIds       []string `json:"closedPayIds"`    // 调减时关闭的待支付记录
+	IsOrderPayFinished bool     `json:"isOrderPayFinished"`
+}
+
+// AdjustAmount 调整订单金额，需配置数据库记录调整记录。调增时为后续支付记录腾出金额，已支付的订单重新待支付；调减时按创建时间倒序关闭待支付记录，直到待支付与已支付之和不超过调整后金额，已支付超出部分作为应退款金额返回
+func (s _PayOrderService) AdjustAmount(in AdjustOrderAmountIn) (out AdjustOrderAmountOut, err error) {
+	if in.OrderAmount <= 0 {
+		err = errors.New("订单金额必须大于0")
+		return out, err
+	}
+	if in.Reason == "" {
+		err = errors.New("调整原因不能为空")
+		return out, err
+	}
+	err = PayRecordService(s).requireDatabase() // 调整记录存于数据库，未配置时不允许调整，避免金额变更没有记录
+	if err != nil {
+		return out, err
+	}
+	closeFs := sqlbuilder.Fields{
+		repository.NewClosedAt(time.Now().Format(time.DateTime)),
+		repository.NewRemark(in.Reason),
+	}
+	var closingRecords repository.PayRecordModels
+	err = s.orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+		// 锁定收款单后再读取，与支付记录创建、支付回调串行，基于最新的已支付、待支付金额调整
+		payOrder, exists, err := s.orderRepository.WithTxHandler(tx).LockByOrderId(in.OrderId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sqlbuilder.ErrNotFound
+		}
+		if payOrder.IsOpen() {
+			err = errors.Errorf("开放式订单按单价收费，不支持调整金额,订单ID-%s", in.OrderId)
+			return err
+		}
+		if payOrder.State == repository.PayOrderModel_state_closed.String() {
+			err = errors.Errorf("订单状态不允许调整金额,订单ID-%s,订单状态-%s", in.OrderId, payOrder.State)
+			return err
+		}
+		if payOrder.OrderAmount == in.OrderAmount {
+			err = errors.Errorf("订单金额未变化,订单ID-%s,订单金额-%d", in.OrderId, in.OrderAmount)
+			return err
+		}
+		txRecordRepository := s.recordRepository.WithTxHandler(tx)
+		records, err := txRecordRepository.GetByOrderId(in.OrderId)
+		if err != nil {
+			return err
+		}
+		paidAmount := records.FilterByStatePaid().TotalAmount()
+		out = AdjustOrderAmountOut{
+			OrderId:        in.OrderId,
+			OldOrderAmount: payOrder.OrderAmount,
+			OrderAmount:    in.OrderAmount,
+			PaidAmount:     paidAmount,
+		}
+		if paidAmount > in.OrderAmount {
+			out.RefundDueAmount = paidAmount - in.OrderAmount
+		}
+		out.IsOrderPayFinished = paidAmount >= in.OrderAmount
+
+		// 调减金额时，关闭超出的待支付记录(后创建的先关闭)
+		pendingRecords := records.FilterByStatePending()
+		pendingAmount := pendingRecords.TotalAmount()
+		for i := len(pendingRecords) - 1; i >= 0 && paidAmount+pendingAmount > in.OrderAmount; i-- {
+			closingRecords = append(closingRecords, pendingRecords[i])
+			out.ClosedPayIds = append(out.ClosedPayIds, pendingRecords[i].PayId)
+			pendingAmount -= pendingRecords[i].PayAmount
+		}
+		for _, record := range closingRecords {
+			err = PayRecordService(s).transformRecord(tx, repository.Action_pay_record_Close, record, closeFs...)
+			if err != nil {
+				return err
+			}
+		}
+		err = s.orderRepository.WithTxHandler(tx).UpdateOrderAmount(in.OrderId, in.OrderAmount)
+		if err != nil {
+			return err
+		}
+		err = txRecordRepository.UpdateOrderAmount(in.OrderId, in.OrderAmount)
+		if err != nil {
+			return err
+		}
+		adjustmentIn := repository.PayOrderAdjustmentCreateIn{
+			OrderId:         in.OrderId,
+			OldOrderAmount:  payOrder.OrderAmount,
+			OrderAmount:     in.OrderAmount,
+			PaidAmount:      paidAmount,
+			RefundDueAmount: out.RefundDueAmount,
+			ClosedPayIds:    strings.Join(out.ClosedPayIds, ","),
+			Remark:          in.Reason,
+		}
+		err = s.adjustRepository.WithTxHandler(tx).Create(adjustmentIn) // 与金额变更同一事务写入调整记录，任何调整都有记录
+		if err != nil {
+			return err
+		}
+		isPaid := payOrder.State == repository.PayOrderModel_state_paid.String()
+		switch {
+		case out.IsOrderPayFinished && !isPaid: // 调减后已支付金额足够支付订单
+			err = s.orderRepository.WithTxHandler(tx).Transform(repository.Action_pay_order_Pay, payOrder.State, payOrder.OrderId)
+		case !out.IsOrderPayFinished && isPaid: // 已支付订单调增金额，重新待支付以便补差价
+			err = s.orderRepository.WithTxHandler(tx).Transform(repository.Action_pay_order_Reopen, payOrder.State, payOrder.OrderId)
+		}
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return out, err
+	}
+	for _, record := range closingRecords {
+		PayRecordService(s).observeTransition(repository.Action_pay_record_Close, record.PayAgent)
+	}
+	return out, nil
+}
+
+// GetAdjustments 获取订单金额调整记录
+func (s _PayOrderService) GetAdjustments(orderId string) (adjustments repository.PayOrderAdjustmentModels, err error) {
+	err = PayRecordService(s).requireDatabase()
+	if err != nil {
+		return nil, err
+	}
+	return s.adjustRepository.GetByOrderId(orderId)
+}
@@ -0,0 +1,178 @@
+package paymentrecord
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+var ErrDuplicateProviderTradeNo = errors.New("支付渠道交易号已被其它支付记录使用(重复回调)")
+
+// GatewayRefundHook 支付渠道退款钩子，自动退还超付金额时调用
+type GatewayRefundHook func(record repository.PayRecordModel, refundAmount int) (err error)
+
+// SetGatewayRefundHook 设置支付渠道退款钩子
+func (s *PayRecordService) SetGatewayRefundHook(hook GatewayRefundHook) *PayRecordService {
+	s.gatewayRefundHook = hook
+	return s
+}
+
+// checkDuplicateCallback 检测不同支付流水号收到同一渠道交易号的回调，发现重复时在支付回调事务内记录超付并返回 true
+func (s PayRecordService) checkDuplicateCallback(tx sqlbuilder.Handler, record repository.PayRecordModel, providerTradeNo string) (duplicated bool, err error) {
+	records, err := s.recordRepository.WithTxHandler(tx).GetByProviderTradeNo(providerTradeNo)
+	if err != nil {
+		return false, err
+	}
+	var paidRecord *repository.PayRecordModel
+	for _, r := range records.FilterByStatePaid() {
+		if r.PayId != record.PayId {
+			paidRecord = &r
+			break
+		}
+	}
+	if paidRecord == nil {
+		return false, nil
+	}
+	if s.handler == nil { // 未配置数据库时不记录超付
+		return true, nil
+	}
+	txOverpaymentRepository := s.overpaymentRepository.WithTxHandler(tx)
+	overpayments, err := txOverpaymentRepository.GetByOrderId(record.OrderId)
+	if err != nil {
+		return true, err
+	}
+	for _, overpayment := range overpayments {
+		if overpayment.PayId == record.PayId && overpayment.OverpaymentType == repository.OverpaymentType_duplicate { // 已记录，回调重试时不重复记录
+			return true, nil
+		}
+	}
+	overpaymentIn := repository.OverpaymentCreateIn{
+		PayId:           record.PayId,
+		OrderId:         record.OrderId,
+		OverpaymentType: repository.OverpaymentType_duplicate,
+		ProviderTradeNo: providerTradeNo,
+		OrderAmount:     record.OrderAmount,
+		OverpaidAmount:  record.PayAmount,
+		Remark:          "渠道交易号已用于支付流水号:" + paidRecord.PayId,
+	}
+	err = txOverpaymentRepository.Create(overpaymentIn)
+	if err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// flagOverpaid 支付后已支付总额超过订单金额时记录超付，返回本次支付的超付金额；同一支付记录只记录一次，已记录时返回0
+func (s PayRecordService) flagOverpaid(tx sqlbuilder.Handler, record repository.PayRecordModel, providerTradeNo string, payRecords repository.PayRecordModels) (overpaidAmount int, err error) {
+	orderAmount := payRecords.GetOrderAmount()
+	paidAmount := payRecords.FilterByStatePaid().TotalAmount()
+	if paidAmount <= orderAmount {
+		return 0, nil
+	}
+	overpaidAmount = min(paidAmount-orderAmount, record.PayAmount)
+	if s.handler == nil { // 未配置数据库时不记录超付
+		return overpaidAmount, nil
+	}
+	overpayments, err := s.overpaymentRepository.WithTxHandler(tx).GetByOrderId(record.OrderId)
+	if err != nil {
+		return 0, err
+	}
+	for _, overpayment := range overpayments {
+		if overpayment.PayId == record.PayId && overpayment.OverpaymentType == repository.OverpaymentType_overpaid { // 已记录，不重复记录及退款
+			return 0, nil
+		}
+	}
+	overpaymentIn := repository.OverpaymentCreateIn{
+		PayId:           record.PayId,
+		OrderId:         record.OrderId,
+		OverpaymentType: repository.OverpaymentType_overpaid,
+		ProviderTradeNo: providerTradeNo,
+		OrderAmount:     orderAmount,
+		PaidAmount:      paidAmount,
+		OverpaidAmount:  overpaidAmount,
+	}
+	err = s.overpaymentRepository.WithTxHandler(tx).Create(overpaymentIn)
+	if err != nil {
+		return 0, err
+	}
+	return overpaidAmount, nil
+}
+
+// RefundOverpayment 退还支付记录的超付金额：锁定待处理的超付记录并标记已退款后调用支付渠道退款钩子，渠道退款失败时恢复为待处理；
+// 渠道退款成功后分摊分账退款并记账
+func (s PayRecordService) RefundOverpayment(payId string, refundAmount int) (err error) {
+	s, span := s.startSpan("RefundOverpayment", repository.Attr_pay_id.String(payId), repository.Attr_pay_amount.Int(refundAmount))
+	defer func() { repository.EndSpan(span, err) }()
+	if s.gatewayRefundHook == nil {
+		err = errors.New("未设置支付渠道退款钩子，无法退还超付金额")
+		return err
+	}
+	if refundAmount <= 0 {
+		err = errors.New("退款金额必须大于0")
+		return err
+	}
+	err = s.requireDatabase()
+	if err != nil {
+		return err
+	}
+	record, err := s.recordRepository.GetByPayIdMust(payId)
+	if err != nil {
+		return err
+	}
+	var overpayment repository.OverpaymentModel
+	err = s.orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+		txOverpaymentRepository := s.overpaymentRepository.WithTxHandler(tx)
+		var exists bool
+		overpayment, exists, err = txOverpaymentRepository.LockPendingByPayId(payId, repository.OverpaymentType_overpaid)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			err = errors.Errorf("没有待退还的超付金额,支付流水号-%s", payId)
+			return err
+		}
+		if refundAmount > overpayment.OverpaidAmount {
+			err = errors.Errorf("退款金额超过超付金额,支付流水号-%s,超付金额-%d,退款金额-%d", payId, overpayment.OverpaidAmount, refundAmount)
+			return err
+		}
+		return txOverpaymentRepository.MarkRefunded(overpayment.Id, refundAmount)
+	})
+	if err != nil {
+		return err
+	}
+	err = s.gatewayRefundHook(record, refundAmount)
+	if err != nil {
+		revertErr := s.orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+			return s.overpaymentRepository.WithTxHandler(tx).RevertRefunded(overpayment.Id)
+		})
+		if revertErr != nil {
+			err = errors.WithMessagef(err, "超付记录恢复待处理失败:%s", revertErr.Error())
+		}
+		return err
+	}
+	err = s.orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+		_, err = s.refundSplit(tx, overpaymentRefundId(payId), payId, refundAmount)
+		return err
+	})
+	if err != nil {
+		err = errors.WithMessagef(err, "支付渠道已退还超付金额，但记账失败,支付流水号-%s,退款金额-%d", payId, refundAmount)
+		return err
+	}
+	return nil
+}
+
+// overpaymentRefundId 超付退款的退款单号，每笔支付记录只有一条超付记录
+func overpaymentRefundId(payId string) string {
+	return fmt.Sprintf("%s_%s", repository.OverpaymentType_overpaid, payId)
+}
+
+// GetOverpayments 获取订单的超付记录
+func (s PayRecordService) GetOverpayments(orderId string) (overpayments repository.OverpaymentModels, err error) {
+	err = s.requireDatabase()
+	if err != nil {
+		return nil, err
+	}
+	return s.overpaymentRepository.GetByOrderId(orderId)
+}
@@ -0,0 +1,12 @@
+package paymentrecord
+
+import (
+	"github.com/suifengpiao14/paymentrecord/repository"
+)
+
+// SetPayAgents 设置服务使用的支付方式注册表，创建支付记录的校验、默认过期时间及手续费均取自该注册表；
+// 默认为 repository.PayAgents，需要单独注册支付方式时可基于 repository.PayAgents.Clone() 修改
+func (s *PayRecordService) SetPayAgents(payAgents *repository.PayAgentRegistry) *PayRecordService {
+	s.payAgents = payAgents
+	return s
+}
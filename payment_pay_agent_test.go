@@ -0,0 +1,94 @@
+package paymentrecord_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+func TestPayAgentRegistry(t *testing.T) {
+	payAgents := repository.PayAgents.Clone()
+	err := payAgents.LoadYAML([]byte(`
+payAgents:
+  - key: testpay
+    title: 测试支付
+    minAmount: 100
+    maxAmount: 10000
+    currencies: [CNY, HKD]
+    defaultExpire: 15
+    needPayUrl: true
+`))
+	require.NoError(t, err)
+	require.Equal(t, "测试支付", payAgents.Enums().Title("testpay"))
+	require.Equal(t, "优惠券", sqlbuilder.GetField(repository.NewPayAgent).Schema.Enums.Title(repository.PayingAgent_Coupon))
+	_, ok := repository.PayAgents.Get("testpay") // 副本注册不影响默认注册表
+	require.False(t, ok)
+
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		s.SetPayAgents(payAgents)
+		newIn := func(payId string, payAmount int) paymentrecord.PayRecordCreateIn {
+			in := newCreateIn(payId, "o_"+payId, payAmount, payAmount)
+			in.PayAgent, in.PayUrl = "testpay", "https://pay.example.com/"+payId
+			return in
+		}
+		err := s.Create(newIn("p1", 500))
+		require.NoError(t, err)
+		record, err := s.Get("p1")
+		require.NoError(t, err)
+		require.Equal(t, 15, record.Expire)
+		require.Equal(t, repository.Currency_CNY, record.Currency)
+
+		in := newIn("p2", 500)
+		in.Expire, in.Currency = 5, "HKD"
+		err = s.Create(in)
+		require.NoError(t, err)
+		record, err = s.Get("p2")
+		require.NoError(t, err)
+		require.Equal(t, 5, record.Expire)
+		require.Equal(t, "HKD", record.Currency)
+
+		for _, in := range []paymentrecord.PayRecordCreateIn{newIn("p3", 50), newIn("p4", 20000)} {
+			err = s.Create(in)
+			require.Error(t, err, in.PayId)
+		}
+		in = newIn("p5", 500)
+		in.Currency = "USD"
+		require.Error(t, s.Create(in))
+		in = newIn("p6", 500)
+		in.PayUrl = ""
+		require.Error(t, s.Create(in))
+		in = newIn("p7", 500)
+		in.PayAgent = "unknown"
+		require.Error(t, s.Create(in))
+	})
+
+	require.Error(t, payAgents.Register(repository.PayAgentConfig{Key: "too_long_agent_key"}))
+}
+
+func TestPayAgentDeductionAccount(t *testing.T) {
+	payAgents := repository.PayAgents.Clone()
+	err := payAgents.Register(repository.PayAgentConfig{Key: "wallet", Title: "钱包", DeductionAccountCode: "wallet_deduction"})
+	require.NoError(t, err)
+	eachDatabaseBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		s.SetPayAgents(payAgents)
+		in := newCreateIn("p1", "o1", 5000, 2000)
+		in.PayAgent = "wallet"
+		err := s.Create(in, newCreateIn("p2", "o1", 5000, 3000))
+		require.NoError(t, err)
+		for _, payId := range []string{"p1", "p2"} {
+			_, err = s.Pay(paymentrecord.PayIn{PayId: payId})
+			require.NoError(t, err)
+		}
+		asOf := time.Now().Add(time.Minute)
+		balance, err := s.GetLedgerBalance("wallet_deduction", asOf) // 抵扣类支付方式记入配置的费用科目
+		require.NoError(t, err)
+		require.Equal(t, 2000, balance)
+		balance, err = s.GetLedgerBalance("clearing_"+repository.PayingAgent_Wechat, asOf)
+		require.NoError(t, err)
+		require.Equal(t, 3000, balance)
+	})
+}
@@ -1,119 +1,293 @@
 package paymentrecord
 
 import (
-	"fmt"
-	"math/rand"
-	"slices"
-	"strings"
+	"context"
+	"log/slog"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/suifengpiao14/paymentrecord/encryption"
+	"github.com/suifengpiao14/paymentrecord/ledger"
 	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/paymentrecord/risk"
 	"github.com/suifengpiao14/sqlbuilder"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PayRecordService struct {
-	orderRepository  repository.PayOrderRepository
-	recordRepository repository.PayRecordRepository
+	orderRepository       repository.PayOrderRepository
+	recordRepository      repository.PayRecordRepository
+	splitRepository       repository.PaySplitRepository
+	ledger                ledger.Ledger
+	adjustRepository      repository.PayOrderAdjustmentRepository
+	gatewayCloseHook      GatewayCloseHook
+	overpaymentRepository repository.OverpaymentRepository
+	gatewayRefundHook     GatewayRefundHook
+	metricsHook           MetricsHook
+	tracer                trace.Tracer
+	ctx                   context.Context // 链路追踪上下文，见 WithContext
+	logger                *slog.Logger
+	logLevels             map[string]slog.Level
+	idGenerator           IdGenerator
+	merchantId            string // 所属商户，见 WithMerchantId
+	riskEngine            *risk.Engine
+	handler               sqlbuilder.Handler           // 分账、账本、超付及调账记录所在的库，为空时(只使用内存仓库)不记录
+	cipher                *encryption.Cipher           // 敏感字段加密，见 SetFieldCipher
+	payAgents             *repository.PayAgentRegistry // 支付方式注册表，见 SetPayAgents
 }
 
 func NewPayRecordService(handler sqlbuilder.Handler) (payRecordService *PayRecordService) {
-	payRecordRepository := repository.NewPayRecordRepository(handler)
 	orderRepository := repository.NewPayOrderRepository(handler)
+	payRecordRepository := repository.NewPayRecordRepository(handler)
+	return NewPayRecordServiceWithRepository(handler, orderRepository, payRecordRepository)
+}
+
+// NewPayRecordServiceWithRepository 指定收款单、支付记录仓库(如内存实现)，分账、账本等其余数据仍使用 handler。
+// handler 可以为空，为空时只支持收款单、支付记录的操作，分账、退款、账本等查询返回 ErrDatabaseRequired
+func NewPayRecordServiceWithRepository(handler sqlbuilder.Handler, orderRepository repository.PayOrderRepository, payRecordRepository repository.PayRecordRepository) (payRecordService *PayRecordService) {
+	splitRepository := repository.NewPaySplitRepository(handler)
 	payRecordService = &PayRecordService{
-		recordRepository: payRecordRepository,
-		orderRepository:  orderRepository,
+		recordRepository:      payRecordRepository,
+		orderRepository:       orderRepository,
+		splitRepository:       splitRepository,
+		ledger:                ledger.NewLedger(handler),
+		adjustRepository:      repository.NewPayOrderAdjustmentRepository(handler),
+		overpaymentRepository: repository.NewOverpaymentRepository(handler),
+		handler:               handler,
+		payAgents:             repository.PayAgents,
 	}
 	return payRecordService
 }
 
+// ErrDatabaseRequired 未配置数据库时，分账、退款、账本等依赖数据库的功能不可用
+var ErrDatabaseRequired = errors.New("未配置数据库，不支持分账、退款及账本")
+
+// requireDatabase 依赖数据库的功能调用前检查
+func (s PayRecordService) requireDatabase() (err error) {
+	if s.handler == nil {
+		return ErrDatabaseRequired
+	}
+	return nil
+}
+
 type PayRecordCreateIn struct {
-	PayId            string `json:"payId"`
-	Expire           int    `json:"expire"` // 过期时间，单位分钟
-	OrderId          string `json:"orderId"`
-	PayAgent         string `json:"payAgent"`   // 支付机构 weixin:微信 alipay:支付宝
-	OrderAmount      int    `json:"orderPrice"` // 订单金额，单位分
-	PayAmount        int    `json:"payAmount"`  // 实际支付金额，单位分
-	PayParam         string `json:"payParam"`
-	UserId           string `json:"userId"`
-	ClientIp         string `json:"clientIp"`
-	RecipientAccount string `json:"recipientAccount"`
-	RecipientName    string `json:"recipientName"`
-	PaymentAccount   string `json:"paymentAccount"`
-	PaymentName      string `json:"paymentName"`
-	PayUrl           string `json:"payUrl"`
-	NotifyUrl        string `json:"notifyUrl"`
-	ReturnUrl        string `json:"returnUrl"`
-	Remark           string `json:"remark"`
-}
-
-// Create 创建订单,支持批量创建支付记录
+	PayId            string      `json:"payId"`      // 支付流水号，为空时自动生成，见 SetIdGenerator
+	MerchantId       string      `json:"merchantId"` // 商户ID，为空时为服务所属商户，同批支付记录须属于同一商户
+	Expire           int         `json:"expire"`     // 过期时间，单位分钟
+	OrderId          string      `json:"orderId"`
+	PayAgent         string      `json:"payAgent"`   // 支付方式，须已在服务的支付方式注册表注册，见 SetPayAgents
+	OrderAmount      int         `json:"orderPrice"` // 订单金额，单位分
+	PayAmount        int         `json:"payAmount"`  // 实际支付金额，单位分
+	Currency         string      `json:"currency"`   // 币种，为空时为 CNY，须为支付方式支持的币种
+	PayParam         string      `json:"payParam"`
+	UserId           string      `json:"userId"`
+	ClientIp         string      `json:"clientIp"`
+	RecipientAccount string      `json:"recipientAccount"`
+	RecipientName    string      `json:"recipientName"`
+	PaymentAccount   string      `json:"paymentAccount"`
+	PaymentName      string      `json:"paymentName"`
+	PayUrl           string      `json:"payUrl"`
+	NotifyUrl        string      `json:"notifyUrl"`
+	ReturnUrl        string      `json:"returnUrl"`
+	Remark           string      `json:"remark"`
+	Splits           PaySplitIns `json:"splits"` // 分账收款方，为空时不分账，分账总额必须等于支付金额
+}
+
+// Create 创建订单,支持批量创建支付记录；未传 PayId 时自动生成，需要获取生成的流水号时使用 CreateWithResult
 func (s PayRecordService) Create(ins ...PayRecordCreateIn) (err error) {
+	_, err = s.CreateWithResult(ins...)
+	return err
+}
+
+// CreateWithResult 同 Create，按入参顺序返回支付流水号(含自动生成的)
+func (s PayRecordService) CreateWithResult(ins ...PayRecordCreateIn) (payIds []string, err error) {
+	err = s.create(ins)
+	if err != nil {
+		return nil, err
+	}
+	payIds = make([]string, 0, len(ins))
+	for _, in := range ins {
+		payIds = append(payIds, in.PayId)
+	}
+	return payIds, nil
+}
+
+func (s PayRecordService) create(ins []PayRecordCreateIn) (err error) {
+	defer func(logger PayRecordService, start time.Time) { // 返回时再取入参，日志中记录生成的支付流水号
+		logger.logMutation(Operation_create, start, maskCreateIns(ins), nil, &err, createdState(&err))
+	}(s, time.Now())
+	defer func() {
+		s.observeError(Operation_create, err)
+	}()
 	if len(ins) == 0 {
 		return errors.New("没有支付单")
 	}
-	for _, in := range ins {
-		err = in.validate()
-		if err != nil {
+	inFirst := ins[0]
+	for _, in := range ins[1:] {
+		if in.MerchantId != inFirst.MerchantId {
+			err = errors.Errorf("批量创建的支付记录须属于同一商户,订单号:%s", inFirst.OrderId)
 			return err
 		}
-		err = s.validate(in)
+	}
+	s, err = s.forMerchant(inFirst.MerchantId)
+	if err != nil {
+		return err
+	}
+	err = s.fillPayIds(ins)
+	if err != nil {
+		return err
+	}
+	s, span := s.startSpan("Create", repository.Attr_order_id.String(inFirst.OrderId), repository.Attr_pay_agent.String(inFirst.PayAgent))
+	defer func() { repository.EndSpan(span, err) }()
+	payOrder, exists, err := s.orderRepository.GetByOrderId(inFirst.OrderId)
+	if err != nil {
+		return err
+	}
+	if exists && payOrder.IsOpen() {
+		return s.createOpen(payOrder, ins...)
+	}
+	for i := range ins {
+		err = ins[i].validate(s.payAgents)
 		if err != nil {
 			return err
 		}
 	}
+	inFirst = ins[0] // 校验时补全了默认过期时间
 
-	inFirst := ins[0]
+	var decisions riskDecisions
 	err = s.orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
-		orderRepository := s.orderRepository.WithTxHandler(tx)
-		// 保存支付单
-		payOrderSetIn := repository.PayOrderSetIn{
-			OrderId:     inFirst.OrderId,
-			OrderAmount: inFirst.OrderAmount,
-			UserId:      inFirst.UserId,
-			Remark:      inFirst.Remark,
-			Expire:      inFirst.Expire,
-		}
-		err = orderRepository.Set(payOrderSetIn)
+		// 锁定收款单后再校验金额，并发创建时不会基于过期的汇总校验；新订单无行可锁，并发写入时由订单号唯一索引保证只有一个成功
+		lockedOrder, exists, err := s.orderRepository.WithTxHandler(tx).LockByOrderId(inFirst.OrderId)
 		if err != nil {
 			return err
 		}
-
-		recordRepository := s.recordRepository.WithTxHandler(tx)
-
 		for _, in := range ins {
-			payOrderIn := repository.PayRecordCreateIn{
-				PayId:            in.PayId,
-				OrderId:          in.OrderId,
-				OrderAmount:      in.OrderAmount,
-				PayAmount:        in.PayAmount,
-				PayAgent:         in.PayAgent,
-				State:            string(repository.PayOrderModel_state_pending),
-				UserId:           in.UserId,
-				ClientIp:         in.ClientIp,
-				PayParam:         in.PayParam,
-				PayUrl:           in.PayUrl,
-				Expire:           0,
-				ReturnUrl:        in.ReturnUrl,
-				NotifyUrl:        in.NotifyUrl,
-				Remark:           in.Remark,
-				RecipientAccount: in.RecipientAccount,
-				RecipientName:    in.RecipientName,
-				PaymentAccount:   in.PaymentAccount,
-				PaymentName:      in.PaymentName,
+			err = s.validate(lockedOrder, in)
+			if err != nil {
+				return err
 			}
-			err = recordRepository.Create(payOrderIn)
+		}
+		if !exists { // 保存支付单，已存在(分批次支付、调整过金额)时不重复写入
+			payOrderSetIn := repository.PayOrderSetIn{
+				OrderId:     inFirst.OrderId,
+				OrderAmount: inFirst.OrderAmount,
+				UserId:      inFirst.UserId,
+				Remark:      inFirst.Remark,
+				Expire:      inFirst.Expire,
+			}
+			err = s.orderRepository.WithTxHandler(tx).Set(payOrderSetIn)
 			if err != nil {
 				return err
 			}
 		}
+
+		err = s.createRecords(tx, &decisions, ins...)
+		if err != nil {
+			return err
+		}
 		return nil
 	})
+	s.recordRiskDecisions(decisions)
+	if err != nil {
+		return err
+	}
+	for _, in := range ins {
+		s.observeTransition(MetricsEvent_create, in.PayAgent)
+	}
+	return nil
+}
 
+// createOpen 开放式订单(如活动报名)创建支付记录，每条支付记录为一个名额，金额为订单单价
+func (s PayRecordService) createOpen(payOrder repository.PayOrderModel, ins ...PayRecordCreateIn) (err error) {
+	for i := range ins {
+		if ins[i].OrderId != payOrder.OrderId {
+			err = errors.Errorf("批量创建支付记录必须属于同一个订单,订单ID-%s,收到订单ID-%s", payOrder.OrderId, ins[i].OrderId)
+			return err
+		}
+		ins[i].OrderAmount = payOrder.UnitPrice
+		ins[i].PayAmount = payOrder.UnitPrice
+		err = ins[i].validate(s.payAgents)
+		if err != nil {
+			return err
+		}
+	}
+	var decisions riskDecisions
+	err = s.orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+		lockedOrder, _, err := s.orderRepository.WithTxHandler(tx).LockByOrderId(payOrder.OrderId) // 锁定收款单，并发报名时名额不会超卖
+		if err != nil {
+			return err
+		}
+		err = s.validateOpen(lockedOrder, len(ins))
+		if err != nil {
+			return err
+		}
+		return s.createRecords(tx, &decisions, ins...)
+	})
+	s.recordRiskDecisions(decisions)
 	if err != nil {
 		return err
 	}
+	for _, in := range ins {
+		s.observeTransition(MetricsEvent_create, in.PayAgent)
+	}
+	return nil
+}
 
+// createRecords 创建待支付记录，同一事务内累加收款单汇总；每条记录创建前评估风控，决策追加到 decisions
+func (s PayRecordService) createRecords(tx sqlbuilder.Handler, decisions *riskDecisions, ins ...PayRecordCreateIn) (err error) {
+	recordRepository := s.recordRepository.WithTxHandler(tx)
+	splitRepository := s.splitRepository.WithTxHandler(tx)
+	orderRepository := s.orderRepository.WithTxHandler(tx)
+	for _, in := range ins {
+		err = s.checkCreateRisk(recordRepository, in, decisions)
+		if err != nil {
+			return err
+		}
+		payOrderIn := repository.PayRecordCreateIn{
+			PayId:            in.PayId,
+			OrderId:          in.OrderId,
+			OrderAmount:      in.OrderAmount,
+			PayAmount:        in.PayAmount,
+			Currency:         in.Currency,
+			PayAgent:         in.PayAgent,
+			State:            string(repository.PayOrderModel_state_pending),
+			UserId:           in.UserId,
+			ClientIp:         in.ClientIp,
+			PayParam:         in.PayParam,
+			PayUrl:           in.PayUrl,
+			Expire:           in.Expire,
+			ReturnUrl:        in.ReturnUrl,
+			NotifyUrl:        in.NotifyUrl,
+			Remark:           in.Remark,
+			RecipientAccount: in.RecipientAccount,
+			RecipientName:    in.RecipientName,
+			PaymentAccount:   in.PaymentAccount,
+			PaymentName:      in.PaymentName,
+		}
+		err = recordRepository.Create(payOrderIn)
+		if err != nil {
+			return err
+		}
+		err = orderRepository.AddTotals(in.OrderId, repository.StateTotals(payOrderIn.State, in.PayAmount))
+		if err != nil {
+			return err
+		}
+		if len(in.Splits) == 0 {
+			continue
+		}
+		err = s.requireDatabase()
+		if err != nil {
+			return err
+		}
+		splitCreateIns, err := in.Splits.toCreateIns(in.PayId, in.OrderId, in.PayAmount)
+		if err != nil {
+			return err
+		}
+		err = splitRepository.Create(splitCreateIns...)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -124,24 +298,21 @@ func (s PayRecordService) GetAllPayRecordByConditon(whereFs sqlbuilder.Fields) (
 func (s PayRecordService) GetFirstPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecord repository.PayRecordModel, err error) {
 	return s.recordRepository.GetFirstPayRecordByConditon(whereFs)
 }
-func (s PayRecordService) validate(ins PayRecordCreateIn) (err error) {
-	payRecords, err := s.recordRepository.GetByOrderId(ins.OrderId)
-	if err != nil {
-		return err
-	}
-	orderAmount := payRecords.GetOrderAmount()
+
+// validate 基于收款单上的汇总校验金额，无需加载支付记录；订单不存在时 payOrder 为零值
+func (s PayRecordService) validate(payOrder repository.PayOrderModel, ins PayRecordCreateIn) (err error) {
+	orderAmount := payOrder.EffectOrderAmount()
 	if orderAmount != 0 && orderAmount != ins.OrderAmount {
 		err = errors.Errorf("订单已开始支付，不许修改金额，已支付的支付单记录订单金额为:%d,当前订单金额为:%d", orderAmount, ins.OrderAmount)
 		return err
 	}
 
-	paidRecords := payRecords.FilterByStatePaid()
-	paidAmount := paidRecords.TotalAmount()
+	paidAmount := payOrder.PaidAmount
 	if paidAmount >= ins.OrderAmount {
 		err = errors.New("订单已支付完成")
 		return err
 	}
-	pendingAmount := payRecords.FilterByStatePending().TotalAmount()
+	pendingAmount := payOrder.PendingAmount
 	paidPendingAmount := paidAmount + pendingAmount
 	if paidPendingAmount >= ins.OrderAmount { // 如果有支付中的订单，则不允许创建新的
 		err = errors.New("支付单金额已足够支付订单，请完成支付中的支付单")
@@ -163,119 +334,395 @@ func (s PayRecordService) validate(ins PayRecordCreateIn) (err error) {
 	return nil
 }
 
-// validate 验证请求参数
-func (req *PayRecordCreateIn) validate() error {
+// validateOpen 开放式订单校验截止时间和名额，替代固定金额订单的总金额校验
+func (s PayRecordService) validateOpen(payOrder repository.PayOrderModel, count int) (err error) {
+	if payOrder.State != repository.PayOrderModel_state_pending.String() {
+		err = errors.Errorf("订单已结束报名,订单ID-%s,订单状态-%s", payOrder.OrderId, payOrder.State)
+		return err
+	}
+	if payOrder.IsDeadlinePassed(time.Now()) {
+		err = errors.Errorf("订单已过截止时间,订单ID-%s,截止时间-%s", payOrder.OrderId, payOrder.Deadline)
+		return err
+	}
+	if payOrder.Capacity <= 0 {
+		return nil
+	}
+	usedCount := payOrder.RecordCount
+	if usedCount+count > payOrder.Capacity {
+		err = errors.Errorf("名额不足(名额上限-%d,已占用-%d),收到报名数-%d,订单ID-%s", payOrder.Capacity, usedCount, count, payOrder.OrderId)
+		return err
+	}
+	return nil
+}
+
+// validate 验证请求参数，未传过期时间时取支付方式的默认过期时间，未传币种时为 CNY
+func (req *PayRecordCreateIn) validate(payAgents *repository.PayAgentRegistry) error {
 	if req.PayId == "" {
 		return errors.New("payId不能为空")
 	}
-	payAgents := []string{repository.PayingAgent_Alipay, repository.PayingAgent_Wechat, repository.PayingAgent_Coupon}
-	// 验证type
-	if !slices.Contains(payAgents, req.PayAgent) {
-		err := errors.Errorf("请传入支付方式=>%s", strings.Join(payAgents, ","))
+	agent, err := payAgents.GetMust(req.PayAgent)
+	if err != nil {
 		return err
 	}
+	err = agent.Validate(req.PayAmount, req.Currency, req.PayUrl)
+	if err != nil {
+		return err
+	}
+	if req.Currency == "" {
+		req.Currency = repository.Currency_CNY
+	}
+	if req.Expire == 0 {
+		req.Expire = agent.DefaultExpire
+	}
 	// 验证price
 	if req.OrderAmount <= 0 {
 		return errors.New("订单金额必须大于0")
 	}
+	if len(req.Splits) > 0 {
+		_, err := req.Splits.resolve(req.PayAmount)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// PayIdGenerator 生成订单ID（格式：YYYYMMDDHHMMSS + 4位随机数）
+// PayIdGenerator 生成支付流水号（格式：YYYYMMDDHHMMSS + 12位随机数）
+//
+// Deprecated: 使用 PayRecordService.NewPayId，或创建支付记录时不传 PayId 自动生成
 func PayIdGenerator() string {
-	// 设置随机数种子
-	rd := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	// 生成时间部分（格式：YYYYMMDDHHMMSS）
-	timePart := time.Now().Format("20060102150405")
-
-	// 生成4位随机数（1-9之间的数字）
-	randPart := fmt.Sprintf("%d%d%d%d",
-		rd.Intn(9)+1,
-		rd.Intn(9)+1,
-		rd.Intn(9)+1,
-		rd.Intn(9)+1)
-	// 组合订单ID
-	return timePart + randPart
+	payId, err := defaultIdGenerator.NextId()
+	if err != nil { // 系统随机源不可用时退回伪随机数，不影响存量调用方
+		payId = pseudoRandomId("")
+	}
+	return payId
 }
 
-// GetOrderPayInfo 获取订单支付信息
+// GetOrderPayInfo 获取订单支付信息，订单已归档时从归档表读取
 func (s PayRecordService) GetOrderPayInfo(orderId string) (payOrders repository.PayRecordModels, err error) {
 	r := s.recordRepository
 	models, err := r.GetByOrderId(orderId)
 	if err != nil {
 		return nil, err
 	}
+	if len(models) == 0 {
+		models, err = r.GetHistoryByOrderId(orderId)
+		if err != nil {
+			return nil, err
+		}
+	}
 	effectRecords := models.FilterByStateEffect()
 	return effectRecords, nil
 }
 
+// GetByPaymentAccount 按付款人账号查询支付记录，账号加密存储时通过盲索引查询
+func (s PayRecordService) GetByPaymentAccount(paymentAccount string) (payRecords repository.PayRecordModels, err error) {
+	return s.recordRepository.GetByPaymentAccount(paymentAccount)
+}
+
 type PayIn struct {
-	PayId       string            `json:"payId" validate:"required"`
-	ExtraFields sqlbuilder.Fields `json:"-"`
+	PayId                 string            `json:"payId" validate:"required"`
+	ProviderTradeNo       string            `json:"providerTradeNo"`       // 支付渠道交易号，用于识别重复回调
+	CloseRestPending      bool              `json:"closeRestPending"`      // 订单支付完成后，是否在同一事务内关闭订单下其余待支付记录
+	AutoRefundOverpayment bool              `json:"autoRefundOverpayment"` // 已支付总额超过订单金额时，是否自动退还超付金额
+	ProviderFee           *int              `json:"providerFee"`           // 支付渠道回调的实际手续费，单位分，传入时覆盖按手续费规则计算的结果
+	ExtraFields           sqlbuilder.Fields `json:"-"`
+}
+
+type PayOut struct {
+	IsOrderPayFinished  bool                       `json:"isOrderPayFinished"`
+	AutoClosedPayIds    []string                   `json:"autoClosedPayIds"`    // 订单支付完成后自动关闭的待支付记录
+	AutoCloseFailed     map[string]string          `json:"autoCloseFailed"`     // 支付渠道关单失败的记录及原因，这些记录已关闭，需要重试渠道关单
+	OverpaidAmount      int                        `json:"overpaidAmount"`      // 本次支付的超付金额
+	OverpaymentRefunded bool                       `json:"overpaymentRefunded"` // 超付金额是否已自动退还
+	autoClosedRecords   repository.PayRecordModels // 事务提交后采集指标
+}
+
+// GatewayCloseHook 支付渠道关单钩子，自动关闭待支付记录的事务提交后调用，返回错误时记入 PayOut.AutoCloseFailed
+type GatewayCloseHook func(record repository.PayRecordModel) (err error)
+
+// SetGatewayCloseHook 设置支付渠道关单钩子
+func (s *PayRecordService) SetGatewayCloseHook(hook GatewayCloseHook) *PayRecordService {
+	s.gatewayCloseHook = hook
+	return s
 }
 
 // Pay 支付订单 返回订单是否已经支付完成（同一个订单下所有已支付的单总额等于订单金额）
 func (s PayRecordService) Pay(in PayIn) (isOrderPayFinished bool, err error) {
-	payId := in.PayId
-	r := s.recordRepository
-	model, err := r.GetByPayIdMust(payId)
+	out, err := s.PayWithResult(in)
 	if err != nil {
-		return false, err
+		return out.IsOrderPayFinished, err
+	}
+	return out.IsOrderPayFinished, nil
+}
+
+// PayWithResult 支付订单，返回订单是否已经支付完成及自动关闭的待支付记录
+func (s PayRecordService) PayWithResult(in PayIn) (out PayOut, err error) {
+	defer s.logMutation(Operation_pay, time.Now(), in, &out, &err, s.recordState(in.PayId))
+	defer func() {
+		s.observeError(Operation_pay, err)
+	}()
+	s, span := s.startSpan("Pay", repository.Attr_pay_id.String(in.PayId))
+	defer func() { repository.EndSpan(span, err) }()
+	model, err := getRecordMust(s.recordRepository, in.PayId)
+	if err != nil {
+		return out, err
 	}
+	span.SetAttributes(recordAttributes(model)...)
+	payOrder, exists, err := s.orderRepository.GetByOrderId(model.OrderId)
+	if err != nil {
+		return out, err
+	}
+	isOpenOrder := exists && payOrder.IsOpen() // 开放式订单每条支付记录独立，订单不会支付完成，截止后通过关闭订单结束
+	paidAt := time.Now()
 	exFs := sqlbuilder.Fields{
-		repository.NewPaidAt(time.Now().Format(time.DateTime)),
+		repository.NewPaidAt(paidAt.Format(time.DateTime)),
+	}
+	if in.ProviderTradeNo != "" {
+		exFs = exFs.Add(repository.NewProviderTradeNo(in.ProviderTradeNo))
 	}
-	exFs = exFs.Add(in.ExtraFields...)
-	err = s.recordRepository.GetStateMachine().Transform(repository.Action_pay_record_Pay, model.State, model.PayId, exFs...)
+	isRepeatPay := false // 重复支付回调(幂等)，不再检测超付，锁定后按最新状态判断
+	duplicated := false
+	err = s.orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+		lockedOrder, _, err := s.orderRepository.WithTxHandler(tx).LockByOrderId(model.OrderId) // 同一订单的支付回调串行执行，超付检测、订单完成判断基于最新的支付记录
+		if err != nil {
+			return err
+		}
+		txRecordRepository := s.recordRepository.WithTxHandler(tx)
+		lockedRecord, err := getRecordMust(txRecordRepository, model.PayId) // 锁定后重新读取状态，并发回调时汇总不会重复累加
+		if err != nil {
+			return err
+		}
+		isRepeatPay = lockedRecord.State == repository.PayOrderModel_state_paid.String() // 并发的相同回调只有一个按首次支付处理
+		if isOpenOrder && !isRepeatPay && lockedOrder.IsDeadlinePassed(paidAt) {         // 开放式订单截止后不允许再支付，已支付记录的重复回调仍幂等返回
+			err = errors.Errorf("订单已过截止时间,不允许支付,订单ID-%s,截止时间-%s", lockedOrder.OrderId, lockedOrder.Deadline)
+			return err
+		}
+		payFs := exFs
+		// 重复回调只在渠道带实际手续费时更新手续费，手续费有误时忽略并记录日志，不影响已支付的记录
+		if !isRepeatPay || in.ProviderFee != nil {
+			fee, err := s.providerFee(lockedRecord, in.ProviderFee)
+			switch {
+			case err == nil:
+				payFs = payFs.Add(repository.NewProviderFee(fee), repository.NewNetAmount(lockedRecord.PayAmount-fee))
+			case isRepeatPay:
+				s.logError(Operation_pay, model.PayId, errors.WithMessage(err, "重复回调的手续费已忽略"))
+			default:
+				return err
+			}
+		}
+		payFs = payFs.Add(in.ExtraFields...)
+		if in.ProviderTradeNo != "" {
+			duplicated, err = s.checkDuplicateCallback(tx, lockedRecord, in.ProviderTradeNo)
+			if err != nil {
+				return err
+			}
+			if duplicated { // 超付记录随事务提交，支付记录不变更
+				return nil
+			}
+		}
+		err = s.transformRecord(tx, repository.Action_pay_record_Pay, lockedRecord, payFs...)
+		if err != nil {
+			return err
+		}
+		err = s.settleableSplits(tx, model.PayId)
+		if err != nil {
+			return err
+		}
+		err = s.postPayLedger(tx, model)
+		if err != nil {
+			return err
+		}
+		if isOpenOrder {
+			return nil
+		}
+		//查看是否订单已经支付完成
+		payRecords, err := txRecordRepository.GetByOrderId(model.OrderId)
+		if err != nil {
+			return err
+		}
+		if !isRepeatPay {
+			out.OverpaidAmount, err = s.flagOverpaid(tx, model, in.ProviderTradeNo, payRecords)
+			if err != nil {
+				return err
+			}
+		}
+		out.IsOrderPayFinished = payRecords.IsOrderPayFinished()
+		if !out.IsOrderPayFinished {
+			return nil
+		}
+		// 如果订单已经支付完成，则改变pay_order 状态为 已支付
+		err = s.orderRepository.WithTxHandler(tx).TransformByIdentity(repository.Action_pay_order_Pay, model.OrderId)
+		if err != nil {
+			return err
+		}
+		if in.CloseRestPending {
+			err = s.closeRestPending(tx, payRecords.FilterByStatePending(), &out)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return false, err
+		return out, err
+	}
+	if duplicated {
+		err = errors.WithMessagef(ErrDuplicateProviderTradeNo, "支付流水号-%s,渠道交易号-%s", model.PayId, in.ProviderTradeNo)
+		return out, err
+	}
+	if !isRepeatPay {
+		s.observeTransition(repository.Action_pay_record_Pay, model.PayAgent)
+		s.observePaid(model, paidAt)
+		s.evaluatePayRisk(model) // 已入账，命中规则时只标记待复核
+	}
+	for _, record := range out.autoClosedRecords {
+		s.observeTransition(repository.Action_pay_record_Close, record.PayAgent)
+	}
+	s.gatewayCloseRecords(out.autoClosedRecords, &out)
+	if out.OverpaidAmount > 0 && in.AutoRefundOverpayment {
+		err = s.RefundOverpayment(model.PayId, out.OverpaidAmount)
+		if err != nil {
+			err = errors.WithMessagef(err, "支付成功，但自动退还超付金额失败,支付流水号-%s,超付金额-%d", model.PayId, out.OverpaidAmount)
+			return out, err
+		}
+		out.OverpaymentRefunded = true
+	}
+	return out, nil
+}
+
+// closeRestPending 订单支付完成后关闭其余待支付记录，支付渠道关单在事务提交后调用(见 gatewayCloseRecords)，不占用事务及锁
+func (s PayRecordService) closeRestPending(tx sqlbuilder.Handler, pendingRecords repository.PayRecordModels, out *PayOut) (err error) {
+	closeFs := sqlbuilder.Fields{
+		repository.NewClosedAt(time.Now().Format(time.DateTime)),
+		repository.NewRemark("订单已支付完成，自动关闭"),
+	}
+	for _, record := range pendingRecords {
+		err = s.transformRecord(tx, repository.Action_pay_record_Close, record, closeFs...)
+		if err != nil {
+			return err
+		}
+		out.AutoClosedPayIds = append(out.AutoClosedPayIds, record.PayId)
+		out.autoClosedRecords = append(out.autoClosedRecords, record)
+	}
+	return nil
+}
+
+// gatewayCloseRecords 调用支付渠道关单钩子，关单失败的记录及原因记入 out.AutoCloseFailed
+func (s PayRecordService) gatewayCloseRecords(records repository.PayRecordModels, out *PayOut) {
+	if s.gatewayCloseHook == nil {
+		return
+	}
+	for _, record := range records {
+		err := s.gatewayCloseHook(record)
+		if err != nil {
+			if out.AutoCloseFailed == nil {
+				out.AutoCloseFailed = make(map[string]string)
+			}
+			out.AutoCloseFailed[record.PayId] = err.Error()
+		}
 	}
-	//查看是否订单已经支付完成
-	payRecords, err := r.GetByOrderId(model.OrderId)
+}
+
+// transformRecord 支付记录状态变更，同一事务内累加收款单汇总，调用方须已锁定收款单
+func (s PayRecordService) transformRecord(tx sqlbuilder.Handler, event string, record repository.PayRecordModel, extraFs ...*sqlbuilder.Field) (err error) {
+	err = s.recordRepository.WithTxHandler(tx).Transform(event, record.State, record.PayId, extraFs...)
 	if err != nil {
-		return false, err
+		return err
 	}
-	isOrderPayFinished = payRecords.IsOrderPayFinished()
+	delta, err := repository.TransformTotalsDelta(event, record)
+	if err != nil {
+		return err
+	}
+	err = s.orderRepository.WithTxHandler(tx).AddTotals(record.OrderId, delta)
+	if err != nil {
+		return err
+	}
+	return nil
+}
 
-	if isOrderPayFinished { // 如果订单已经支付完成，则改变pay_order 状态为 已支付
-		err = s.orderRepository.GetStateMachine().TransformByIdentity(repository.Action_pay_order_Pay, model.OrderId)
+// transformRecordLocked 锁定收款单后按最新状态变更支付记录，与支付回调、创建支付记录串行
+func (s PayRecordService) transformRecordLocked(event string, record repository.PayRecordModel, extraFs ...*sqlbuilder.Field) (err error) {
+	err = s.orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+		_, _, err = s.orderRepository.WithTxHandler(tx).LockByOrderId(record.OrderId)
 		if err != nil {
-			return isOrderPayFinished, err
+			return err
+		}
+		record, err := getRecordMust(s.recordRepository.WithTxHandler(tx), record.PayId)
+		if err != nil {
+			return err
 		}
+		return s.transformRecord(tx, event, record, extraFs...)
+	})
+	if err != nil {
+		return err
 	}
-	return isOrderPayFinished, nil
+	return nil
 }
 
+// IsPaid 订单是否已支付完成，读取收款单上的汇总
 func (s PayRecordService) IsPaid(orderId string) (ok bool, err error) {
-	records, err := s.recordRepository.GetByOrderId(orderId)
+	payOrder, exists, err := s.orderRepository.GetByOrderId(orderId)
 	if err != nil {
 		return false, err
 	}
-	payFinished := records.IsOrderPayFinished()
-	return payFinished, nil
+	if !exists { // 没有支付记录，同 PayRecordModels.IsOrderPayFinished
+		return true, nil
+	}
+	return payOrder.IsPayFinished(), nil
 }
 
-// GetOrderRestPayRecordAmount 获取订单剩余可创建待支付单的金额
+// GetOrderRestPayRecordAmount 获取订单剩余可创建待支付单的金额，读取收款单上的汇总
 func (s PayRecordService) GetOrderRestPayRecordAmount(orderId string) (restPayRecordAmount int, err error) {
-	records, err := s.recordRepository.GetByOrderId(orderId)
+	payOrder, exists, err := s.orderRepository.GetByOrderId(orderId)
 	if err != nil {
 		return 0, err
 	}
-	effectRecords := records.FilterByStateEffect()
-	restPayRecordAmount = effectRecords.GetOrderAmount() - effectRecords.TotalAmount()
-	return restPayRecordAmount, nil
+	if !exists {
+		return 0, nil
+	}
+	return payOrder.RestAmount(), nil
 }
 
+// Get 获取支付记录，已归档时从归档表读取
 func (s PayRecordService) Get(payId string) (payOrder *repository.PayRecordModel, err error) {
 	r := s.recordRepository
-	model, err := r.GetByPayIdMust(payId)
+	model, exists, err := r.GetByPayId(payId)
 	if err != nil {
 		return nil, err
 	}
+	if !exists {
+		model, exists, err = r.GetHistoryByPayId(payId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !exists {
+		return nil, sqlbuilder.ErrNotFound
+	}
 	return &model, nil
 }
 
+// getRecordMust 读取待变更的支付记录，已归档(如归档后收到的迟到回调)时返回 repository.ErrArchived，不存在时返回 sqlbuilder.ErrNotFound
+func getRecordMust(r repository.PayRecordRepository, payId string) (model repository.PayRecordModel, err error) {
+	model, exists, err := r.GetByPayId(payId)
+	if err != nil {
+		return model, err
+	}
+	if exists {
+		return model, nil
+	}
+	_, archived, err := r.GetHistoryByPayId(payId)
+	if err != nil {
+		return model, err
+	}
+	if archived {
+		err = errors.WithMessagef(repository.ErrArchived, "支付流水号-%s", payId)
+		return model, err
+	}
+	return model, sqlbuilder.ErrNotFound
+}
+
 type CloseIn struct {
 	PayId       string `json:"payId" validate:"required"`
 	Reason      string `json:"reason"`
@@ -283,15 +730,27 @@ type CloseIn struct {
 }
 
 func (s PayRecordService) Close(in CloseIn) (err error) {
+	defer s.logMutation(Operation_close, time.Now(), in, nil, &err, s.recordState(in.PayId))
+	defer func() {
+		s.observeError(Operation_close, err)
+	}()
+	s, span := s.startSpan("Close", repository.Attr_pay_id.String(in.PayId))
+	defer func() { repository.EndSpan(span, err) }()
+	record, err := getRecordMust(s.recordRepository, in.PayId)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(recordAttributes(record)...)
 	fs := sqlbuilder.Fields{
 		repository.NewClosedAt(time.Now().Format(time.DateTime)),
 		repository.NewRemark(in.Reason),
 	}
 	fs = fs.Add(in.ExtraFields...)
-	err = s.recordRepository.GetStateMachine().TransformByIdentity(repository.Action_pay_record_Close, in.PayId, fs...)
+	err = s.transformRecordLocked(repository.Action_pay_record_Close, record, fs...)
 	if err != nil {
 		return err
 	}
+	s.observeTransition(repository.Action_pay_record_Close, record.PayAgent)
 	return nil
 }
 
@@ -302,15 +761,27 @@ type ExpireIn struct {
 }
 
 func (s PayRecordService) Expire(in ExpireIn) (err error) {
+	defer s.logMutation(Operation_expire, time.Now(), in, nil, &err, s.recordState(in.PayId))
+	defer func() {
+		s.observeError(Operation_expire, err)
+	}()
+	s, span := s.startSpan("Expire", repository.Attr_pay_id.String(in.PayId))
+	defer func() { repository.EndSpan(span, err) }()
+	record, err := getRecordMust(s.recordRepository, in.PayId)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(recordAttributes(record)...)
 	fs := sqlbuilder.Fields{
 		repository.NewExpiredAt(time.Now().Format(time.DateTime)),
 		repository.NewRemark(in.Reason),
 	}
 	fs = fs.Add(in.ExtraFields...)
-	err = s.recordRepository.GetStateMachine().TransformByIdentity(repository.Action_pay_record_Expire, in.PayId, fs...)
+	err = s.transformRecordLocked(repository.Action_pay_record_Expire, record, fs...)
 	if err != nil {
 		return err
 	}
+	s.observeTransition(repository.Action_pay_record_Expire, record.PayAgent)
 	return nil
 }
 
@@ -321,15 +792,27 @@ type FailIn struct {
 }
 
 func (s PayRecordService) Fail(in FailIn) (err error) {
+	defer s.logMutation(Operation_fail, time.Now(), in, nil, &err, s.recordState(in.PayId))
+	defer func() {
+		s.observeError(Operation_fail, err)
+	}()
+	s, span := s.startSpan("Fail", repository.Attr_pay_id.String(in.PayId))
+	defer func() { repository.EndSpan(span, err) }()
+	record, err := getRecordMust(s.recordRepository, in.PayId)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(recordAttributes(record)...)
 	fs := sqlbuilder.Fields{
 		repository.NewFailedAt(time.Now().Format(time.DateTime)),
 		repository.NewRemark(in.Reason),
 	}
 	fs = fs.Add(in.ExtraFields...)
-	err = s.recordRepository.GetStateMachine().TransformByIdentity(repository.Action_pay_record_Expire, in.PayId, fs...)
+	err = s.transformRecordLocked(repository.Action_pay_record_Fail, record, fs...)
 	if err != nil {
 		return err
 	}
+	s.observeTransition(repository.Action_pay_record_Fail, record.PayAgent)
 	return nil
 }
 
@@ -342,7 +825,41 @@ func (s PayRecordService) CratePayOrder(in PayOrderSetIn) (err error) {
 	return nil
 }
 
+// CreateOpenOrder 创建开放式订单(如活动报名)，之后每次 Create 占用一个名额
+func (s PayRecordService) CreateOpenOrder(in OpenOrderSetIn) (err error) {
+	orderService := _PayOrderService(s)
+	err = orderService.SetOpen(in)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AdjustOrderAmount 支付过程中调整订单金额
+func (s PayRecordService) AdjustOrderAmount(in AdjustOrderAmountIn) (out AdjustOrderAmountOut, err error) {
+	defer s.logMutation(Operation_adjust_amount, time.Now(), in, &out, &err, s.orderState(in.OrderId))
+	defer func() {
+		s.observeError(Operation_adjust_amount, err)
+	}()
+	s, span := s.startSpan("AdjustOrderAmount", repository.Attr_order_id.String(in.OrderId), repository.Attr_pay_amount.Int(in.OrderAmount))
+	defer func() { repository.EndSpan(span, err) }()
+	orderService := _PayOrderService(s)
+	return orderService.AdjustAmount(in)
+}
+
+// GetOrderAdjustments 获取订单金额调整记录
+func (s PayRecordService) GetOrderAdjustments(orderId string) (adjustments repository.PayOrderAdjustmentModels, err error) {
+	orderService := _PayOrderService(s)
+	return orderService.GetAdjustments(orderId)
+}
+
 func (s PayRecordService) CloseByOrderId(in CloseByOrderIdIn) (err error) {
+	defer s.logMutation(Operation_close_by_order, time.Now(), in, nil, &err, s.orderState(in.OrderId))
+	defer func() {
+		s.observeError(Operation_close_by_order, err)
+	}()
+	s, span := s.startSpan("CloseByOrderId", repository.Attr_order_id.String(in.OrderId))
+	defer func() { repository.EndSpan(span, err) }()
 	orderService := _PayOrderService(s)
 	err = orderService.Close(in)
 	if err != nil {
@@ -350,3 +867,20 @@ func (s PayRecordService) CloseByOrderId(in CloseByOrderIdIn) (err error) {
 	}
 	return nil
 }
+
+// providerFee 支付渠道手续费，渠道未返回实际手续费时按支付方式的手续费规则计算，未注册的支付方式不收手续费
+func (s PayRecordService) providerFee(record repository.PayRecordModel, reportedFee *int) (fee int, err error) {
+	if reportedFee != nil {
+		fee = *reportedFee
+		if fee < 0 || fee > record.PayAmount {
+			err = errors.Errorf("手续费须在0到支付金额之间,支付流水号-%s,支付金额-%d,手续费-%d", record.PayId, record.PayAmount, fee)
+			return 0, err
+		}
+		return fee, nil
+	}
+	agent, ok := s.payAgents.Get(record.PayAgent)
+	if !ok {
+		return 0, nil
+	}
+	return agent.Fee.Compute(record.PayAmount), nil
+}
@@ -1,120 +1,663 @@
 package paymentrecord_test
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/require"
 	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/cache"
+	"github.com/suifengpiao14/paymentrecord/ledger"
+	"github.com/suifengpiao14/paymentrecord/migration"
 	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/paymentrecord/risk"
+	"github.com/suifengpiao14/paymentrecord/sqlite"
 	"github.com/suifengpiao14/sqlbuilder"
 )
 
-// userName string, password string, host string, port int, database string
-var mysqlDB = sqlbuilder.DB2Gorm(sqlbuilder.MakeDBHandler(sqlbuilder.DBConfig{
-	UserName:     "root",
-	Password:     "123456",
-	Host:         "10.0.11.125",
-	Port:         3306,
-	DatabaseName: "test",
-}), nil)
+func newSqliteHandler(t *testing.T) sqlbuilder.Handler {
+	db, handler, err := sqlite.OpenAndMigrate(filepath.Join(t.TempDir(), "paymentrecord.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return handler
+}
+
+// newMysqlHandler 设置环境变量 PAYMENTRECORD_TEST_MYSQL_DSN 后运行，每个用例执行前清空业务表，请使用专用测试库
+func newMysqlHandler(t *testing.T) sqlbuilder.Handler {
+	dsn := os.Getenv("PAYMENTRECORD_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("PAYMENTRECORD_TEST_MYSQL_DSN not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	handler := sqlbuilder.NewGormHandler(sqlbuilder.DB2Gorm(func() *sql.DB { return db }, nil))
+	err = migration.Migrate(handler)
+	require.NoError(t, err)
+	resetTables(t, handler)
+	return handler
+}
+
+// resetTables 清空业务表，用于 mysql、postgres 等共享库
+func resetTables(t *testing.T, handler sqlbuilder.Handler) {
+	dialect := sqlbuilder.Driver(handler.GetDialector()).GoquDialect()
+	for _, table := range append(append(repository.Tables(), ledger.Tables()...), risk.Tables()...) {
+		sql, _, err := dialect.Delete(table.DBName.Name).ToSQL()
+		require.NoError(t, err)
+		err = handler.Exec(sql)
+		require.NoError(t, err)
+	}
+}
+
+type backend struct {
+	name       string
+	newHandler func(t *testing.T) sqlbuilder.Handler
+	newService func(handler sqlbuilder.Handler) *paymentrecord.PayRecordService
+	inMemory   bool // 收款单、支付记录不落库，未配置数据库
+}
 
-var handler = sqlbuilder.NewGormHandler(mysqlDB)
-var payOrderService = paymentrecord.NewPayRecordService(handler)
+// noHandler 内存仓库不使用数据库
+func noHandler(t *testing.T) sqlbuilder.Handler {
+	return nil
+}
 
-func init() {
-	sqlbuilder.CreateTableIfNotExists = true
+var backends = []backend{
+	{
+		name:       "memory", // 收款单、支付记录使用内存仓库，不依赖数据库
+		newHandler: noHandler,
+		newService: func(handler sqlbuilder.Handler) *paymentrecord.PayRecordService {
+			store := repository.NewMemoryStore(handler)
+			return paymentrecord.NewPayRecordServiceWithRepository(handler, store.PayOrderRepository(), store.PayRecordRepository())
+		},
+		inMemory: true,
+	},
+	{
+		name:       "cache", // 内存仓库前加进程内缓存，所有用例均校验缓存失效
+		newHandler: noHandler,
+		newService: func(handler sqlbuilder.Handler) *paymentrecord.PayRecordService {
+			store := repository.NewMemoryStore(handler)
+			s := paymentrecord.NewPayRecordServiceWithRepository(handler, store.PayOrderRepository(), store.PayRecordRepository())
+			return s.SetCache(cache.NewLRU(1000), time.Minute, nil)
+		},
+		inMemory: true,
+	},
+	{
+		name:       "sqlite",
+		newHandler: newSqliteHandler,
+		newService: paymentrecord.NewPayRecordService,
+	},
+	{
+		name:       "mysql",
+		newHandler: newMysqlHandler,
+		newService: paymentrecord.NewPayRecordService,
+	},
 }
 
-var payId = paymentrecord.PayIdGenerator()
+// eachBackend 每个仓库实现、数据库方言使用独立的库运行同一用例
+func eachBackend(t *testing.T, fn func(t *testing.T, s *paymentrecord.PayRecordService)) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			fn(t, backend.newService(backend.newHandler(t)))
+		})
+	}
+}
 
-// var payId = "202507291414062636"
-var orderId = "o1545"
+// eachDatabaseBackend 分账、退款、账本等依赖数据库的用例，跳过未配置数据库的内存仓库
+func eachDatabaseBackend(t *testing.T, fn func(t *testing.T, s *paymentrecord.PayRecordService)) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			if backend.inMemory {
+				t.Skip("内存仓库未配置数据库")
+			}
+			fn(t, backend.newService(backend.newHandler(t)))
+		})
+	}
+}
 
-func TestCreateOrder(t *testing.T) {
-	crateIn := paymentrecord.PayRecordCreateIn{
+func newCreateIn(payId string, orderId string, orderAmount int, payAmount int) paymentrecord.PayRecordCreateIn {
+	return paymentrecord.PayRecordCreateIn{
 		PayId:       payId,
 		OrderId:     orderId,
 		PayAgent:    repository.PayingAgent_Wechat,
-		OrderAmount: 5000,
-		PayAmount:   1000,
-		PayParam:    "",
+		OrderAmount: orderAmount,
+		PayAmount:   payAmount,
 		UserId:      "test_user_154",
 		ClientIp:    "127.0.0.1",
 	}
-	err := payOrderService.Create(crateIn)
-	require.NoError(t, err)
 }
 
-func TestPayOrder(t *testing.T) {
-	payId := "202508011738119472"
-	in := paymentrecord.PayIn{
-		PayId: payId,
-	}
-	isOrderPaid, err := payOrderService.Pay(in)
+func requireRecordState(t *testing.T, s *paymentrecord.PayRecordService, payId string, state repository.PayOrderState) {
+	record, err := s.Get(payId)
 	require.NoError(t, err)
-	fmt.Println(isOrderPaid)
+	require.Equal(t, state.String(), record.State, payId)
 }
 
-func TestIsPaid(t *testing.T) {
-	isPaid, err := payOrderService.IsPaid(orderId)
-	require.NoError(t, err)
-	fmt.Println(isPaid)
+func TestCreateAndPay(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 3000), newCreateIn("p2", "o1", 5000, 2000))
+		require.NoError(t, err)
+		restPayAmount, err := s.GetOrderRestPayRecordAmount("o1")
+		require.NoError(t, err)
+		require.Equal(t, 0, restPayAmount)
+
+		isOrderPaid, err := s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+		require.False(t, isOrderPaid)
+		requireRecordState(t, s, "p1", repository.PayOrderModel_state_paid)
+
+		isOrderPaid, err = s.Pay(paymentrecord.PayIn{PayId: "p2"})
+		require.NoError(t, err)
+		require.True(t, isOrderPaid)
+		isPaid, err := s.IsPaid("o1")
+		require.NoError(t, err)
+		require.True(t, isPaid)
+
+		isOrderPaid, err = s.Pay(paymentrecord.PayIn{PayId: "p2"}) // 重复回调幂等
+		require.NoError(t, err)
+		require.True(t, isOrderPaid)
+
+		payRecords, err := s.GetOrderPayInfo("o1")
+		require.NoError(t, err)
+		require.Len(t, payRecords, 2)
+	})
 }
 
-func TestCloseByPayId(t *testing.T) {
-	payId := "202508011738066655"
-	in := paymentrecord.CloseIn{
-		PayId:  payId,
-		Reason: "测试关闭",
-	}
-	err := payOrderService.Close(in)
-	require.NoError(t, err)
+func TestCreateExceedOrderAmount(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 3000))
+		require.NoError(t, err)
+		err = s.Create(newCreateIn("p2", "o1", 5000, 3000))
+		require.Error(t, err)
+		err = s.Create(newCreateIn("p3", "o1", 6000, 1000)) // 订单金额不允许通过创建支付记录修改
+		require.Error(t, err)
+	})
+}
+
+func TestCreateRollback(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 1000), newCreateIn("p1", "o1", 5000, 1000)) // 支付流水号重复
+		require.Error(t, err)
+		_, err = s.Get("p1")
+		require.Error(t, err)
+		err = s.Create(newCreateIn("p1", "o1", 5000, 1000)) // 收款单同样已回滚，可以重新创建
+		require.NoError(t, err)
+	})
+}
+
+func TestCloseFailExpire(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 1000), newCreateIn("p2", "o1", 5000, 1000), newCreateIn("p3", "o1", 5000, 1000))
+		require.NoError(t, err)
+
+		err = s.Close(paymentrecord.CloseIn{PayId: "p1", Reason: "测试关闭"})
+		require.NoError(t, err)
+		requireRecordState(t, s, "p1", repository.PayOrderModel_state_closed)
+
+		err = s.Fail(paymentrecord.FailIn{PayId: "p2", Reason: "测试失败"})
+		require.NoError(t, err)
+		requireRecordState(t, s, "p2", repository.PayOrderModel_state_failed)
+
+		err = s.Expire(paymentrecord.ExpireIn{PayId: "p3", Reason: "很长时间没有支付，过期了"})
+		require.NoError(t, err)
+		requireRecordState(t, s, "p3", repository.PayOrderModel_state_expired)
+
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"}) // 已关闭的支付记录不能支付
+		require.Error(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p2"}) // 支付失败可以继续支付
+		require.NoError(t, err)
+		requireRecordState(t, s, "p2", repository.PayOrderModel_state_paid)
+	})
 }
 
 func TestCloseByOrderId(t *testing.T) {
-	in := paymentrecord.CloseByOrderIdIn{
-		OrderId: orderId,
-		Reason:  "测试关闭",
-	}
-	err := payOrderService.CloseByOrderId(in)
-	require.NoError(t, err)
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 1000), newCreateIn("p2", "o1", 5000, 1000))
+		require.NoError(t, err)
+		err = s.CloseByOrderId(paymentrecord.CloseByOrderIdIn{OrderId: "o1", Reason: "测试关闭"})
+		require.NoError(t, err)
+		requireRecordState(t, s, "p1", repository.PayOrderModel_state_closed)
+		requireRecordState(t, s, "p2", repository.PayOrderModel_state_closed)
+
+		err = s.Create(newCreateIn("p3", "o2", 5000, 5000))
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p3"})
+		require.NoError(t, err)
+		err = s.CloseByOrderId(paymentrecord.CloseByOrderIdIn{OrderId: "o2", Reason: "已支付订单不能关闭"})
+		require.Error(t, err)
+		requireRecordState(t, s, "p3", repository.PayOrderModel_state_paid)
+	})
 }
 
-func TestFailByPayId(t *testing.T) {
-	payId := "202507291725134384"
-	in := paymentrecord.FailIn{
-		PayId:  payId,
-		Reason: "测试失败",
-	}
-	err := payOrderService.Fail(in)
-	require.NoError(t, err)
+func TestOpenOrder(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		in := paymentrecord.OpenOrderSetIn{
+			OrderId:   "open_o1",
+			UnitPrice: 2000,
+			Capacity:  2,
+			Deadline:  time.Now().Add(7 * 24 * time.Hour).Format(time.DateTime),
+			UserId:    "test_user_154",
+			Remark:    "活动报名",
+		}
+		err := s.CreateOpenOrder(in)
+		require.NoError(t, err)
+		err = s.Create(newCreateIn("p1", "open_o1", 0, 0), newCreateIn("p2", "open_o1", 0, 0))
+		require.NoError(t, err)
+		record, err := s.Get("p1")
+		require.NoError(t, err)
+		require.Equal(t, 2000, record.PayAmount)
+		err = s.Create(newCreateIn("p3", "open_o1", 0, 0)) // 名额已满
+		require.Error(t, err)
+
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+		err = s.CloseByOrderId(paymentrecord.CloseByOrderIdIn{OrderId: "open_o1", Reason: "报名截止"})
+		require.NoError(t, err)
+		requireRecordState(t, s, "p1", repository.PayOrderModel_state_paid)
+		requireRecordState(t, s, "p2", repository.PayOrderModel_state_closed)
+	})
 }
-func TestExpireByPayId(t *testing.T) {
-	payId := "202507291740221449"
-	in := paymentrecord.ExpireIn{
-		PayId:  payId,
-		Reason: "很长时间m没有支付，过期了",
-	}
-	err := payOrderService.Expire(in)
-	require.NoError(t, err)
+
+func TestOpenOrderPayAfterDeadline(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		deadline := time.Now().Add(2 * time.Second).Truncate(time.Second)
+		err := s.CreateOpenOrder(paymentrecord.OpenOrderSetIn{OrderId: "open_o1", UnitPrice: 2000, Deadline: deadline.Format(time.DateTime)})
+		require.NoError(t, err)
+		err = s.Create(newCreateIn("p1", "open_o1", 0, 0), newCreateIn("p2", "open_o1", 0, 0))
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+
+		time.Sleep(time.Until(deadline.Add(time.Second))) // 报名截止
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p2"})
+		require.Error(t, err)
+		requireRecordState(t, s, "p2", repository.PayOrderModel_state_pending)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"}) // 截止前已支付，重复回调幂等
+		require.NoError(t, err)
+	})
 }
 
-func TestGetOrderPayInfo(t *testing.T) {
-	payRecords, err := payOrderService.GetOrderPayInfo(orderId)
-	require.NoError(t, err)
-	fmt.Println(payRecords)
+func TestSplits(t *testing.T) {
+	eachDatabaseBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		in := newCreateIn("p1", "split_o1", 10000, 10000)
+		in.Splits = paymentrecord.PaySplitIns{
+			{RecipientAccount: "seller_001", SplitRole: "seller", Rate: 9000},
+			{RecipientAccount: "platform", SplitRole: "platform", Rate: 500},
+			{RecipientAccount: "logistics_001", SplitRole: "logistics", Amount: 500},
+		}
+		err := s.Create(in)
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+
+		refunds, err := s.RefundSplit(paymentrecord.RefundSplitIn{RefundId: "r1", PayId: "p1", RefundAmount: 3333})
+		require.NoError(t, err)
+		refundAmounts := map[string]int{}
+		for _, refund := range refunds {
+			refundAmounts[refund.RecipientAccount] = refund.RefundAmount
+		}
+		// 按比例向下取整后尾差依次分摊
+		require.Equal(t, map[string]int{"seller_001": 3000, "platform": 167, "logistics_001": 166}, refundAmounts)
+
+		splitLedger, err := s.GetSplitLedger("seller_001")
+		require.NoError(t, err)
+		require.Equal(t, "seller_001", splitLedger.RecipientAccount)
+		require.Zero(t, splitLedger.PendingAmount)
+		require.Equal(t, 6000, splitLedger.SettleableAmount)
+		require.Zero(t, splitLedger.SettledAmount)
+		require.Equal(t, 3000, splitLedger.RefundAmount)
+		require.Len(t, splitLedger.Splits, 1)
+
+		// 超过剩余可退金额
+		_, err = s.RefundSplit(paymentrecord.RefundSplitIn{RefundId: "r2", PayId: "p1", RefundAmount: 10000 - 3333 + 1})
+		require.Error(t, err)
+
+		result, err := s.CheckLedger("split_o1")
+		require.NoError(t, err)
+		require.Equal(t, 10000-3333, result.LedgerAmount)
+	})
 }
 
-func TestGetByPayId(t *testing.T) {
-	payId := "202508011738119472"
-	payRecord, err := payOrderService.Get(payId)
-	require.NoError(t, err)
-	fmt.Println(payRecord)
+func TestLedger(t *testing.T) {
+	eachDatabaseBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 5000))
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+		result, err := s.CheckLedger("o1")
+		require.NoError(t, err)
+		require.Equal(t, paymentrecord.LedgerCheckResult{OrderId: "o1", RecordAmount: 5000, LedgerAmount: 5000}, result)
+		balance, err := s.GetLedgerBalance(paymentrecord.LedgerAccount_order_receipts, time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, 5000, balance)
+
+		// 未分账的支付记录退款累计在支付记录上
+		refunds, err := s.RefundSplit(paymentrecord.RefundSplitIn{RefundId: "r1", PayId: "p1", RefundAmount: 2000})
+		require.NoError(t, err)
+		require.Empty(t, refunds)
+		_, err = s.RefundSplit(paymentrecord.RefundSplitIn{RefundId: "r1", PayId: "p1", RefundAmount: 2000}) // 同一退款单号只退一次
+		require.Error(t, err)
+		_, err = s.RefundSplit(paymentrecord.RefundSplitIn{RefundId: "r2", PayId: "p1", RefundAmount: 3001})
+		require.Error(t, err)
+		record, err := s.Get("p1")
+		require.NoError(t, err)
+		require.Equal(t, 2000, record.RefundAmount)
+		result, err = s.CheckLedger("o1")
+		require.NoError(t, err)
+		require.Equal(t, paymentrecord.LedgerCheckResult{OrderId: "o1", RecordAmount: 3000, LedgerAmount: 3000}, result)
+	})
 }
 
-func TestGetOrderRestPayRecordAmount(t *testing.T) {
-	restPayAmount, err := payOrderService.GetOrderRestPayRecordAmount(orderId)
-	require.NoError(t, err)
-	fmt.Println(restPayAmount)
+func TestAdjustOrderAmount(t *testing.T) {
+	eachDatabaseBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 2000))
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+
+		out, err := s.AdjustOrderAmount(paymentrecord.AdjustOrderAmountIn{OrderId: "o1", OrderAmount: 3000, Reason: "价格修正"})
+		require.NoError(t, err)
+		require.Equal(t, paymentrecord.AdjustOrderAmountOut{
+			OrderId:        "o1",
+			OldOrderAmount: 5000,
+			OrderAmount:    3000,
+			PaidAmount:     2000,
+			ClosedPayIds:   []string{"p2"},
+		}, out)
+		requireRecordState(t, s, "p2", repository.PayOrderModel_state_closed)
+		restPayAmount, err := s.GetOrderRestPayRecordAmount("o1")
+		require.NoError(t, err)
+		require.Equal(t, 1000, restPayAmount)
+
+		_, err = s.AdjustOrderAmount(paymentrecord.AdjustOrderAmountIn{OrderId: "o1", OrderAmount: 3000, Reason: "价格修正"}) // 金额未变化
+		require.Error(t, err)
+
+		// 调减到已支付金额以下，超出部分应退款，订单支付完成
+		out, err = s.AdjustOrderAmount(paymentrecord.AdjustOrderAmountIn{OrderId: "o1", OrderAmount: 1500, Reason: "优惠"})
+		require.NoError(t, err)
+		require.Equal(t, 500, out.RefundDueAmount)
+		require.True(t, out.IsOrderPayFinished)
+		err = s.Create(newCreateIn("p3", "o1", 1500, 100))
+		require.Error(t, err)
+
+		// 已支付订单调增金额，重新待支付，可以补差价
+		out, err = s.AdjustOrderAmount(paymentrecord.AdjustOrderAmountIn{OrderId: "o1", OrderAmount: 2600, Reason: "加购"})
+		require.NoError(t, err)
+		require.Zero(t, out.RefundDueAmount)
+		require.False(t, out.IsOrderPayFinished)
+		restPayAmount, err = s.GetOrderRestPayRecordAmount("o1")
+		require.NoError(t, err)
+		require.Equal(t, 600, restPayAmount)
+		err = s.Create(newCreateIn("p3", "o1", 2600, 600))
+		require.NoError(t, err)
+		payOut, err := s.PayWithResult(paymentrecord.PayIn{PayId: "p3"})
+		require.NoError(t, err)
+		require.True(t, payOut.IsOrderPayFinished)
+
+		adjustments, err := s.GetOrderAdjustments("o1")
+		require.NoError(t, err)
+		require.Len(t, adjustments, 3)
+		require.Equal(t, "p2", adjustments[0].ClosedPayIds)
+		require.Equal(t, 500, adjustments[1].RefundDueAmount)
+		require.Equal(t, 2600, adjustments[2].OrderAmount)
+
+		_, err = s.AdjustOrderAmount(paymentrecord.AdjustOrderAmountIn{OrderId: "o2", OrderAmount: 3000, Reason: "价格修正"})
+		require.Error(t, err)
+	})
+}
+
+func TestAdjustOrderAmountRequiresDatabase(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 2000))
+		require.NoError(t, err)
+		_, err = s.AdjustOrderAmount(paymentrecord.AdjustOrderAmountIn{OrderId: "o1", OrderAmount: 3000, Reason: "价格修正"})
+		if _, dbErr := s.GetOrderAdjustments("o1"); errors.Is(dbErr, paymentrecord.ErrDatabaseRequired) {
+			require.ErrorIs(t, err, paymentrecord.ErrDatabaseRequired) // 无法记录调整记录时不调整金额
+			restPayAmount, err := s.GetOrderRestPayRecordAmount("o1")
+			require.NoError(t, err)
+			require.Equal(t, 3000, restPayAmount)
+			return
+		}
+		require.NoError(t, err)
+		adjustments, err := s.GetOrderAdjustments("o1")
+		require.NoError(t, err)
+		require.Len(t, adjustments, 1)
+	})
+}
+
+func TestPayWithCloseRestPending(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 3000))
+		require.NoError(t, err)
+		err = s.Fail(paymentrecord.FailIn{PayId: "p1", Reason: "余额不足"})
+		require.NoError(t, err)
+		err = s.Create(newCreateIn("p3", "o1", 5000, 2000))
+		require.NoError(t, err)
+
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p2"})
+		require.NoError(t, err)
+		out, err := s.PayWithResult(paymentrecord.PayIn{PayId: "p3", CloseRestPending: true})
+		require.NoError(t, err)
+		require.True(t, out.IsOrderPayFinished)
+		requireRecordState(t, s, "p1", repository.PayOrderModel_state_failed) // 只关闭待支付记录
+	})
+}
+
+func TestCloseRestPendingGatewayHook(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		var hookStates []string
+		s.SetGatewayCloseHook(func(record repository.PayRecordModel) (err error) {
+			current, err := s.Get(record.PayId) // 事务提交后调用，读到的已是关闭状态
+			if err != nil {
+				return err
+			}
+			hookStates = append(hookStates, current.State)
+			return errors.New("渠道关单超时")
+		})
+		err := s.Create(newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 3000))
+		require.NoError(t, err)
+		err = s.Fail(paymentrecord.FailIn{PayId: "p1", Reason: "余额不足"})
+		require.NoError(t, err)
+		err = s.Create(newCreateIn("p3", "o1", 5000, 2000))
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p2"})
+		require.NoError(t, err)
+
+		out, err := s.PayWithResult(paymentrecord.PayIn{PayId: "p1", CloseRestPending: true}) // 失败后又支付成功
+		require.NoError(t, err)
+		require.True(t, out.IsOrderPayFinished)
+		require.Equal(t, []string{"p3"}, out.AutoClosedPayIds)
+		require.Equal(t, map[string]string{"p3": "渠道关单超时"}, out.AutoCloseFailed)
+		require.Equal(t, []string{repository.PayOrderModel_state_closed.String()}, hookStates)
+		requireRecordState(t, s, "p3", repository.PayOrderModel_state_closed)
+	})
+}
+
+func TestDuplicateProviderTradeNo(t *testing.T) {
+	eachDatabaseBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 3000))
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1", ProviderTradeNo: "4200002721202508011738119472"})
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1", ProviderTradeNo: "4200002721202508011738119472"}) // 同一记录重复回调
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p2", ProviderTradeNo: "4200002721202508011738119472"})
+		require.ErrorIs(t, err, paymentrecord.ErrDuplicateProviderTradeNo)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p2", ProviderTradeNo: "4200002721202508011738119472"}) // 重复回调重试不重复记录超付
+		require.ErrorIs(t, err, paymentrecord.ErrDuplicateProviderTradeNo)
+		requireRecordState(t, s, "p2", repository.PayOrderModel_state_pending)
+
+		overpayments, err := s.GetOverpayments("o1")
+		require.NoError(t, err)
+		require.Len(t, overpayments, 1)
+		require.Equal(t, repository.OverpaymentType_duplicate, overpayments[0].OverpaymentType)
+	})
+}
+
+func TestOverpaymentAutoRefund(t *testing.T) {
+	eachDatabaseBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		refunded := make(map[string]int)
+		s.SetGatewayRefundHook(func(record repository.PayRecordModel, refundAmount int) (err error) {
+			refunded[record.PayId] += refundAmount
+			return nil
+		})
+		err := s.Create(newCreateIn("p1", "o1", 5000, 3000), newCreateIn("p2", "o1", 5000, 2000))
+		require.NoError(t, err)
+		err = s.Fail(paymentrecord.FailIn{PayId: "p1", Reason: "渠道超时"})
+		require.NoError(t, err)
+		err = s.Create(newCreateIn("p3", "o1", 5000, 3000))
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p2"})
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p3"})
+		require.NoError(t, err)
+
+		out, err := s.PayWithResult(paymentrecord.PayIn{PayId: "p1", AutoRefundOverpayment: true}) // 失败后渠道又回调支付成功
+		require.NoError(t, err)
+		require.Equal(t, 3000, out.OverpaidAmount)
+		require.True(t, out.OverpaymentRefunded)
+		require.Equal(t, 3000, refunded["p1"])
+
+		result, err := s.CheckLedger("o1")
+		require.NoError(t, err)
+		require.Equal(t, 5000, result.LedgerAmount)
+	})
+}
+
+func TestRefundOverpayment(t *testing.T) {
+	eachDatabaseBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		refunded := 0
+		gatewayErr := errors.New("渠道退款失败")
+		s.SetGatewayRefundHook(func(record repository.PayRecordModel, refundAmount int) (err error) {
+			if gatewayErr != nil {
+				return gatewayErr
+			}
+			refunded += refundAmount
+			return nil
+		})
+		err := s.RefundOverpayment("p1", 100) // 没有超付记录
+		require.Error(t, err)
+
+		err = s.Create(newCreateIn("p1", "o1", 5000, 3000), newCreateIn("p2", "o1", 5000, 2000))
+		require.NoError(t, err)
+		err = s.Fail(paymentrecord.FailIn{PayId: "p1", Reason: "渠道超时"})
+		require.NoError(t, err)
+		err = s.Create(newCreateIn("p3", "o1", 5000, 3000))
+		require.NoError(t, err)
+		for _, payId := range []string{"p2", "p3", "p1"} {
+			_, err = s.Pay(paymentrecord.PayIn{PayId: payId})
+			require.NoError(t, err)
+		}
+
+		err = s.RefundOverpayment("p1", 3001)
+		require.Error(t, err)
+		err = s.RefundOverpayment("p1", 3000)
+		require.ErrorIs(t, err, gatewayErr)
+		overpayments, err := s.GetOverpayments("o1")
+		require.NoError(t, err)
+		require.Len(t, overpayments, 1)
+		require.Equal(t, repository.OverpaymentState_pending, overpayments[0].State) // 渠道退款失败，可以再次退还
+		require.Equal(t, 0, overpayments[0].RefundAmount)
+
+		gatewayErr = nil
+		err = s.RefundOverpayment("p1", 3000)
+		require.NoError(t, err)
+		err = s.RefundOverpayment("p1", 3000)
+		require.Error(t, err)
+		require.Equal(t, 3000, refunded)
+		overpayments, err = s.GetOverpayments("o1")
+		require.NoError(t, err)
+		require.Equal(t, repository.OverpaymentState_refunded, overpayments[0].State)
+		require.Equal(t, 3000, overpayments[0].RefundAmount)
+		result, err := s.CheckLedger("o1")
+		require.NoError(t, err)
+		require.Equal(t, 5000, result.LedgerAmount)
+	})
+}
+
+func TestConcurrentPay(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			if backend.inMemory {
+				t.Skip("内存仓库不支持并发事务")
+			}
+			s := backend.newService(backend.newHandler(t))
+			ins := make([]paymentrecord.PayRecordCreateIn, 0, 5)
+			for i := 1; i <= 5; i++ {
+				ins = append(ins, newCreateIn(fmt.Sprintf("p%d", i), "o1", 5000, 1000))
+			}
+			err := s.Create(ins...)
+			require.NoError(t, err)
+
+			var wg sync.WaitGroup
+			errs := make([]error, len(ins))
+			for i, in := range ins { // 多笔支付回调同时到达，事务排队执行而不是报锁冲突
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, errs[i] = s.Pay(paymentrecord.PayIn{PayId: in.PayId})
+				}()
+			}
+			wg.Wait()
+			for _, err := range errs {
+				require.NoError(t, err)
+			}
+			isPaid, err := s.IsPaid("o1")
+			require.NoError(t, err)
+			require.True(t, isPaid)
+		})
+	}
+}
+
+func TestConcurrentRepeatPayOverpayment(t *testing.T) {
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			if backend.inMemory {
+				t.Skip("内存仓库不支持并发事务")
+			}
+			s := backend.newService(backend.newHandler(t))
+			var refundMu sync.Mutex
+			refunded := 0
+			s.SetGatewayRefundHook(func(record repository.PayRecordModel, refundAmount int) (err error) {
+				refundMu.Lock()
+				defer refundMu.Unlock()
+				refunded += refundAmount
+				return nil
+			})
+			err := s.Create(newCreateIn("p1", "o1", 5000, 3000), newCreateIn("p2", "o1", 5000, 2000))
+			require.NoError(t, err)
+			err = s.Fail(paymentrecord.FailIn{PayId: "p1", Reason: "渠道超时"})
+			require.NoError(t, err)
+			err = s.Create(newCreateIn("p3", "o1", 5000, 3000))
+			require.NoError(t, err)
+			for _, payId := range []string{"p2", "p3"} {
+				_, err = s.Pay(paymentrecord.PayIn{PayId: payId})
+				require.NoError(t, err)
+			}
+
+			var wg sync.WaitGroup
+			errs := make([]error, 3)
+			for i := range errs { // 同一超付记录的回调同时到达，只记录、退还一次
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, errs[i] = s.PayWithResult(paymentrecord.PayIn{PayId: "p1", AutoRefundOverpayment: true})
+				}()
+			}
+			wg.Wait()
+			for _, err := range errs {
+				require.NoError(t, err)
+			}
+			overpayments, err := s.GetOverpayments("o1")
+			require.NoError(t, err)
+			require.Len(t, overpayments, 1)
+			require.Equal(t, 3000, refunded)
+		})
+	}
 }
@@ -0,0 +1,65 @@
+package paymentrecord_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+func TestPayRecordSummary(t *testing.T) {
+	handler := newSqliteHandler(t)
+	s := paymentrecord.NewPayRecordService(handler)
+	weixin, alipay := newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p3", "o2", 5000, 5000)
+	alipay.PayAgent = repository.PayingAgent_Alipay
+	err := s.Create(weixin, newCreateIn("p2", "o1", 5000, 3000))
+	require.NoError(t, err)
+	err = s.Create(alipay)
+	require.NoError(t, err)
+
+	// 固定创建、支付时间，便于按天统计
+	dialect := sqlbuilder.Driver(handler.GetDialector()).GoquDialect()
+	for payId, record := range map[string]goqu.Record{
+		"p1": {"Fcreated_at": "2024-05-01 10:00:00", "Fpaid_at": "2024-05-01 10:01:00", "Fstate": repository.PayOrderModel_state_paid.String(), "Fprovider_fee": 12},
+		"p2": {"Fcreated_at": "2024-05-01 11:00:00"},
+		"p3": {"Fcreated_at": "2024-05-02 09:00:00", "Fpaid_at": "2024-05-02 09:03:00", "Fstate": repository.PayOrderModel_state_paid.String()},
+	} {
+		sql, _, err := dialect.Update("pay_record").Set(record).Where(goqu.C("Fpay_id").Eq(payId)).ToSQL()
+		require.NoError(t, err)
+		require.NoError(t, handler.Exec(sql))
+	}
+	startAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+	endAt := startAt.AddDate(0, 0, 2)
+
+	rows, err := repository.PayRecordSummary(handler, repository.SummaryIn{StartAt: startAt, EndAt: endAt})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, 3, rows[0].Count)
+	require.Equal(t, 10000, rows[0].Amount)
+	require.Equal(t, 7000, rows[0].PaidAmount)
+	require.Equal(t, 12, rows[0].PaidFee)
+	require.Equal(t, 6988, rows[0].PaidNetAmount())
+	require.InDelta(t, 2.0/3, rows[0].ConversionRate(), 0.001)
+	require.InDelta(t, 120, rows[0].AvgPaySeconds, 0.5)
+
+	rows, err = repository.PayRecordSummary(handler, repository.SummaryIn{StartAt: startAt, EndAt: endAt, GroupBy: []string{repository.SummaryGroupBy_day, repository.SummaryGroupBy_pay_agent}})
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, "2024-05-01", rows[0].Period)
+	require.Equal(t, 2, rows[0].Count)
+	require.Equal(t, 1, rows[0].PaidCount)
+	require.InDelta(t, 60, rows[0].AvgPaySeconds, 0.5)
+	require.Equal(t, "2024-05-02", rows[1].Period)
+	require.Equal(t, repository.PayingAgent_Alipay, rows[1].PayAgent)
+	require.Equal(t, 5000, rows[1].PaidAmount)
+
+	rows, err = repository.PayRecordSummary(handler, repository.SummaryIn{StartAt: startAt, EndAt: startAt.Add(time.Hour), GroupBy: []string{repository.SummaryGroupBy_state}})
+	require.NoError(t, err)
+	require.Empty(t, rows)
+	_, err = repository.PayRecordSummary(handler, repository.SummaryIn{StartAt: startAt, EndAt: endAt, GroupBy: []string{repository.SummaryGroupBy_day, repository.SummaryGroupBy_hour}})
+	require.Error(t, err)
+}
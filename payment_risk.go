@@ -0,0 +1,132 @@
+package paymentrecord
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/paymentrecord/risk"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+var ErrRiskDenied = errors.New("风控拒绝")
+
+// SetRiskEngine 设置风控引擎，创建支付记录时按规则评估，决策为拒绝时返回 ErrRiskDenied；
+// 支付回调同样评估，但渠道已扣款，命中规则时照常入账并标记待复核。未设置时不做风控
+func (s *PayRecordService) SetRiskEngine(engine *risk.Engine) *PayRecordService {
+	s.riskEngine = engine
+	return s
+}
+
+// GetRiskDecisions 支付记录的风控决策记录
+func (s PayRecordService) GetRiskDecisions(payId string) (decisions risk.DecisionModels, err error) {
+	if s.riskEngine == nil {
+		return nil, nil
+	}
+	return s.riskEngine.GetByPayId(payId)
+}
+
+// engine 风控引擎，服务启用字段加密时客户端IP只记录盲索引
+func (s PayRecordService) engine() *risk.Engine {
+	if s.cipher == nil {
+		return s.riskEngine
+	}
+	return s.riskEngine.WithCipher(s.cipher)
+}
+
+// riskDecision 业务事务内评估的决策，事务结束后再记录，被拒绝、回滚的请求同样留痕
+type riskDecision struct {
+	in     risk.Input
+	result risk.Result
+}
+
+type riskDecisions []riskDecision
+
+// checkCreateRisk 创建支付记录前评估风控，在创建事务内、金额校验后调用；
+// 日累计金额、待支付记录数按事务内的支付记录统计，同批次先创建的支付记录计入
+func (s PayRecordService) checkCreateRisk(recordRepository repository.PayRecordRepository, in PayRecordCreateIn, decisions *riskDecisions) (err error) {
+	if s.riskEngine == nil {
+		return nil
+	}
+	input := s.riskInput(recordRepository, risk.Stage_create, in.riskInput())
+	result, err := s.engine().Check(input)
+	if err != nil {
+		return err
+	}
+	*decisions = append(*decisions, riskDecision{in: input, result: result})
+	if result.IsDenied() {
+		err = errors.WithMessagef(ErrRiskDenied, "规则-%s,%s,支付流水号-%s", result.Rule, result.Reason, in.PayId)
+		return err
+	}
+	return nil
+}
+
+// recordRiskDecisions 记录事务内评估的决策，记录失败不影响已提交的业务，只记录日志
+func (s PayRecordService) recordRiskDecisions(decisions riskDecisions) {
+	for _, decision := range decisions {
+		err := s.engine().Record(decision.in, decision.result)
+		if err != nil {
+			s.logError(decision.in.Stage, decision.in.PayId, errors.WithMessage(err, "风控决策记录失败")) // 环节取值与 Operation_create、Operation_pay 相同
+		}
+	}
+}
+
+// evaluatePayRisk 支付回调风控评估，决策只用于标记待复核，不拒绝回调；评估失败时只记录日志
+func (s PayRecordService) evaluatePayRisk(model repository.PayRecordModel) {
+	if s.riskEngine == nil {
+		return
+	}
+	input := s.riskInput(s.recordRepository, risk.Stage_pay, recordRiskInput(model))
+	_, err := s.engine().Evaluate(input)
+	if err != nil {
+		s.logError(Operation_pay, input.PayId, errors.WithMessage(err, "风控评估失败"))
+	}
+}
+
+// riskInput 补全评估环节、商户及基于支付记录的统计
+func (s PayRecordService) riskInput(recordRepository repository.PayRecordRepository, stage string, in risk.Input) risk.Input {
+	in.Stage = stage
+	if in.MerchantId == "" {
+		in.MerchantId = s.merchantId
+	}
+	userId := in.UserId
+	in.CountPending = func() (count int, err error) {
+		whereFs := sqlbuilder.Fields{
+			repository.NewUserId(userId).AppendWhereFn(sqlbuilder.ValueFnForward),
+			repository.NewState(repository.PayOrderModel_state_pending.String()).AppendWhereFn(sqlbuilder.ValueFnForward),
+		}
+		total, err := recordRepository.CountPayRecordByConditon(whereFs)
+		if err != nil {
+			return 0, err
+		}
+		return int(total), nil
+	}
+	in.AmountSince = func(since time.Time) (amount int, err error) {
+		return recordRepository.GetUserAmountSince(userId, since)
+	}
+	return in
+}
+
+func (in PayRecordCreateIn) riskInput() risk.Input {
+	return risk.Input{
+		MerchantId:     in.MerchantId,
+		PayId:          in.PayId,
+		OrderId:        in.OrderId,
+		UserId:         in.UserId,
+		ClientIp:       in.ClientIp,
+		PaymentAccount: in.PaymentAccount,
+		PayAmount:      in.PayAmount,
+	}
+}
+
+func recordRiskInput(model repository.PayRecordModel) risk.Input {
+	return risk.Input{
+		MerchantId:     model.MerchantId,
+		PayId:          model.PayId,
+		OrderId:        model.OrderId,
+		UserId:         model.UserId,
+		ClientIp:       model.ClientIp,
+		PaymentAccount: model.PaymentAccount,
+		PayAmount:      model.PayAmount,
+	}
+}
@@ -0,0 +1,171 @@
+package paymentrecord_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/encryption"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/paymentrecord/risk"
+)
+
+const riskConfig = `
+rules:
+  - type: userDailyAmount
+    limit: 10000
+  - type: ipVelocity
+    window: 10m
+    limit: 3
+    action: review
+  - type: blacklist
+    clientIps: ["10.0.0.1"]
+    paymentAccounts: ["6222000000000000"]
+  - type: userPendingLimit
+    limit: 2
+`
+
+func TestRisk(t *testing.T) {
+	handler := newSqliteHandler(t)
+	engine, err := risk.NewEngineFromYAML(handler, []byte(riskConfig))
+	require.NoError(t, err)
+	s := paymentrecord.NewPayRecordService(handler).SetRiskEngine(engine)
+
+	err = s.Create(newCreateIn("p1", "o1", 6000, 6000))
+	require.NoError(t, err)
+	_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+	require.NoError(t, err)
+
+	// 当日累计 6000+5000 超过上限，拒绝且不创建支付记录
+	err = s.Create(newCreateIn("p2", "o2", 5000, 5000))
+	require.ErrorIs(t, err, paymentrecord.ErrRiskDenied)
+	_, err = s.Get("p2")
+	require.Error(t, err)
+	decisions, err := s.GetRiskDecisions("p2")
+	require.NoError(t, err)
+	require.Len(t, decisions, 1)
+	require.Equal(t, risk.Decision_deny, decisions[0].Decision)
+	require.Equal(t, risk.RuleType_user_daily_amount, decisions[0].Rule)
+
+	// 黑名单 IP
+	blocked := newCreateIn("p3", "o3", 100, 100)
+	blocked.UserId, blocked.ClientIp = "other_user", "10.0.0.1"
+	err = s.Create(blocked)
+	require.ErrorIs(t, err, paymentrecord.ErrRiskDenied)
+
+	// 待支付记录数达到上限
+	for _, payId := range []string{"p4", "p5"} {
+		in := newCreateIn(payId, "o_"+payId, 100, 100)
+		in.UserId, in.ClientIp = "pending_user", "192.168.0.1"
+		err = s.Create(in)
+		require.NoError(t, err)
+	}
+	in := newCreateIn("p6", "o_p6", 100, 100)
+	in.UserId, in.ClientIp = "pending_user", "192.168.0.2"
+	err = s.Create(in)
+	require.ErrorIs(t, err, paymentrecord.ErrRiskDenied)
+	decisions, err = s.GetRiskDecisions("p6")
+	require.NoError(t, err)
+	require.Equal(t, risk.RuleType_user_pending_limit, decisions[0].Rule)
+
+	// 同一 IP 超过频次为待复核，放行并记录
+	in = newCreateIn("p7", "o_p7", 100, 100)
+	in.UserId, in.ClientIp = "review_user", "192.168.0.1"
+	err = s.Create(in)
+	require.NoError(t, err)
+	in = newCreateIn("p8", "o_p8", 100, 100)
+	in.UserId, in.ClientIp = "review_user", "192.168.0.1"
+	err = s.Create(in)
+	require.NoError(t, err)
+	requireRecordState(t, s, "p8", repository.PayOrderModel_state_pending)
+	decisions, err = s.GetRiskDecisions("p8")
+	require.NoError(t, err)
+	require.Equal(t, risk.Decision_review, decisions[0].Decision)
+	require.Equal(t, risk.RuleType_ip_velocity, decisions[0].Rule)
+
+	// 支付回调同样评估，放行的决策也有记录
+	_, err = s.Pay(paymentrecord.PayIn{PayId: "p8"})
+	require.NoError(t, err)
+	decisions, err = s.GetRiskDecisions("p8")
+	require.NoError(t, err)
+	require.Len(t, decisions, 2)
+	require.Equal(t, risk.Stage_pay, decisions[1].Stage)
+	require.Equal(t, risk.Decision_allow, decisions[1].Decision)
+
+	_, err = risk.ParseConfig([]byte("rules:\n  - type: unknown\n"))
+	require.Error(t, err)
+}
+
+func TestRiskCreateInTransaction(t *testing.T) {
+	handler := newSqliteHandler(t)
+	engine, err := risk.NewEngineFromYAML(handler, []byte(riskConfig))
+	require.NoError(t, err)
+	s := paymentrecord.NewPayRecordService(handler).SetRiskEngine(engine)
+
+	// 同批次先创建的支付记录计入日累计金额，拒绝时整批回滚，决策仍有记录
+	err = s.Create(newCreateIn("p1", "o1", 12000, 6000), newCreateIn("p2", "o1", 12000, 6000))
+	require.ErrorIs(t, err, paymentrecord.ErrRiskDenied)
+	_, err = s.Get("p1")
+	require.Error(t, err)
+	decisions, err := s.GetRiskDecisions("p1")
+	require.NoError(t, err)
+	require.Equal(t, risk.Decision_allow, decisions[0].Decision)
+	decisions, err = s.GetRiskDecisions("p2")
+	require.NoError(t, err)
+	require.Equal(t, risk.Decision_deny, decisions[0].Decision)
+
+	// 关闭的支付记录不计入日累计金额
+	err = s.Create(newCreateIn("p3", "o3", 6000, 6000))
+	require.NoError(t, err)
+	err = s.Close(paymentrecord.CloseIn{PayId: "p3"})
+	require.NoError(t, err)
+	err = s.Create(newCreateIn("p4", "o4", 6000, 6000))
+	require.NoError(t, err)
+}
+
+func TestRiskPayOnlyFlagsReview(t *testing.T) {
+	handler := newSqliteHandler(t)
+	engine, err := risk.NewEngineFromYAML(handler, []byte(`
+rules:
+  - type: blacklist
+    stages: [pay]
+    paymentAccounts: ["6222000000000000"]
+`))
+	require.NoError(t, err)
+	s := paymentrecord.NewPayRecordService(handler).SetRiskEngine(engine)
+
+	in := newCreateIn("p1", "o1", 100, 100)
+	in.PaymentAccount = "6222000000000000"
+	err = s.Create(in)
+	require.NoError(t, err)
+	// 渠道已扣款，命中拒绝规则时照常入账，标记待复核
+	_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+	require.NoError(t, err)
+	requireRecordState(t, s, "p1", repository.PayOrderModel_state_paid)
+	decisions, err := s.GetRiskDecisions("p1")
+	require.NoError(t, err)
+	require.Len(t, decisions, 2)
+	require.Equal(t, risk.Stage_pay, decisions[1].Stage)
+	require.Equal(t, risk.Decision_review, decisions[1].Decision)
+}
+
+func TestRiskClientIpBlindIndex(t *testing.T) {
+	handler := newSqliteHandler(t)
+	keys, err := encryption.GenerateFileKeys("k1")
+	require.NoError(t, err)
+	engine, err := risk.NewEngineFromYAML(handler, []byte(riskConfig))
+	require.NoError(t, err)
+	s := paymentrecord.NewPayRecordService(handler).SetFieldCipher(newCipher(t, keys)).SetRiskEngine(engine)
+
+	for _, payId := range []string{"p1", "p2", "p3", "p4"} {
+		in := newCreateIn(payId, "o_"+payId, 100, 100)
+		in.UserId = "u_" + payId
+		err = s.Create(in)
+		require.NoError(t, err)
+	}
+	decisions, err := s.GetRiskDecisions("p4")
+	require.NoError(t, err)
+	require.Empty(t, decisions[0].ClientIp) // 只记录盲索引
+	require.NotEmpty(t, decisions[0].ClientIpBlindIndex)
+	require.Equal(t, risk.RuleType_ip_velocity, decisions[0].Rule) // 按盲索引统计 IP 频次
+}
@@ -0,0 +1,327 @@
+package paymentrecord
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+const SplitRate_base = 10000 // 分账比例基数，万分比
+
+type PaySplitIn struct {
+	RecipientAccount string `json:"recipientAccount"`
+	RecipientName    string `json:"recipientName"`
+	SplitRole        string `json:"splitRole"` // 分账角色，如 seller、platform、logistics
+	Amount           int    `json:"amount"`    // 固定分账金额，单位分，与 Rate 二选一
+	Rate             int    `json:"rate"`      // 分账比例，万分比(100表示1%)，与 Amount 二选一
+}
+
+type PaySplitIns []PaySplitIn
+
+// resolve 计算每个收款方的分账金额，按比例分账向下取整，尾差计入最后一个按比例分账的收款方，分账总额必须等于支付金额
+func (ins PaySplitIns) resolve(payAmount int) (amounts []int, err error) {
+	amounts = make([]int, len(ins))
+	total := 0
+	lastRateIndex := -1
+	for i, in := range ins {
+		if in.RecipientAccount == "" {
+			err = errors.Errorf("分账收款人账号不能为空,第%d个分账", i+1)
+			return nil, err
+		}
+		switch {
+		case in.Amount > 0 && in.Rate > 0:
+			err = errors.Errorf("分账金额和分账比例只能二选一,收款人账号-%s", in.RecipientAccount)
+			return nil, err
+		case in.Amount > 0:
+			amounts[i] = in.Amount
+		case in.Rate > 0:
+			if in.Rate > SplitRate_base {
+				err = errors.Errorf("分账比例不能超过%d,收款人账号-%s,分账比例-%d", SplitRate_base, in.RecipientAccount, in.Rate)
+				return nil, err
+			}
+			amounts[i] = payAmount * in.Rate / SplitRate_base
+			lastRateIndex = i
+		default:
+			err = errors.Errorf("分账金额或分账比例必须大于0,收款人账号-%s", in.RecipientAccount)
+			return nil, err
+		}
+		total += amounts[i]
+	}
+	diff := payAmount - total
+	rateCount := 0
+	for _, in := range ins {
+		if in.Rate > 0 {
+			rateCount++
+		}
+	}
+	if lastRateIndex >= 0 && diff > 0 && diff < rateCount { // 按比例向下取整产生的尾差
+		amounts[lastRateIndex] += diff
+		total += diff
+	}
+	if total != payAmount {
+		err = errors.Errorf("分账总额必须等于支付金额,支付金额-%d,分账总额-%d", payAmount, total)
+		return nil, err
+	}
+	return amounts, nil
+}
+
+func (ins PaySplitIns) toCreateIns(payId string, orderId string, payAmount int) (createIns []repository.PaySplitCreateIn, err error) {
+	if len(ins) == 0 { // 不分账
+		return nil, nil
+	}
+	amounts, err := ins.resolve(payAmount)
+	if err != nil {
+		return nil, err
+	}
+	for i, in := range ins {
+		createIn := repository.PaySplitCreateIn{
+			SplitId:          fmt.Sprintf("%s_%d", payId, i+1),
+			PayId:            payId,
+			OrderId:          orderId,
+			RecipientAccount: in.RecipientAccount,
+			RecipientName:    in.RecipientName,
+			SplitRole:        in.SplitRole,
+			SplitRate:        in.Rate,
+			SplitAmount:      amounts[i],
+		}
+		createIns = append(createIns, createIn)
+	}
+	return createIns, nil
+}
+
+// settleableSplits 支付完成后，支付记录下的分账变更为待结算
+func (s PayRecordService) settleableSplits(tx sqlbuilder.Handler, payId string) (err error) {
+	if s.handler == nil { // 未配置数据库时没有分账
+		return nil
+	}
+	splits, err := s.splitRepository.WithTxHandler(tx).GetByPayId(payId)
+	if err != nil {
+		return err
+	}
+	txSplitStateMachine := s.splitRepository.GetStateMachine().WithTxHandler(tx)
+	for _, split := range splits.FilterByState(repository.PaySplitModel_state_pending.String()) {
+		err = txSplitStateMachine.Transform(repository.Action_pay_split_Settleable, split.State, split.SplitId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSplits 获取支付记录的分账明细
+func (s PayRecordService) GetSplits(payId string) (splits repository.PaySplitModels, err error) {
+	err = s.requireDatabase()
+	if err != nil {
+		return nil, err
+	}
+	return s.splitRepository.GetByPayId(payId)
+}
+
+type SettleSplitIn struct {
+	SplitId     string            `json:"splitId" validate:"required"`
+	ExtraFields sqlbuilder.Fields `json:"-"`
+}
+
+// SettleSplit 分账结算，只有待结算的分账可以结算，不能结算其它商户的分账
+func (s PayRecordService) SettleSplit(in SettleSplitIn) (err error) {
+	err = s.requireDatabase()
+	if err != nil {
+		return err
+	}
+	_, err = s.splitRepository.GetBySplitIdMust(in.SplitId) // 状态机只按分账流水号变更，先确认分账属于当前商户
+	if err != nil {
+		return err
+	}
+	fs := sqlbuilder.Fields{
+		repository.NewSettledAt(time.Now().Format(time.DateTime)),
+	}
+	fs = fs.Add(in.ExtraFields...)
+	err = s.splitRepository.GetStateMachine().TransformByIdentity(repository.Action_pay_split_Settle, in.SplitId, fs...)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+type RefundSplitIn struct {
+	RefundId     string `json:"refundId" validate:"required"` // 退款单号，同一退款单号只退一次，记账凭证号由此生成
+	PayId        string `json:"payId" validate:"required"`
+	RefundAmount int    `json:"refundAmount" validate:"required"` // 退款金额，单位分
+}
+
+type SplitRefund struct {
+	SplitId          string `json:"splitId"`
+	RecipientAccount string `json:"recipientAccount"`
+	RefundAmount     int    `json:"refundAmount"` // 本次退款分摊金额
+}
+
+// RefundSplit 支付记录退款时，按各收款方剩余分账金额比例分摊退款金额；未分账的支付记录累计在支付记录上，返回的分摊明细为空
+func (s PayRecordService) RefundSplit(in RefundSplitIn) (refunds []SplitRefund, err error) {
+	if in.RefundId == "" {
+		err = errors.New("退款单号不能为空")
+		return nil, err
+	}
+	if in.RefundAmount <= 0 {
+		err = errors.New("退款金额必须大于0")
+		return nil, err
+	}
+	err = s.requireDatabase()
+	if err != nil {
+		return nil, err
+	}
+	err = s.orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+		refunds, err = s.refundSplit(tx, in.RefundId, in.PayId, in.RefundAmount)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+func (s PayRecordService) refundSplit(tx sqlbuilder.Handler, refundId string, payId string, refundAmount int) (refunds []SplitRefund, err error) {
+	txRecordRepository := s.recordRepository.WithTxHandler(tx)
+	record, err := txRecordRepository.GetByPayIdMust(payId)
+	if err != nil {
+		return nil, err
+	}
+	refunded, err := s.ledger.WithTxHandler(tx).ExistsEntry(refundEntryId(refundId))
+	if err != nil {
+		return nil, err
+	}
+	if refunded {
+		err = errors.Errorf("退款单号-%s已退款", refundId)
+		return nil, err
+	}
+	txSplitRepository := s.splitRepository.WithTxHandler(tx)
+	splits, err := txSplitRepository.LockByPayId(payId)
+	if err != nil {
+		return nil, err
+	}
+	if len(splits) == 0 { // 未分账
+		err = s.refundRecord(tx, record, refundAmount)
+		if err != nil {
+			return nil, err
+		}
+		err = s.postRefundLedger(tx, record, refundId, refundAmount)
+		if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	splits = splits.FilterByState(repository.PaySplitModel_state_settleable.String(), repository.PaySplitModel_state_settled.String())
+	shares, err := allocateRefund(splits, refundAmount)
+	if err != nil {
+		err = errors.WithMessagef(err, "支付流水号-%s", payId)
+		return nil, err
+	}
+	txSplitStateMachine := s.splitRepository.GetStateMachine().WithTxHandler(tx)
+	for i, split := range splits {
+		if shares[i] == 0 {
+			continue
+		}
+		splitRefundAmount := split.RefundAmount + shares[i]
+		err = txSplitRepository.SetRefundAmount(split.SplitId, splitRefundAmount)
+		if err != nil {
+			return nil, err
+		}
+		if splitRefundAmount >= split.SplitAmount { // 全额退款
+			err = txSplitStateMachine.Transform(repository.Action_pay_split_Refund, split.State, split.SplitId)
+			if err != nil {
+				return nil, err
+			}
+		}
+		refunds = append(refunds, SplitRefund{
+			SplitId:          split.SplitId,
+			RecipientAccount: split.RecipientAccount,
+			RefundAmount:     shares[i],
+		})
+	}
+	err = s.postRefundLedger(tx, record, refundId, refundAmount)
+	if err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+// refundRecord 未分账的支付记录退款，锁定收款单后累加支付记录的退款金额，累计退款不能超过支付金额
+func (s PayRecordService) refundRecord(tx sqlbuilder.Handler, record repository.PayRecordModel, refundAmount int) (err error) {
+	_, _, err = s.orderRepository.WithTxHandler(tx).LockByOrderId(record.OrderId)
+	if err != nil {
+		return err
+	}
+	txRecordRepository := s.recordRepository.WithTxHandler(tx)
+	record, err = txRecordRepository.GetByPayIdMust(record.PayId) // 锁定后重新读取，并发退款不会相互覆盖
+	if err != nil {
+		return err
+	}
+	if record.State != repository.PayOrderModel_state_paid.String() {
+		err = errors.Errorf("支付记录未支付,不能退款,支付流水号-%s,状态-%s", record.PayId, record.State)
+		return err
+	}
+	restAmount := record.PayAmount - record.RefundAmount
+	if refundAmount > restAmount {
+		err = errors.Errorf("退款金额超过可退金额,支付流水号-%s,可退金额-%d,退款金额-%d", record.PayId, restAmount, refundAmount)
+		return err
+	}
+	err = txRecordRepository.SetRefundAmount(record.PayId, record.RefundAmount+refundAmount)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// allocateRefund 按剩余分账金额比例分摊退款，向下取整后的尾差按顺序逐分分摊给仍有余额的收款方
+func allocateRefund(splits repository.PaySplitModels, refundAmount int) (shares []int, err error) {
+	restTotal := splits.RestAmount()
+	if refundAmount > restTotal {
+		err = errors.Errorf("退款金额超过可退分账金额,可退金额-%d,退款金额-%d", restTotal, refundAmount)
+		return nil, err
+	}
+	shares = make([]int, len(splits))
+	allocated := 0
+	for i, split := range splits {
+		shares[i] = refundAmount * split.RestAmount() / restTotal
+		allocated += shares[i]
+	}
+	for i := 0; allocated < refundAmount; i = (i + 1) % len(splits) {
+		if shares[i] < splits[i].RestAmount() {
+			shares[i]++
+			allocated++
+		}
+	}
+	return shares, nil
+}
+
+type SplitLedger struct {
+	RecipientAccount string                    `json:"recipientAccount"`
+	PendingAmount    int                       `json:"pendingAmount"`    // 支付未完成的分账金额
+	SettleableAmount int                       `json:"settleableAmount"` // 待结算金额(已扣除退款)
+	SettledAmount    int                       `json:"settledAmount"`    // 已结算金额(已扣除退款)
+	RefundAmount     int                       `json:"refundAmount"`     // 累计退款金额
+	Splits           repository.PaySplitModels `json:"splits"`
+}
+
+// GetSplitLedger 获取收款人分账结算台账
+func (s PayRecordService) GetSplitLedger(recipientAccount string) (ledger SplitLedger, err error) {
+	err = s.requireDatabase()
+	if err != nil {
+		return ledger, err
+	}
+	splits, err := s.splitRepository.GetByRecipientAccount(recipientAccount)
+	if err != nil {
+		return ledger, err
+	}
+	ledger = SplitLedger{
+		RecipientAccount: recipientAccount,
+		PendingAmount:    splits.FilterByState(repository.PaySplitModel_state_pending.String()).TotalAmount(),
+		SettleableAmount: splits.FilterByState(repository.PaySplitModel_state_settleable.String()).RestAmount(),
+		SettledAmount:    splits.FilterByState(repository.PaySplitModel_state_settled.String()).RestAmount(),
+		RefundAmount:     splits.RefundAmount(),
+		Splits:           splits,
+	}
+	return ledger, nil
+}
@@ -0,0 +1,90 @@
+package paymentrecord_test
+
+import (
+	"testing"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+func requireRestAmount(t *testing.T, s *paymentrecord.PayRecordService, orderId string, expected int) {
+	restAmount, err := s.GetOrderRestPayRecordAmount(orderId)
+	require.NoError(t, err)
+	require.Equal(t, expected, restAmount)
+}
+
+func TestOrderTotals(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		err := s.Create(newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 1000))
+		require.NoError(t, err)
+		requireRestAmount(t, s, "o1", 2000)
+
+		err = s.Fail(paymentrecord.FailIn{PayId: "p2", Reason: "余额不足"})
+		require.NoError(t, err)
+		requireRestAmount(t, s, "o1", 3000)
+		err = s.Expire(paymentrecord.ExpireIn{PayId: "p1", Reason: "超时"})
+		require.NoError(t, err)
+		requireRestAmount(t, s, "o1", 0) // 无有效支付记录，同 PayRecordModels.GetOrderAmount
+
+		err = s.Create(newCreateIn("p3", "o1", 5000, 3000))
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p3"})
+		require.NoError(t, err)
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p3"}) // 重复回调不重复累加
+		require.NoError(t, err)
+		requireRestAmount(t, s, "o1", 2000)
+		isPaid, err := s.IsPaid("o1")
+		require.NoError(t, err)
+		require.False(t, isPaid)
+
+		err = s.Create(newCreateIn("p4", "o1", 5000, 2500)) // 超出剩余金额
+		require.Error(t, err)
+		err = s.Create(newCreateIn("p4", "o1", 5000, 2000))
+		require.NoError(t, err)
+		err = s.Close(paymentrecord.CloseIn{PayId: "p4", Reason: "用户取消"})
+		require.NoError(t, err)
+		requireRestAmount(t, s, "o1", 2000)
+
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p2"}) // 支付失败后渠道补回调
+		require.NoError(t, err)
+		requireRestAmount(t, s, "o1", 1000)
+		err = s.Create(newCreateIn("p5", "o1", 5000, 1000))
+		require.NoError(t, err)
+		isOrderPaid, err := s.Pay(paymentrecord.PayIn{PayId: "p5"})
+		require.NoError(t, err)
+		require.True(t, isOrderPaid)
+		isPaid, err = s.IsPaid("o1")
+		require.NoError(t, err)
+		require.True(t, isPaid)
+		requireRestAmount(t, s, "o1", 0)
+	})
+}
+
+func TestRepairPayOrderTotals(t *testing.T) {
+	handler := newSqliteHandler(t)
+	s := paymentrecord.NewPayRecordService(handler)
+	err := s.Create(newCreateIn("p1", "o1", 5000, 2000))
+	require.NoError(t, err)
+	err = s.Create(newCreateIn("p2", "o2", 3000, 1000))
+	require.NoError(t, err)
+
+	// 模拟汇总与支付记录不一致
+	sql, _, err := sqlbuilder.Driver(handler.GetDialector()).GoquDialect().Update("pay_order").
+		Set(goqu.Record{"Fpending_amount": 0, "Frecord_count": 0}).Where(goqu.C("Forder_id").Eq("o1")).ToSQL()
+	require.NoError(t, err)
+	require.NoError(t, handler.Exec(sql))
+	requireRestAmount(t, s, "o1", 0)
+
+	repaired, err := repository.RepairPayOrderTotals(handler, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, repaired)
+	requireRestAmount(t, s, "o1", 3000)
+	requireRestAmount(t, s, "o2", 2000)
+
+	repaired, err = repository.RepairPayOrderTotals(handler, 0) // 重复执行无副作用
+	require.NoError(t, err)
+	require.Equal(t, 0, repaired)
+}
@@ -0,0 +1,49 @@
+package paymentrecord
+
+import (
+	"context"
+
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TracerName = "github.com/suifengpiao14/paymentrecord"
+
+// SetTracerProvider 设置链路追踪，未设置时不创建 span
+func (s *PayRecordService) SetTracerProvider(provider trace.TracerProvider) *PayRecordService {
+	s.tracer = provider.Tracer(TracerName)
+	return s
+}
+
+// WithContext 返回绑定 ctx 的服务，服务方法的 span 挂在 ctx 所在链路下
+func (s PayRecordService) WithContext(ctx context.Context) *PayRecordService {
+	s.ctx = ctx
+	return &s
+}
+
+// startSpan 创建服务方法 span，返回的服务中收款单、支付记录仓库调用均为该 span 的子 span
+func (s PayRecordService) startSpan(name string, attrs ...attribute.KeyValue) (traced PayRecordService, span trace.Span) {
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if s.tracer == nil {
+		return s, trace.SpanFromContext(context.Background()) // 不记录的空 span
+	}
+	ctx, span = s.tracer.Start(ctx, "PayRecordService."+name, trace.WithAttributes(attrs...))
+	traced = s
+	traced.ctx = ctx
+	traced.orderRepository = repository.NewTracedPayOrderRepository(ctx, s.tracer, s.orderRepository)
+	traced.recordRepository = repository.NewTracedPayRecordRepository(ctx, s.tracer, s.recordRepository)
+	return traced, span
+}
+
+func recordAttributes(record repository.PayRecordModel) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		repository.Attr_pay_id.String(record.PayId),
+		repository.Attr_order_id.String(record.OrderId),
+		repository.Attr_pay_agent.String(record.PayAgent),
+		repository.Attr_pay_amount.Int(record.PayAmount),
+	}
+}
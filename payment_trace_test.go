@@ -0,0 +1,79 @@
+package paymentrecord_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/suifengpiao14/paymentrecord"
+	"github.com/suifengpiao14/paymentrecord/repository"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	require.Failf(t, "span not found", name)
+	return tracetest.SpanStub{}
+}
+
+func spanAttributes(attrs []attribute.KeyValue) map[attribute.Key]string {
+	m := make(map[attribute.Key]string)
+	for _, attr := range attrs {
+		m[attr.Key] = attr.Value.Emit()
+	}
+	return m
+}
+
+func TestTracing(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *paymentrecord.PayRecordService) {
+		exporter := tracetest.NewInMemoryExporter()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+		s.SetTracerProvider(provider)
+		err := s.Create(newCreateIn("p1", "o1", 5000, 2000), newCreateIn("p2", "o1", 5000, 3000))
+		require.NoError(t, err)
+
+		exporter.Reset()
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "p1"})
+		require.NoError(t, err)
+		spans := exporter.GetSpans()
+		paySpan := findSpan(t, spans, "PayRecordService.Pay")
+		require.Equal(t, map[attribute.Key]string{
+			repository.Attr_pay_id:     "p1",
+			repository.Attr_order_id:   "o1",
+			repository.Attr_pay_agent:  repository.PayingAgent_Wechat,
+			repository.Attr_pay_amount: "2000",
+		}, spanAttributes(paySpan.Attributes))
+		for _, name := range []string{"PayRecordRepository.GetByPayId", "PayOrderRepository.LockByOrderId", "PayRecordRepository.Transform", "PayRecordRepository.GetByOrderId"} {
+			require.Equal(t, paySpan.SpanContext.SpanID(), findSpan(t, spans, name).Parent.SpanID(), name)
+		}
+		require.NotEmpty(t, paySpan.Events)
+		require.Equal(t, repository.SpanEvent_transition, paySpan.Events[0].Name)
+		require.Equal(t, repository.Action_pay_record_Pay, spanAttributes(paySpan.Events[0].Attributes)[repository.Attr_event])
+
+		exporter.Reset()
+		ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+		err = s.WithContext(ctx).Close(paymentrecord.CloseIn{PayId: "p2", Reason: "用户取消"})
+		require.NoError(t, err)
+		parent.End()
+		closeSpan := findSpan(t, exporter.GetSpans(), "PayRecordService.Close")
+		require.Equal(t, parent.SpanContext().SpanID(), closeSpan.Parent.SpanID())
+		require.Equal(t, map[attribute.Key]string{
+			repository.Attr_pay_id:     "p2",
+			repository.Attr_order_id:   "o1",
+			repository.Attr_pay_agent:  repository.PayingAgent_Wechat,
+			repository.Attr_pay_amount: "3000",
+		}, spanAttributes(closeSpan.Attributes))
+
+		exporter.Reset()
+		_, err = s.Pay(paymentrecord.PayIn{PayId: "notExists"})
+		require.Error(t, err)
+		require.Equal(t, codes.Error, findSpan(t, exporter.GetSpans(), "PayRecordService.Pay").Status.Code)
+	})
+}
@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// 归档表与在线表结构一致，已结束的订单及其支付记录移入后不再变更
+var table_pay_order_history = table_pay_order.WithTableName("pay_order_history").WithComment("收款单归档表")
+
+var table_pay_record_history = table_pay_record.WithTableName("pay_record_history").WithComment("收款记录归档表")
+
+// ArchivedOrderStates 可归档的收款单状态
+var ArchivedOrderStates = []string{PayOrderModel_state_paid.String(), PayOrderModel_state_closed.String()}
+
+// ErrArchived 收款单、支付记录已归档，不再变更，订单号也不能再次使用
+var ErrArchived = errors.New("订单已归档")
+
+// ArchivePayOrders 归档任务：将已支付、已关闭且创建时间早于 before 的收款单连同其支付记录移入归档表，返回归档的收款单数。
+// 按主键分批执行，每批在一个事务内锁定收款单后完成复制与删除，提交后删除 cache 中的收款单及支付记录(cache 可为空)；
+// 仍有待支付记录的收款单跳过，分账、账本等关联记录保留在原表
+func ArchivePayOrders(handler sqlbuilder.Handler, cache Cache, before time.Time, batchSize int) (archived int, err error) {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	var lastId int64
+	for {
+		var orders PayOrderModels
+		var records PayRecordModels
+		err = handler.Transaction(func(tx sqlbuilder.Handler) (err error) {
+			orders, err = lockArchivableOrders(tx, before, lastId, batchSize)
+			if err != nil {
+				return err
+			}
+			if len(orders) == 0 {
+				return nil
+			}
+			records, err = archiveBatch(tx, orders)
+			return err
+		})
+		if err != nil {
+			return archived, err
+		}
+		archived += len(orders)
+		if cache != nil && len(orders) > 0 {
+			_ = cache.Delete(archivedCacheKeys(orders, records)...)
+		}
+		if len(orders) < batchSize {
+			return archived, nil
+		}
+		lastId = orders[len(orders)-1].Id
+	}
+}
+
+// lockArchivableOrders 在事务内锁定一批可归档的收款单，归档期间支付回调、创建支付记录等待锁释放；sqlite 不支持行锁，由 immediate 事务串行
+func lockArchivableOrders(tx sqlbuilder.Handler, before time.Time, lastId int64, batchSize int) (orders PayOrderModels, err error) {
+	driver := sqlbuilder.Driver(tx.GetDialector())
+	dbNameId := table_pay_order.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))
+	dbNameState := table_pay_order.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewState))
+	dbNameCreatedAt := table_pay_order.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewCreatedAt))
+	dbNamePendingAmount := table_pay_order.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPendingAmount))
+	ds := driver.GoquDialect().From(table_pay_order.DBName.Name).
+		Where(
+			goqu.C(dbNameId).Gt(lastId),
+			goqu.C(dbNameState).In(ArchivedOrderStates),
+			goqu.C(dbNameCreatedAt).Lt(before.Format(time.DateTime)),
+			goqu.C(dbNamePendingAmount).Eq(0),
+		).Order(goqu.C(dbNameId).Asc()).Limit(uint(batchSize))
+	if !strings.HasPrefix(driver.String(), "sqlite") {
+		ds = ds.ForUpdate(exp.Wait)
+	}
+	sql, _, err := ds.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Query(context.Background(), sql, &orders)
+	if err != nil {
+		return nil, err
+	}
+	return orders, nil
+}
+
+// archivedCacheKeys 归档数据的缓存 key，与 NewCachedPayOrderRepository、NewCachedPayRecordRepository 一致
+func archivedCacheKeys(orders PayOrderModels, records PayRecordModels) (keys []string) {
+	for _, order := range orders {
+		keys = append(keys, cacheKey(CacheName_pay_order, order.MerchantId, order.OrderId), cacheKey(CacheName_pay_record_order, order.MerchantId, order.OrderId))
+	}
+	for _, record := range records {
+		keys = append(keys, cacheKey(CacheName_pay_record, record.MerchantId, record.PayId))
+	}
+	return keys
+}
+
+// archiveBatch 复制到归档表后删除在线数据，主键一并复制，归档表中的记录可与在线时的主键对应；返回归档的支付记录
+func archiveBatch(tx sqlbuilder.Handler, orders PayOrderModels) (records PayRecordModels, err error) {
+	driver := sqlbuilder.Driver(tx.GetDialector())
+	dialect := driver.GoquDialect()
+	dbNameId := table_pay_order.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))
+	dbNameMerchantId := table_pay_record.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId))
+	dbNameOrderId := table_pay_record.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId))
+	orderIds := make([]int64, 0, len(orders))
+	recordConds := make([]exp.Expression, 0, len(orders))
+	for _, order := range orders {
+		orderIds = append(orderIds, order.Id)
+		recordConds = append(recordConds, goqu.And(goqu.C(dbNameMerchantId).Eq(order.MerchantId), goqu.C(dbNameOrderId).Eq(order.OrderId)))
+	}
+	moves := []struct {
+		table   sqlbuilder.TableConfig
+		history sqlbuilder.TableConfig
+		where   exp.Expression
+	}{
+		{table: table_pay_record, history: table_pay_record_history, where: goqu.Or(recordConds...)},
+		{table: table_pay_order, history: table_pay_order_history, where: goqu.C(dbNameId).In(orderIds)},
+	}
+	dbNamePayId := table_pay_record.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPayId))
+	sql, _, err := dialect.From(table_pay_record.DBName.Name).Select(dbNameMerchantId, dbNamePayId).Where(moves[0].where).ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	err = tx.Query(context.Background(), sql, &records)
+	if err != nil {
+		return nil, err
+	}
+	for _, move := range moves {
+		cols := tableDbNames(move.table)
+		selectCols := make([]any, 0, len(cols))
+		quotedCols := make([]string, 0, len(cols))
+		for _, col := range cols {
+			selectCols = append(selectCols, col)
+			quotedCols = append(quotedCols, quoteIdent(driver, col))
+		}
+		selectSQL, _, err := dialect.From(move.table.DBName.Name).Select(selectCols...).Where(move.where).ToSQL()
+		if err != nil {
+			return nil, err
+		}
+		// goqu 未注册的方言(如 sqlite)每次生成新实例，INSERT ... FromQuery 会判定方言不一致，此处拼接语句
+		sql = fmt.Sprintf("INSERT INTO %s (%s) %s", quoteIdent(driver, move.history.DBName.Name), strings.Join(quotedCols, ","), selectSQL)
+		err = tx.Exec(sql)
+		if err != nil {
+			return nil, err
+		}
+		sql, _, err = dialect.Delete(move.table.DBName.Name).Where(move.where).ToSQL()
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Exec(sql)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// tableDbNames 表的全部字段名，字段别名对应同一列，只取一次
+func tableDbNames(table sqlbuilder.TableConfig) (cols []string) {
+	seen := map[string]bool{}
+	for _, col := range table.Columns {
+		if seen[col.DbName] {
+			continue
+		}
+		seen[col.DbName] = true
+		cols = append(cols, col.DbName)
+	}
+	return cols
+}
+
+// quoteIdent 表名、字段名加引号，postgres 字段名区分大小写(如 Fid)，必须使用双引号
+func quoteIdent(driver sqlbuilder.Driver, name string) string {
+	if driver == Driver_postgres {
+		return fmt.Sprintf(`"%s"`, name)
+	}
+	return fmt.Sprintf("`%s`", name)
+}
+
+// GetHistoryByPayId 从归档表读取支付记录
+func (repo PayRecordDBRepository) GetHistoryByPayId(payId string) (model PayRecordModel, exists bool, err error) {
+	fs := liveScope(repo.merchantId).Add(
+		NewPayId(payId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	exists, err = repo.historyRepository.First(&model, fs)
+	if err != nil {
+		return model, exists, err
+	}
+	err = model.decrypt(repo.cipher)
+	if err != nil {
+		return model, exists, err
+	}
+	return model, exists, nil
+}
+
+// GetHistoryByOrderId 从归档表读取订单下的支付记录
+func (repo PayRecordDBRepository) GetHistoryByOrderId(orderId string) (models PayRecordModels, err error) {
+	fs := liveScope(repo.merchantId).Add(
+		NewOrderId(orderId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	err = repo.historyRepository.All(&models, fs)
+	if err != nil {
+		return models, err
+	}
+	err = models.decrypt(repo.cipher)
+	if err != nil {
+		return models, err
+	}
+	return models, nil
+}
@@ -0,0 +1,552 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/suifengpiao14/paymentrecord/encryption"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// Cache 收款单、支付记录读缓存，进程内 LRU、redis 实现见 cache 包。
+// 缓存读写失败时按未命中处理，删除失败时数据在 ttl 后过期，均不影响数据库操作结果
+type Cache interface {
+	Get(key string) (value []byte, exists bool, err error)
+	Set(key string, value []byte, ttl time.Duration) (err error)
+	Delete(keys ...string) (err error)
+}
+
+// CacheMetrics 缓存命中指标，name 取值 CacheName_pay_order、CacheName_pay_record、CacheName_pay_record_order
+type CacheMetrics interface {
+	CacheLookup(name string, hit bool)
+}
+
+const (
+	CacheName_pay_order        = "payOrder"
+	CacheName_pay_record       = "payRecord"
+	CacheName_pay_record_order = "payRecordByOrder" // 订单下的支付记录
+)
+
+// CacheKeyPrefix 缓存 key 前缀，多个服务共用 redis 时用于区分
+var CacheKeyPrefix = "paymentrecord"
+
+func cacheKey(name string, merchantId string, id string) string {
+	return fmt.Sprintf("%s:%s:%s:%s", CacheKeyPrefix, name, merchantId, id)
+}
+
+// cacheTx 事务内的缓存：读取结果只在事务内复用，写操作涉及的 key 在事务提交后再从共享缓存删除，
+// 避免提交前其它请求读到旧数据重新写入缓存
+type cacheTx struct {
+	mu      sync.Mutex
+	values  map[string][]byte
+	deletes []string
+}
+
+func (tx *cacheTx) get(key string) (value []byte, exists bool) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	value, exists = tx.values[key]
+	return value, exists
+}
+
+func (tx *cacheTx) set(key string, value []byte) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.values[key] = value
+}
+
+func (tx *cacheTx) delete(keys ...string) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	for _, key := range keys {
+		delete(tx.values, key)
+	}
+	tx.deletes = append(tx.deletes, keys...)
+}
+
+// cacheTxHandler 携带事务缓存的事务句柄，由带缓存的收款单仓库开启事务时传给回调
+type cacheTxHandler struct {
+	sqlbuilder.Handler
+	tx *cacheTx
+}
+
+// cacheLayer 带缓存仓库的公共部分
+type cacheLayer struct {
+	cache   Cache
+	ttl     time.Duration
+	metrics CacheMetrics
+	inTx    bool     // 事务内不读写共享缓存
+	tx      *cacheTx // 非带缓存仓库开启的事务为空，写操作时直接删除缓存
+}
+
+// withTxHandler 返回事务内的缓存及去掉缓存包装的事务句柄
+func (c cacheLayer) withTxHandler(txHandler sqlbuilder.Handler) (layer cacheLayer, unwrapped sqlbuilder.Handler) {
+	c.inTx = true
+	c.tx = nil
+	if h, ok := txHandler.(cacheTxHandler); ok {
+		c.tx = h.tx
+		txHandler = h.Handler
+	}
+	return c, txHandler
+}
+
+// transaction 开启事务，提交后删除事务内写操作涉及的缓存
+func (c cacheLayer) transaction(transaction func(fc func(tx sqlbuilder.Handler) (err error)) error, fc func(tx sqlbuilder.Handler) (err error)) (err error) {
+	tx := &cacheTx{values: map[string][]byte{}}
+	err = transaction(func(txHandler sqlbuilder.Handler) (err error) {
+		if txHandler == nil { // 内存仓库未设置数据库时无事务句柄
+			return fc(nil)
+		}
+		return fc(cacheTxHandler{Handler: txHandler, tx: tx})
+	})
+	if err != nil {
+		return err
+	}
+	if len(tx.deletes) > 0 {
+		_ = c.cache.Delete(tx.deletes...)
+	}
+	return nil
+}
+
+// get 读取缓存到 dst，未命中返回 false
+func (c cacheLayer) get(name string, key string, dst any) (hit bool) {
+	var value []byte
+	switch {
+	case c.tx != nil:
+		value, hit = c.tx.get(key)
+	case c.inTx:
+		return false
+	default:
+		var err error
+		value, hit, err = c.cache.Get(key)
+		hit = hit && err == nil
+		if c.metrics != nil {
+			c.metrics.CacheLookup(name, hit)
+		}
+	}
+	if !hit {
+		return false
+	}
+	return json.Unmarshal(value, dst) == nil
+}
+
+func (c cacheLayer) set(key string, value any) {
+	if c.inTx && c.tx == nil {
+		return
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	if c.tx != nil {
+		c.tx.set(key, b)
+		return
+	}
+	_ = c.cache.Set(key, b, c.ttl)
+}
+
+// invalidate 数据变更后删除缓存，事务内在提交后删除
+func (c cacheLayer) invalidate(keys ...string) {
+	if c.tx != nil {
+		c.tx.delete(keys...)
+		return
+	}
+	_ = c.cache.Delete(keys...)
+}
+
+type cachedPayOrderRepository struct {
+	cacheLayer
+	merchantId string
+	repo       PayOrderRepository
+}
+
+// NewCachedPayOrderRepository 按订单号查询收款单时先读缓存，写入、状态变更后删除缓存，metrics 可为空。
+// 事务须通过返回的仓库开启(TransactionForMutiTable)，缓存才会在事务提交后删除
+func NewCachedPayOrderRepository(cache Cache, ttl time.Duration, metrics CacheMetrics, repo PayOrderRepository) PayOrderRepository {
+	return cachedPayOrderRepository{
+		cacheLayer: cacheLayer{cache: cache, ttl: ttl, metrics: metrics},
+		repo:       repo,
+	}
+}
+
+func (r cachedPayOrderRepository) key(orderId string) string {
+	return cacheKey(CacheName_pay_order, r.merchantId, orderId)
+}
+
+func (r cachedPayOrderRepository) TransactionForMutiTable(fc func(tx sqlbuilder.Handler) (err error)) (err error) {
+	return r.transaction(r.repo.TransactionForMutiTable, fc)
+}
+
+func (r cachedPayOrderRepository) WithTxHandler(txHandler sqlbuilder.Handler) PayOrderRepository {
+	r.cacheLayer, txHandler = r.withTxHandler(txHandler)
+	r.repo = r.repo.WithTxHandler(txHandler)
+	return r
+}
+
+func (r cachedPayOrderRepository) WithMerchantId(merchantId string) PayOrderRepository {
+	r.merchantId = merchantId
+	r.repo = r.repo.WithMerchantId(merchantId)
+	return r
+}
+
+func (r cachedPayOrderRepository) Set(in PayOrderSetIn) (err error) {
+	err = r.repo.Set(in)
+	if err != nil {
+		return err
+	}
+	r.invalidate(r.key(in.OrderId))
+	return nil
+}
+
+// GetByOrderId 只缓存已存在的收款单
+func (r cachedPayOrderRepository) GetByOrderId(orderId string) (model PayOrderModel, exists bool, err error) {
+	key := r.key(orderId)
+	if r.get(CacheName_pay_order, key, &model) {
+		return model, true, nil
+	}
+	model, exists, err = r.repo.GetByOrderId(orderId)
+	if err != nil || !exists {
+		return model, exists, err
+	}
+	r.set(key, model)
+	return model, true, nil
+}
+
+func (r cachedPayOrderRepository) GetByOrderIdMust(orderId string) (model PayOrderModel, err error) {
+	model, exists, err := r.GetByOrderId(orderId)
+	if err != nil {
+		return model, err
+	}
+	if !exists {
+		err = sqlbuilder.ErrNotFound
+		return model, err
+	}
+	return model, nil
+}
+
+// LockByOrderId 加锁读取不使用缓存
+func (r cachedPayOrderRepository) LockByOrderId(orderId string) (model PayOrderModel, exists bool, err error) {
+	return r.repo.LockByOrderId(orderId)
+}
+
+func (r cachedPayOrderRepository) UpdateOrderAmount(orderId string, orderAmount int) (err error) {
+	err = r.repo.UpdateOrderAmount(orderId, orderAmount)
+	if err != nil {
+		return err
+	}
+	r.invalidate(r.key(orderId))
+	return nil
+}
+
+func (r cachedPayOrderRepository) AddTotals(orderId string, delta PayOrderTotals) (err error) {
+	err = r.repo.AddTotals(orderId, delta)
+	if err != nil {
+		return err
+	}
+	r.invalidate(r.key(orderId))
+	return nil
+}
+
+func (r cachedPayOrderRepository) SetTotals(orderId string, totals PayOrderTotals) (err error) {
+	err = r.repo.SetTotals(orderId, totals)
+	if err != nil {
+		return err
+	}
+	r.invalidate(r.key(orderId))
+	return nil
+}
+
+func (r cachedPayOrderRepository) Delete(orderId string, reason string) (err error) {
+	err = r.repo.Delete(orderId, reason)
+	if err != nil {
+		return err
+	}
+	r.invalidate(r.key(orderId))
+	return nil
+}
+
+func (r cachedPayOrderRepository) Restore(orderId string) (err error) {
+	err = r.repo.Restore(orderId)
+	if err != nil {
+		return err
+	}
+	r.invalidate(r.key(orderId))
+	return nil
+}
+
+// GetDeleted 已删除数据只在管理后台查询，不使用缓存
+// GetHistory 归档数据读取较少，不使用缓存
+func (r cachedPayOrderRepository) GetHistory(orderId string) (model PayOrderModel, exists bool, err error) {
+	return r.repo.GetHistory(orderId)
+}
+
+func (r cachedPayOrderRepository) GetDeleted(orderId string) (model PayOrderModel, exists bool, err error) {
+	return r.repo.GetDeleted(orderId)
+}
+
+func (r cachedPayOrderRepository) CanAsErr(state string, event string) (err error) {
+	return r.repo.CanAsErr(state, event)
+}
+
+func (r cachedPayOrderRepository) Transform(event string, srcState string, orderId string, extraFs ...*sqlbuilder.Field) (err error) {
+	err = r.repo.Transform(event, srcState, orderId, extraFs...)
+	if err != nil {
+		return err
+	}
+	r.invalidate(r.key(orderId))
+	return nil
+}
+
+func (r cachedPayOrderRepository) TransformByIdentity(event string, orderId string, extraFs ...*sqlbuilder.Field) (err error) {
+	err = r.repo.TransformByIdentity(event, orderId, extraFs...)
+	if err != nil {
+		return err
+	}
+	r.invalidate(r.key(orderId))
+	return nil
+}
+
+type cachedPayRecordRepository struct {
+	cacheLayer
+	merchantId string
+	cipher     *encryption.Cipher
+	repo       PayRecordRepository
+}
+
+// NewCachedPayRecordRepository 按支付流水号、订单号查询支付记录时先读缓存，写入、状态变更后删除缓存，metrics 可为空。
+// 敏感字段在缓存中按 WithCipher 设置的密钥加密存储
+func NewCachedPayRecordRepository(cache Cache, ttl time.Duration, metrics CacheMetrics, repo PayRecordRepository) PayRecordRepository {
+	return cachedPayRecordRepository{
+		cacheLayer: cacheLayer{cache: cache, ttl: ttl, metrics: metrics},
+		repo:       repo,
+	}
+}
+
+func (r cachedPayRecordRepository) key(payId string) string {
+	return cacheKey(CacheName_pay_record, r.merchantId, payId)
+}
+
+func (r cachedPayRecordRepository) orderKey(orderId string) string {
+	return cacheKey(CacheName_pay_record_order, r.merchantId, orderId)
+}
+
+// getModels 读取缓存并解密敏感字段
+func (r cachedPayRecordRepository) getModels(name string, key string) (models PayRecordModels, hit bool) {
+	if !r.get(name, key, &models) {
+		return nil, false
+	}
+	if models.decrypt(r.cipher) != nil {
+		return nil, false
+	}
+	return models, true
+}
+
+// setModels 加密敏感字段后写入缓存
+func (r cachedPayRecordRepository) setModels(key string, models PayRecordModels) {
+	models = append(PayRecordModels{}, models...)
+	if models.encrypt(r.cipher) != nil {
+		return
+	}
+	r.set(key, models)
+}
+
+func (r cachedPayRecordRepository) WithTxHandler(txHandler sqlbuilder.Handler) PayRecordRepository {
+	r.cacheLayer, txHandler = r.withTxHandler(txHandler)
+	r.repo = r.repo.WithTxHandler(txHandler)
+	return r
+}
+
+func (r cachedPayRecordRepository) WithMerchantId(merchantId string) PayRecordRepository {
+	r.merchantId = merchantId
+	r.repo = r.repo.WithMerchantId(merchantId)
+	return r
+}
+
+func (r cachedPayRecordRepository) WithCipher(cipher *encryption.Cipher) PayRecordRepository {
+	r.cipher = cipher
+	r.repo = r.repo.WithCipher(cipher)
+	return r
+}
+
+func (r cachedPayRecordRepository) Create(in PayRecordCreateIn) (err error) {
+	err = r.repo.Create(in)
+	if err != nil {
+		return err
+	}
+	r.invalidate(r.key(in.PayId), r.orderKey(in.OrderId))
+	return nil
+}
+
+// GetByPayId 只缓存已存在的支付记录
+func (r cachedPayRecordRepository) GetByPayId(payId string) (model PayRecordModel, exists bool, err error) {
+	key := r.key(payId)
+	if models, hit := r.getModels(CacheName_pay_record, key); hit && len(models) == 1 {
+		return models[0], true, nil
+	}
+	model, exists, err = r.repo.GetByPayId(payId)
+	if err != nil || !exists {
+		return model, exists, err
+	}
+	r.setModels(key, PayRecordModels{model})
+	return model, true, nil
+}
+
+func (r cachedPayRecordRepository) GetByPayIdMust(payId string) (model PayRecordModel, err error) {
+	model, exists, err := r.GetByPayId(payId)
+	if err != nil {
+		return model, err
+	}
+	if !exists {
+		err = sqlbuilder.ErrNotFound
+		return model, err
+	}
+	return model, nil
+}
+
+func (r cachedPayRecordRepository) GetByOrderId(orderId string) (models PayRecordModels, err error) {
+	key := r.orderKey(orderId)
+	if models, hit := r.getModels(CacheName_pay_record_order, key); hit {
+		return models, nil
+	}
+	models, err = r.repo.GetByOrderId(orderId)
+	if err != nil {
+		return models, err
+	}
+	r.setModels(key, models)
+	return models, nil
+}
+
+// GetHistoryByPayId 归档数据读取较少，不使用缓存
+func (r cachedPayRecordRepository) GetHistoryByPayId(payId string) (model PayRecordModel, exists bool, err error) {
+	return r.repo.GetHistoryByPayId(payId)
+}
+
+func (r cachedPayRecordRepository) GetHistoryByOrderId(orderId string) (models PayRecordModels, err error) {
+	return r.repo.GetHistoryByOrderId(orderId)
+}
+
+// DeleteByOrderId 删除后按订单号查不到支付记录，删除前读取支付流水号用于删除缓存
+func (r cachedPayRecordRepository) DeleteByOrderId(orderId string, reason string) (err error) {
+	models, err := r.repo.GetByOrderId(orderId)
+	if err != nil {
+		return err
+	}
+	err = r.repo.DeleteByOrderId(orderId, reason)
+	if err != nil {
+		return err
+	}
+	r.invalidateOrder(orderId, models)
+	return nil
+}
+
+func (r cachedPayRecordRepository) RestoreByOrderId(orderId string) (err error) {
+	err = r.repo.RestoreByOrderId(orderId)
+	if err != nil {
+		return err
+	}
+	models, err := r.repo.GetByOrderId(orderId)
+	if err != nil {
+		return err
+	}
+	r.invalidateOrder(orderId, models)
+	return nil
+}
+
+func (r cachedPayRecordRepository) GetDeletedByOrderId(orderId string) (models PayRecordModels, err error) {
+	return r.repo.GetDeletedByOrderId(orderId)
+}
+
+func (r cachedPayRecordRepository) GetByProviderTradeNo(providerTradeNo string) (models PayRecordModels, err error) {
+	return r.repo.GetByProviderTradeNo(providerTradeNo)
+}
+
+func (r cachedPayRecordRepository) GetByPaymentAccount(paymentAccount string) (models PayRecordModels, err error) {
+	return r.repo.GetByPaymentAccount(paymentAccount)
+}
+
+func (r cachedPayRecordRepository) GetAllPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModels PayRecordModels, err error) {
+	return r.repo.GetAllPayRecordByConditon(whereFs)
+}
+
+func (r cachedPayRecordRepository) GetFirstPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModel PayRecordModel, err error) {
+	return r.repo.GetFirstPayRecordByConditon(whereFs)
+}
+
+func (r cachedPayRecordRepository) CountPayRecordByConditon(whereFs sqlbuilder.Fields) (count int64, err error) {
+	return r.repo.CountPayRecordByConditon(whereFs)
+}
+
+func (r cachedPayRecordRepository) GetUserAmountSince(userId string, since time.Time) (amount int, err error) {
+	return r.repo.GetUserAmountSince(userId, since)
+}
+
+func (r cachedPayRecordRepository) GetPendingStats() (stats PendingStats, err error) {
+	return r.repo.GetPendingStats()
+}
+
+// UpdateOrderAmount 订单下支付记录的订单金额均已变更，逐条删除缓存
+func (r cachedPayRecordRepository) UpdateOrderAmount(orderId string, orderAmount int) (err error) {
+	err = r.repo.UpdateOrderAmount(orderId, orderAmount)
+	if err != nil {
+		return err
+	}
+	models, err := r.repo.GetByOrderId(orderId)
+	if err != nil {
+		return err
+	}
+	r.invalidateOrder(orderId, models)
+	return nil
+}
+
+func (r cachedPayRecordRepository) SetRefundAmount(payId string, refundAmount int) (err error) {
+	err = r.repo.SetRefundAmount(payId, refundAmount)
+	if err != nil {
+		return err
+	}
+	return r.invalidateRecord(payId)
+}
+
+// invalidateOrder 删除订单下支付记录及订单维度的缓存
+func (r cachedPayRecordRepository) invalidateOrder(orderId string, models PayRecordModels) {
+	keys := []string{r.orderKey(orderId)}
+	for _, model := range models {
+		keys = append(keys, r.key(model.PayId))
+	}
+	r.invalidate(keys...)
+}
+
+func (r cachedPayRecordRepository) CanAsErr(state string, event string) (err error) {
+	return r.repo.CanAsErr(state, event)
+}
+
+func (r cachedPayRecordRepository) Transform(event string, srcState string, payId string, extraFs ...*sqlbuilder.Field) (err error) {
+	err = r.repo.Transform(event, srcState, payId, extraFs...)
+	if err != nil {
+		return err
+	}
+	return r.invalidateRecord(payId)
+}
+
+func (r cachedPayRecordRepository) TransformByIdentity(event string, payId string, extraFs ...*sqlbuilder.Field) (err error) {
+	err = r.repo.TransformByIdentity(event, payId, extraFs...)
+	if err != nil {
+		return err
+	}
+	return r.invalidateRecord(payId)
+}
+
+// invalidateRecord 状态变更只知道支付流水号，订单号(不会变更)从缓存或数据库获取
+func (r cachedPayRecordRepository) invalidateRecord(payId string) (err error) {
+	model, exists, err := r.GetByPayId(payId)
+	if err != nil {
+		return err
+	}
+	keys := []string{r.key(payId)}
+	if exists {
+		keys = append(keys, r.orderKey(model.OrderId))
+	}
+	r.invalidate(keys...)
+	return nil
+}
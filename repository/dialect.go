@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"time"
+
+	_ "github.com/doug-martin/goqu/v9/dialect/postgres" // sqlbuilder 按 handler 的驱动名选择 goqu 方言生成语句，postgres 方言需额外注册
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// Driver_postgres sqlbuilder 未内置 postgres 驱动，名称与 gorm postgres 驱动保持一致
+const Driver_postgres sqlbuilder.Driver = "postgres"
+
+// ParseDateTime 解析时间字段。mysql、sqlite 返回 time.DateTime 格式，postgres 的 timestamp 字段读取到字符串时为 RFC3339 格式，
+// 写入时均为本地时间的字面值，因此统一按本地时间解析
+func ParseDateTime(value string) (t time.Time, err error) {
+	t, err = time.ParseInLocation(time.DateTime, value, time.Local)
+	if err == nil {
+		return t, nil
+	}
+	t, err = time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return t, err
+	}
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+	return t, nil
+}
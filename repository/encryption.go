@@ -0,0 +1,278 @@
+package repository
+
+import (
+	"context"
+	"strings"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"github.com/suifengpiao14/paymentrecord/encryption"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// Length_encrypted 加密字段的列长度，密文包含加密的数据密钥，64字节明文加密后约212字节(不含主密钥ID)
+const Length_encrypted = 255
+
+// encryptValueFn 写入数据库前加密，只作用于 insert、update 的数据部分，字段校验仍按明文执行；cipher 为 nil 时明文存储
+func encryptValueFn(cipher *encryption.Cipher) sqlbuilder.ValueFn {
+	return sqlbuilder.ValueFnOnlyForData(func(inputValue any, f *sqlbuilder.Field, fs ...*sqlbuilder.Field) (any, error) {
+		if cipher == nil {
+			return inputValue, nil
+		}
+		value, err := cipher.Encrypt(cast.ToString(inputValue))
+		if err != nil {
+			return nil, err
+		}
+		if len(value) > Length_encrypted {
+			err = errors.Errorf("字段%s加密后长度%d超过%d", f.Name, len(value), Length_encrypted)
+			return nil, err
+		}
+		return value, nil
+	})
+}
+
+// blindIndexValueFn 写入明文的盲索引，未启用加密时为空
+func blindIndexValueFn(cipher *encryption.Cipher) sqlbuilder.ValueFn {
+	return sqlbuilder.ValueFnOnlyForData(func(inputValue any, f *sqlbuilder.Field, fs ...*sqlbuilder.Field) (any, error) {
+		if cipher == nil {
+			return "", nil
+		}
+		return cipher.BlindIndex(cast.ToString(inputValue))
+	})
+}
+
+// encryptedColumn 加密字段
+type encryptedColumn[M any] struct {
+	dbName string
+	value  func(m *M) *string
+}
+
+var payRecordEncryptedColumns = []encryptedColumn[PayRecordModel]{
+	{dbName: "Frecipient_account", value: func(m *PayRecordModel) *string { return &m.RecipientAccount }},
+	{dbName: "Frecipient_name", value: func(m *PayRecordModel) *string { return &m.RecipientName }},
+	{dbName: "Fpayment_account", value: func(m *PayRecordModel) *string { return &m.PaymentAccount }},
+	{dbName: "Fpayment_name", value: func(m *PayRecordModel) *string { return &m.PaymentName }},
+	{dbName: "Fclient_ip", value: func(m *PayRecordModel) *string { return &m.ClientIp }},
+}
+
+var paySplitEncryptedColumns = []encryptedColumn[PaySplitModel]{
+	{dbName: "Frecipient_account", value: func(m *PaySplitModel) *string { return &m.RecipientAccount }},
+	{dbName: "Frecipient_name", value: func(m *PaySplitModel) *string { return &m.RecipientName }},
+}
+
+// decryptColumns 解密敏感字段，cipher 为 nil 时密文原样保留；读取时兼容明文
+func decryptColumns[M any](cipher *encryption.Cipher, m *M, cols []encryptedColumn[M]) (err error) {
+	if cipher == nil {
+		return nil
+	}
+	for _, col := range cols {
+		value := col.value(m)
+		*value, err = cipher.Decrypt(*value)
+		if err != nil {
+			return errors.WithMessagef(err, "字段-%s", col.dbName)
+		}
+	}
+	return nil
+}
+
+// encryptColumns 加密敏感字段，用于缓存等数据库以外的存储，cipher 为 nil 时不处理
+func encryptColumns[M any](cipher *encryption.Cipher, m *M, cols []encryptedColumn[M]) (err error) {
+	if cipher == nil {
+		return nil
+	}
+	for _, col := range cols {
+		value := col.value(m)
+		*value, err = cipher.Encrypt(*value)
+		if err != nil {
+			return errors.WithMessagef(err, "字段-%s", col.dbName)
+		}
+	}
+	return nil
+}
+
+func (m *PayRecordModel) decrypt(cipher *encryption.Cipher) (err error) {
+	err = decryptColumns(cipher, m, payRecordEncryptedColumns)
+	if err != nil {
+		return errors.WithMessagef(err, "支付流水号-%s", m.PayId)
+	}
+	return nil
+}
+
+func (m *PayRecordModel) encrypt(cipher *encryption.Cipher) (err error) {
+	err = encryptColumns(cipher, m, payRecordEncryptedColumns)
+	if err != nil {
+		return errors.WithMessagef(err, "支付流水号-%s", m.PayId)
+	}
+	return nil
+}
+
+func (ms PayRecordModels) encrypt(cipher *encryption.Cipher) (err error) {
+	for i := range ms {
+		err = ms[i].encrypt(cipher)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms PayRecordModels) decrypt(cipher *encryption.Cipher) (err error) {
+	for i := range ms {
+		err = ms[i].decrypt(cipher)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *PaySplitModel) decrypt(cipher *encryption.Cipher) (err error) {
+	err = decryptColumns(cipher, m, paySplitEncryptedColumns)
+	if err != nil {
+		return errors.WithMessagef(err, "分账流水号-%s", m.SplitId)
+	}
+	return nil
+}
+
+func (ms PaySplitModels) decrypt(cipher *encryption.Cipher) (err error) {
+	for i := range ms {
+		err = ms[i].decrypt(cipher)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptedTable 敏感字段加密的表，用于密钥轮换
+type encryptedTable[M any] struct {
+	table            sqlbuilder.TableConfig
+	columns          []encryptedColumn[M]
+	blindIndex       encryptedColumn[M] // 盲索引字段
+	blindIndexSource string             // 盲索引对应的加密字段
+	id               func(m *M) int64
+}
+
+// RotatePayRecordKeys 密钥轮换任务：将旧主密钥加密的密文及存量明文按 cipher 的当前主密钥重新加密，并重建付款人账号盲索引，返回更新的记录数。
+// 按主键分批执行，每批在事务内锁定后更新，不会覆盖并发写入；可重复执行，已是当前主密钥加密的记录跳过
+func RotatePayRecordKeys(handler sqlbuilder.Handler, cipher *encryption.Cipher, batchSize int) (rotated int, err error) {
+	return rotateKeys(handler, cipher, batchSize, encryptedTable[PayRecordModel]{
+		table:            table_pay_record.WithHandler(handler),
+		columns:          payRecordEncryptedColumns,
+		blindIndex:       encryptedColumn[PayRecordModel]{dbName: "Fpayment_account_bidx", value: func(m *PayRecordModel) *string { return &m.PaymentAccountBlindIndex }},
+		blindIndexSource: "Fpayment_account",
+		id:               func(m *PayRecordModel) int64 { return m.Id },
+	})
+}
+
+// RotatePaySplitKeys 同 RotatePayRecordKeys，处理分账的收款人账号、名称，并重建收款人账号盲索引
+func RotatePaySplitKeys(handler sqlbuilder.Handler, cipher *encryption.Cipher, batchSize int) (rotated int, err error) {
+	return rotateKeys(handler, cipher, batchSize, encryptedTable[PaySplitModel]{
+		table:            table_pay_split.WithHandler(handler),
+		columns:          paySplitEncryptedColumns,
+		blindIndex:       encryptedColumn[PaySplitModel]{dbName: "Frecipient_account_bidx", value: func(m *PaySplitModel) *string { return &m.RecipientAccountBlindIndex }},
+		blindIndexSource: "Frecipient_account",
+		id:               func(m *PaySplitModel) int64 { return m.Id },
+	})
+}
+
+func rotateKeys[M any](handler sqlbuilder.Handler, cipher *encryption.Cipher, batchSize int, t encryptedTable[M]) (rotated int, err error) {
+	if cipher == nil {
+		err = errors.New("未设置敏感字段加密")
+		return 0, err
+	}
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	driver := sqlbuilder.Driver(handler.GetDialector())
+	dialect := driver.GoquDialect()
+	dbNameId := t.table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))
+	cols := []any{dbNameId, t.blindIndex.dbName}
+	for _, col := range t.columns {
+		cols = append(cols, col.dbName)
+	}
+	var lastId int64
+	for {
+		var models []M
+		batchRotated := 0
+		err = handler.Transaction(func(tx sqlbuilder.Handler) (err error) {
+			ds := dialect.From(t.table.DBName.Name).Select(cols...).
+				Where(goqu.C(dbNameId).Gt(lastId)).Order(goqu.C(dbNameId).Asc()).Limit(uint(batchSize))
+			if !strings.HasPrefix(driver.String(), "sqlite") { // sqlite 不支持行锁，由 immediate 事务串行
+				ds = ds.ForUpdate(exp.Wait)
+			}
+			sql, _, err := ds.ToSQL()
+			if err != nil {
+				return err
+			}
+			err = tx.Query(context.Background(), sql, &models)
+			if err != nil {
+				return err
+			}
+			for i := range models {
+				record, err := rotateModel(cipher, t, &models[i])
+				if err != nil {
+					return errors.WithMessagef(err, "记录ID-%d", t.id(&models[i]))
+				}
+				if len(record) == 0 {
+					continue
+				}
+				sql, _, err := dialect.Update(t.table.DBName.Name).Set(record).Where(goqu.C(dbNameId).Eq(t.id(&models[i]))).ToSQL()
+				if err != nil {
+					return err
+				}
+				err = tx.Exec(sql)
+				if err != nil {
+					return err
+				}
+				batchRotated++
+			}
+			return nil
+		})
+		if err != nil {
+			return rotated, err
+		}
+		rotated += batchRotated
+		if len(models) < batchSize {
+			return rotated, nil
+		}
+		lastId = t.id(&models[len(models)-1])
+	}
+}
+
+// rotateModel 计算需要更新的字段
+func rotateModel[M any](cipher *encryption.Cipher, t encryptedTable[M], model *M) (record goqu.Record, err error) {
+	record = goqu.Record{}
+	var blindIndexSource string
+	for _, col := range t.columns {
+		value := *col.value(model)
+		plaintext, err := cipher.Decrypt(value)
+		if err != nil {
+			return nil, err
+		}
+		if col.dbName == t.blindIndexSource {
+			blindIndexSource = plaintext
+		}
+		needRotate, err := cipher.NeedRotate(value)
+		if err != nil {
+			return nil, err
+		}
+		if !needRotate {
+			continue
+		}
+		record[col.dbName], err = cipher.Encrypt(plaintext)
+		if err != nil {
+			return nil, err
+		}
+	}
+	blindIndex, err := cipher.BlindIndex(blindIndexSource)
+	if err != nil {
+		return nil, err
+	}
+	if blindIndex != *t.blindIndex.value(model) {
+		record[t.blindIndex.dbName] = blindIndex
+	}
+	return record, nil
+}
@@ -0,0 +1,281 @@
+package repository
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"github.com/suifengpiao14/paymentrecord/encryption"
+	"github.com/suifengpiao14/sqlbuilder"
+	"github.com/xuri/excelize/v2"
+)
+
+// 导出文件格式
+const (
+	ExportFormat_csv  = "csv"
+	ExportFormat_xlsx = "xlsx"
+)
+
+type ExportIn struct {
+	MerchantId string    `json:"merchantId"` // 为空时导出全部商户
+	StartAt    time.Time `json:"startAt"`    // 按支付记录创建时间筛选，包含，为空不限
+	EndAt      time.Time `json:"endAt"`      // 不包含，为空不限
+	States     []string  `json:"states"`     // 为空不限
+	PayAgents  []string  `json:"payAgents"`  // 为空不限
+	// Columns 导出的字段名(如 payNo、state，见 field.go)，按顺序输出，为空时导出全部字段(盲索引除外)
+	Columns   []string `json:"columns"`
+	Format    string   `json:"format"`    // 取值见 ExportFormat_xxx，默认 csv
+	BatchSize int      `json:"batchSize"` // 每批读取的记录数，默认500
+}
+
+// exportExcludedColumns 默认不导出的字段
+var exportExcludedColumns = []string{"Fpayment_account_bidx"}
+
+// ExportPayRecords 导出支付记录，按主键分批读取并逐行写入 w，内存占用与总行数无关，返回导出的行数。
+// 表头为字段标题，枚举字段输出枚举标题(如"已支付"、"微信")，加密字段按 cipher 解密后输出，已删除的支付记录不导出
+func ExportPayRecords(handler sqlbuilder.Handler, cipher *encryption.Cipher, in ExportIn, w io.Writer) (exported int, err error) {
+	batchSize := in.BatchSize
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	table := table_pay_record.WithHandler(handler)
+	cols, err := exportColumns(table, in.Columns)
+	if err != nil {
+		return 0, err
+	}
+	writer, err := newExportWriter(in.Format, w)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		closeErr := writer.Close() // 出错提前返回时也释放临时文件
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	headers := make([]any, 0, len(cols))
+	for _, col := range cols {
+		headers = append(headers, exportTitle(col))
+	}
+	err = writer.Write(headers)
+	if err != nil {
+		return 0, err
+	}
+
+	col := func(fieldName string) exp.IdentifierExpression {
+		return goqu.C(table.GetDBNameByFieldNameMust(fieldName))
+	}
+	colId, colCreatedAt := col(sqlbuilder.GetFieldName(NewId)), col(sqlbuilder.GetFieldName(NewCreatedAt))
+	where := []exp.Expression{notDeletedExpression(col(sqlbuilder.GetFieldName(NewDeletedAt)))}
+	if in.MerchantId != "" {
+		where = append(where, col(sqlbuilder.GetFieldName(NewMerchantId)).Eq(in.MerchantId))
+	}
+	if !in.StartAt.IsZero() {
+		where = append(where, colCreatedAt.Gte(in.StartAt.Format(time.DateTime)))
+	}
+	if !in.EndAt.IsZero() {
+		where = append(where, colCreatedAt.Lt(in.EndAt.Format(time.DateTime)))
+	}
+	if len(in.States) > 0 {
+		where = append(where, col(sqlbuilder.GetFieldName(NewState)).In(in.States))
+	}
+	if len(in.PayAgents) > 0 {
+		where = append(where, col(sqlbuilder.GetFieldName(NewPayAgent)).In(in.PayAgents))
+	}
+	dialect := sqlbuilder.Driver(handler.GetDialector()).GoquDialect()
+	index := modelColumnIndex(reflect.TypeOf(PayRecordModel{}))
+	var lastId int64
+	for {
+		sql, _, err := dialect.From(table.DBName.Name).Where(append(where, colId.Gt(lastId))...).
+			Order(colId.Asc()).Limit(uint(batchSize)).ToSQL()
+		if err != nil {
+			return exported, err
+		}
+		var models PayRecordModels
+		err = handler.Query(context.Background(), sql, &models)
+		if err != nil {
+			return exported, err
+		}
+		err = models.decrypt(cipher)
+		if err != nil {
+			return exported, err
+		}
+		for _, model := range models {
+			rv := reflect.ValueOf(model)
+			row := make([]any, 0, len(cols))
+			for _, col := range cols {
+				row = append(row, escapeFormula(exportValue(col, rv.Field(index[col.DbName]).Interface())))
+			}
+			err = writer.Write(row)
+			if err != nil {
+				return exported, err
+			}
+			exported++
+		}
+		if len(models) < batchSize {
+			break
+		}
+		lastId = models[len(models)-1].Id
+	}
+	err = writer.Flush()
+	if err != nil {
+		return exported, err
+	}
+	return exported, nil
+}
+
+// exportColumns 按字段名选择导出列，字段别名对应同一列，只取一次
+func exportColumns(table sqlbuilder.TableConfig, fieldNames []string) (cols sqlbuilder.ColumnConfigs, err error) {
+	index := modelColumnIndex(reflect.TypeOf(PayRecordModel{}))
+	if len(fieldNames) == 0 {
+		for _, dbName := range tableDbNames(table) {
+			if _, ok := index[dbName]; !ok || slices.Contains(exportExcludedColumns, dbName) {
+				continue
+			}
+			cols = append(cols, table.Columns.GetByDbNameMust(dbName))
+		}
+		return cols, nil
+	}
+	for _, fieldName := range fieldNames {
+		col, ok := table.Columns.GetByFieldName(fieldName)
+		if !ok {
+			err = errors.Errorf("导出字段不存在:%s", fieldName)
+			return nil, err
+		}
+		if _, ok := index[col.DbName]; !ok {
+			err = errors.Errorf("导出字段不存在:%s", fieldName)
+			return nil, err
+		}
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// exportTitle 表头取字段标题，未设置标题时取字段注释
+func exportTitle(col sqlbuilder.ColumnConfig) string {
+	f := col.GetField()
+	if f.Schema != nil && f.Schema.Title != "" {
+		return f.Schema.Title
+	}
+	if col.Comment != "" {
+		return col.Comment
+	}
+	return col.FieldName
+}
+
+// exportValue 枚举字段输出枚举标题，未定义的枚举值原样输出
+func exportValue(col sqlbuilder.ColumnConfig, value any) any {
+	if len(col.Enums) == 0 {
+		return value
+	}
+	if title := col.Enums.Title(cast.ToString(value)); title != "" {
+		return title
+	}
+	return value
+}
+
+// escapeFormula 以 = + - @ 开头的文本在 Excel 中会被当作公式执行，前置单引号按文本输出
+func escapeFormula(value any) any {
+	str, ok := value.(string)
+	if !ok || str == "" {
+		return value
+	}
+	switch str[0] {
+	case '=', '+', '-', '@':
+		return "'" + str
+	}
+	return value
+}
+
+type exportWriter interface {
+	Write(row []any) error
+	Flush() error // 输出全部内容
+	Close() error // 释放资源，可重复调用
+}
+
+func newExportWriter(format string, w io.Writer) (writer exportWriter, err error) {
+	switch format {
+	case "", ExportFormat_csv:
+		return newCsvExportWriter(w)
+	case ExportFormat_xlsx:
+		return newXlsxExportWriter(w)
+	}
+	err = errors.Errorf("不支持的导出格式:%s", format)
+	return nil, err
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+// newCsvExportWriter 写入 UTF-8 BOM，Excel 打开时中文不乱码
+func newCsvExportWriter(w io.Writer) (writer *csvExportWriter, err error) {
+	_, err = w.Write([]byte("\xEF\xBB\xBF"))
+	if err != nil {
+		return nil, err
+	}
+	return &csvExportWriter{w: csv.NewWriter(w)}, nil
+}
+
+func (writer *csvExportWriter) Write(row []any) error {
+	return writer.w.Write(cast.ToStringSlice(row))
+}
+
+func (writer *csvExportWriter) Flush() error {
+	writer.w.Flush()
+	return writer.w.Error()
+}
+
+func (writer *csvExportWriter) Close() error {
+	return nil
+}
+
+// xlsxExportWriter 使用流式写入，超出内存阈值的行暂存临时文件
+type xlsxExportWriter struct {
+	w      io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	rowNum int
+}
+
+const xlsxSheetName = "Sheet1"
+
+func newXlsxExportWriter(w io.Writer) (writer *xlsxExportWriter, err error) {
+	file := excelize.NewFile()
+	stream, err := file.NewStreamWriter(xlsxSheetName)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &xlsxExportWriter{w: w, file: file, stream: stream}, nil
+}
+
+func (writer *xlsxExportWriter) Write(row []any) error {
+	writer.rowNum++
+	cell, err := excelize.CoordinatesToCellName(1, writer.rowNum)
+	if err != nil {
+		return err
+	}
+	return writer.stream.SetRow(cell, row)
+}
+
+func (writer *xlsxExportWriter) Flush() (err error) {
+	err = writer.stream.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = writer.file.WriteTo(writer.w)
+	return err
+}
+
+// Close 删除流式写入产生的临时文件
+func (writer *xlsxExportWriter) Close() error {
+	return writer.file.Close()
+}
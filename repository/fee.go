@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"github.com/pkg/errors"
+)
+
+const FeeRate_base = 10000 // 手续费费率基数，万分比
+
+// FeeTier 阶梯费率，支付金额不超过 UpTo 时适用
+type FeeTier struct {
+	UpTo  int `json:"upTo" yaml:"upTo"` // 阶梯上限(含)，单位分，0 表示不设上限，须放在最后
+	Rate  int `json:"rate" yaml:"rate"` // 万分比，见 FeeSchedule.Rate
+	Fixed int `json:"fixed" yaml:"fixed"`
+}
+
+// FeeSchedule 支付渠道手续费规则，手续费 = 支付金额 × 费率 + 固定费用(四舍五入到分)，配置阶梯时按支付金额所在阶梯取费率及固定费用，
+// 结果限制在 [Min, Max] 内且不超过支付金额
+type FeeSchedule struct {
+	Rate  int       `json:"rate" yaml:"rate"`   // 费率，万分比，如 60 表示 0.6%
+	Fixed int       `json:"fixed" yaml:"fixed"` // 每笔固定费用，单位分
+	Tiers []FeeTier `json:"tiers" yaml:"tiers"` // 阶梯按 UpTo 升序排列，配置后忽略 Rate、Fixed
+	Min   int       `json:"min" yaml:"min"`     // 单笔最低手续费，单位分，0 不限
+	Max   int       `json:"max" yaml:"max"`     // 单笔最高手续费，单位分，0 不限
+}
+
+func (schedule FeeSchedule) validate() (err error) {
+	if schedule.Rate < 0 || schedule.Fixed < 0 || schedule.Min < 0 || schedule.Max < 0 {
+		err = errors.New("手续费费率、固定费用及上下限不能为负数")
+		return err
+	}
+	if schedule.Rate > FeeRate_base {
+		err = errors.Errorf("手续费费率不能超过%d", FeeRate_base)
+		return err
+	}
+	if schedule.Max > 0 && schedule.Max < schedule.Min {
+		err = errors.New("最高手续费不能小于最低手续费")
+		return err
+	}
+	for i, tier := range schedule.Tiers {
+		if tier.Rate < 0 || tier.Fixed < 0 {
+			err = errors.Errorf("第%d档手续费费率、固定费用不能为负数", i+1)
+			return err
+		}
+		if tier.Rate > FeeRate_base {
+			err = errors.Errorf("第%d档手续费费率不能超过%d", i+1, FeeRate_base)
+			return err
+		}
+		last := i == len(schedule.Tiers)-1
+		if tier.UpTo == 0 && !last {
+			err = errors.Errorf("第%d档未设上限，须为最后一档", i+1)
+			return err
+		}
+		if i > 0 && tier.UpTo != 0 && tier.UpTo <= schedule.Tiers[i-1].UpTo {
+			err = errors.Errorf("第%d档上限须大于上一档", i+1)
+			return err
+		}
+	}
+	return nil
+}
+
+// Compute 按支付金额计算手续费，单位分
+func (schedule FeeSchedule) Compute(payAmount int) (fee int) {
+	rate, fixed := schedule.Rate, schedule.Fixed
+	if len(schedule.Tiers) > 0 {
+		tier := schedule.Tiers[len(schedule.Tiers)-1] // 超过所有阶梯上限时取最后一档
+		for _, t := range schedule.Tiers {
+			if t.UpTo == 0 || payAmount <= t.UpTo {
+				tier = t
+				break
+			}
+		}
+		rate, fixed = tier.Rate, tier.Fixed
+	}
+	fee = (payAmount*rate+FeeRate_base/2)/FeeRate_base + fixed // 四舍五入到分
+	if fee < schedule.Min {
+		fee = schedule.Min
+	}
+	if schedule.Max > 0 && fee > schedule.Max {
+		fee = schedule.Max
+	}
+	return min(fee, payAmount)
+}
@@ -1,36 +1,13 @@
 package repository
 
 import (
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/pkg/errors"
 	"github.com/suifengpiao14/commonlanguage"
 	"github.com/suifengpiao14/sqlbuilder"
 )
 
-/*
-CREATE TABLE `t_payment_record` (
-  `Fid` int(10) unsigned NOT NULL AUTO_INCREMENT COMMENT '主键',
-    `Fpay_id` varchar(50) NOT NULL DEFAULT '' COMMENT '支付流水号',
-  `Forder_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '订单Id',
-  `Ftotal_amount` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '订单的总金额',
-  `Fpay_amount` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '支付金额',
-  `Fpay_agent` varchar(20)  NOT NULL DEFAULT '' COMMENT '支付机构 weixin:微信 alipay:支付宝',
-  `Fstate` varchar(15) unsigned NOT NULL DEFAULT '1' COMMENT '支付状态 pending-未支付 paid-已支付,expired-已过期,failed-支付失败,closed-已关闭',
-  `Fuser_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '用户ID',
- `Fclient_ip` varchar(255) NOT NULL DEFAULT '' COMMENT 'ip地址',
- `Fpay_url` varchar(255) NOT NULL DEFAULT '' COMMENT '支付链接',
- `Freturn_url` varchar(255) NOT NULL DEFAULT '' COMMENT '支付完成后的回调地址',
- `Fnotify_url` varchar(255) NOT NULL DEFAULT '' COMMENT '支付完成后的通知地址',
- `Fpay_param` varchar(255) NOT NULL DEFAULT '' COMMENT '支付参数',
-  `Fpay_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '支付成功时间',
-  `Fclosed_at`datetime NOT NULL DEFAULT '' COMMENT '关闭时间',
-  `Fcreated_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '发起支付时间',
-  PRIMARY KEY (`Fid`),
-  KEY `key_order` (`Forder_id`),
-  KEY `key_user` (`Fuser_id`),
-  KEY `key_pay_agent` (`Fpay_agent`),
-  KEY `key_state` (`Fstate`)
-) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='支付记录';
-*/
-
 func NewId(id int) *sqlbuilder.Field {
 	return commonlanguage.NewId(id).SetMaximum(sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_autoIncrement)
 }
@@ -46,25 +23,58 @@ func NewPayAmount(payAmount int) *sqlbuilder.Field {
 	return sqlbuilder.NewIntField(payAmount, "paymentAmount", "支付金额，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
 }
 
+// NewCurrency 支付金额的币种，如 CNY、HKD；存量数据为空，视为 CNY
+func NewCurrency(currency string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(currency, "currency", "币种", 8)
+}
+
 const (
 	PayingAgent_Wechat = "weixin"
 	PayingAgent_Alipay = "alipay"
 	PayingAgent_Coupon = "coupon"
 )
 
+// NewPayAgent 枚举取自默认支付方式注册表 PayAgents，用于输出支付方式标题
 func NewPayAgent(payAgent string) *sqlbuilder.Field {
-	return sqlbuilder.NewStringField(payAgent, "payAgent", "支付类型", 0).AppendEnum(
+	return sqlbuilder.NewStringField(payAgent, "payAgent", "支付类型", 0).AppendEnum(PayAgents.Enums()...)
+}
+
+// newPayAgentUnchecked 写入时不按默认注册表的枚举校验，支付方式已由服务使用的注册表校验(见 PayRecordService.SetPayAgents)
+func newPayAgentUnchecked(payAgent string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(payAgent, "payAgent", "支付类型", 0)
+}
+
+const (
+	OrderType_fixed = "fixed" // 固定金额订单
+	OrderType_open  = "open"  // 开放式订单，按人头收费，人数不固定（如活动报名）
+)
+
+func NewOrderType(orderType string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(orderType, "orderType", "订单类型", 0).AppendEnum(
 		sqlbuilder.Enum{
-			Key:   PayingAgent_Wechat,
-			Title: "微信",
+			Key:   OrderType_fixed,
+			Title: "固定金额",
 		},
 		sqlbuilder.Enum{
-			Key:   PayingAgent_Alipay,
-			Title: "支付宝",
+			Key:   OrderType_open,
+			Title: "开放式",
 		},
 	)
 }
 
+func NewUnitPrice(unitPrice int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(unitPrice, "unitPrice", "单价，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewCapacity(capacity int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(capacity, "capacity", "名额上限，0表示不限", 0).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewDeadline(deadline string) *sqlbuilder.Field {
+	f := commonlanguage.NewTime(deadline).SetName("deadline").SetTitle("截止时间")
+	return f
+}
+
 type PayOrderState string
 
 func (s PayOrderState) String() string {
@@ -113,6 +123,58 @@ func NewPayId(payId string) *sqlbuilder.Field {
 	return sqlbuilder.NewStringField(payId, "payNo", "支付流水号", 64)
 }
 
+// NewMerchantId 商户(租户)ID，订单号在商户内唯一，未启用多商户时为空
+func NewMerchantId(merchantId string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(merchantId, "merchantId", "商户ID", 64)
+}
+
+// merchantScope 按商户隔离的查询、更新条件，商户为空时只匹配未指定商户的数据
+func merchantScope(merchantId string) sqlbuilder.Fields {
+	return sqlbuilder.Fields{
+		NewMerchantId(merchantId).AppendWhereFn(sqlbuilder.ValueFnForward).ShieldUpdate(true),
+	}
+}
+
+// deletedAtZero 删除时间早于该值视为未删除：未删除时 mysql 为零值时间，sqlite 为字段默认值，postgres 为 NULL
+const deletedAtZero = "1000-01-01 00:00:00"
+
+func notDeletedExpression(col exp.IdentifierExpression) exp.Expression {
+	return goqu.Or(col.IsNull(), col.Lt(deletedAtZero))
+}
+
+func deletedExpression(col exp.IdentifierExpression) exp.Expression {
+	return col.Gte(deletedAtZero)
+}
+
+// deletedScope 软删除查询条件，只用于 where
+func deletedScope(expressionFn func(col exp.IdentifierExpression) exp.Expression) *sqlbuilder.Field {
+	return NewDeletedAt(deletedAtZero).AppendWhereFn(sqlbuilder.ValueFn{
+		Fn: func(in any, f *sqlbuilder.Field, fs ...*sqlbuilder.Field) (any, error) {
+			return expressionFn(goqu.I(f.DBColumnName().FullName())), nil
+		},
+		Layer: sqlbuilder.Value_Layer_DBFormat,
+	}).ShieldUpdate(true)
+}
+
+// liveScope 商户隔离并排除已软删除的数据，收款单、支付记录的查询、更新默认使用
+func liveScope(merchantId string) sqlbuilder.Fields {
+	return merchantScope(merchantId).Add(deletedScope(notDeletedExpression))
+}
+
+// deletedOnlyScope 只匹配已软删除的数据，用于管理查询及恢复
+func deletedOnlyScope(merchantId string) sqlbuilder.Fields {
+	return merchantScope(merchantId).Add(deletedScope(deletedExpression))
+}
+
+// resolveMerchantId 写入数据的商户，未指定时取仓库所属商户，不允许写入其它商户
+func resolveMerchantId(repoMerchantId string, merchantId string) (resolved string, err error) {
+	if merchantId != "" && merchantId != repoMerchantId {
+		err = errors.Errorf("商户不一致,当前商户-%s,收到商户-%s", repoMerchantId, merchantId)
+		return "", err
+	}
+	return repoMerchantId, nil
+}
+
 func NewUserId(userId string) *sqlbuilder.Field {
 	return sqlbuilder.NewStringField(userId, "userId", "用户ID", 64)
 }
@@ -176,11 +238,174 @@ func NewPaymentName(paymentName string) *sqlbuilder.Field {
 	return sqlbuilder.NewStringField(paymentName, "paymentName", "付款人名称", 64)
 }
 
+// NewPaymentAccountBlindIndex 付款人账号盲索引，账号加密存储后通过盲索引等值查询，值为账号明文，写入时计算
+func NewPaymentAccountBlindIndex(paymentAccount string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(paymentAccount, "paymentAccountBlindIndex", "付款人账号盲索引", 64)
+}
+
+// NewRecipientAccountBlindIndex 分账收款人账号盲索引，同 NewPaymentAccountBlindIndex
+func NewRecipientAccountBlindIndex(recipientAccount string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(recipientAccount, "recipientAccountBlindIndex", "收款人账号盲索引", 64)
+}
+
+func NewSequenceName(name string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(name, "sequenceName", "序列名称", 64)
+}
+
+func NewSequenceValue(value int64) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(value, "sequenceValue", "已分配的最大序号", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewSplitId(splitId string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(splitId, "splitId", "分账流水号", 80)
+}
+
+func NewSplitRole(splitRole string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(splitRole, "splitRole", "分账角色(如卖家、平台服务费、物流)", 32)
+}
+
+func NewSplitRate(splitRate int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(splitRate, "splitRate", "分账比例，万分比，0表示按固定金额分账", 10000).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewSplitAmount(splitAmount int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(splitAmount, "splitAmount", "分账金额，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewRefundAmount(refundAmount int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(refundAmount, "refundAmount", "已退款金额，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+type PaySplitState string
+
+func (s PaySplitState) String() string {
+	return string(s)
+}
+
+const (
+	PaySplitModel_state_pending    PaySplitState = "pending"    //支付未完成，不可结算
+	PaySplitModel_state_settleable PaySplitState = "settleable" //支付完成，待结算
+	PaySplitModel_state_settled    PaySplitState = "settled"    //已结算
+	PaySplitModel_state_refunded   PaySplitState = "refunded"   //已全额退款
+)
+
+func NewSplitState(state string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(state, "state", "分账状态", 0).AppendEnum(
+		sqlbuilder.Enum{
+			Key:   PaySplitModel_state_pending.String(),
+			Title: "未支付",
+		},
+		sqlbuilder.Enum{
+			Key:   PaySplitModel_state_settleable.String(),
+			Title: "待结算",
+		},
+		sqlbuilder.Enum{
+			Key:   PaySplitModel_state_settled.String(),
+			Title: "已结算",
+		},
+		sqlbuilder.Enum{
+			Key:   PaySplitModel_state_refunded.String(),
+			Title: "已退款",
+		},
+	)
+}
+
+func NewSettledAt(settledAt string) *sqlbuilder.Field {
+	f := commonlanguage.NewTime(settledAt).SetName("settledAt").SetTitle("结算时间")
+	return f
+}
+
+func NewOldOrderAmount(oldOrderAmount int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(oldOrderAmount, "oldOrderAmount", "调整前订单金额，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewPaidAmount(paidAmount int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(paidAmount, "paidAmount", "已支付金额，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewPendingAmount(pendingAmount int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(pendingAmount, "pendingAmount", "待支付金额，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewRecordCount(recordCount int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(recordCount, "recordCount", "有效支付记录数", 0).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewRefundDueAmount(refundDueAmount int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(refundDueAmount, "refundDueAmount", "应退款金额，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewClosedPayIds(closedPayIds string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(closedPayIds, "closedPayIds", "调整时关闭的支付流水号，逗号分隔", 0)
+}
+
+func NewProviderTradeNo(providerTradeNo string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(providerTradeNo, "providerTradeNo", "支付渠道交易号", 64)
+}
+
+func NewProviderFee(providerFee int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(providerFee, "providerFee", "支付渠道手续费，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+func NewNetAmount(netAmount int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(netAmount, "netAmount", "扣除手续费后的实收金额，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+const (
+	OverpaymentType_overpaid  = "overpaid"  // 已支付总额超过订单金额
+	OverpaymentType_duplicate = "duplicate" // 不同支付流水号收到相同渠道交易号的回调
+)
+
+func NewOverpaymentType(overpaymentType string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(overpaymentType, "overpaymentType", "超付类型", 0).AppendEnum(
+		sqlbuilder.Enum{
+			Key:   OverpaymentType_overpaid,
+			Title: "超额支付",
+		},
+		sqlbuilder.Enum{
+			Key:   OverpaymentType_duplicate,
+			Title: "重复回调",
+		},
+	)
+}
+
+func NewOverpaidAmount(overpaidAmount int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(overpaidAmount, "overpaidAmount", "超付金额，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+const (
+	OverpaymentState_pending  = "pending"  // 待处理
+	OverpaymentState_refunded = "refunded" // 已退款
+)
+
+func NewOverpaymentState(state string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(state, "state", "处理状态", 0).AppendEnum(
+		sqlbuilder.Enum{
+			Key:   OverpaymentState_pending,
+			Title: "待处理",
+		},
+		sqlbuilder.Enum{
+			Key:   OverpaymentState_refunded,
+			Title: "已退款",
+		},
+	)
+}
+
+func NewRefundedAt(refundedAt string) *sqlbuilder.Field {
+	f := commonlanguage.NewTime(refundedAt).SetName("refundedAt").SetTitle("退款时间")
+	return f
+}
+
 var NewCreatedAt = commonlanguage.NewCreatedAt
 var NewUpdatedAt = commonlanguage.NewUpdatedAt
 
 var NewDeletedAt = commonlanguage.NewDeletedAt
 
+// NewDeleteReason 软删除原因，与备注分开存储，恢复后备注不丢失
+func NewDeleteReason(deleteReason string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(deleteReason, "deleteReason", "删除原因", 255)
+}
+
 const (
 	Pay_order_type_wechat = 1
 	Pay_order_type_alipay = 2
@@ -0,0 +1,747 @@
+package repository
+
+import (
+	"reflect"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"github.com/suifengpiao14/paymentrecord/encryption"
+	"github.com/suifengpiao14/sqlbuilder"
+	"gitlab.huishoubao.com/gopackage/statemachine"
+)
+
+// MemoryStore 收款单、支付记录的内存存储，主要用于单元测试。
+// 收款单与支付记录共用一个存储，事务失败时撤销本事务的变更；分账、账本等仍存于数据库的表通过 handler 参与同一事务
+type MemoryStore struct {
+	handler sqlbuilder.Handler
+	data    *memoryData
+}
+
+type memoryData struct {
+	mu      sync.Mutex
+	lastId  int64
+	orders  PayOrderModels
+	records PayRecordModels
+}
+
+// NewMemoryStore handler 可以为空，为空时事务只回滚内存数据
+func NewMemoryStore(handler sqlbuilder.Handler) *MemoryStore {
+	return &MemoryStore{
+		handler: handler,
+		data:    &memoryData{},
+	}
+}
+
+func (store *MemoryStore) PayOrderRepository() PayOrderRepository {
+	return payOrderMemoryRepository{store: store}
+}
+
+func (store *MemoryStore) PayRecordRepository() PayRecordRepository {
+	return payRecordMemoryRepository{store: store}
+}
+
+// transaction 内存数据的变更记录在事务中，失败时按相反顺序撤销，只恢复本事务变更的收款单、支付记录，
+// 不影响同时提交的其它事务。已在事务中时加入当前事务
+func (store *MemoryStore) transaction(tx *memoryTx, fc func(tx sqlbuilder.Handler) (err error)) (err error) {
+	if tx != nil {
+		return fc(tx.handler)
+	}
+	tx = &memoryTx{}
+	run := func(txHandler sqlbuilder.Handler) (err error) {
+		tx.handler = memoryTxHandler{Handler: txHandler, tx: tx}
+		return fc(tx.handler)
+	}
+	if store.handler == nil {
+		err = run(nil)
+	} else {
+		err = store.handler.Transaction(run)
+	}
+	if err != nil {
+		store.data.mu.Lock()
+		tx.rollback(store.data)
+		store.data.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// memoryTx 内存事务，记录已变更数据的撤销操作
+type memoryTx struct {
+	handler sqlbuilder.Handler
+	undo    []func(data *memoryData)
+}
+
+// memoryTxHandler 事务内传递给回调的 handler，内存仓库据此加入事务，其余仓库使用其中的数据库事务
+type memoryTxHandler struct {
+	sqlbuilder.Handler
+	tx *memoryTx
+}
+
+// txOf 取 handler 所属的内存事务，非内存事务返回 nil
+func txOf(txHandler sqlbuilder.Handler) *memoryTx {
+	if h, ok := txHandler.(memoryTxHandler); ok {
+		return h.tx
+	}
+	return nil
+}
+
+func (tx *memoryTx) rollback(data *memoryData) {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i](data)
+	}
+	tx.undo = nil
+}
+
+// orderChanged 收款单变更前调用，回滚时恢复原值；不在事务中时不记录
+func (tx *memoryTx) orderChanged(old PayOrderModel) {
+	if tx == nil {
+		return
+	}
+	tx.undo = append(tx.undo, func(data *memoryData) {
+		if i := slices.IndexFunc(data.orders, func(m PayOrderModel) bool { return m.Id == old.Id }); i >= 0 {
+			data.orders[i] = old
+		}
+	})
+}
+
+func (tx *memoryTx) orderCreated(id int64) {
+	if tx == nil {
+		return
+	}
+	tx.undo = append(tx.undo, func(data *memoryData) {
+		data.orders = slices.DeleteFunc(data.orders, func(m PayOrderModel) bool { return m.Id == id })
+	})
+}
+
+// recordChanged 支付记录变更前调用，回滚时恢复原值；不在事务中时不记录
+func (tx *memoryTx) recordChanged(old PayRecordModel) {
+	if tx == nil {
+		return
+	}
+	tx.undo = append(tx.undo, func(data *memoryData) {
+		if i := slices.IndexFunc(data.records, func(m PayRecordModel) bool { return m.Id == old.Id }); i >= 0 {
+			data.records[i] = old
+		}
+	})
+}
+
+func (tx *memoryTx) recordCreated(id int64) {
+	if tx == nil {
+		return
+	}
+	tx.undo = append(tx.undo, func(data *memoryData) {
+		data.records = slices.DeleteFunc(data.records, func(m PayRecordModel) bool { return m.Id == id })
+	})
+}
+
+func (store *MemoryStore) nextId() int64 {
+	store.data.lastId++
+	return store.data.lastId
+}
+
+type payOrderMemoryRepository struct {
+	store      *MemoryStore
+	tx         *memoryTx
+	merchantId string
+}
+
+// visible 收款单属于当前商户且未删除
+func (repo payOrderMemoryRepository) visible(m PayOrderModel) bool {
+	return m.MerchantId == repo.merchantId && m.DeletedAt == ""
+}
+
+// index 当前商户下订单号对应的下标，不存在返回-1
+func (repo payOrderMemoryRepository) index(orderId string) int {
+	return slices.IndexFunc(repo.store.data.orders, func(m PayOrderModel) bool { return m.OrderId == orderId && repo.visible(m) })
+}
+
+func (repo payOrderMemoryRepository) TransactionForMutiTable(fc func(tx sqlbuilder.Handler) (err error)) error {
+	return repo.store.transaction(repo.tx, fc)
+}
+
+func (repo payOrderMemoryRepository) WithTxHandler(txHandler sqlbuilder.Handler) PayOrderRepository {
+	repo.tx = txOf(txHandler)
+	return repo
+}
+
+func (repo payOrderMemoryRepository) WithMerchantId(merchantId string) PayOrderRepository {
+	repo.merchantId = merchantId
+	return repo
+}
+
+func (repo payOrderMemoryRepository) Set(in PayOrderSetIn) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	in.MerchantId, err = resolveMerchantId(repo.merchantId, in.MerchantId)
+	if err != nil {
+		return err
+	}
+	var model PayOrderModel
+	err = assignFields(&model, table_pay_order, in.Fields())
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(data.orders, func(m PayOrderModel) bool { return m.MerchantId == model.MerchantId && m.OrderId == model.OrderId })
+	if i >= 0 && data.orders[i].DeletedAt != "" {
+		err = errors.WithMessagef(ErrDeleted, "订单ID-%s", model.OrderId)
+		return err
+	}
+	if i >= 0 {
+		err = errors.Errorf("订单已存在,订单ID-%s", model.OrderId)
+		return err
+	}
+	model.Id = repo.store.nextId()
+	data.orders = append(data.orders, model)
+	repo.tx.orderCreated(model.Id)
+	return nil
+}
+
+func (repo payOrderMemoryRepository) GetByOrderId(orderId string) (model PayOrderModel, exists bool, err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	i := repo.index(orderId)
+	if i < 0 {
+		return model, false, nil
+	}
+	return data.orders[i], true, nil
+}
+
+func (repo payOrderMemoryRepository) GetByOrderIdMust(orderId string) (model PayOrderModel, err error) {
+	model, exists, err := repo.GetByOrderId(orderId)
+	if err != nil {
+		return model, err
+	}
+	if !exists {
+		err = sqlbuilder.ErrNotFound
+		return model, err
+	}
+	return model, nil
+}
+
+// LockByOrderId 内存仓库不支持并发事务，不加锁
+func (repo payOrderMemoryRepository) LockByOrderId(orderId string) (model PayOrderModel, exists bool, err error) {
+	return repo.GetByOrderId(orderId)
+}
+
+func (repo payOrderMemoryRepository) UpdateOrderAmount(orderId string, orderAmount int) (err error) {
+	if orderAmount < 1 {
+		err = errors.Errorf("订单金额必须大于0,订单ID-%s", orderId)
+		return err
+	}
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	for i := range data.orders {
+		if data.orders[i].OrderId == orderId && repo.visible(data.orders[i]) {
+			repo.tx.orderChanged(data.orders[i])
+			data.orders[i].OrderAmount = orderAmount
+		}
+	}
+	return nil
+}
+
+func (repo payOrderMemoryRepository) AddTotals(orderId string, delta PayOrderTotals) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	i := repo.index(orderId)
+	if i < 0 {
+		return nil
+	}
+	repo.tx.orderChanged(data.orders[i])
+	totals := data.orders[i].Totals().Add(delta)
+	data.orders[i].PaidAmount, data.orders[i].PendingAmount, data.orders[i].RecordCount = totals.PaidAmount, totals.PendingAmount, totals.RecordCount
+	return nil
+}
+
+func (repo payOrderMemoryRepository) SetTotals(orderId string, totals PayOrderTotals) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	i := repo.index(orderId)
+	if i < 0 {
+		return nil
+	}
+	repo.tx.orderChanged(data.orders[i])
+	data.orders[i].PaidAmount, data.orders[i].PendingAmount, data.orders[i].RecordCount = totals.PaidAmount, totals.PendingAmount, totals.RecordCount
+	return nil
+}
+
+func (repo payOrderMemoryRepository) Delete(orderId string, reason string) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	i := repo.index(orderId)
+	if i < 0 {
+		return nil
+	}
+	repo.tx.orderChanged(data.orders[i])
+	data.orders[i].DeletedAt, data.orders[i].DeleteReason = time.Now().Format(time.DateTime), reason
+	return nil
+}
+
+func (repo payOrderMemoryRepository) Restore(orderId string) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	for i, m := range data.orders {
+		if m.MerchantId == repo.merchantId && m.OrderId == orderId {
+			repo.tx.orderChanged(m)
+			data.orders[i].DeletedAt, data.orders[i].DeleteReason = "", ""
+		}
+	}
+	return nil
+}
+
+// GetHistory 内存仓库不归档
+func (repo payOrderMemoryRepository) GetHistory(orderId string) (model PayOrderModel, exists bool, err error) {
+	return model, false, nil
+}
+
+func (repo payOrderMemoryRepository) GetDeleted(orderId string) (model PayOrderModel, exists bool, err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	i := slices.IndexFunc(data.orders, func(m PayOrderModel) bool {
+		return m.MerchantId == repo.merchantId && m.OrderId == orderId && m.DeletedAt != ""
+	})
+	if i < 0 {
+		return model, false, nil
+	}
+	return data.orders[i], true, nil
+}
+
+func (repo payOrderMemoryRepository) CanAsErr(state string, event string) (err error) {
+	_, err = transformState(payOrderTransformEvents, event, state)
+	return err
+}
+
+func (repo payOrderMemoryRepository) Transform(event string, srcState string, orderId string, extraFs ...*sqlbuilder.Field) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	i := repo.index(orderId)
+	if i < 0 {
+		return sqlbuilder.ErrNotFound
+	}
+	model := data.orders[i]
+	if model.State != srcState {
+		err = errors.Errorf("订单状态已变更,订单ID-%s,期望状态-%s,当前状态-%s", orderId, srcState, model.State)
+		return err
+	}
+	model.State, err = transformState(payOrderTransformEvents, event, srcState)
+	if err != nil {
+		return err
+	}
+	err = assignFields(&model, table_pay_order, extraFs)
+	if err != nil {
+		return err
+	}
+	repo.tx.orderChanged(data.orders[i])
+	data.orders[i] = model
+	return nil
+}
+
+func (repo payOrderMemoryRepository) TransformByIdentity(event string, orderId string, extraFs ...*sqlbuilder.Field) (err error) {
+	model, err := repo.GetByOrderIdMust(orderId)
+	if err != nil {
+		return err
+	}
+	return repo.Transform(event, model.State, orderId, extraFs...)
+}
+
+type payRecordMemoryRepository struct {
+	store      *MemoryStore
+	tx         *memoryTx
+	merchantId string
+}
+
+// visible 支付记录属于当前商户且未删除
+func (repo payRecordMemoryRepository) visible(m PayRecordModel) bool {
+	return m.MerchantId == repo.merchantId && m.DeletedAt == ""
+}
+
+func (repo payRecordMemoryRepository) WithTxHandler(txHandler sqlbuilder.Handler) PayRecordRepository {
+	repo.tx = txOf(txHandler)
+	return repo
+}
+
+func (repo payRecordMemoryRepository) WithMerchantId(merchantId string) PayRecordRepository {
+	repo.merchantId = merchantId
+	return repo
+}
+
+// WithCipher 内存仓库不落库，不加密
+func (repo payRecordMemoryRepository) WithCipher(cipher *encryption.Cipher) PayRecordRepository {
+	return repo
+}
+
+func (repo payRecordMemoryRepository) Create(in PayRecordCreateIn) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	in.MerchantId, err = resolveMerchantId(repo.merchantId, in.MerchantId)
+	if err != nil {
+		return err
+	}
+	model := PayRecordModel{
+		CreatedAt: time.Now().Format(time.DateTime),
+	}
+	err = assignFields(&model, table_pay_record, in.Fields())
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(data.records, func(m PayRecordModel) bool { return m.PayId == model.PayId }) {
+		err = errors.Errorf("支付流水号已存在,支付流水号-%s", model.PayId)
+		return err
+	}
+	model.Id = repo.store.nextId()
+	data.records = append(data.records, model)
+	repo.tx.recordCreated(model.Id)
+	return nil
+}
+
+func (repo payRecordMemoryRepository) filter(fn func(m PayRecordModel) bool) (models PayRecordModels) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	for _, m := range data.records {
+		if repo.visible(m) && fn(m) {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+func (repo payRecordMemoryRepository) GetByPayId(payId string) (model PayRecordModel, exists bool, err error) {
+	models := repo.filter(func(m PayRecordModel) bool { return m.PayId == payId })
+	first, exists := models.First()
+	if !exists {
+		return model, false, nil
+	}
+	return *first, true, nil
+}
+
+func (repo payRecordMemoryRepository) GetByPayIdMust(payId string) (model PayRecordModel, err error) {
+	model, exists, err := repo.GetByPayId(payId)
+	if err != nil {
+		return model, err
+	}
+	if !exists {
+		err = sqlbuilder.ErrNotFound
+		return model, err
+	}
+	return model, nil
+}
+
+func (repo payRecordMemoryRepository) GetByOrderId(orderId string) (models PayRecordModels, err error) {
+	models = repo.filter(func(m PayRecordModel) bool { return m.OrderId == orderId })
+	return models, nil
+}
+
+// GetHistoryByPayId 内存仓库不归档
+func (repo payRecordMemoryRepository) GetHistoryByPayId(payId string) (model PayRecordModel, exists bool, err error) {
+	return model, false, nil
+}
+
+func (repo payRecordMemoryRepository) GetHistoryByOrderId(orderId string) (models PayRecordModels, err error) {
+	return nil, nil
+}
+
+func (repo payRecordMemoryRepository) DeleteByOrderId(orderId string, reason string) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	deletedAt := time.Now().Format(time.DateTime)
+	for i, m := range data.records {
+		if m.OrderId == orderId && repo.visible(m) {
+			repo.tx.recordChanged(m)
+			data.records[i].DeletedAt, data.records[i].DeleteReason = deletedAt, reason
+		}
+	}
+	return nil
+}
+
+func (repo payRecordMemoryRepository) RestoreByOrderId(orderId string) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	for i, m := range data.records {
+		if m.MerchantId == repo.merchantId && m.OrderId == orderId {
+			repo.tx.recordChanged(m)
+			data.records[i].DeletedAt, data.records[i].DeleteReason = "", ""
+		}
+	}
+	return nil
+}
+
+func (repo payRecordMemoryRepository) GetDeletedByOrderId(orderId string) (models PayRecordModels, err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	for _, m := range data.records {
+		if m.MerchantId == repo.merchantId && m.OrderId == orderId && m.DeletedAt != "" {
+			models = append(models, m)
+		}
+	}
+	return models, nil
+}
+
+func (repo payRecordMemoryRepository) GetByProviderTradeNo(providerTradeNo string) (models PayRecordModels, err error) {
+	if providerTradeNo == "" {
+		err = errors.New("providerTradeNo 不能为空")
+		return nil, err
+	}
+	models = repo.filter(func(m PayRecordModel) bool { return m.ProviderTradeNo == providerTradeNo })
+	return models, nil
+}
+
+// GetByPaymentAccount 内存实现不加密，直接按账号查询
+func (repo payRecordMemoryRepository) GetByPaymentAccount(paymentAccount string) (models PayRecordModels, err error) {
+	if paymentAccount == "" {
+		err = errors.New("paymentAccount 不能为空")
+		return nil, err
+	}
+	models = repo.filter(func(m PayRecordModel) bool { return m.PaymentAccount == paymentAccount })
+	return models, nil
+}
+
+// GetAllPayRecordByConditon 内存实现只支持等值(数组为 in)条件
+func (repo payRecordMemoryRepository) GetAllPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModels PayRecordModels, err error) {
+	if len(whereFs) == 0 {
+		err = errors.New("whereFs 不能为空")
+		return nil, err
+	}
+	var matchErr error
+	payRecordModels = repo.filter(func(m PayRecordModel) bool {
+		ok, err := matchFields(&m, table_pay_record, whereFs)
+		if err != nil {
+			matchErr = err
+		}
+		return ok
+	})
+	if matchErr != nil {
+		return nil, matchErr
+	}
+	return payRecordModels, nil
+}
+
+func (repo payRecordMemoryRepository) GetFirstPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModel PayRecordModel, err error) {
+	models, err := repo.GetAllPayRecordByConditon(whereFs)
+	if err != nil {
+		return payRecordModel, err
+	}
+	first, exists := models.First()
+	if !exists {
+		return payRecordModel, sqlbuilder.ErrNotFound
+	}
+	return *first, nil
+}
+
+func (repo payRecordMemoryRepository) CountPayRecordByConditon(whereFs sqlbuilder.Fields) (count int64, err error) {
+	models, err := repo.GetAllPayRecordByConditon(whereFs)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(models)), nil
+}
+
+func (repo payRecordMemoryRepository) GetUserAmountSince(userId string, since time.Time) (amount int, err error) {
+	sinceAt := since.Format(time.DateTime)
+	for _, m := range repo.filter(func(m PayRecordModel) bool {
+		return m.UserId == userId && m.CreatedAt >= sinceAt && slices.Contains(EffectStates, m.State)
+	}) {
+		amount += m.PayAmount
+	}
+	return amount, nil
+}
+
+func (repo payRecordMemoryRepository) GetPendingStats() (stats PendingStats, err error) {
+	indexs := make(map[string]int)
+	for _, m := range repo.filter(func(m PayRecordModel) bool { return m.State == PayOrderModel_state_pending.String() }) {
+		i, ok := indexs[m.PayAgent]
+		if !ok {
+			i = len(stats)
+			indexs[m.PayAgent] = i
+			stats = append(stats, PendingStat{PayAgent: m.PayAgent})
+		}
+		stats[i].Count++
+		stats[i].Amount += m.PayAmount
+	}
+	return stats, nil
+}
+
+func (repo payRecordMemoryRepository) UpdateOrderAmount(orderId string, orderAmount int) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	for i, m := range data.records {
+		if m.OrderId == orderId && repo.visible(m) && slices.Contains(EffectStates, m.State) {
+			repo.tx.recordChanged(m)
+			data.records[i].OrderAmount = orderAmount
+		}
+	}
+	return nil
+}
+
+func (repo payRecordMemoryRepository) SetRefundAmount(payId string, refundAmount int) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	i := slices.IndexFunc(data.records, func(m PayRecordModel) bool { return m.PayId == payId && repo.visible(m) })
+	if i < 0 {
+		return sqlbuilder.ErrNotFound
+	}
+	repo.tx.recordChanged(data.records[i])
+	data.records[i].RefundAmount = refundAmount
+	return nil
+}
+
+func (repo payRecordMemoryRepository) CanAsErr(state string, event string) (err error) {
+	_, err = transformState(payRecordTransformEvents, event, state)
+	return err
+}
+
+func (repo payRecordMemoryRepository) Transform(event string, srcState string, payId string, extraFs ...*sqlbuilder.Field) (err error) {
+	data := repo.store.data
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	i := slices.IndexFunc(data.records, func(m PayRecordModel) bool { return m.PayId == payId && repo.visible(m) })
+	if i < 0 {
+		return sqlbuilder.ErrNotFound
+	}
+	model := data.records[i]
+	if model.State != srcState {
+		err = errors.Errorf("支付记录状态已变更,支付流水号-%s,期望状态-%s,当前状态-%s", payId, srcState, model.State)
+		return err
+	}
+	model.State, err = transformState(payRecordTransformEvents, event, srcState)
+	if err != nil {
+		return err
+	}
+	err = assignFields(&model, table_pay_record, extraFs)
+	if err != nil {
+		return err
+	}
+	repo.tx.recordChanged(data.records[i])
+	data.records[i] = model
+	return nil
+}
+
+func (repo payRecordMemoryRepository) TransformByIdentity(event string, payId string, extraFs ...*sqlbuilder.Field) (err error) {
+	model, err := repo.GetByPayIdMust(payId)
+	if err != nil {
+		return err
+	}
+	return repo.Transform(event, model.State, payId, extraFs...)
+}
+
+// transformState 按状态机规则计算目标状态
+func transformState(events statemachine.TransformEvents, event string, srcState string) (dstState string, err error) {
+	for _, e := range events {
+		if e.EventName != event {
+			continue
+		}
+		if !slices.Contains(e.SrcStates, srcState) {
+			err = errors.Errorf("状态(%s)不允许执行%s", srcState, event)
+			return "", err
+		}
+		return e.DstState, nil
+	}
+	err = errors.Errorf("未定义的状态变更事件:%s", event)
+	return "", err
+}
+
+var gormColumnReg = regexp.MustCompile(`column:(\w+)`)
+
+// modelColumnIndex 模型字段按 gorm column 标签索引
+func modelColumnIndex(typ reflect.Type) (index map[string]int) {
+	index = make(map[string]int)
+	for i := 0; i < typ.NumField(); i++ {
+		matches := gormColumnReg.FindStringSubmatch(typ.Field(i).Tag.Get("gorm"))
+		if len(matches) == 2 {
+			index[matches[1]] = i
+		}
+	}
+	return index
+}
+
+// assignFields 按字段对应的数据库列，将字段值写入模型，与数据库写入一样会执行字段校验
+func assignFields(model any, table sqlbuilder.TableConfig, fs sqlbuilder.Fields) (err error) {
+	rv := reflect.ValueOf(model).Elem()
+	index := modelColumnIndex(rv.Type())
+	for _, f := range fs {
+		if len(f.WhereFns) > 0 && f.Schema != nil && f.Schema.ShieldUpdate { // 只作为条件的字段
+			continue
+		}
+		col, ok := table.Columns.GetByFieldName(f.Name)
+		if !ok {
+			continue
+		}
+		i, ok := index[col.DbName]
+		if !ok {
+			continue
+		}
+		cp := f.Copy().InitBeforeCalValue(fs...)
+		val, err := cp.GetValue(sqlbuilder.Layer_get_value_before_db, fs...)
+		if sqlbuilder.IsErrorValueNil(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		field := rv.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(cast.ToString(val))
+		case reflect.Int, reflect.Int64:
+			field.SetInt(cast.ToInt64(val))
+		}
+	}
+	return nil
+}
+
+// matchFields 模型是否满足条件字段，支持等值及数组(in)条件
+func matchFields(model any, table sqlbuilder.TableConfig, fs sqlbuilder.Fields) (ok bool, err error) {
+	rv := reflect.ValueOf(model).Elem()
+	index := modelColumnIndex(rv.Type())
+	for _, f := range fs {
+		if len(f.WhereFns) == 0 {
+			continue
+		}
+		col, exists := table.Columns.GetByFieldName(f.Name)
+		if !exists {
+			err = errors.Errorf("表%s未定义字段%s", table.DBName.Name, f.Name)
+			return false, err
+		}
+		i, exists := index[col.DbName]
+		if !exists {
+			continue
+		}
+		val, err := f.WhereData(sqlbuilder.Layer_where, fs...)
+		if err != nil {
+			return false, err
+		}
+		if sqlbuilder.IsNil(val) {
+			continue
+		}
+		modelVal := cast.ToString(rv.Field(i).Interface())
+		switch reflect.ValueOf(val).Kind() {
+		case reflect.Slice, reflect.Array:
+			if !slices.Contains(cast.ToStringSlice(val), modelVal) {
+				return false, nil
+			}
+		default:
+			if cast.ToString(val) != modelVal {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+/*
+CREATE TABLE `overpayment` (
+  `Fid` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '主键',
+  `Fmerchant_id` varchar(64) NOT NULL DEFAULT '' COMMENT '商户ID',
+  `Fpay_id` varchar(64) NOT NULL DEFAULT '' COMMENT '支付流水号',
+  `Forder_id` varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  `Foverpayment_type` varchar(15) NOT NULL DEFAULT '' COMMENT '超付类型 overpaid-超额支付 duplicate-重复回调',
+  `Fprovider_trade_no` varchar(64) NOT NULL DEFAULT '' COMMENT '支付渠道交易号',
+  `Forder_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '订单金额',
+  `Fpaid_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '已支付总额',
+  `Foverpaid_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '超付金额',
+  `Frefund_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '已退款金额',
+  `Fstate` varchar(15) NOT NULL DEFAULT '' COMMENT '处理状态 pending-待处理 refunded-已退款',
+  `Fremark` varchar(255) NOT NULL DEFAULT '' COMMENT '备注',
+  `Fcreated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '发现时间',
+  `Frefunded_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '退款时间',
+  PRIMARY KEY (`Fid`),
+  KEY `ik_Fmerchant_id_Forder_id` (`Fmerchant_id`,`Forder_id`),
+  KEY `key_pay_id` (`Fpay_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='超付记录';
+*/
+
+var table_overpayment = sqlbuilder.NewTableConfig("overpayment").AddColumns(
+	sqlbuilder.NewColumn("Fid", sqlbuilder.GetField(NewId)),
+	sqlbuilder.NewColumn("Fmerchant_id", sqlbuilder.GetField(NewMerchantId)),
+	sqlbuilder.NewColumn("Fpay_id", sqlbuilder.GetField(NewPayId)),
+	sqlbuilder.NewColumn("Forder_id", sqlbuilder.GetField(NewOrderId)),
+	sqlbuilder.NewColumn("Foverpayment_type", sqlbuilder.GetField(NewOverpaymentType)),
+	sqlbuilder.NewColumn("Fprovider_trade_no", sqlbuilder.GetField(NewProviderTradeNo)),
+	sqlbuilder.NewColumn("Forder_amount", sqlbuilder.GetField(NewOrderAmount)),
+	sqlbuilder.NewColumn("Fpaid_amount", sqlbuilder.GetField(NewPaidAmount)),
+	sqlbuilder.NewColumn("Foverpaid_amount", sqlbuilder.GetField(NewOverpaidAmount)),
+	sqlbuilder.NewColumn("Frefund_amount", sqlbuilder.GetField(NewRefundAmount)),
+	sqlbuilder.NewColumn("Fstate", sqlbuilder.GetField(NewOverpaymentState)),
+	sqlbuilder.NewColumn("Fremark", sqlbuilder.GetField(NewRemark)),
+	sqlbuilder.NewColumn("Fcreated_at", sqlbuilder.GetField(NewCreatedAt)),
+	sqlbuilder.NewColumn("Frefunded_at", sqlbuilder.GetField(NewRefundedAt)),
+).AddIndexs(
+	sqlbuilder.Index{
+		IsPrimary: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId)),
+			}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPayId))}
+		},
+	},
+).WithComment("超付记录")
+
+type OverpaymentModel struct {
+	Id              int64  `gorm:"column:Fid" json:"id"`
+	MerchantId      string `gorm:"column:Fmerchant_id" json:"merchantId"`
+	PayId           string `gorm:"column:Fpay_id" json:"payId"`
+	OrderId         string `gorm:"column:Forder_id" json:"orderId"`
+	OverpaymentType string `gorm:"column:Foverpayment_type" json:"overpaymentType"`
+	ProviderTradeNo string `gorm:"column:Fprovider_trade_no" json:"providerTradeNo"`
+	OrderAmount     int    `gorm:"column:Forder_amount" json:"orderAmount"`
+	PaidAmount      int    `gorm:"column:Fpaid_amount" json:"paidAmount"`
+	OverpaidAmount  int    `gorm:"column:Foverpaid_amount" json:"overpaidAmount"`
+	RefundAmount    int    `gorm:"column:Frefund_amount" json:"refundAmount"`
+	State           string `gorm:"column:Fstate" json:"state"`
+	Remark          string `gorm:"column:Fremark" json:"remark"`
+	CreatedAt       string `gorm:"column:Fcreated_at" json:"createdAt"`
+	RefundedAt      string `gorm:"column:Frefunded_at" json:"refundedAt"`
+}
+
+type OverpaymentModels []OverpaymentModel
+
+type OverpaymentRepository struct {
+	repository sqlbuilder.Repository
+	merchantId string
+}
+
+func NewOverpaymentRepository(handler sqlbuilder.Handler) (repository OverpaymentRepository) {
+	tableConfig := table_overpayment.WithHandler(handler)
+	repository = OverpaymentRepository{
+		repository: sqlbuilder.NewRepository(tableConfig),
+	}
+	return repository
+}
+
+func (repo OverpaymentRepository) GetTable() sqlbuilder.TableConfig {
+	return repo.repository.GetTable()
+}
+
+func (repo OverpaymentRepository) WithTxHandler(txHandler sqlbuilder.Handler) OverpaymentRepository {
+	repo.repository = repo.repository.WithTxHandler(txHandler)
+	return repo
+}
+
+// WithMerchantId 按商户隔离，超付记录写入该商户，按订单号只查询该商户的超付记录
+func (repo OverpaymentRepository) WithMerchantId(merchantId string) OverpaymentRepository {
+	repo.merchantId = merchantId
+	return repo
+}
+
+type OverpaymentCreateIn struct {
+	PayId           string `json:"payId"`
+	OrderId         string `json:"orderId"`
+	OverpaymentType string `json:"overpaymentType"`
+	ProviderTradeNo string `json:"providerTradeNo"`
+	OrderAmount     int    `json:"orderAmount"`
+	PaidAmount      int    `json:"paidAmount"`
+	OverpaidAmount  int    `json:"overpaidAmount"`
+	Remark          string `json:"remark"`
+}
+
+func (in OverpaymentCreateIn) Fields() sqlbuilder.Fields {
+	return sqlbuilder.Fields{
+		NewPayId(in.PayId).SetRequired(true),
+		NewOrderId(in.OrderId).SetRequired(true),
+		NewOverpaymentType(in.OverpaymentType).SetRequired(true),
+		NewProviderTradeNo(in.ProviderTradeNo),
+		NewOrderAmount(in.OrderAmount),
+		NewPaidAmount(in.PaidAmount),
+		NewOverpaidAmount(in.OverpaidAmount),
+		NewOverpaymentState(OverpaymentState_pending),
+		NewRemark(in.Remark),
+		NewCreatedAt(time.Now().Format(time.DateTime)),
+	}
+}
+
+func (repo OverpaymentRepository) Create(in OverpaymentCreateIn) (err error) {
+	err = repo.repository.Insert(in.Fields().Add(NewMerchantId(repo.merchantId)))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (repo OverpaymentRepository) GetByOrderId(orderId string) (models OverpaymentModels, err error) {
+	fs := merchantScope(repo.merchantId).Add(
+		NewOrderId(orderId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	err = repo.repository.All(&models, fs)
+	if err != nil {
+		return models, err
+	}
+	return models, nil
+}
+
+// LockPendingByPayId 在事务内(WithTxHandler)锁定支付记录待处理的超付记录，退还超付金额前调用，并发退款时只有一个能处理。
+// sqlite 不支持行锁，由 immediate 事务串行
+func (repo OverpaymentRepository) LockPendingByPayId(payId string, overpaymentType string) (model OverpaymentModel, exists bool, err error) {
+	table := repo.GetTable()
+	handler := table.GetHandler()
+	driver := sqlbuilder.Driver(handler.GetDialector())
+	ds := driver.GoquDialect().From(table.DBName.Name).Where(
+		goqu.C(table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId))).Eq(repo.merchantId),
+		goqu.C(table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPayId))).Eq(payId),
+		goqu.C(table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOverpaymentType))).Eq(overpaymentType),
+		goqu.C(table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOverpaymentState))).Eq(OverpaymentState_pending),
+	).Limit(1)
+	if !strings.HasPrefix(driver.String(), "sqlite") {
+		ds = ds.ForUpdate(exp.Wait)
+	}
+	sql, _, err := ds.ToSQL()
+	if err != nil {
+		return model, false, err
+	}
+	exists, err = handler.First(context.Background(), sql, &model)
+	if err != nil {
+		return model, false, err
+	}
+	return model, exists, nil
+}
+
+// MarkRefunded 超付金额退款完成，只更新锁定的超付记录并记录实际退款金额
+func (repo OverpaymentRepository) MarkRefunded(id int64, refundAmount int) (err error) {
+	fs := merchantScope(repo.merchantId).Add(
+		NewId(int(id)).SetRequired(true).AppendWhereFn(sqlbuilder.ValueFnForward).ShieldUpdate(true),
+		NewRefundAmount(refundAmount),
+		NewOverpaymentState(OverpaymentState_refunded),
+		NewRefundedAt(time.Now().Format(time.DateTime)),
+	)
+	err = repo.repository.Update(fs)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RevertRefunded 支付渠道退款失败，超付记录恢复为待处理，可以再次退还；在事务内(WithTxHandler)调用
+func (repo OverpaymentRepository) RevertRefunded(id int64) (err error) {
+	table := repo.GetTable()
+	handler := table.GetHandler()
+	sql, _, err := sqlbuilder.Driver(handler.GetDialector()).GoquDialect().Update(table.DBName.Name).Set(goqu.Record{
+		table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOverpaymentState)): OverpaymentState_pending,
+		table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewRefundAmount)):     0,
+		table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewRefundedAt)):       zeroTime(table),
+	}).Where(
+		goqu.C(table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId))).Eq(repo.merchantId),
+		goqu.C(table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))).Eq(id),
+		goqu.C(table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOverpaymentState))).Eq(OverpaymentState_refunded),
+	).ToSQL()
+	if err != nil {
+		return err
+	}
+	return handler.Exec(sql)
+}
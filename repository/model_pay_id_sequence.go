@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+/*
+CREATE TABLE `pay_id_sequence` (
+  `Fid` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '主键',
+  `Fsequence_name` varchar(64) NOT NULL DEFAULT '' COMMENT '序列名称',
+  `Fsequence_value` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '已分配的最大序号',
+  PRIMARY KEY (`Fid`),
+  UNIQUE KEY `uk_sequence_name` (`Fsequence_name`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='支付流水号序列';
+*/
+
+var table_pay_id_sequence = sqlbuilder.NewTableConfig("pay_id_sequence").AddColumns(
+	sqlbuilder.NewColumn("Fid", sqlbuilder.GetField(NewId)),
+	sqlbuilder.NewColumn("Fsequence_name", sqlbuilder.GetField(NewSequenceName)),
+	sqlbuilder.NewColumn("Fsequence_value", sqlbuilder.GetField(NewSequenceValue)),
+).AddIndexs(
+	sqlbuilder.Index{
+		IsPrimary: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))}
+		},
+	},
+	sqlbuilder.Index{
+		Unique: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewSequenceName))}
+		},
+	},
+).WithComment("支付流水号序列")
+
+type PayIdSequenceModel struct {
+	Id            int64  `gorm:"column:Fid" json:"id"`
+	SequenceName  string `gorm:"column:Fsequence_name" json:"sequenceName"`
+	SequenceValue int64  `gorm:"column:Fsequence_value" json:"sequenceValue"`
+}
+
+type PayIdSequenceRepository struct {
+	repository sqlbuilder.Repository
+}
+
+func NewPayIdSequenceRepository(handler sqlbuilder.Handler) (repository PayIdSequenceRepository) {
+	tableConfig := table_pay_id_sequence.WithHandler(handler)
+	repository = PayIdSequenceRepository{
+		repository: sqlbuilder.NewRepository(tableConfig),
+	}
+	return repository
+}
+
+func (repo PayIdSequenceRepository) GetTable() sqlbuilder.TableConfig {
+	return repo.repository.GetTable()
+}
+
+// Allocate 分配一段序号，返回分配后的最大序号，调用方可使用 (maxValue-step, maxValue] 区间内的序号。
+// 序列不存在时自动创建；更新语句锁定序列行，多实例并发分配的号段不会重叠
+func (repo PayIdSequenceRepository) Allocate(name string, step int) (maxValue int64, err error) {
+	if step <= 0 {
+		err = errors.Errorf("号段步长必须大于0,收到:%d", step)
+		return 0, err
+	}
+	table := repo.GetTable()
+	dbNameName := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewSequenceName))
+	dbNameValue := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewSequenceValue))
+	err = repo.repository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+		dialect := sqlbuilder.Driver(tx.GetDialector()).GoquDialect()
+		sql, _, err := dialect.Insert(table.DBName.Name).Rows(goqu.Record{dbNameName: name, dbNameValue: 0}).OnConflict(goqu.DoNothing()).ToSQL()
+		if err != nil {
+			return err
+		}
+		err = tx.Exec(sql)
+		if err != nil {
+			return err
+		}
+		sql, _, err = dialect.Update(table.DBName.Name).Set(goqu.Record{dbNameValue: goqu.L("? + ?", goqu.C(dbNameValue), step)}).
+			Where(goqu.C(dbNameName).Eq(name)).ToSQL()
+		if err != nil {
+			return err
+		}
+		err = tx.Exec(sql)
+		if err != nil {
+			return err
+		}
+		sql, _, err = dialect.From(table.DBName.Name).Select(dbNameValue).Where(goqu.C(dbNameName).Eq(name)).ToSQL()
+		if err != nil {
+			return err
+		}
+		var model PayIdSequenceModel
+		exists, err := tx.First(context.Background(), sql, &model)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			err = errors.Errorf("序列不存在:%s", name)
+			return err
+		}
+		maxValue = model.SequenceValue
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return maxValue, nil
+}
@@ -1,40 +1,65 @@
 package repository
 
 import (
+	"context"
+	"strings"
 	"time"
 
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/pkg/errors"
 	"github.com/suifengpiao14/sqlbuilder"
 	"gitlab.huishoubao.com/gopackage/statemachine"
 )
 
 /*
-CREATE TABLE `t_payment_order` (
-  `Fid` int(10) unsigned NOT NULL AUTO_INCREMENT COMMENT '主键',
-  `Forder_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '订单Id',
-  `Forder_amount` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '订单的总金额',
-  `Fstate` varchar(15) unsigned NOT NULL DEFAULT '1' COMMENT '支付状态 pending-未支付 paid-已支付,closed-已关闭',
-  `Fuser_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '用户ID',
+CREATE TABLE `pay_order` (
+  `Fid` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '主键',
+  `Fmerchant_id` varchar(64) NOT NULL DEFAULT '' COMMENT '商户ID',
+  `Forder_id` varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  `Forder_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '订单总金额，单位分',
+  `Fstate` varchar(15) NOT NULL DEFAULT '' COMMENT '支付状态 pending-未支付 paid-已支付 closed-已关闭',
+  `Fuser_id` varchar(64) NOT NULL DEFAULT '' COMMENT '用户ID',
+  `Fremark` varchar(255) NOT NULL DEFAULT '' COMMENT '备注',
+  `Fexpire` int(11) unsigned NOT NULL DEFAULT '0' COMMENT '超时时间，单位分钟',
+  `Forder_type` varchar(15) NOT NULL DEFAULT '' COMMENT '订单类型 fixed-固定金额 open-开放式',
+  `Funit_price` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '单价，单位分',
+  `Fcapacity` int(11) unsigned NOT NULL DEFAULT '0' COMMENT '名额上限，0表示不限',
+  `Fdeadline` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '截止时间',
+  `Fpaid_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '已支付金额，单位分',
+  `Fpending_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '待支付金额，单位分',
+  `Frecord_count` int(11) unsigned NOT NULL DEFAULT '0' COMMENT '有效支付记录数',
+  `Fcreated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
   `Fpaid_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '支付成功时间',
-  `Fclosed_at`datetime NOT NULL DEFAULT '' COMMENT '关闭时间',
-  `Fcreated_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '发起支付时间',
+  `Fclosed_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '关闭时间',
+  `Fdeleted_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '删除时间',
+  `Fdelete_reason` varchar(255) NOT NULL DEFAULT '' COMMENT '删除原因',
   PRIMARY KEY (`Fid`),
-  KEY `key_order` (`Forder_id`),
-  KEY `key_user` (`Fuser_id`),
-  KEY `key_state` (`Fstate`)
-) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='支付单表';
+  UNIQUE KEY `uk_Fmerchant_id_Forder_id` (`Fmerchant_id`,`Forder_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='收款单表';
 */
 
 var table_pay_order = sqlbuilder.NewTableConfig("pay_order").AddColumns(
 	sqlbuilder.NewColumn("Fid", sqlbuilder.GetField(NewId)),
+	sqlbuilder.NewColumn("Fmerchant_id", sqlbuilder.GetField(NewMerchantId)),
 	sqlbuilder.NewColumn("Forder_id", sqlbuilder.GetField(NewOrderId)),
 	sqlbuilder.NewColumn("Forder_amount", sqlbuilder.GetField(NewOrderAmount)),
 	sqlbuilder.NewColumn("Fstate", sqlbuilder.GetField(NewState)),
 	sqlbuilder.NewColumn("Fuser_id", sqlbuilder.GetField(NewUserId)),
 	sqlbuilder.NewColumn("Fremark", sqlbuilder.GetField(NewRemark)),
 	sqlbuilder.NewColumn("Fexpire", sqlbuilder.GetField(NewExpire)),
+	sqlbuilder.NewColumn("Forder_type", sqlbuilder.GetField(NewOrderType)),
+	sqlbuilder.NewColumn("Funit_price", sqlbuilder.GetField(NewUnitPrice)),
+	sqlbuilder.NewColumn("Fcapacity", sqlbuilder.GetField(NewCapacity)),
+	sqlbuilder.NewColumn("Fdeadline", sqlbuilder.GetField(NewDeadline)),
+	sqlbuilder.NewColumn("Fpaid_amount", sqlbuilder.GetField(NewPaidAmount)),
+	sqlbuilder.NewColumn("Fpending_amount", sqlbuilder.GetField(NewPendingAmount)),
+	sqlbuilder.NewColumn("Frecord_count", sqlbuilder.GetField(NewRecordCount)),
 	sqlbuilder.NewColumn("Fcreated_at", sqlbuilder.GetField(NewCreatedAt)),
 	sqlbuilder.NewColumn("Fpaid_at", sqlbuilder.GetField(NewPaidAt)),
 	sqlbuilder.NewColumn("Fclosed_at", sqlbuilder.GetField(NewClosedAt)),
+	sqlbuilder.NewColumn("Fdeleted_at", sqlbuilder.GetField(NewDeletedAt)),
+	sqlbuilder.NewColumn("Fdelete_reason", sqlbuilder.GetField(NewDeleteReason)),
 ).AddIndexs(
 	sqlbuilder.Index{
 		IsPrimary: true,
@@ -43,59 +68,199 @@ var table_pay_order = sqlbuilder.NewTableConfig("pay_order").AddColumns(
 			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))}
 		},
 	},
-	sqlbuilder.Index{
+	sqlbuilder.Index{ // 订单号在商户内唯一
 		Unique: true,
 		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
-			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId))}
+			return []string{
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId)),
+			}
 		},
 	},
 ).WithComment("收款单表")
 
 type PayOrderModel struct {
-	Id          int64  `gorm:"column:Fid" json:"id"`
-	OrderId     string `gorm:"column:Forder_id" json:"orderId"`
-	OrderAmount int    `gorm:"column:Forder_amount" json:"orderAmount"`
-	State       string `gorm:"column:Fstate" json:"state"`
-	UserId      string `gorm:"column:Fuser_id" json:"userId"`
-	Remark      string `gorm:"column:Fremark" json:"remark"`
-	Expire      string `gorm:"column:Fexpire" json:"expire"`
-	CreatedAt   string `gorm:"column:Fcreated_at" json:"createdAt"`
-	PaidAt      string `gorm:"column:Fpaid_at" json:"paidAt"`
-	ClosedAt    string `gorm:"column:Fclosed_at" json:"closedAt"`
+	Id            int64  `gorm:"column:Fid" json:"id"`
+	MerchantId    string `gorm:"column:Fmerchant_id" json:"merchantId"`
+	OrderId       string `gorm:"column:Forder_id" json:"orderId"`
+	OrderAmount   int    `gorm:"column:Forder_amount" json:"orderAmount"`
+	State         string `gorm:"column:Fstate" json:"state"`
+	UserId        string `gorm:"column:Fuser_id" json:"userId"`
+	Remark        string `gorm:"column:Fremark" json:"remark"`
+	Expire        string `gorm:"column:Fexpire" json:"expire"`
+	OrderType     string `gorm:"column:Forder_type" json:"orderType"`
+	UnitPrice     int    `gorm:"column:Funit_price" json:"unitPrice"`         // 开放式订单单价
+	Capacity      int    `gorm:"column:Fcapacity" json:"capacity"`            // 开放式订单名额上限，0表示不限
+	Deadline      string `gorm:"column:Fdeadline" json:"deadline"`            // 开放式订单截止时间，截止后不允许再支付
+	PaidAmount    int    `gorm:"column:Fpaid_amount" json:"paidAmount"`       // 已支付记录金额合计，随支付记录状态变更同事务更新
+	PendingAmount int    `gorm:"column:Fpending_amount" json:"pendingAmount"` // 待支付记录金额合计
+	RecordCount   int    `gorm:"column:Frecord_count" json:"recordCount"`     // 有效(待支付、已支付)支付记录数
+	CreatedAt     string `gorm:"column:Fcreated_at" json:"createdAt"`
+	PaidAt        string `gorm:"column:Fpaid_at" json:"paidAt"`
+	ClosedAt      string `gorm:"column:Fclosed_at" json:"closedAt"`
+	DeletedAt     string `gorm:"column:Fdeleted_at" json:"deletedAt"` // 软删除时间，见 Delete
+	DeleteReason  string `gorm:"column:Fdelete_reason" json:"deleteReason"`
+}
+
+// IsOpen 是否为开放式订单
+func (m PayOrderModel) IsOpen() bool {
+	return m.OrderType == OrderType_open
+}
+
+// IsDeadlinePassed 开放式订单是否已过截止时间，未设置截止时间返回false
+func (m PayOrderModel) IsDeadlinePassed(now time.Time) bool {
+	deadline, err := ParseDateTime(m.Deadline)
+	if err != nil || deadline.Year() <= 1 { // 未设置截止时间
+		return false
+	}
+	return now.After(deadline)
+}
+
+// Totals 收款单上冗余的支付记录汇总
+func (m PayOrderModel) Totals() PayOrderTotals {
+	return PayOrderTotals{PaidAmount: m.PaidAmount, PendingAmount: m.PendingAmount, RecordCount: m.RecordCount}
+}
+
+// EffectOrderAmount 有效支付记录对应的订单金额，开放式订单按单价计，无有效支付记录时为0(同 PayRecordModels.GetOrderAmount)
+func (m PayOrderModel) EffectOrderAmount() int {
+	if m.RecordCount == 0 {
+		return 0
+	}
+	if m.IsOpen() {
+		return m.UnitPrice
+	}
+	return m.OrderAmount
+}
+
+// IsPayFinished 订单是否已支付完成，同 PayRecordModels.IsOrderPayFinished
+func (m PayOrderModel) IsPayFinished() bool {
+	return m.EffectOrderAmount() <= m.PaidAmount
+}
+
+// RestAmount 订单剩余可创建支付记录的金额，同 PayRecordModels.GetOrderRestPayRecordAmount
+func (m PayOrderModel) RestAmount() int {
+	return m.EffectOrderAmount() - m.PaidAmount - m.PendingAmount
 }
 
 type PayOrderModels []PayOrderModel
 
-type PayOrderRepository struct {
-	stateMachine statemachine.StateMachine
-	repository   sqlbuilder.Repository
+// PayOrderRepository 收款单仓库，状态变更需遵循 payOrderTransformEvents 定义的状态机规则
+type PayOrderRepository interface {
+	TransactionForMutiTable(fc func(tx sqlbuilder.Handler) (err error)) error
+	WithTxHandler(txHandler sqlbuilder.Handler) PayOrderRepository
+	// WithMerchantId 按商户隔离，返回的仓库只读写该商户的收款单，默认为空商户
+	WithMerchantId(merchantId string) PayOrderRepository
+	Set(in PayOrderSetIn) (err error)
+	GetByOrderId(orderId string) (model PayOrderModel, exists bool, err error)
+	GetByOrderIdMust(orderId string) (model PayOrderModel, err error)
+	LockByOrderId(orderId string) (model PayOrderModel, exists bool, err error)
+	UpdateOrderAmount(orderId string, orderAmount int) (err error)
+	// AddTotals 原子累加支付记录汇总，需与支付记录状态变更在同一事务内(WithTxHandler)调用
+	AddTotals(orderId string, delta PayOrderTotals) (err error)
+	// SetTotals 覆盖支付记录汇总，用于按支付记录修复
+	SetTotals(orderId string, totals PayOrderTotals) (err error)
+	// Delete 软删除收款单，删除后除 GetDeleted 外的查询、变更均不再匹配；Restore 恢复
+	Delete(orderId string, reason string) (err error)
+	Restore(orderId string) (err error)
+	GetDeleted(orderId string) (model PayOrderModel, exists bool, err error)
+	// GetHistory 读取已归档(ArchivePayOrders)的收款单，已归档的订单号不能再次创建
+	GetHistory(orderId string) (model PayOrderModel, exists bool, err error)
+	CanAsErr(state string, event string) (err error)
+	Transform(event string, srcState string, orderId string, extraFs ...*sqlbuilder.Field) (err error)
+	TransformByIdentity(event string, orderId string, extraFs ...*sqlbuilder.Field) (err error)
 }
 
-func NewPayOrderRepository(handler sqlbuilder.Handler) (repository PayOrderRepository) {
+// PayOrderDBRepository 基于数据库的收款单仓库
+type PayOrderDBRepository struct {
+	stateMachine      statemachine.StateMachine
+	repository        sqlbuilder.Repository
+	historyRepository sqlbuilder.Repository // 归档表，见 ArchivePayOrders
+	merchantId        string
+}
+
+func NewPayOrderRepository(handler sqlbuilder.Handler) PayOrderRepository {
+	return NewPayOrderDBRepository(handler)
+}
+
+func NewPayOrderDBRepository(handler sqlbuilder.Handler) (repository PayOrderDBRepository) {
 	tableConfig := table_pay_order.WithHandler(handler)
 	stateMachine := repository.makeStateMachine(tableConfig)
-	repository = PayOrderRepository{
-		stateMachine: *stateMachine,
-		repository:   sqlbuilder.NewRepository(tableConfig),
+	repository = PayOrderDBRepository{
+		stateMachine:      *stateMachine,
+		repository:        sqlbuilder.NewRepository(tableConfig),
+		historyRepository: sqlbuilder.NewRepository(table_pay_order_history.WithHandler(handler)),
 	}
 	return repository
 }
 
-func (repo PayOrderRepository) GetStateMachine() statemachine.StateMachine {
+func (repo PayOrderDBRepository) GetStateMachine() statemachine.StateMachine {
 	return repo.stateMachine
 }
-func (repo PayOrderRepository) GetTable() sqlbuilder.TableConfig {
+func (repo PayOrderDBRepository) GetTable() sqlbuilder.TableConfig {
 	return repo.repository.GetTable()
 }
 
-func (repo PayOrderRepository) TransactionForMutiTable(fc func(tx sqlbuilder.Handler) (err error)) error {
+func (repo PayOrderDBRepository) TransactionForMutiTable(fc func(tx sqlbuilder.Handler) (err error)) error {
 	return repo.repository.TransactionForMutiTable(fc)
 }
-func (repo PayOrderRepository) WithTxHandler(txHandler sqlbuilder.Handler) PayOrderRepository {
+func (repo PayOrderDBRepository) WithTxHandler(txHandler sqlbuilder.Handler) PayOrderRepository {
 	repo.repository = repo.repository.WithTxHandler(txHandler)
+	repo.historyRepository = repo.historyRepository.WithTxHandler(txHandler)
+	repo.stateMachine = repo.stateMachine.WithTxHandler(txHandler)
+	return repo
+}
+
+func (repo PayOrderDBRepository) WithMerchantId(merchantId string) PayOrderRepository {
+	repo.merchantId = merchantId
 	return repo
 }
-func (repo PayOrderRepository) makeStateMachine(tableConfig sqlbuilder.TableConfig) (stateMachine *statemachine.StateMachine) {
+
+func (repo PayOrderDBRepository) CanAsErr(state string, event string) (err error) {
+	return repo.stateMachine.CanAsErr(state, event)
+}
+
+// Transform 状态机只按订单号定位收款单，订单号在不同商户间可能重复，改为按商户、订单号及原状态更新
+func (repo PayOrderDBRepository) Transform(event string, srcState string, orderId string, extraFs ...*sqlbuilder.Field) (err error) {
+	model, err := repo.GetByOrderIdMust(orderId)
+	if err != nil {
+		return err
+	}
+	if model.State != srcState {
+		err = errors.Errorf("订单状态已变更,订单ID-%s,期望状态-%s,当前状态-%s", orderId, srcState, model.State)
+		return err
+	}
+	dstState, err := transformState(payOrderTransformEvents, event, srcState)
+	if err != nil {
+		return err
+	}
+	fs := liveScope(repo.merchantId).Add(
+		NewOrderId(orderId).SetRequired(true).AppendWhereFn(sqlbuilder.ValueFnForward).ShieldUpdate(true),
+		NewState(dstState).AppendWhereFn(sqlbuilder.ValueFn{ // 更新为目标状态，条件为原状态
+			Fn: func(in any, f *sqlbuilder.Field, fs ...*sqlbuilder.Field) (any, error) {
+				return srcState, nil
+			},
+			Layer: sqlbuilder.Value_Layer_DBFormat,
+		}),
+	).Add(extraFs...)
+	rowsAffected, err := repo.repository.UpdateWithRowsAffected(fs)
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 && dstState != srcState { // 并发下状态已被其它请求变更；幂等变更时 mysql 未修改的行不计入影响行数
+		err = errors.Errorf("订单状态已变更,订单ID-%s,期望状态-%s", orderId, srcState)
+		return err
+	}
+	return nil
+}
+
+func (repo PayOrderDBRepository) TransformByIdentity(event string, orderId string, extraFs ...*sqlbuilder.Field) (err error) {
+	model, err := repo.GetByOrderIdMust(orderId)
+	if err != nil {
+		return err
+	}
+	return repo.Transform(event, model.State, orderId, extraFs...)
+}
+func (repo PayOrderDBRepository) makeStateMachine(tableConfig sqlbuilder.TableConfig) (stateMachine *statemachine.StateMachine) {
 	fieldNameOrderId := sqlbuilder.GetFieldName(NewOrderId)
 	colIdentity := tableConfig.Columns.GetByFieldNameMust(fieldNameOrderId)
 	fieldNameState := sqlbuilder.GetFieldName(NewState)
@@ -111,58 +276,111 @@ func (repo PayOrderRepository) makeStateMachine(tableConfig sqlbuilder.TableConf
 	return stateMachine
 }
 
-func newPayOrderStateMachine(stateRepository statemachine.StateRepository) *statemachine.StateMachine {
-	var actions = statemachine.TransformEvents{
-		{
-			EventName: Action_pay_order_Pay,
-			SrcStates: []string{
-				PayOrderModel_state_pending.String(),
-				PayOrderModel_state_paid.String(), // 支持幂等
-			},
-			DstState: PayOrderModel_state_paid.String(),
+var payOrderTransformEvents = statemachine.TransformEvents{
+	{
+		EventName: Action_pay_order_Pay,
+		SrcStates: []string{
+			PayOrderModel_state_pending.String(),
+			PayOrderModel_state_paid.String(), // 支持幂等
 		},
-		{
-			EventName: Action_pay_order_Close,
-			SrcStates: []string{
-				PayOrderModel_state_pending.String(),
-				PayOrderModel_state_closed.String(), // 支持幂等
-			},
-			DstState: PayOrderModel_state_closed.String(),
+		DstState: PayOrderModel_state_paid.String(),
+	},
+	{
+		EventName: Action_pay_order_Close,
+		SrcStates: []string{
+			PayOrderModel_state_pending.String(),
+			PayOrderModel_state_closed.String(), // 支持幂等
 		},
-	}
-	stateMachine := statemachine.NewStateMachine(actions, stateRepository)
+		DstState: PayOrderModel_state_closed.String(),
+	},
+	{
+		EventName: Action_pay_order_Reopen, // 已支付订单调增金额后重新待支付
+		SrcStates: []string{
+			PayOrderModel_state_paid.String(),
+		},
+		DstState: PayOrderModel_state_pending.String(),
+	},
+}
+
+func newPayOrderStateMachine(stateRepository statemachine.StateRepository) *statemachine.StateMachine {
+	stateMachine := statemachine.NewStateMachine(payOrderTransformEvents, stateRepository)
 	return stateMachine
 }
 
 const (
-	Action_pay_order_Pay   = "actionPay"
-	Action_pay_order_Close = "actionClose"
+	Action_pay_order_Pay    = "actionPay"
+	Action_pay_order_Close  = "actionClose"
+	Action_pay_order_Reopen = "actionReopen"
 )
 
 type PayOrderSetIn struct {
+	MerchantId  string            `json:"merchantId"`
 	OrderId     string            `json:"orderId"`
 	OrderAmount int               `json:"orderAmount"`
 	UserId      string            `json:"userId"`
 	Remark      string            `json:"remark"`
 	Expire      int               `json:"expire"`
+	OrderType   string            `json:"orderType"` // 订单类型，默认固定金额
+	UnitPrice   int               `json:"unitPrice"` // 开放式订单单价，单位分
+	Capacity    int               `json:"capacity"`  // 开放式订单名额上限，0表示不限
+	Deadline    string            `json:"deadline"`  // 开放式订单截止时间，空表示不限
 	ExtraFields sqlbuilder.Fields `json:"extraFields"`
 }
 
 func (in PayOrderSetIn) Fields() sqlbuilder.Fields {
+	orderType := in.OrderType
+	if orderType == "" {
+		orderType = OrderType_fixed
+	}
 	fs := sqlbuilder.Fields{
+		NewMerchantId(in.MerchantId).AppendWhereFn(sqlbuilder.ValueFnForward),
 		NewOrderId(in.OrderId).SetRequired(true).AppendWhereFn(sqlbuilder.ValueFnForward),
-		NewOrderAmount(in.OrderAmount).SetRequired(true).SetMinimum(1),
 		NewUserId(in.UserId),
 		NewRemark(in.Remark),
 		NewCreatedAt(time.Now().Format(time.DateTime)),
 		NewState(PayOrderModel_state_pending.String()),
 		NewExpire(in.Expire),
+		NewOrderType(orderType),
+	}
+	switch orderType {
+	case OrderType_open: // 开放式订单总金额不确定，按单价收费
+		fs = fs.Add(
+			NewOrderAmount(in.OrderAmount),
+			NewUnitPrice(in.UnitPrice).SetRequired(true).SetMinimum(1),
+			NewCapacity(in.Capacity),
+		)
+		if in.Deadline != "" {
+			fs = fs.Add(NewDeadline(in.Deadline))
+		}
+	default:
+		fs = fs.Add(NewOrderAmount(in.OrderAmount).SetRequired(true).SetMinimum(1))
 	}
 	fs = fs.Add(in.ExtraFields...)
 	return fs
 }
 
-func (repo PayOrderRepository) Set(in PayOrderSetIn) (err error) {
+// Set 新增收款单，已存在时不变更；订单已删除时返回 ErrDeleted，订单号已归档时返回 ErrArchived
+func (repo PayOrderDBRepository) Set(in PayOrderSetIn) (err error) {
+	in.MerchantId, err = resolveMerchantId(repo.merchantId, in.MerchantId)
+	if err != nil {
+		return err
+	}
+	_, deleted, err := repo.GetDeleted(in.OrderId)
+	if err != nil {
+		return err
+	}
+	if deleted {
+		err = errors.WithMessagef(ErrDeleted, "订单ID-%s", in.OrderId)
+		return err
+	}
+	_, archived, err := repo.GetHistory(in.OrderId)
+	if err != nil {
+		return err
+	}
+	if archived {
+		err = errors.WithMessagef(ErrArchived, "订单ID-%s", in.OrderId)
+		return err
+	}
 	_, _, _, err = repo.repository.Set(in.Fields(), func(p *sqlbuilder.SetParam) {
 		p.WithPolicy(sqlbuilder.SetPolicy_only_Insert)
 	})
@@ -171,3 +389,143 @@ func (repo PayOrderRepository) Set(in PayOrderSetIn) (err error) {
 	}
 	return nil
 }
+
+func (repo PayOrderDBRepository) GetByOrderId(orderId string) (model PayOrderModel, exists bool, err error) {
+	fs := liveScope(repo.merchantId).Add(
+		NewOrderId(orderId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	exists, err = repo.repository.First(&model, fs)
+	if err != nil {
+		return model, exists, err
+	}
+	return model, exists, nil
+}
+
+func (repo PayOrderDBRepository) GetByOrderIdMust(orderId string) (model PayOrderModel, err error) {
+	model, exists, err := repo.GetByOrderId(orderId)
+	if err != nil {
+		return model, err
+	}
+	if !exists {
+		err = sqlbuilder.ErrNotFound
+		return model, err
+	}
+	return model, nil
+}
+
+// LockByOrderId 在事务内(WithTxHandler)锁定收款单，同一订单的创建支付记录、支付回调串行执行。
+// mysql、postgres 使用 SELECT ... FOR UPDATE，sqlite 不支持行锁，由 immediate 事务串行
+func (repo PayOrderDBRepository) LockByOrderId(orderId string) (model PayOrderModel, exists bool, err error) {
+	table := repo.GetTable()
+	handler := table.GetHandler()
+	driver := sqlbuilder.Driver(handler.GetDialector())
+	dbNameOrderId := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId))
+	dbNameMerchantId := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId))
+	dbNameDeletedAt := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewDeletedAt))
+	ds := driver.GoquDialect().From(table.DBName.Name).
+		Where(goqu.C(dbNameMerchantId).Eq(repo.merchantId), goqu.C(dbNameOrderId).Eq(orderId), notDeletedExpression(goqu.C(dbNameDeletedAt))).Limit(1)
+	if !strings.HasPrefix(driver.String(), "sqlite") {
+		ds = ds.ForUpdate(exp.Wait)
+	}
+	sql, _, err := ds.ToSQL()
+	if err != nil {
+		return model, false, err
+	}
+	exists, err = handler.First(context.Background(), sql, &model)
+	if err != nil {
+		return model, exists, err
+	}
+	return model, exists, nil
+}
+
+// UpdateOrderAmount 调整订单金额
+func (repo PayOrderDBRepository) UpdateOrderAmount(orderId string, orderAmount int) (err error) {
+	fs := liveScope(repo.merchantId).Add(
+		NewOrderId(orderId).SetRequired(true).AppendWhereFn(sqlbuilder.ValueFnForward),
+		NewOrderAmount(orderAmount).SetRequired(true).SetMinimum(1),
+	)
+	err = repo.repository.Update(fs)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AddTotals 使用 col = col + delta 更新，并发事务在行锁上串行，不会丢失更新
+func (repo PayOrderDBRepository) AddTotals(orderId string, delta PayOrderTotals) (err error) {
+	if delta.IsZero() {
+		return nil
+	}
+	table := repo.GetTable()
+	handler := table.GetHandler()
+	dialect := sqlbuilder.Driver(handler.GetDialector()).GoquDialect()
+	record := goqu.Record{}
+	for fieldName, value := range map[string]int{
+		sqlbuilder.GetFieldName(NewPaidAmount):    delta.PaidAmount,
+		sqlbuilder.GetFieldName(NewPendingAmount): delta.PendingAmount,
+		sqlbuilder.GetFieldName(NewRecordCount):   delta.RecordCount,
+	} {
+		if value == 0 {
+			continue
+		}
+		dbName := table.GetDBNameByFieldNameMust(fieldName)
+		record[dbName] = goqu.L("? + ?", goqu.C(dbName), value)
+	}
+	dbNameOrderId := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId))
+	dbNameMerchantId := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId))
+	dbNameDeletedAt := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewDeletedAt))
+	sql, _, err := dialect.Update(table.DBName.Name).Set(record).
+		Where(goqu.C(dbNameMerchantId).Eq(repo.merchantId), goqu.C(dbNameOrderId).Eq(orderId), notDeletedExpression(goqu.C(dbNameDeletedAt))).ToSQL()
+	if err != nil {
+		return err
+	}
+	err = handler.Exec(sql)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (repo PayOrderDBRepository) SetTotals(orderId string, totals PayOrderTotals) (err error) {
+	fs := liveScope(repo.merchantId).Add(
+		NewOrderId(orderId).SetRequired(true).AppendWhereFn(sqlbuilder.ValueFnForward).ShieldUpdate(true),
+		NewPaidAmount(totals.PaidAmount),
+		NewPendingAmount(totals.PendingAmount),
+		NewRecordCount(totals.RecordCount),
+	)
+	err = repo.repository.Update(fs)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (repo PayOrderDBRepository) Delete(orderId string, reason string) (err error) {
+	return markDeleted(repo.GetTable(), repo.merchantId, orderId, reason)
+}
+
+func (repo PayOrderDBRepository) Restore(orderId string) (err error) {
+	return unmarkDeleted(repo.GetTable(), repo.merchantId, orderId)
+}
+
+func (repo PayOrderDBRepository) GetHistory(orderId string) (model PayOrderModel, exists bool, err error) {
+	fs := liveScope(repo.merchantId).Add(
+		NewOrderId(orderId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	exists, err = repo.historyRepository.First(&model, fs)
+	if err != nil {
+		return model, exists, err
+	}
+	return model, exists, nil
+}
+
+func (repo PayOrderDBRepository) GetDeleted(orderId string) (model PayOrderModel, exists bool, err error) {
+	fs := deletedOnlyScope(repo.merchantId).Add(
+		NewOrderId(orderId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	exists, err = repo.repository.First(&model, fs)
+	if err != nil {
+		return model, exists, err
+	}
+	return model, exists, nil
+}
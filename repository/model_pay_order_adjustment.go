@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+/*
+CREATE TABLE `pay_order_adjustment` (
+  `Fid` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '主键',
+  `Fmerchant_id` varchar(64) NOT NULL DEFAULT '' COMMENT '商户ID',
+  `Forder_id` varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  `Fold_order_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '调整前订单金额',
+  `Forder_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '调整后订单金额',
+  `Fpaid_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '调整时已支付金额',
+  `Frefund_due_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '应退款金额',
+  `Fclosed_pay_ids` varchar(255) NOT NULL DEFAULT '' COMMENT '调整时关闭的支付流水号',
+  `Fremark` varchar(255) NOT NULL DEFAULT '' COMMENT '调整原因',
+  `Fcreated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '调整时间',
+  PRIMARY KEY (`Fid`),
+  KEY `ik_Fmerchant_id_Forder_id` (`Fmerchant_id`,`Forder_id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='订单金额调整记录';
+*/
+
+var table_pay_order_adjustment = sqlbuilder.NewTableConfig("pay_order_adjustment").AddColumns(
+	sqlbuilder.NewColumn("Fid", sqlbuilder.GetField(NewId)),
+	sqlbuilder.NewColumn("Fmerchant_id", sqlbuilder.GetField(NewMerchantId)),
+	sqlbuilder.NewColumn("Forder_id", sqlbuilder.GetField(NewOrderId)),
+	sqlbuilder.NewColumn("Fold_order_amount", sqlbuilder.GetField(NewOldOrderAmount)),
+	sqlbuilder.NewColumn("Forder_amount", sqlbuilder.GetField(NewOrderAmount)),
+	sqlbuilder.NewColumn("Fpaid_amount", sqlbuilder.GetField(NewPaidAmount)),
+	sqlbuilder.NewColumn("Frefund_due_amount", sqlbuilder.GetField(NewRefundDueAmount)),
+	sqlbuilder.NewColumn("Fclosed_pay_ids", sqlbuilder.GetField(NewClosedPayIds)),
+	sqlbuilder.NewColumn("Fremark", sqlbuilder.GetField(NewRemark)),
+	sqlbuilder.NewColumn("Fcreated_at", sqlbuilder.GetField(NewCreatedAt)),
+).AddIndexs(
+	sqlbuilder.Index{
+		IsPrimary: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId)),
+			}
+		},
+	},
+).WithComment("订单金额调整记录")
+
+type PayOrderAdjustmentModel struct {
+	Id              int64  `gorm:"column:Fid" json:"id"`
+	MerchantId      string `gorm:"column:Fmerchant_id" json:"merchantId"`
+	OrderId         string `gorm:"column:Forder_id" json:"orderId"`
+	OldOrderAmount  int    `gorm:"column:Fold_order_amount" json:"oldOrderAmount"`
+	OrderAmount     int    `gorm:"column:Forder_amount" json:"orderAmount"`
+	PaidAmount      int    `gorm:"column:Fpaid_amount" json:"paidAmount"`
+	RefundDueAmount int    `gorm:"column:Frefund_due_amount" json:"refundDueAmount"`
+	ClosedPayIds    string `gorm:"column:Fclosed_pay_ids" json:"closedPayIds"`
+	Remark          string `gorm:"column:Fremark" json:"remark"` // 调整原因
+	CreatedAt       string `gorm:"column:Fcreated_at" json:"createdAt"`
+}
+
+type PayOrderAdjustmentModels []PayOrderAdjustmentModel
+
+type PayOrderAdjustmentRepository struct {
+	repository sqlbuilder.Repository
+	merchantId string
+}
+
+func NewPayOrderAdjustmentRepository(handler sqlbuilder.Handler) (repository PayOrderAdjustmentRepository) {
+	tableConfig := table_pay_order_adjustment.WithHandler(handler)
+	repository = PayOrderAdjustmentRepository{
+		repository: sqlbuilder.NewRepository(tableConfig),
+	}
+	return repository
+}
+
+func (repo PayOrderAdjustmentRepository) GetTable() sqlbuilder.TableConfig {
+	return repo.repository.GetTable()
+}
+
+func (repo PayOrderAdjustmentRepository) WithTxHandler(txHandler sqlbuilder.Handler) PayOrderAdjustmentRepository {
+	repo.repository = repo.repository.WithTxHandler(txHandler)
+	return repo
+}
+
+// WithMerchantId 按商户隔离，调整记录写入该商户，只查询该商户的调整记录
+func (repo PayOrderAdjustmentRepository) WithMerchantId(merchantId string) PayOrderAdjustmentRepository {
+	repo.merchantId = merchantId
+	return repo
+}
+
+type PayOrderAdjustmentCreateIn struct {
+	OrderId         string `json:"orderId"`
+	OldOrderAmount  int    `json:"oldOrderAmount"`
+	OrderAmount     int    `json:"orderAmount"`
+	PaidAmount      int    `json:"paidAmount"`
+	RefundDueAmount int    `json:"refundDueAmount"`
+	ClosedPayIds    string `json:"closedPayIds"`
+	Remark          string `json:"remark"`
+}
+
+func (in PayOrderAdjustmentCreateIn) Fields() sqlbuilder.Fields {
+	return sqlbuilder.Fields{
+		NewOrderId(in.OrderId).SetRequired(true),
+		NewOldOrderAmount(in.OldOrderAmount),
+		NewOrderAmount(in.OrderAmount).SetRequired(true),
+		NewPaidAmount(in.PaidAmount),
+		NewRefundDueAmount(in.RefundDueAmount),
+		NewClosedPayIds(in.ClosedPayIds),
+		NewRemark(in.Remark).SetRequired(true),
+		NewCreatedAt(time.Now().Format(time.DateTime)),
+	}
+}
+
+func (repo PayOrderAdjustmentRepository) Create(in PayOrderAdjustmentCreateIn) (err error) {
+	err = repo.repository.Insert(in.Fields().Add(NewMerchantId(repo.merchantId)))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (repo PayOrderAdjustmentRepository) GetByOrderId(orderId string) (models PayOrderAdjustmentModels, err error) {
+	fs := merchantScope(repo.merchantId).Add(
+		NewOrderId(orderId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	err = repo.repository.All(&models, fs)
+	if err != nil {
+		return models, err
+	}
+	return models, nil
+}
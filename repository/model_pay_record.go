@@ -3,40 +3,60 @@ package repository
 import (
 	"errors"
 	"slices"
+	"time"
 
 	"github.com/spf13/cast"
+	"github.com/suifengpiao14/paymentrecord/encryption"
 	"github.com/suifengpiao14/sqlbuilder"
 	"gitlab.huishoubao.com/gopackage/statemachine"
 )
 
 /*
-CREATE TABLE `t_payment_record` (
-  `Fid` int(10) unsigned NOT NULL AUTO_INCREMENT COMMENT '主键',
-    `Fpay_id` varchar(50) NOT NULL DEFAULT '' COMMENT '支付流水号',
-  `Forder_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '订单Id',
-  `Ftotal_amount` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '订单的总金额',
-  `Fpay_amount` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '支付金额',
-  `Fpay_agent` varchar(20)  NOT NULL DEFAULT '' COMMENT '支付机构 weixin:微信 alipay:支付宝',
-  `Fstate` varchar(15) unsigned NOT NULL DEFAULT '1' COMMENT '支付状态 pending-未支付 paid-已支付,expired-已过期,failed-支付失败,closed-已关闭',
-  `Fuser_id` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '用户ID',
- `Fclient_ip` varchar(255) NOT NULL DEFAULT '' COMMENT 'ip地址',
- `Fpay_url` varchar(255) NOT NULL DEFAULT '' COMMENT '支付链接',
- `Freturn_url` varchar(255) NOT NULL DEFAULT '' COMMENT '支付完成后的回调地址',
- `Fnotify_url` varchar(255) NOT NULL DEFAULT '' COMMENT '支付完成后的通知地址',
- `Fpay_param` varchar(255) NOT NULL DEFAULT '' COMMENT '支付参数',
-  `Fpay_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '支付成功时间',
-  `Fclosed_at`datetime NOT NULL DEFAULT '' COMMENT '关闭时间',
-  `Fcreated_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '发起支付时间',
+CREATE TABLE `pay_record` (
+  `Fid` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '主键',
+  `Fmerchant_id` varchar(64) NOT NULL DEFAULT '' COMMENT '商户ID',
+  `Fpay_id` varchar(64) NOT NULL DEFAULT '' COMMENT '支付流水号',
+  `Forder_id` varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  `Forder_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '订单总金额，单位分',
+  `Fpay_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '支付金额，单位分',
+  `Fpay_agent` varchar(15) NOT NULL DEFAULT '' COMMENT '支付类型 weixin-微信 alipay-支付宝',
+  `Frecipient_account` varchar(255) NOT NULL DEFAULT '' COMMENT '收款人账号',
+  `Frecipient_name` varchar(255) NOT NULL DEFAULT '' COMMENT '收款人名称',
+  `Fpayment_account` varchar(255) NOT NULL DEFAULT '' COMMENT '付款人账号',
+  `Fpayment_name` varchar(255) NOT NULL DEFAULT '' COMMENT '付款人名称',
+  `Fstate` varchar(15) NOT NULL DEFAULT '' COMMENT '支付状态 pending-未支付 paid-已支付 expired-已过期 failed-支付失败 closed-已关闭',
+  `Fuser_id` varchar(64) NOT NULL DEFAULT '' COMMENT '用户ID',
+  `Fclient_ip` varchar(255) NOT NULL DEFAULT '' COMMENT '客户端IP地址',
+  `Fpay_url` varchar(255) NOT NULL DEFAULT '' COMMENT '支付链接地址',
+  `Freturn_url` varchar(255) NOT NULL DEFAULT '' COMMENT '支付完成后的回调地址',
+  `Fnotify_url` varchar(255) NOT NULL DEFAULT '' COMMENT '支付完成后的通知地址',
+  `Fpay_param` varchar(255) NOT NULL DEFAULT '' COMMENT '支付参数，json格式',
+  `Fremark` varchar(255) NOT NULL DEFAULT '' COMMENT '支付备注(如失败原因)',
+  `Fexpire` int(11) unsigned NOT NULL DEFAULT '0' COMMENT '超时时间，单位分钟',
+  `Fcreated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '发起支付时间',
+  `Fpaid_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '支付成功时间',
+  `Fclosed_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '关闭时间',
+  `Fexpired_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '过期时间',
+  `Ffailed_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '失败时间',
+  `Fprovider_trade_no` varchar(64) NOT NULL DEFAULT '' COMMENT '支付渠道交易号',
+  `Fpayment_account_bidx` varchar(64) NOT NULL DEFAULT '' COMMENT '付款人账号盲索引',
+  `Fdeleted_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '删除时间',
+  `Fprovider_fee` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '支付渠道手续费，单位分',
+  `Fnet_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '扣除手续费后的实收金额，单位分',
+  `Frefund_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '已退款金额，单位分(无分账的支付记录)',
+  `Fdelete_reason` varchar(255) NOT NULL DEFAULT '' COMMENT '删除原因',
+  `Fcurrency` varchar(8) NOT NULL DEFAULT '' COMMENT '币种',
   PRIMARY KEY (`Fid`),
-  KEY `key_order` (`Forder_id`),
-  KEY `key_user` (`Fuser_id`),
-  KEY `key_pay_agent` (`Fpay_agent`),
-  KEY `key_state` (`Fstate`)
-) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='支付记录';
+  UNIQUE KEY `uk_Fpay_id` (`Fpay_id`),
+  KEY `ik_Fmerchant_id_Forder_id` (`Fmerchant_id`,`Forder_id`),
+  KEY `ik_Fprovider_trade_no` (`Fprovider_trade_no`),
+  KEY `ik_Fpayment_account_bidx` (`Fpayment_account_bidx`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8mb4 COMMENT='收款记录表';
 */
 
 type PayRecordModel struct {
 	Id          int64  `gorm:"column:Fid" json:"id"`
+	MerchantId  string `gorm:"column:Fmerchant_id" json:"merchantId"`
 	PayId       string `gorm:"column:Fpay_id" json:"payId"`
 	OrderId     string `gorm:"column:Forder_id" json:"orderId"`
 	OrderAmount int    `gorm:"column:Forder_amount" json:"orderAmount"`
@@ -56,6 +76,22 @@ type PayRecordModel struct {
 	ClosedAt    string `gorm:"column:Fclosed_at" json:"closedAt"`
 	ExpiredAt   string `gorm:"column:Fexpired_at" json:"expiredAt"`
 	FailedAt    string `gorm:"column:Ffailed_at" json:"failedAt"`
+	// 支付渠道交易号，支付回调时写入，用于识别重复回调
+	ProviderTradeNo string `gorm:"column:Fprovider_trade_no" json:"providerTradeNo"`
+	// 收付款人账号、名称及客户端IP启用加密(WithCipher)后密文存储，读取时解密
+	RecipientAccount         string `gorm:"column:Frecipient_account" json:"recipientAccount"`
+	RecipientName            string `gorm:"column:Frecipient_name" json:"recipientName"`
+	PaymentAccount           string `gorm:"column:Fpayment_account" json:"paymentAccount"`
+	PaymentName              string `gorm:"column:Fpayment_name" json:"paymentName"`
+	PaymentAccountBlindIndex string `gorm:"column:Fpayment_account_bidx" json:"-"`
+	DeletedAt                string `gorm:"column:Fdeleted_at" json:"deletedAt"` // 软删除时间，随收款单删除
+	// 支付成功时按支付方式的手续费规则计算，渠道回调带实际手续费时以渠道为准
+	ProviderFee int `gorm:"column:Fprovider_fee" json:"providerFee"`
+	NetAmount   int `gorm:"column:Fnet_amount" json:"netAmount"`
+	// 累计退款金额，有分账的支付记录退款分摊在分账上(见 PaySplitModel.RefundAmount)，不写此字段
+	RefundAmount int    `gorm:"column:Frefund_amount" json:"refundAmount"`
+	DeleteReason string `gorm:"column:Fdelete_reason" json:"deleteReason"`
+	Currency     string `gorm:"column:Fcurrency" json:"currency"` // 为空时为 CNY
 }
 
 type PayRecordModels []PayRecordModel
@@ -135,18 +171,19 @@ func (ms PayRecordModels) IsOrderPayFinished() (payfinished bool) {
 
 var table_pay_record = sqlbuilder.NewTableConfig("pay_record").AddColumns(
 	sqlbuilder.NewColumn("Fid", sqlbuilder.GetField(NewId)),
+	sqlbuilder.NewColumn("Fmerchant_id", sqlbuilder.GetField(NewMerchantId)),
 	sqlbuilder.NewColumn("Fpay_id", sqlbuilder.GetField(NewPayId)),
 	sqlbuilder.NewColumn("Forder_id", sqlbuilder.GetField(NewOrderId)),
 	sqlbuilder.NewColumn("Forder_amount", sqlbuilder.GetField(NewOrderAmount)),
 	sqlbuilder.NewColumn("Fpay_amount", sqlbuilder.GetField(NewPayAmount)),
 	sqlbuilder.NewColumn("Fpay_agent", sqlbuilder.GetField(NewPayAgent)),
-	sqlbuilder.NewColumn("Frecipient_account", sqlbuilder.GetField(NewRecipientAccount)),
-	sqlbuilder.NewColumn("Frecipient_name", sqlbuilder.GetField(NewRecipientName)),
-	sqlbuilder.NewColumn("Fpayment_account", sqlbuilder.GetField(NewPaymentAccount)),
-	sqlbuilder.NewColumn("Fpayment_name", sqlbuilder.GetField(NewPaymentName)),
+	sqlbuilder.NewColumn("Frecipient_account", sqlbuilder.GetField(NewRecipientAccount)).WithLength(Length_encrypted),
+	sqlbuilder.NewColumn("Frecipient_name", sqlbuilder.GetField(NewRecipientName)).WithLength(Length_encrypted),
+	sqlbuilder.NewColumn("Fpayment_account", sqlbuilder.GetField(NewPaymentAccount)).WithLength(Length_encrypted),
+	sqlbuilder.NewColumn("Fpayment_name", sqlbuilder.GetField(NewPaymentName)).WithLength(Length_encrypted),
 	sqlbuilder.NewColumn("Fstate", sqlbuilder.GetField(NewState)),
 	sqlbuilder.NewColumn("Fuser_id", sqlbuilder.GetField(NewUserId)),
-	sqlbuilder.NewColumn("Fclient_ip", sqlbuilder.GetField(NewClientIp)),
+	sqlbuilder.NewColumn("Fclient_ip", sqlbuilder.GetField(NewClientIp)).WithLength(Length_encrypted),
 	sqlbuilder.NewColumn("Fpay_url", sqlbuilder.GetField(NewPayUrl)),
 	sqlbuilder.NewColumn("Freturn_url", sqlbuilder.GetField(NewReturnUrl)),
 	sqlbuilder.NewColumn("Fnotify_url", sqlbuilder.GetField(NewNotifyUrl)),
@@ -158,6 +195,14 @@ var table_pay_record = sqlbuilder.NewTableConfig("pay_record").AddColumns(
 	sqlbuilder.NewColumn("Fclosed_at", sqlbuilder.GetField(NewClosedAt)),
 	sqlbuilder.NewColumn("Fexpired_at", sqlbuilder.GetField(NewExpiredAt)),
 	sqlbuilder.NewColumn("Ffailed_at", sqlbuilder.GetField(NewFailedAt)),
+	sqlbuilder.NewColumn("Fprovider_trade_no", sqlbuilder.GetField(NewProviderTradeNo)),
+	sqlbuilder.NewColumn("Fpayment_account_bidx", sqlbuilder.GetField(NewPaymentAccountBlindIndex)),
+	sqlbuilder.NewColumn("Fdeleted_at", sqlbuilder.GetField(NewDeletedAt)),
+	sqlbuilder.NewColumn("Fprovider_fee", sqlbuilder.GetField(NewProviderFee)),
+	sqlbuilder.NewColumn("Fnet_amount", sqlbuilder.GetField(NewNetAmount)),
+	sqlbuilder.NewColumn("Frefund_amount", sqlbuilder.GetField(NewRefundAmount)),
+	sqlbuilder.NewColumn("Fdelete_reason", sqlbuilder.GetField(NewDeleteReason)),
+	sqlbuilder.NewColumn("Fcurrency", sqlbuilder.GetField(NewCurrency)),
 ).AddIndexs(
 	sqlbuilder.Index{
 		IsPrimary: true,
@@ -166,7 +211,7 @@ var table_pay_record = sqlbuilder.NewTableConfig("pay_record").AddColumns(
 			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))}
 		},
 	},
-	sqlbuilder.Index{
+	sqlbuilder.Index{ // 支付流水号由系统生成(见 IdGenerator)，全局唯一，分账、账本、超付等记录据此关联，不按商户区分
 		Unique: true,
 		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
 			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPayId))}
@@ -174,54 +219,129 @@ var table_pay_record = sqlbuilder.NewTableConfig("pay_record").AddColumns(
 	},
 	sqlbuilder.Index{
 		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
-			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId))}
+			return []string{
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId)),
+			}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewProviderTradeNo))}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPaymentAccountBlindIndex))}
 		},
 	},
 ).WithComment("收款记录表")
 
-type PayRecordRepository struct {
-	stateMachine statemachine.StateMachine
-	repository   sqlbuilder.Repository
+// PayRecordRepository 支付记录仓库，状态变更需遵循 payRecordTransformEvents 定义的状态机规则
+type PayRecordRepository interface {
+	WithTxHandler(txHandler sqlbuilder.Handler) PayRecordRepository
+	// WithMerchantId 按商户隔离，返回的仓库只读写该商户的支付记录，默认为空商户
+	WithMerchantId(merchantId string) PayRecordRepository
+	// WithCipher 收付款人账号、名称及客户端IP按 cipher 加密存储，nil 时明文存储；读取时兼容明文，
+	// 启用加密后可通过 RotatePayRecordKeys 加密存量数据
+	WithCipher(cipher *encryption.Cipher) PayRecordRepository
+	Create(in PayRecordCreateIn) (err error)
+	GetByPayId(payId string) (model PayRecordModel, exists bool, err error)
+	GetByPayIdMust(payId string) (model PayRecordModel, err error)
+	GetByOrderId(orderId string) (models PayRecordModels, err error)
+	GetByProviderTradeNo(providerTradeNo string) (models PayRecordModels, err error)
+	GetByPaymentAccount(paymentAccount string) (models PayRecordModels, err error)
+	GetAllPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModels PayRecordModels, err error)
+	GetFirstPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModel PayRecordModel, err error)
+	// CountPayRecordByConditon 按条件统计支付记录数，不加载明细
+	CountPayRecordByConditon(whereFs sqlbuilder.Fields) (count int64, err error)
+	// GetUserAmountSince 用户自 since 起创建的待支付、已支付记录金额合计
+	GetUserAmountSince(userId string, since time.Time) (amount int, err error)
+	// GetPendingStats 按支付方式统计待支付记录数及金额，不加载明细
+	GetPendingStats() (stats PendingStats, err error)
+	UpdateOrderAmount(orderId string, orderAmount int) (err error)
+	// SetRefundAmount 更新无分账支付记录的累计退款金额
+	SetRefundAmount(payId string, refundAmount int) (err error)
+	CanAsErr(state string, event string) (err error)
+	Transform(event string, srcState string, payId string, extraFs ...*sqlbuilder.Field) (err error)
+	TransformByIdentity(event string, payId string, extraFs ...*sqlbuilder.Field) (err error)
+	// GetHistoryByPayId、GetHistoryByOrderId 读取已归档(ArchivePayOrders)的支付记录，在线表查询不到时使用
+	GetHistoryByPayId(payId string) (model PayRecordModel, exists bool, err error)
+	GetHistoryByOrderId(orderId string) (models PayRecordModels, err error)
+	// DeleteByOrderId 随收款单软删除订单下的支付记录；RestoreByOrderId 恢复
+	DeleteByOrderId(orderId string, reason string) (err error)
+	RestoreByOrderId(orderId string) (err error)
+	GetDeletedByOrderId(orderId string) (models PayRecordModels, err error)
 }
 
-func NewPayRecordRepository(handler sqlbuilder.Handler) (repository PayRecordRepository) {
+// PayRecordDBRepository 基于数据库的支付记录仓库
+type PayRecordDBRepository struct {
+	stateMachine      statemachine.StateMachine
+	repository        sqlbuilder.Repository
+	historyRepository sqlbuilder.Repository // 归档表，见 ArchivePayOrders
+	merchantId        string
+	cipher            *encryption.Cipher
+}
+
+func NewPayRecordRepository(handler sqlbuilder.Handler) PayRecordRepository {
+	return NewPayRecordDBRepository(handler)
+}
+
+func NewPayRecordDBRepository(handler sqlbuilder.Handler) (repository PayRecordDBRepository) {
 	tableConfig := table_pay_record.WithHandler(handler)
 	stateMachine := repository.makeStateMachine(tableConfig)
-	repository = PayRecordRepository{
-		stateMachine: *stateMachine,
-		repository:   sqlbuilder.NewRepository(tableConfig),
+	repository = PayRecordDBRepository{
+		stateMachine:      *stateMachine,
+		repository:        sqlbuilder.NewRepository(tableConfig),
+		historyRepository: sqlbuilder.NewRepository(table_pay_record_history.WithHandler(handler)),
 	}
 	return repository
 }
 
-func (repo PayRecordRepository) GetStateMachine() statemachine.StateMachine {
+func (repo PayRecordDBRepository) GetStateMachine() statemachine.StateMachine {
 	return repo.stateMachine
 }
-func (repo PayRecordRepository) GetAllPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModels PayRecordModels, err error) {
+func (repo PayRecordDBRepository) GetAllPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModels PayRecordModels, err error) {
 	if len(whereFs) == 0 {
 		err = errors.New("whereFs 不能为空")
 		return nil, err
 	}
-	err = repo.repository.All(&payRecordModels, whereFs)
+	err = repo.repository.All(&payRecordModels, liveScope(repo.merchantId).Add(whereFs...))
+	if err != nil {
+		return nil, err
+	}
+	err = payRecordModels.decrypt(repo.cipher)
 	if err != nil {
 		return nil, err
 	}
 	return payRecordModels, nil
 
 }
-func (repo PayRecordRepository) GetFirstPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModel PayRecordModel, err error) {
+func (repo PayRecordDBRepository) CountPayRecordByConditon(whereFs sqlbuilder.Fields) (count int64, err error) {
+	if len(whereFs) == 0 {
+		err = errors.New("whereFs 不能为空")
+		return 0, err
+	}
+	return repo.repository.Count(liveScope(repo.merchantId).Add(whereFs...))
+}
+
+func (repo PayRecordDBRepository) GetFirstPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModel PayRecordModel, err error) {
 	if len(whereFs) == 0 {
 		err = errors.New("whereFs 不能为空")
 		return payRecordModel, err
 	}
-	err = repo.repository.FirstMustExists(&payRecordModel, whereFs)
+	err = repo.repository.FirstMustExists(&payRecordModel, liveScope(repo.merchantId).Add(whereFs...))
+	if err != nil {
+		return payRecordModel, err
+	}
+	err = payRecordModel.decrypt(repo.cipher)
 	if err != nil {
 		return payRecordModel, err
 	}
 	return payRecordModel, nil
 }
 
-func (payRecordRepository PayRecordRepository) makeStateMachine(tableConfig sqlbuilder.TableConfig) (stateMachine *statemachine.StateMachine) {
+func (repo PayRecordDBRepository) makeStateMachine(tableConfig sqlbuilder.TableConfig) (stateMachine *statemachine.StateMachine) {
 	fieldNamePayId := sqlbuilder.GetFieldName(NewPayId)
 	colIdentity := tableConfig.Columns.GetByFieldNameMust(fieldNamePayId)
 	fieldNameState := sqlbuilder.GetFieldName(NewState)
@@ -237,44 +357,45 @@ func (payRecordRepository PayRecordRepository) makeStateMachine(tableConfig sqlb
 	return stateMachine
 }
 
-func newPayRecordStateMachine(stateRepository statemachine.StateRepository) *statemachine.StateMachine {
-	var actions = statemachine.TransformEvents{
-		{
-			EventName: Action_pay_record_Pay,
-			SrcStates: []string{
-				PayOrderModel_state_pending.String(),
-				PayOrderModel_state_failed.String(), // 支付失败可以继续支付（比如钱包金额不够、选中的优惠券过期等，充值后再支付，增加支付失败状态可以记录原因）
-				PayOrderModel_state_paid.String(),   // 支持幂等
-			},
-			DstState: PayOrderModel_state_paid.String(),
+var payRecordTransformEvents = statemachine.TransformEvents{
+	{
+		EventName: Action_pay_record_Pay,
+		SrcStates: []string{
+			PayOrderModel_state_pending.String(),
+			PayOrderModel_state_failed.String(), // 支付失败可以继续支付（比如钱包金额不够、选中的优惠券过期等，充值后再支付，增加支付失败状态可以记录原因）
+			PayOrderModel_state_paid.String(),   // 支持幂等
 		},
-		{
-			EventName: Action_pay_record_Expire, // 过期时需要先同步查询，看是否已经支付（比如消息异常导致未同步到数据）
-
-			SrcStates: []string{
-				PayOrderModel_state_pending.String(),
-				PayOrderModel_state_expired.String(), // 支持幂等
-			},
-			DstState: PayOrderModel_state_expired.String(),
+		DstState: PayOrderModel_state_paid.String(),
+	},
+	{
+		EventName: Action_pay_record_Expire, // 过期时需要先同步查询，看是否已经支付（比如消息异常导致未同步到数据）
+
+		SrcStates: []string{
+			PayOrderModel_state_pending.String(),
+			PayOrderModel_state_expired.String(), // 支持幂等
 		},
-		{
-			EventName: Action_pay_record_Fail,
-			SrcStates: []string{
-				PayOrderModel_state_pending.String(),
-				PayOrderModel_state_failed.String(), // 支持幂等
-			},
-			DstState: PayOrderModel_state_failed.String(),
+		DstState: PayOrderModel_state_expired.String(),
+	},
+	{
+		EventName: Action_pay_record_Fail,
+		SrcStates: []string{
+			PayOrderModel_state_pending.String(),
+			PayOrderModel_state_failed.String(), // 支持幂等
 		},
-		{
-			EventName: Action_pay_record_Close,
-			SrcStates: []string{
-				PayOrderModel_state_pending.String(),
-				PayOrderModel_state_closed.String(), // 支持幂等
-			},
-			DstState: PayOrderModel_state_closed.String(),
+		DstState: PayOrderModel_state_failed.String(),
+	},
+	{
+		EventName: Action_pay_record_Close,
+		SrcStates: []string{
+			PayOrderModel_state_pending.String(),
+			PayOrderModel_state_closed.String(), // 支持幂等
 		},
-	}
-	stateMachine := statemachine.NewStateMachine(actions, stateRepository)
+		DstState: PayOrderModel_state_closed.String(),
+	},
+}
+
+func newPayRecordStateMachine(stateRepository statemachine.StateRepository) *statemachine.StateMachine {
+	stateMachine := statemachine.NewStateMachine(payRecordTransformEvents, stateRepository)
 	return stateMachine
 }
 
@@ -286,10 +407,12 @@ const (
 )
 
 type PayRecordCreateIn struct {
+	MerchantId       string `json:"merchantId"`
 	PayId            string `json:"payId"`
 	OrderId          string `json:"orderId"`
 	OrderAmount      int    `json:"totalAmount"`
 	PayAmount        int    `json:"payAmount"`
+	Currency         string `json:"currency"`
 	PayAgent         string `json:"payAgent"`
 	State            string `json:"state"`
 	UserId           string `json:"userId"`
@@ -307,57 +430,115 @@ type PayRecordCreateIn struct {
 }
 
 func (in PayRecordCreateIn) Fields() sqlbuilder.Fields {
+	return in.fields(nil)
+}
+
+// fields 敏感字段按 cipher 加密写入，cipher 为 nil 时明文存储
+func (in PayRecordCreateIn) fields(cipher *encryption.Cipher) sqlbuilder.Fields {
 	return sqlbuilder.Fields{
+		NewMerchantId(in.MerchantId),
 		NewPayId(in.PayId).SetRequired(true),
 		NewOrderId(in.OrderId).SetRequired(true),
 		NewOrderAmount(in.OrderAmount).SetRequired(true),
 		NewPayAmount(in.PayAmount).SetRequired(true),
-		NewPayAgent(in.PayAgent).SetRequired(true),
+		NewCurrency(in.Currency),
+		newPayAgentUnchecked(in.PayAgent).SetRequired(true),
 		NewState(in.State).SetRequired(true),
 		NewUserId(in.UserId).SetRequired(true),
-		NewClientIp(in.ClientIp),
+		NewClientIp(in.ClientIp).AppendValueFn(encryptValueFn(cipher)),
 		NewPayUrl(in.PayUrl),
 		NewReturnUrl(in.ReturnUrl),
 		NewNotifyUrl(in.NotifyUrl),
 		NewPayParam(in.PayParam),
 		NewExpire(in.Expire),
 		NewRemark(in.Remark),
-		NewRecipientAccount(in.RecipientAccount),
-		NewRecipientName(in.RecipientName),
-		NewPaymentAccount(in.PaymentAccount),
-		NewPaymentName(in.PaymentName),
+		NewRecipientAccount(in.RecipientAccount).AppendValueFn(encryptValueFn(cipher)),
+		NewRecipientName(in.RecipientName).AppendValueFn(encryptValueFn(cipher)),
+		NewPaymentAccount(in.PaymentAccount).AppendValueFn(encryptValueFn(cipher)),
+		NewPaymentName(in.PaymentName).AppendValueFn(encryptValueFn(cipher)),
+		NewPaymentAccountBlindIndex(in.PaymentAccount).AppendValueFn(blindIndexValueFn(cipher)),
 	}
 }
 
-func (repo PayRecordRepository) GetTable() sqlbuilder.TableConfig {
+func (repo PayRecordDBRepository) GetTable() sqlbuilder.TableConfig {
 	return repo.repository.GetTable()
 }
 
-func (repo PayRecordRepository) Create(in PayRecordCreateIn) (err error) {
-	err = repo.repository.Insert(in.Fields())
+func (repo PayRecordDBRepository) Create(in PayRecordCreateIn) (err error) {
+	in.MerchantId, err = resolveMerchantId(repo.merchantId, in.MerchantId)
+	if err != nil {
+		return err
+	}
+	err = repo.repository.Insert(in.fields(repo.cipher))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (repo PayRecordRepository) WithTxHandler(txHandler sqlbuilder.Handler) PayRecordRepository {
+func (repo PayRecordDBRepository) WithTxHandler(txHandler sqlbuilder.Handler) PayRecordRepository {
 	repo.repository = repo.repository.WithTxHandler(txHandler)
+	repo.historyRepository = repo.historyRepository.WithTxHandler(txHandler)
+	repo.stateMachine = repo.stateMachine.WithTxHandler(txHandler)
 	return repo
 }
 
-func (repo PayRecordRepository) GetByPayId(payId string) (model PayRecordModel, exists bool, err error) {
-	fs := sqlbuilder.Fields{
-		NewPayId(payId).AppendWhereFn(sqlbuilder.ValueFnForward),
+func (repo PayRecordDBRepository) WithMerchantId(merchantId string) PayRecordRepository {
+	repo.merchantId = merchantId
+	return repo
+}
+
+func (repo PayRecordDBRepository) WithCipher(cipher *encryption.Cipher) PayRecordRepository {
+	repo.cipher = cipher
+	return repo
+}
+
+func (repo PayRecordDBRepository) CanAsErr(state string, event string) (err error) {
+	return repo.stateMachine.CanAsErr(state, event)
+}
+
+// Transform 状态机按支付流水号(全局唯一)更新，先校验支付记录属于当前商户
+func (repo PayRecordDBRepository) Transform(event string, srcState string, payId string, extraFs ...*sqlbuilder.Field) (err error) {
+	err = repo.checkMerchant(payId)
+	if err != nil {
+		return err
+	}
+	return repo.stateMachine.Transform(event, srcState, payId, extraFs...)
+}
+
+func (repo PayRecordDBRepository) TransformByIdentity(event string, payId string, extraFs ...*sqlbuilder.Field) (err error) {
+	err = repo.checkMerchant(payId)
+	if err != nil {
+		return err
+	}
+	return repo.stateMachine.TransformByIdentity(event, payId, extraFs...)
+}
+
+// checkMerchant 支付记录不属于当前商户时返回 sqlbuilder.ErrNotFound
+func (repo PayRecordDBRepository) checkMerchant(payId string) (err error) {
+	_, err = repo.GetByPayIdMust(payId)
+	if err != nil {
+		return err
 	}
+	return nil
+}
+
+func (repo PayRecordDBRepository) GetByPayId(payId string) (model PayRecordModel, exists bool, err error) {
+	fs := liveScope(repo.merchantId).Add(
+		NewPayId(payId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
 	exists, err = repo.repository.First(&model, fs)
 	if err != nil {
 		return model, exists, err
 	}
+	err = model.decrypt(repo.cipher)
+	if err != nil {
+		return model, exists, err
+	}
 	return model, exists, nil
 }
 
-func (repo PayRecordRepository) GetByPayIdMust(payId string) (model PayRecordModel, err error) {
+func (repo PayRecordDBRepository) GetByPayIdMust(payId string) (model PayRecordModel, err error) {
 	model, exists, err := repo.GetByPayId(payId)
 	if err != nil {
 		return model, err
@@ -369,13 +550,114 @@ func (repo PayRecordRepository) GetByPayIdMust(payId string) (model PayRecordMod
 	return model, nil
 }
 
-func (repo PayRecordRepository) GetByOrderId(orderId string) (models PayRecordModels, err error) {
-	fs := sqlbuilder.Fields{
+func (repo PayRecordDBRepository) GetByOrderId(orderId string) (models PayRecordModels, err error) {
+	fs := liveScope(repo.merchantId).Add(
 		NewOrderId(orderId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	err = repo.repository.All(&models, fs)
+	if err != nil {
+		return models, err
+	}
+	err = models.decrypt(repo.cipher)
+	if err != nil {
+		return models, err
+	}
+	return models, nil
+}
+
+// UpdateOrderAmount 订单金额调整后，同步更新订单下有效(待支付、已支付)支付记录的订单金额
+func (repo PayRecordDBRepository) UpdateOrderAmount(orderId string, orderAmount int) (err error) {
+	fs := liveScope(repo.merchantId).Add(
+		NewOrderId(orderId).SetRequired(true).AppendWhereFn(sqlbuilder.ValueFnForward),
+		NewState("").SetValue(EffectStates).AppendWhereFn(sqlbuilder.ValueFnForward).ShieldUpdate(true),
+		NewOrderAmount(orderAmount).SetRequired(true),
+	)
+	err = repo.repository.Update(fs)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (repo PayRecordDBRepository) SetRefundAmount(payId string, refundAmount int) (err error) {
+	fs := liveScope(repo.merchantId).Add(
+		NewPayId(payId).SetRequired(true).AppendWhereFn(sqlbuilder.ValueFnForward),
+		NewRefundAmount(refundAmount),
+	)
+	err = repo.repository.Update(fs)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (repo PayRecordDBRepository) GetByProviderTradeNo(providerTradeNo string) (models PayRecordModels, err error) {
+	if providerTradeNo == "" {
+		err = errors.New("providerTradeNo 不能为空")
+		return nil, err
+	}
+	fs := liveScope(repo.merchantId).Add(
+		NewProviderTradeNo(providerTradeNo).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	err = repo.repository.All(&models, fs)
+	if err != nil {
+		return models, err
+	}
+	err = models.decrypt(repo.cipher)
+	if err != nil {
+		return models, err
+	}
+	return models, nil
+}
+
+// GetByPaymentAccount 按付款人账号查询，启用加密后通过盲索引查询，存量明文数据需先执行 RotatePayRecordKeys 建立盲索引
+func (repo PayRecordDBRepository) GetByPaymentAccount(paymentAccount string) (models PayRecordModels, err error) {
+	if paymentAccount == "" {
+		err = errors.New("paymentAccount 不能为空")
+		return nil, err
+	}
+	fs := liveScope(repo.merchantId).Add(
+		NewPaymentAccount(paymentAccount).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	if repo.cipher != nil {
+		blindIndex, err := repo.cipher.BlindIndex(paymentAccount)
+		if err != nil {
+			return nil, err
+		}
+		fs = liveScope(repo.merchantId).Add(
+			NewPaymentAccountBlindIndex(blindIndex).AppendWhereFn(sqlbuilder.ValueFnForward),
+		)
+	}
+	err = repo.repository.All(&models, fs)
+	if err != nil {
+		return models, err
+	}
+	err = models.decrypt(repo.cipher)
+	if err != nil {
+		return models, err
 	}
+	return models, nil
+}
+
+func (repo PayRecordDBRepository) DeleteByOrderId(orderId string, reason string) (err error) {
+	return markDeleted(repo.GetTable(), repo.merchantId, orderId, reason)
+}
+
+func (repo PayRecordDBRepository) RestoreByOrderId(orderId string) (err error) {
+	return unmarkDeleted(repo.GetTable(), repo.merchantId, orderId)
+}
+
+func (repo PayRecordDBRepository) GetDeletedByOrderId(orderId string) (models PayRecordModels, err error) {
+	fs := deletedOnlyScope(repo.merchantId).Add(
+		NewOrderId(orderId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
 	err = repo.repository.All(&models, fs)
 	if err != nil {
 		return models, err
 	}
+	err = models.decrypt(repo.cipher)
+	if err != nil {
+		return models, err
+	}
 	return models, nil
 }
@@ -0,0 +1,407 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/suifengpiao14/paymentrecord/encryption"
+	"github.com/suifengpiao14/sqlbuilder"
+	"gitlab.huishoubao.com/gopackage/statemachine"
+)
+
+/*
+CREATE TABLE `pay_split` (
+  `Fid` bigint(20) unsigned NOT NULL AUTO_INCREMENT COMMENT '主键',
+  `Fsplit_id` varchar(80) NOT NULL DEFAULT '' COMMENT '分账流水号',
+  `Fmerchant_id` varchar(64) NOT NULL DEFAULT '' COMMENT '商户ID',
+  `Fpay_id` varchar(64) NOT NULL DEFAULT '' COMMENT '支付流水号',
+  `Forder_id` varchar(64) NOT NULL DEFAULT '' COMMENT '订单号',
+  `Frecipient_account` varchar(255) NOT NULL DEFAULT '' COMMENT '收款人账号',
+  `Frecipient_name` varchar(255) NOT NULL DEFAULT '' COMMENT '收款人名称',
+  `Frecipient_account_bidx` varchar(64) NOT NULL DEFAULT '' COMMENT '收款人账号盲索引',
+  `Fsplit_role` varchar(32) NOT NULL DEFAULT '' COMMENT '分账角色',
+  `Fsplit_rate` int(10) unsigned NOT NULL DEFAULT '0' COMMENT '分账比例，万分比',
+  `Fsplit_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '分账金额，单位分',
+  `Frefund_amount` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '已退款金额，单位分',
+  `Fstate` varchar(15) NOT NULL DEFAULT '' COMMENT '分账状态 pending-未支付 settleable-待结算 settled-已结算 refunded-已退款',
+  `Fcreated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '创建时间',
+  `Fsettled_at` datetime NOT NULL DEFAULT '0000-00-00 00:00:00' COMMENT '结算时间',
+  PRIMARY KEY (`Fid`),
+  UNIQUE KEY `uk_split_id` (`Fsplit_id`),
+  KEY `key_pay_id` (`Fpay_id`),
+  KEY `key_merchant_id_pay_id` (`Fmerchant_id`,`Fpay_id`),
+  KEY `key_recipient_account` (`Frecipient_account`),
+  KEY `key_recipient_account_bidx` (`Frecipient_account_bidx`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8 COMMENT='支付分账表';
+*/
+
+var table_pay_split = sqlbuilder.NewTableConfig("pay_split").AddColumns(
+	sqlbuilder.NewColumn("Fid", sqlbuilder.GetField(NewId)),
+	sqlbuilder.NewColumn("Fsplit_id", sqlbuilder.GetField(NewSplitId)),
+	sqlbuilder.NewColumn("Fmerchant_id", sqlbuilder.GetField(NewMerchantId)),
+	sqlbuilder.NewColumn("Fpay_id", sqlbuilder.GetField(NewPayId)),
+	sqlbuilder.NewColumn("Forder_id", sqlbuilder.GetField(NewOrderId)),
+	sqlbuilder.NewColumn("Frecipient_account", sqlbuilder.GetField(NewRecipientAccount)).WithLength(Length_encrypted),
+	sqlbuilder.NewColumn("Frecipient_name", sqlbuilder.GetField(NewRecipientName)).WithLength(Length_encrypted),
+	sqlbuilder.NewColumn("Frecipient_account_bidx", sqlbuilder.GetField(NewRecipientAccountBlindIndex)),
+	sqlbuilder.NewColumn("Fsplit_role", sqlbuilder.GetField(NewSplitRole)),
+	sqlbuilder.NewColumn("Fsplit_rate", sqlbuilder.GetField(NewSplitRate)),
+	sqlbuilder.NewColumn("Fsplit_amount", sqlbuilder.GetField(NewSplitAmount)),
+	sqlbuilder.NewColumn("Frefund_amount", sqlbuilder.GetField(NewRefundAmount)),
+	sqlbuilder.NewColumn("Fstate", sqlbuilder.GetField(NewSplitState)),
+	sqlbuilder.NewColumn("Fcreated_at", sqlbuilder.GetField(NewCreatedAt)),
+	sqlbuilder.NewColumn("Fsettled_at", sqlbuilder.GetField(NewSettledAt)),
+).AddIndexs(
+	sqlbuilder.Index{
+		IsPrimary: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))}
+		},
+	},
+	sqlbuilder.Index{
+		Unique: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewSplitId))}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPayId))}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPayId)),
+			}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewRecipientAccount))}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewRecipientAccountBlindIndex))}
+		},
+	},
+).WithComment("支付分账表")
+
+type PaySplitModel struct {
+	Id         int64  `gorm:"column:Fid" json:"id"`
+	SplitId    string `gorm:"column:Fsplit_id" json:"splitId"`
+	MerchantId string `gorm:"column:Fmerchant_id" json:"merchantId"`
+	PayId      string `gorm:"column:Fpay_id" json:"payId"`
+	OrderId    string `gorm:"column:Forder_id" json:"orderId"`
+	// 收款人账号、名称启用加密(WithCipher)后密文存储，读取时解密
+	RecipientAccount           string `gorm:"column:Frecipient_account" json:"recipientAccount"`
+	RecipientName              string `gorm:"column:Frecipient_name" json:"recipientName"`
+	RecipientAccountBlindIndex string `gorm:"column:Frecipient_account_bidx" json:"-"`
+	SplitRole                  string `gorm:"column:Fsplit_role" json:"splitRole"`
+	SplitRate                  int    `gorm:"column:Fsplit_rate" json:"splitRate"`
+	SplitAmount                int    `gorm:"column:Fsplit_amount" json:"splitAmount"`
+	RefundAmount               int    `gorm:"column:Frefund_amount" json:"refundAmount"`
+	State                      string `gorm:"column:Fstate" json:"state"`
+	CreatedAt                  string `gorm:"column:Fcreated_at" json:"createdAt"`
+	SettledAt                  string `gorm:"column:Fsettled_at" json:"settledAt"`
+}
+
+// RestAmount 扣除退款后的分账金额
+func (m PaySplitModel) RestAmount() int {
+	return m.SplitAmount - m.RefundAmount
+}
+
+type PaySplitModels []PaySplitModel
+
+func (ms PaySplitModels) TotalAmount() int {
+	var total = 0
+	for _, m := range ms {
+		total += m.SplitAmount
+	}
+	return total
+}
+
+func (ms PaySplitModels) RestAmount() int {
+	var total = 0
+	for _, m := range ms {
+		total += m.RestAmount()
+	}
+	return total
+}
+
+func (ms PaySplitModels) RefundAmount() int {
+	var total = 0
+	for _, m := range ms {
+		total += m.RefundAmount
+	}
+	return total
+}
+
+func (ms PaySplitModels) FilterByState(state ...string) (filtered PaySplitModels) {
+	for _, m := range ms {
+		for _, s := range state {
+			if m.State == s {
+				filtered = append(filtered, m)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+type PaySplitRepository struct {
+	stateMachine statemachine.StateMachine
+	repository   sqlbuilder.Repository
+	cipher       *encryption.Cipher
+	merchantId   string
+}
+
+func NewPaySplitRepository(handler sqlbuilder.Handler) (repository PaySplitRepository) {
+	tableConfig := table_pay_split.WithHandler(handler)
+	stateMachine := repository.makeStateMachine(tableConfig)
+	repository = PaySplitRepository{
+		stateMachine: *stateMachine,
+		repository:   sqlbuilder.NewRepository(tableConfig),
+	}
+	return repository
+}
+
+func (repo PaySplitRepository) GetStateMachine() statemachine.StateMachine {
+	return repo.stateMachine
+}
+
+func (repo PaySplitRepository) GetTable() sqlbuilder.TableConfig {
+	return repo.repository.GetTable()
+}
+
+func (repo PaySplitRepository) WithTxHandler(txHandler sqlbuilder.Handler) PaySplitRepository {
+	repo.repository = repo.repository.WithTxHandler(txHandler)
+	return repo
+}
+
+// WithCipher 收款人账号、名称按 cipher 加密存储，nil 时明文存储；存量数据通过 RotatePaySplitKeys 加密
+func (repo PaySplitRepository) WithCipher(cipher *encryption.Cipher) PaySplitRepository {
+	repo.cipher = cipher
+	return repo
+}
+
+// WithMerchantId 按商户隔离，返回的仓库只读写该商户的分账，默认为空商户
+func (repo PaySplitRepository) WithMerchantId(merchantId string) PaySplitRepository {
+	repo.merchantId = merchantId
+	return repo
+}
+
+func (repo PaySplitRepository) makeStateMachine(tableConfig sqlbuilder.TableConfig) (stateMachine *statemachine.StateMachine) {
+	fieldNameSplitId := sqlbuilder.GetFieldName(NewSplitId)
+	colIdentity := tableConfig.Columns.GetByFieldNameMust(fieldNameSplitId)
+	fieldNameState := sqlbuilder.GetFieldName(NewSplitState)
+	colState := tableConfig.Columns.GetByFieldNameMust(fieldNameState)
+	stateRepository := statemachine.NewStateRepository(
+		tableConfig,
+		statemachine.StateModelDbColumnRefer{
+			Identity: colIdentity.DbName,
+			State:    colState.DbName,
+		},
+	)
+	stateMachine = newPaySplitStateMachine(stateRepository)
+	return stateMachine
+}
+
+func newPaySplitStateMachine(stateRepository statemachine.StateRepository) *statemachine.StateMachine {
+	var actions = statemachine.TransformEvents{
+		{
+			EventName: Action_pay_split_Settleable,
+			SrcStates: []string{
+				PaySplitModel_state_pending.String(),
+				PaySplitModel_state_settleable.String(), // 支持幂等
+			},
+			DstState: PaySplitModel_state_settleable.String(),
+		},
+		{
+			EventName: Action_pay_split_Settle,
+			SrcStates: []string{
+				PaySplitModel_state_settleable.String(),
+				PaySplitModel_state_settled.String(), // 支持幂等
+			},
+			DstState: PaySplitModel_state_settled.String(),
+		},
+		{
+			EventName: Action_pay_split_Refund, // 全额退款后变更为已退款，部分退款只累加退款金额
+			SrcStates: []string{
+				PaySplitModel_state_settleable.String(),
+				PaySplitModel_state_settled.String(),
+				PaySplitModel_state_refunded.String(), // 支持幂等
+			},
+			DstState: PaySplitModel_state_refunded.String(),
+		},
+	}
+	stateMachine := statemachine.NewStateMachine(actions, stateRepository)
+	return stateMachine
+}
+
+const (
+	Action_pay_split_Settleable = "actionSettleable"
+	Action_pay_split_Settle     = "actionSettle"
+	Action_pay_split_Refund     = "actionRefund"
+)
+
+type PaySplitCreateIn struct {
+	SplitId          string `json:"splitId"`
+	MerchantId       string `json:"merchantId"`
+	PayId            string `json:"payId"`
+	OrderId          string `json:"orderId"`
+	RecipientAccount string `json:"recipientAccount"`
+	RecipientName    string `json:"recipientName"`
+	SplitRole        string `json:"splitRole"`
+	SplitRate        int    `json:"splitRate"`
+	SplitAmount      int    `json:"splitAmount"`
+}
+
+func (in PaySplitCreateIn) Fields() sqlbuilder.Fields {
+	return in.fields(nil)
+}
+
+// fields 收款人账号、名称按 cipher 加密写入，cipher 为 nil 时明文存储
+func (in PaySplitCreateIn) fields(cipher *encryption.Cipher) sqlbuilder.Fields {
+	return sqlbuilder.Fields{
+		NewSplitId(in.SplitId).SetRequired(true),
+		NewMerchantId(in.MerchantId),
+		NewPayId(in.PayId).SetRequired(true),
+		NewOrderId(in.OrderId).SetRequired(true),
+		NewRecipientAccount(in.RecipientAccount).SetRequired(true).AppendValueFn(encryptValueFn(cipher)),
+		NewRecipientName(in.RecipientName).AppendValueFn(encryptValueFn(cipher)),
+		NewRecipientAccountBlindIndex(in.RecipientAccount).AppendValueFn(blindIndexValueFn(cipher)),
+		NewSplitRole(in.SplitRole),
+		NewSplitRate(in.SplitRate),
+		NewSplitAmount(in.SplitAmount).SetRequired(true),
+		NewSplitState(PaySplitModel_state_pending.String()),
+		NewCreatedAt(time.Now().Format(time.DateTime)),
+	}
+}
+
+func (repo PaySplitRepository) Create(ins ...PaySplitCreateIn) (err error) {
+	if len(ins) == 0 {
+		return nil
+	}
+	fieldsList := make([]sqlbuilder.Fields, 0, len(ins))
+	for _, in := range ins {
+		in.MerchantId, err = resolveMerchantId(repo.merchantId, in.MerchantId)
+		if err != nil {
+			return err
+		}
+		fieldsList = append(fieldsList, in.fields(repo.cipher))
+	}
+	err = repo.repository.BatchInsert(fieldsList)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (repo PaySplitRepository) GetByPayId(payId string) (models PaySplitModels, err error) {
+	fs := merchantScope(repo.merchantId).Add(
+		NewPayId(payId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	err = repo.repository.All(&models, fs)
+	if err != nil {
+		return models, err
+	}
+	err = models.decrypt(repo.cipher)
+	if err != nil {
+		return models, err
+	}
+	return models, nil
+}
+
+// LockByPayId 在事务内(WithTxHandler)锁定支付记录的分账行，退款累加分账退款金额前调用，避免并发退款相互覆盖。
+// sqlite 不支持行锁，由 immediate 事务串行
+func (repo PaySplitRepository) LockByPayId(payId string) (models PaySplitModels, err error) {
+	table := repo.GetTable()
+	handler := table.GetHandler()
+	driver := sqlbuilder.Driver(handler.GetDialector())
+	dbNamePayId := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPayId))
+	dbNameMerchantId := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId))
+	ds := driver.GoquDialect().From(table.DBName.Name).Where(goqu.C(dbNameMerchantId).Eq(repo.merchantId), goqu.C(dbNamePayId).Eq(payId))
+	if !strings.HasPrefix(driver.String(), "sqlite") {
+		ds = ds.ForUpdate(exp.Wait)
+	}
+	sql, _, err := ds.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	err = handler.Query(context.Background(), sql, &models)
+	if err != nil {
+		return nil, err
+	}
+	err = models.decrypt(repo.cipher)
+	if err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+func (repo PaySplitRepository) GetBySplitIdMust(splitId string) (model PaySplitModel, err error) {
+	fs := merchantScope(repo.merchantId).Add(
+		NewSplitId(splitId).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	err = repo.repository.FirstMustExists(&model, fs)
+	if err != nil {
+		return model, err
+	}
+	err = model.decrypt(repo.cipher)
+	if err != nil {
+		return model, err
+	}
+	return model, nil
+}
+
+// GetByRecipientAccount 获取收款人分账记录，state 为空时返回全部状态；启用加密后通过盲索引查询，存量明文数据需先执行 RotatePaySplitKeys
+func (repo PaySplitRepository) GetByRecipientAccount(recipientAccount string, state ...string) (models PaySplitModels, err error) {
+	if recipientAccount == "" {
+		err = errors.New("recipientAccount 不能为空")
+		return nil, err
+	}
+	fs := merchantScope(repo.merchantId).Add(
+		NewRecipientAccount(recipientAccount).AppendWhereFn(sqlbuilder.ValueFnForward),
+	)
+	if repo.cipher != nil {
+		blindIndex, err := repo.cipher.BlindIndex(recipientAccount)
+		if err != nil {
+			return nil, err
+		}
+		fs = merchantScope(repo.merchantId).Add(
+			NewRecipientAccountBlindIndex(blindIndex).AppendWhereFn(sqlbuilder.ValueFnForward),
+		)
+	}
+	if len(state) > 0 {
+		fs = fs.Add(NewSplitState("").SetValue(state).AppendWhereFn(sqlbuilder.ValueFnForward))
+	}
+	err = repo.repository.All(&models, fs)
+	if err != nil {
+		return models, err
+	}
+	err = models.decrypt(repo.cipher)
+	if err != nil {
+		return models, err
+	}
+	return models, nil
+}
+
+// SetRefundAmount 更新分账累计退款金额
+func (repo PaySplitRepository) SetRefundAmount(splitId string, refundAmount int) (err error) {
+	fs := merchantScope(repo.merchantId).Add(
+		NewSplitId(splitId).AppendWhereFn(sqlbuilder.ValueFnForward),
+		NewRefundAmount(refundAmount),
+	)
+	err = repo.repository.Update(fs)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"slices"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// PayOrderTotals 收款单冗余的支付记录汇总，校验金额、判断是否支付完成时无需加载全部支付记录
+type PayOrderTotals struct {
+	PaidAmount    int `json:"paidAmount"`    // 已支付金额
+	PendingAmount int `json:"pendingAmount"` // 待支付金额
+	RecordCount   int `json:"recordCount"`   // 有效(待支付、已支付)支付记录数
+}
+
+func (t PayOrderTotals) IsZero() bool {
+	return t == PayOrderTotals{}
+}
+
+func (t PayOrderTotals) Add(delta PayOrderTotals) PayOrderTotals {
+	return PayOrderTotals{
+		PaidAmount:    t.PaidAmount + delta.PaidAmount,
+		PendingAmount: t.PendingAmount + delta.PendingAmount,
+		RecordCount:   t.RecordCount + delta.RecordCount,
+	}
+}
+
+func (t PayOrderTotals) Sub(delta PayOrderTotals) PayOrderTotals {
+	return t.Add(PayOrderTotals{PaidAmount: -delta.PaidAmount, PendingAmount: -delta.PendingAmount, RecordCount: -delta.RecordCount})
+}
+
+// StateTotals 单条支付记录在指定状态下计入汇总的部分
+func StateTotals(state string, payAmount int) (totals PayOrderTotals) {
+	switch state {
+	case PayOrderModel_state_paid.String():
+		totals.PaidAmount = payAmount
+	case PayOrderModel_state_pending.String():
+		totals.PendingAmount = payAmount
+	}
+	if slices.Contains(EffectStates, state) {
+		totals.RecordCount = 1
+	}
+	return totals
+}
+
+// TransformTotalsDelta 支付记录执行状态变更事件后汇总的增量，幂等变更(如已支付再支付)增量为零
+func TransformTotalsDelta(event string, record PayRecordModel) (delta PayOrderTotals, err error) {
+	dstState, err := transformState(payRecordTransformEvents, event, record.State)
+	if err != nil {
+		return delta, err
+	}
+	delta = StateTotals(dstState, record.PayAmount).Sub(StateTotals(record.State, record.PayAmount))
+	return delta, nil
+}
+
+// Totals 按支付记录计算汇总
+func (ms PayRecordModels) Totals() (totals PayOrderTotals) {
+	for _, m := range ms {
+		totals = totals.Add(StateTotals(m.State, m.PayAmount))
+	}
+	return totals
+}
+
+// RepairPayOrderTotals 修复任务：按支付记录重新计算收款单上的汇总，返回修复的收款单数。
+// 按主键分批执行，可重复执行，汇总一致的收款单跳过；每个收款单在单独的事务内锁定后重新计算，与支付记录变更串行
+func RepairPayOrderTotals(handler sqlbuilder.Handler, batchSize int) (repaired int, err error) {
+	if batchSize <= 0 {
+		batchSize = 500
+	}
+	orderTable := table_pay_order.WithHandler(handler)
+	dialect := sqlbuilder.Driver(handler.GetDialector()).GoquDialect()
+	dbNameId := orderTable.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))
+	orderCols := []any{
+		dbNameId,
+		orderTable.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId)),
+		orderTable.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId)),
+	}
+	var lastId int64
+	for {
+		sql, _, err := dialect.From(orderTable.DBName.Name).Select(orderCols...).
+			Where(goqu.C(dbNameId).Gt(lastId)).Order(goqu.C(dbNameId).Asc()).Limit(uint(batchSize)).ToSQL()
+		if err != nil {
+			return repaired, err
+		}
+		var orders PayOrderModels
+		err = handler.Query(context.Background(), sql, &orders)
+		if err != nil {
+			return repaired, err
+		}
+		for _, order := range orders {
+			ok, err := repairOrderTotals(handler, order.MerchantId, order.OrderId)
+			if err != nil {
+				return repaired, errors.WithMessagef(err, "订单号-%s", order.OrderId)
+			}
+			if ok {
+				repaired++
+			}
+		}
+		if len(orders) < batchSize {
+			return repaired, nil
+		}
+		lastId = orders[len(orders)-1].Id
+	}
+}
+
+// repairOrderTotals 在单独的事务内锁定收款单后读取支付记录重新计算汇总，汇总一致或收款单已删除时不更新
+func repairOrderTotals(handler sqlbuilder.Handler, merchantId string, orderId string) (repaired bool, err error) {
+	orderRepository := NewPayOrderDBRepository(handler).WithMerchantId(merchantId)
+	err = orderRepository.TransactionForMutiTable(func(tx sqlbuilder.Handler) (err error) {
+		txOrderRepository := orderRepository.WithTxHandler(tx)
+		order, exists, err := txOrderRepository.LockByOrderId(orderId)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return nil
+		}
+		recordTable := table_pay_record.WithHandler(tx)
+		sql, _, err := sqlbuilder.Driver(tx.GetDialector()).GoquDialect().From(recordTable.DBName.Name).Select(
+			recordTable.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewState)),
+			recordTable.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPayAmount)),
+		).Where(
+			goqu.C(recordTable.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId))).Eq(merchantId),
+			goqu.C(recordTable.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId))).Eq(orderId),
+		).ToSQL()
+		if err != nil {
+			return err
+		}
+		var records PayRecordModels
+		err = tx.Query(context.Background(), sql, &records)
+		if err != nil {
+			return err
+		}
+		totals := records.Totals()
+		if totals == order.Totals() {
+			return nil
+		}
+		err = txOrderRepository.SetTotals(orderId, totals)
+		if err != nil {
+			return err
+		}
+		repaired = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return repaired, nil
+}
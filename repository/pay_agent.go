@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/sqlbuilder"
+	"gopkg.in/yaml.v3"
+)
+
+const Currency_CNY = "CNY"
+
+// payAgentKeyMaxLength 支付方式标识最大长度，与按内置支付方式生成的 Fpay_agent 列宽一致
+const payAgentKeyMaxLength = 12
+
+// PayAgentConfig 支付方式配置
+type PayAgentConfig struct {
+	Key           string      `json:"key" yaml:"key"` // 支付方式标识，写入 Fpay_agent
+	Title         string      `json:"title" yaml:"title"`
+	MinAmount     int         `json:"minAmount" yaml:"minAmount"`         // 单笔最小支付金额，单位分，0 不限
+	MaxAmount     int         `json:"maxAmount" yaml:"maxAmount"`         // 单笔最大支付金额，单位分，0 不限
+	Currencies    []string    `json:"currencies" yaml:"currencies"`       // 支持的币种，为空时只支持 CNY
+	DefaultExpire int         `json:"defaultExpire" yaml:"defaultExpire"` // 创建支付记录未传过期时间时使用，单位分钟
+	NeedPayUrl    bool        `json:"needPayUrl" yaml:"needPayUrl"`       // 创建支付记录时必须传支付链接
+	Fee           FeeSchedule `json:"fee" yaml:"fee"`                     // 支付渠道手续费，支付成功时计算
+	// 抵扣类支付方式(优惠券、钱包等)记账的费用科目，为空时记入支付机构待清算资金
+	DeductionAccountCode string `json:"deductionAccountCode" yaml:"deductionAccountCode"`
+	DeductionAccountName string `json:"deductionAccountName" yaml:"deductionAccountName"` // 为空时取支付方式名称+抵扣
+}
+
+// SupportsCurrency 是否支持币种，currency 为空时视为 CNY
+func (agent PayAgentConfig) SupportsCurrency(currency string) bool {
+	if currency == "" {
+		currency = Currency_CNY
+	}
+	if len(agent.Currencies) == 0 {
+		return currency == Currency_CNY
+	}
+	return slices.Contains(agent.Currencies, currency)
+}
+
+// Validate 校验支付金额、币种及支付链接是否满足支付方式的要求
+func (agent PayAgentConfig) Validate(payAmount int, currency string, payUrl string) (err error) {
+	if agent.MinAmount > 0 && payAmount < agent.MinAmount {
+		err = errors.Errorf("%s单笔支付金额不能小于%d,收到-%d", agent.Title, agent.MinAmount, payAmount)
+		return err
+	}
+	if agent.MaxAmount > 0 && payAmount > agent.MaxAmount {
+		err = errors.Errorf("%s单笔支付金额不能大于%d,收到-%d", agent.Title, agent.MaxAmount, payAmount)
+		return err
+	}
+	if !agent.SupportsCurrency(currency) {
+		err = errors.Errorf("%s不支持币种-%s", agent.Title, currency)
+		return err
+	}
+	if agent.NeedPayUrl && payUrl == "" {
+		err = errors.Errorf("%s支付链接不能为空", agent.Title)
+		return err
+	}
+	return nil
+}
+
+// PayAgentRegistry 支付方式注册表，支付记录校验、支付方式枚举及默认过期时间均取自注册表，按注册顺序排列
+type PayAgentRegistry struct {
+	mu     sync.RWMutex
+	agents []PayAgentConfig
+}
+
+func NewPayAgentRegistry(agents ...PayAgentConfig) *PayAgentRegistry {
+	r := &PayAgentRegistry{}
+	err := r.Register(agents...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// PayAgents 默认注册表，内置微信、支付宝、优惠券，可通过 Register、LoadYAML 增加或覆盖；
+// 服务默认使用该注册表，也可通过 PayRecordService.SetPayAgents 使用单独的注册表
+var PayAgents = NewPayAgentRegistry(
+	PayAgentConfig{Key: PayingAgent_Wechat, Title: "微信"},
+	PayAgentConfig{Key: PayingAgent_Alipay, Title: "支付宝"},
+	PayAgentConfig{Key: PayingAgent_Coupon, Title: "优惠券", DeductionAccountCode: "coupon_deduction", DeductionAccountName: "优惠券抵扣"},
+)
+
+// Clone 复制注册表，修改副本不影响原注册表
+func (r *PayAgentRegistry) Clone() *PayAgentRegistry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return &PayAgentRegistry{agents: slices.Clone(r.agents)}
+}
+
+// Register 注册支付方式，已注册的同名支付方式被覆盖
+func (r *PayAgentRegistry) Register(agents ...PayAgentConfig) (err error) {
+	for _, agent := range agents {
+		if agent.Key == "" || len(agent.Key) > payAgentKeyMaxLength {
+			err = errors.Errorf("支付方式标识不能为空且长度不能超过%d:%s", payAgentKeyMaxLength, agent.Key)
+			return err
+		}
+		if agent.MaxAmount > 0 && agent.MaxAmount < agent.MinAmount {
+			err = errors.Errorf("支付方式%s的最大金额不能小于最小金额", agent.Key)
+			return err
+		}
+		err = agent.Fee.validate()
+		if err != nil {
+			err = errors.WithMessagef(err, "支付方式%s", agent.Key)
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, agent := range agents {
+		if agent.Title == "" {
+			agent.Title = agent.Key
+		}
+		i := slices.IndexFunc(r.agents, func(a PayAgentConfig) bool { return a.Key == agent.Key })
+		if i >= 0 {
+			r.agents[i] = agent
+			continue
+		}
+		r.agents = append(r.agents, agent)
+	}
+	return nil
+}
+
+// LoadYAML 从配置注册支付方式，示例:
+//
+//	payAgents:
+//	  - key: unionpay
+//	    title: 银联
+//	    maxAmount: 5000000
+//	    currencies: [CNY, HKD]
+//	    defaultExpire: 15
+//	    needPayUrl: true
+//	    fee:
+//	      rate: 60
+//	      min: 1
+//	      max: 2500
+func (r *PayAgentRegistry) LoadYAML(data []byte) (err error) {
+	var config struct {
+		PayAgents []PayAgentConfig `yaml:"payAgents"`
+	}
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		err = errors.WithMessage(err, "支付方式配置格式有误")
+		return err
+	}
+	return r.Register(config.PayAgents...)
+}
+
+func (r *PayAgentRegistry) Get(key string) (agent PayAgentConfig, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i := slices.IndexFunc(r.agents, func(a PayAgentConfig) bool { return a.Key == key })
+	if i < 0 {
+		return agent, false
+	}
+	return r.agents[i], true
+}
+
+// GetMust 未注册的支付方式返回错误，错误信息列出可用的支付方式
+func (r *PayAgentRegistry) GetMust(key string) (agent PayAgentConfig, err error) {
+	agent, ok := r.Get(key)
+	if !ok {
+		err = errors.Errorf("请传入支付方式=>%s", strings.Join(r.Keys(), ","))
+		return agent, err
+	}
+	return agent, nil
+}
+
+func (r *PayAgentRegistry) Keys() (keys []string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, agent := range r.agents {
+		keys = append(keys, agent.Key)
+	}
+	return keys
+}
+
+// Enums 支付方式枚举，供 NewPayAgent 使用
+func (r *PayAgentRegistry) Enums() (enums sqlbuilder.Enums) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, agent := range r.agents {
+		enums = append(enums, sqlbuilder.Enum{Key: agent.Key, Title: agent.Title})
+	}
+	return enums
+}
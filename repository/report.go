@@ -0,0 +1,262 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// 统计分组维度
+const (
+	SummaryGroupBy_day       = "day"
+	SummaryGroupBy_hour      = "hour"
+	SummaryGroupBy_pay_agent = "payAgent"
+	SummaryGroupBy_state     = "state"
+	SummaryGroupBy_merchant  = "merchant"
+)
+
+type SummaryIn struct {
+	MerchantId string    `json:"merchantId"` // 为空时统计全部商户
+	StartAt    time.Time `json:"startAt"`    // 按支付记录创建时间统计，包含
+	EndAt      time.Time `json:"endAt"`      // 不包含
+	GroupBy    []string  `json:"groupBy"`    // 取值见 SummaryGroupBy_xxx，day、hour 只能选一个，为空时汇总为一行
+}
+
+// SummaryRow 一个分组的统计结果，未参与分组的维度为空
+type SummaryRow struct {
+	Period        string  `gorm:"column:period" json:"period,omitempty"` // 按天 2006-01-02，按小时 2006-01-02 15:00
+	PayAgent      string  `gorm:"column:pay_agent" json:"payAgent,omitempty"`
+	State         string  `gorm:"column:state" json:"state,omitempty"`
+	MerchantId    string  `gorm:"column:merchant_id" json:"merchantId,omitempty"`
+	Count         int     `gorm:"column:record_count" json:"count"`   // 创建的支付记录数
+	Amount        int     `gorm:"column:amount" json:"amount"`        // 创建的支付金额
+	PaidCount     int     `gorm:"column:paid_count" json:"paidCount"` // 其中已支付的记录数
+	PaidAmount    int     `gorm:"column:paid_amount" json:"paidAmount"`
+	PaidFee       int     `gorm:"column:paid_fee" json:"paidFee"`              // 已支付记录的支付渠道手续费
+	AvgPaySeconds float64 `gorm:"column:avg_pay_seconds" json:"avgPaySeconds"` // 已支付记录从创建到支付的平均耗时(秒)
+}
+
+// ConversionRate 支付转化率，已支付记录数/创建的记录数
+func (row SummaryRow) ConversionRate() float64 {
+	if row.Count == 0 {
+		return 0
+	}
+	return float64(row.PaidCount) / float64(row.Count)
+}
+
+// PaidNetAmount 已支付金额扣除手续费后的实收金额
+func (row SummaryRow) PaidNetAmount() int {
+	return row.PaidAmount - row.PaidFee
+}
+
+type SummaryRows []SummaryRow
+
+// PayRecordSummary 按时间范围统计支付记录的笔数、金额、手续费、转化率及平均支付耗时，在数据库中分组聚合，不加载明细；已删除的支付记录不计入
+func PayRecordSummary(handler sqlbuilder.Handler, in SummaryIn) (rows SummaryRows, err error) {
+	if in.StartAt.IsZero() || in.EndAt.IsZero() || !in.StartAt.Before(in.EndAt) {
+		err = errors.Errorf("统计时间范围有误:%s~%s", in.StartAt.Format(time.DateTime), in.EndAt.Format(time.DateTime))
+		return nil, err
+	}
+	if slices.Contains(in.GroupBy, SummaryGroupBy_day) && slices.Contains(in.GroupBy, SummaryGroupBy_hour) {
+		err = errors.New("day、hour 只能选择一个分组")
+		return nil, err
+	}
+	driver := sqlbuilder.Driver(handler.GetDialector())
+	table := table_pay_record.WithHandler(handler)
+	col := func(fieldName string) exp.IdentifierExpression {
+		return goqu.C(table.GetDBNameByFieldNameMust(fieldName))
+	}
+	colCreatedAt, colPaidAt, colState, colPayAmount := col(sqlbuilder.GetFieldName(NewCreatedAt)), col(sqlbuilder.GetFieldName(NewPaidAt)), col(sqlbuilder.GetFieldName(NewState)), col(sqlbuilder.GetFieldName(NewPayAmount))
+
+	var groups []exp.Expression
+	var selects []any
+	for _, groupBy := range in.GroupBy {
+		var expr exp.Expression
+		var alias string
+		switch groupBy {
+		case SummaryGroupBy_day, SummaryGroupBy_hour:
+			expr, err = periodExpression(driver, colCreatedAt, groupBy)
+			if err != nil {
+				return nil, err
+			}
+			alias = "period"
+		case SummaryGroupBy_pay_agent:
+			expr, alias = col(sqlbuilder.GetFieldName(NewPayAgent)), "pay_agent"
+		case SummaryGroupBy_state:
+			expr, alias = colState, "state"
+		case SummaryGroupBy_merchant:
+			expr, alias = col(sqlbuilder.GetFieldName(NewMerchantId)), "merchant_id"
+		default:
+			err = errors.Errorf("不支持的统计分组:%s", groupBy)
+			return nil, err
+		}
+		groups = append(groups, expr)
+		selects = append(selects, goqu.L("?", expr).As(alias))
+	}
+	paySeconds, err := secondsBetween(driver, colCreatedAt, colPaidAt)
+	if err != nil {
+		return nil, err
+	}
+	isPaid := colState.Eq(PayOrderModel_state_paid.String())
+	selects = append(selects,
+		goqu.COUNT(goqu.Star()).As("record_count"),
+		goqu.COALESCE(goqu.SUM(colPayAmount), 0).As("amount"),
+		goqu.COALESCE(goqu.SUM(goqu.Case().When(isPaid, 1).Else(0)), 0).As("paid_count"),
+		goqu.COALESCE(goqu.SUM(goqu.Case().When(isPaid, colPayAmount).Else(0)), 0).As("paid_amount"),
+		goqu.COALESCE(goqu.SUM(goqu.Case().When(isPaid, col(sqlbuilder.GetFieldName(NewProviderFee))).Else(0)), 0).As("paid_fee"),
+		goqu.COALESCE(goqu.AVG(goqu.Case().When(isPaid, paySeconds)), 0).As("avg_pay_seconds"),
+	)
+
+	where := []exp.Expression{
+		colCreatedAt.Gte(in.StartAt.Format(time.DateTime)),
+		colCreatedAt.Lt(in.EndAt.Format(time.DateTime)),
+		notDeletedExpression(col(sqlbuilder.GetFieldName(NewDeletedAt))),
+	}
+	if in.MerchantId != "" {
+		where = append(where, col(sqlbuilder.GetFieldName(NewMerchantId)).Eq(in.MerchantId))
+	}
+	ds := driver.GoquDialect().From(table.DBName.Name).Select(selects...).Where(where...)
+	if len(groups) > 0 {
+		groupBy := make([]any, 0, len(groups))
+		orderBy := make([]exp.OrderedExpression, 0, len(groups))
+		for _, group := range groups {
+			groupBy = append(groupBy, group)
+			orderBy = append(orderBy, goqu.L("?", group).Asc())
+		}
+		ds = ds.GroupBy(groupBy...).Order(orderBy...)
+	}
+	sql, _, err := ds.ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	err = handler.Query(context.Background(), sql, &rows)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// PendingStat 一种支付方式的待支付记录数及金额
+type PendingStat struct {
+	PayAgent string `gorm:"column:pay_agent" json:"payAgent"`
+	Count    int    `gorm:"column:record_count" json:"count"`
+	Amount   int    `gorm:"column:amount" json:"amount"` // 待支付金额，单位分
+}
+
+type PendingStats []PendingStat
+
+// GetPendingStats 在数据库中按支付方式分组统计，供指标采集频繁调用
+func (repo PayRecordDBRepository) GetPendingStats() (stats PendingStats, err error) {
+	table := repo.repository.GetTable()
+	handler := table.GetHandler()
+	col := func(fieldName string) exp.IdentifierExpression {
+		return goqu.C(table.GetDBNameByFieldNameMust(fieldName))
+	}
+	colPayAgent := col(sqlbuilder.GetFieldName(NewPayAgent))
+	where := []exp.Expression{
+		col(sqlbuilder.GetFieldName(NewMerchantId)).Eq(repo.merchantId),
+		col(sqlbuilder.GetFieldName(NewState)).Eq(PayOrderModel_state_pending.String()),
+		notDeletedExpression(col(sqlbuilder.GetFieldName(NewDeletedAt))),
+	}
+	sql, _, err := sqlbuilder.Driver(handler.GetDialector()).GoquDialect().From(table.DBName.Name).
+		Select(
+			colPayAgent.As("pay_agent"),
+			goqu.COUNT(goqu.Star()).As("record_count"),
+			goqu.COALESCE(goqu.SUM(col(sqlbuilder.GetFieldName(NewPayAmount))), 0).As("amount"),
+		).
+		Where(where...).
+		GroupBy(colPayAgent).
+		Order(colPayAgent.Asc()).
+		ToSQL()
+	if err != nil {
+		return nil, err
+	}
+	err = handler.Query(context.Background(), sql, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (repo PayRecordDBRepository) GetUserAmountSince(userId string, since time.Time) (amount int, err error) {
+	table := repo.repository.GetTable()
+	handler := table.GetHandler()
+	col := func(fieldName string) exp.IdentifierExpression {
+		return goqu.C(table.GetDBNameByFieldNameMust(fieldName))
+	}
+	sql, _, err := sqlbuilder.Driver(handler.GetDialector()).GoquDialect().From(table.DBName.Name).
+		Select(goqu.COALESCE(goqu.SUM(col(sqlbuilder.GetFieldName(NewPayAmount))), 0).As("amount")).
+		Where(
+			col(sqlbuilder.GetFieldName(NewMerchantId)).Eq(repo.merchantId),
+			col(sqlbuilder.GetFieldName(NewUserId)).Eq(userId),
+			col(sqlbuilder.GetFieldName(NewCreatedAt)).Gte(since.Format(time.DateTime)),
+			col(sqlbuilder.GetFieldName(NewState)).In(EffectStates),
+			notDeletedExpression(col(sqlbuilder.GetFieldName(NewDeletedAt))),
+		).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		Amount int `gorm:"column:amount"`
+	}
+	err = handler.Query(context.Background(), sql, &result)
+	if err != nil {
+		return 0, err
+	}
+	return result.Amount, nil
+}
+
+// periodExpression 时间字段按天、小时截取，各数据库日期函数不同
+func periodExpression(driver sqlbuilder.Driver, col exp.IdentifierExpression, groupBy string) (expr exp.Expression, err error) {
+	hour := groupBy == SummaryGroupBy_hour
+	switch reportDriver(driver) {
+	case sqlbuilder.Driver_mysql:
+		format := "%Y-%m-%d"
+		if hour {
+			format = "%Y-%m-%d %H:00"
+		}
+		return goqu.Func("DATE_FORMAT", col, format), nil
+	case sqlbuilder.Driver_sqlite3:
+		format := "%Y-%m-%d"
+		if hour {
+			format = "%Y-%m-%d %H:00"
+		}
+		return goqu.Func("strftime", format, col), nil
+	case Driver_postgres:
+		format := "YYYY-MM-DD"
+		if hour {
+			format = "YYYY-MM-DD HH24:00"
+		}
+		return goqu.Func("to_char", col, format), nil
+	}
+	err = errors.Errorf("统计不支持的数据库驱动:%s", driver)
+	return nil, err
+}
+
+// secondsBetween 两个时间字段相差的秒数
+func secondsBetween(driver sqlbuilder.Driver, start exp.IdentifierExpression, end exp.IdentifierExpression) (expr exp.Expression, err error) {
+	switch reportDriver(driver) {
+	case sqlbuilder.Driver_mysql:
+		return goqu.L("TIMESTAMPDIFF(SECOND, ?, ?)", start, end), nil
+	case sqlbuilder.Driver_sqlite3:
+		return goqu.L("(julianday(?) - julianday(?)) * 86400", end, start), nil
+	case Driver_postgres:
+		return goqu.L("EXTRACT(EPOCH FROM (? - ?))", end, start), nil
+	}
+	err = errors.Errorf("统计不支持的数据库驱动:%s", driver)
+	return nil, err
+}
+
+// reportDriver sqlite 的不同驱动名统一为 sqlite3
+func reportDriver(driver sqlbuilder.Driver) sqlbuilder.Driver {
+	if strings.HasPrefix(driver.String(), "sqlite") {
+		return sqlbuilder.Driver_sqlite3
+	}
+	return driver
+}
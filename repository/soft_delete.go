@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/doug-martin/goqu/v9/exp"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+// ErrDeleted 收款单已软删除，恢复(Restore)前不能再创建支付记录
+var ErrDeleted = errors.New("订单已删除")
+
+// markDeleted 按商户、订单号软删除收款单或支付记录，删除原因单独存储，不覆盖备注；已删除的数据不重复更新
+func markDeleted(table sqlbuilder.TableConfig, merchantId string, orderId string, reason string) (err error) {
+	dbNameDeletedAt := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewDeletedAt))
+	record := goqu.Record{
+		dbNameDeletedAt: time.Now().Format(time.DateTime),
+		table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewDeleteReason)): reason,
+	}
+	return updateByOrderId(table, merchantId, orderId, record, notDeletedExpression(goqu.C(dbNameDeletedAt)))
+}
+
+// unmarkDeleted 恢复软删除的数据，删除时间还原为未删除时的默认值并清空删除原因
+func unmarkDeleted(table sqlbuilder.TableConfig, merchantId string, orderId string) (err error) {
+	dbNameDeletedAt := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewDeletedAt))
+	record := goqu.Record{
+		dbNameDeletedAt: zeroTime(table),
+		table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewDeleteReason)): "",
+	}
+	return updateByOrderId(table, merchantId, orderId, record, deletedExpression(goqu.C(dbNameDeletedAt)))
+}
+
+// zeroTime 时间列未设置时的默认值，postgres 不支持零值时间，使用 NULL
+func zeroTime(table sqlbuilder.TableConfig) any {
+	if sqlbuilder.Driver(table.GetHandler().GetDialector()) == Driver_postgres {
+		return nil
+	}
+	return "0000-00-00 00:00:00"
+}
+
+func updateByOrderId(table sqlbuilder.TableConfig, merchantId string, orderId string, record goqu.Record, cond exp.Expression) (err error) {
+	handler := table.GetHandler()
+	dialect := sqlbuilder.Driver(handler.GetDialector()).GoquDialect()
+	dbNameOrderId := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewOrderId))
+	dbNameMerchantId := table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId))
+	sql, _, err := dialect.Update(table.DBName.Name).Set(record).
+		Where(goqu.C(dbNameMerchantId).Eq(merchantId), goqu.C(dbNameOrderId).Eq(orderId), cond).ToSQL()
+	if err != nil {
+		return err
+	}
+	return handler.Exec(sql)
+}
@@ -0,0 +1,17 @@
+package repository
+
+import "github.com/suifengpiao14/sqlbuilder"
+
+// Tables 业务表定义，数据库迁移据此生成建表及变更语句
+func Tables() sqlbuilder.TableConfigs {
+	return sqlbuilder.TableConfigs{
+		table_pay_order,
+		table_pay_record,
+		table_pay_split,
+		table_pay_order_adjustment,
+		table_overpayment,
+		table_pay_id_sequence,
+		table_pay_order_history,
+		table_pay_record_history,
+	}
+}
@@ -0,0 +1,335 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/suifengpiao14/paymentrecord/encryption"
+	"github.com/suifengpiao14/sqlbuilder"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// span 属性
+const (
+	Attr_pay_id     = attribute.Key("pay.id")
+	Attr_order_id   = attribute.Key("order.id")
+	Attr_pay_agent  = attribute.Key("pay.agent")
+	Attr_pay_amount = attribute.Key("pay.amount")
+	Attr_event      = attribute.Key("state.event")
+	Attr_src_state  = attribute.Key("state.src")
+)
+
+// SpanEvent_transition 状态变更记录为所在 span 的事件
+const SpanEvent_transition = "stateTransition"
+
+// EndSpan 结束 span，失败时记录错误
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// AddTransitionEvent 在 ctx 所在 span(一般为服务方法 span)上记录状态变更
+func AddTransitionEvent(ctx context.Context, event string, srcState string, attrs ...attribute.KeyValue) {
+	attrs = append(attrs, Attr_event.String(event), Attr_src_state.String(srcState))
+	trace.SpanFromContext(ctx).AddEvent(SpanEvent_transition, trace.WithAttributes(attrs...))
+}
+
+type tracedPayOrderRepository struct {
+	ctx    context.Context
+	tracer trace.Tracer
+	repo   PayOrderRepository
+}
+
+// NewTracedPayOrderRepository 收款单仓库每次调用创建 span，ctx 为父 span 所在上下文
+func NewTracedPayOrderRepository(ctx context.Context, tracer trace.Tracer, repo PayOrderRepository) PayOrderRepository {
+	if traced, ok := repo.(tracedPayOrderRepository); ok { // 嵌套调用时替换父 span，避免重复创建 span
+		repo = traced.repo
+	}
+	return tracedPayOrderRepository{ctx: ctx, tracer: tracer, repo: repo}
+}
+
+func (r tracedPayOrderRepository) start(name string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := r.tracer.Start(r.ctx, "PayOrderRepository."+name, trace.WithAttributes(attrs...))
+	return span
+}
+
+func (r tracedPayOrderRepository) TransactionForMutiTable(fc func(tx sqlbuilder.Handler) (err error)) (err error) {
+	span := r.start("TransactionForMutiTable")
+	defer func() { EndSpan(span, err) }()
+	return r.repo.TransactionForMutiTable(fc)
+}
+
+func (r tracedPayOrderRepository) WithTxHandler(txHandler sqlbuilder.Handler) PayOrderRepository {
+	return NewTracedPayOrderRepository(r.ctx, r.tracer, r.repo.WithTxHandler(txHandler))
+}
+
+func (r tracedPayOrderRepository) WithMerchantId(merchantId string) PayOrderRepository {
+	return NewTracedPayOrderRepository(r.ctx, r.tracer, r.repo.WithMerchantId(merchantId))
+}
+
+func (r tracedPayOrderRepository) Set(in PayOrderSetIn) (err error) {
+	span := r.start("Set", Attr_order_id.String(in.OrderId), Attr_pay_amount.Int(in.OrderAmount))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.Set(in)
+}
+
+func (r tracedPayOrderRepository) GetByOrderId(orderId string) (model PayOrderModel, exists bool, err error) {
+	span := r.start("GetByOrderId", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetByOrderId(orderId)
+}
+
+func (r tracedPayOrderRepository) GetByOrderIdMust(orderId string) (model PayOrderModel, err error) {
+	span := r.start("GetByOrderIdMust", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetByOrderIdMust(orderId)
+}
+
+func (r tracedPayOrderRepository) LockByOrderId(orderId string) (model PayOrderModel, exists bool, err error) {
+	span := r.start("LockByOrderId", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.LockByOrderId(orderId)
+}
+
+func (r tracedPayOrderRepository) UpdateOrderAmount(orderId string, orderAmount int) (err error) {
+	span := r.start("UpdateOrderAmount", Attr_order_id.String(orderId), Attr_pay_amount.Int(orderAmount))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.UpdateOrderAmount(orderId, orderAmount)
+}
+
+func (r tracedPayOrderRepository) AddTotals(orderId string, delta PayOrderTotals) (err error) {
+	span := r.start("AddTotals", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.AddTotals(orderId, delta)
+}
+
+func (r tracedPayOrderRepository) SetTotals(orderId string, totals PayOrderTotals) (err error) {
+	span := r.start("SetTotals", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.SetTotals(orderId, totals)
+}
+
+func (r tracedPayOrderRepository) Delete(orderId string, reason string) (err error) {
+	span := r.start("Delete", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.Delete(orderId, reason)
+}
+
+func (r tracedPayOrderRepository) Restore(orderId string) (err error) {
+	span := r.start("Restore", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.Restore(orderId)
+}
+
+func (r tracedPayOrderRepository) GetHistory(orderId string) (model PayOrderModel, exists bool, err error) {
+	span := r.start("GetHistory", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetHistory(orderId)
+}
+
+func (r tracedPayOrderRepository) GetDeleted(orderId string) (model PayOrderModel, exists bool, err error) {
+	span := r.start("GetDeleted", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetDeleted(orderId)
+}
+
+func (r tracedPayOrderRepository) CanAsErr(state string, event string) (err error) {
+	return r.repo.CanAsErr(state, event)
+}
+
+func (r tracedPayOrderRepository) Transform(event string, srcState string, orderId string, extraFs ...*sqlbuilder.Field) (err error) {
+	span := r.start("Transform", Attr_order_id.String(orderId), Attr_event.String(event))
+	defer func() { EndSpan(span, err) }()
+	err = r.repo.Transform(event, srcState, orderId, extraFs...)
+	if err != nil {
+		return err
+	}
+	AddTransitionEvent(r.ctx, event, srcState, Attr_order_id.String(orderId))
+	return nil
+}
+
+func (r tracedPayOrderRepository) TransformByIdentity(event string, orderId string, extraFs ...*sqlbuilder.Field) (err error) {
+	span := r.start("TransformByIdentity", Attr_order_id.String(orderId), Attr_event.String(event))
+	defer func() { EndSpan(span, err) }()
+	err = r.repo.TransformByIdentity(event, orderId, extraFs...)
+	if err != nil {
+		return err
+	}
+	AddTransitionEvent(r.ctx, event, "", Attr_order_id.String(orderId))
+	return nil
+}
+
+type tracedPayRecordRepository struct {
+	ctx    context.Context
+	tracer trace.Tracer
+	repo   PayRecordRepository
+}
+
+// NewTracedPayRecordRepository 支付记录仓库每次调用创建 span，ctx 为父 span 所在上下文
+func NewTracedPayRecordRepository(ctx context.Context, tracer trace.Tracer, repo PayRecordRepository) PayRecordRepository {
+	if traced, ok := repo.(tracedPayRecordRepository); ok { // 嵌套调用时替换父 span，避免重复创建 span
+		repo = traced.repo
+	}
+	return tracedPayRecordRepository{ctx: ctx, tracer: tracer, repo: repo}
+}
+
+func (r tracedPayRecordRepository) start(name string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := r.tracer.Start(r.ctx, "PayRecordRepository."+name, trace.WithAttributes(attrs...))
+	return span
+}
+
+func (r tracedPayRecordRepository) WithTxHandler(txHandler sqlbuilder.Handler) PayRecordRepository {
+	return NewTracedPayRecordRepository(r.ctx, r.tracer, r.repo.WithTxHandler(txHandler))
+}
+
+func (r tracedPayRecordRepository) WithMerchantId(merchantId string) PayRecordRepository {
+	return NewTracedPayRecordRepository(r.ctx, r.tracer, r.repo.WithMerchantId(merchantId))
+}
+
+func (r tracedPayRecordRepository) WithCipher(cipher *encryption.Cipher) PayRecordRepository {
+	return NewTracedPayRecordRepository(r.ctx, r.tracer, r.repo.WithCipher(cipher))
+}
+
+func (r tracedPayRecordRepository) Create(in PayRecordCreateIn) (err error) {
+	span := r.start("Create",
+		Attr_pay_id.String(in.PayId),
+		Attr_order_id.String(in.OrderId),
+		Attr_pay_agent.String(in.PayAgent),
+		Attr_pay_amount.Int(in.PayAmount),
+	)
+	defer func() { EndSpan(span, err) }()
+	return r.repo.Create(in)
+}
+
+func (r tracedPayRecordRepository) GetByPayId(payId string) (model PayRecordModel, exists bool, err error) {
+	span := r.start("GetByPayId", Attr_pay_id.String(payId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetByPayId(payId)
+}
+
+func (r tracedPayRecordRepository) GetByPayIdMust(payId string) (model PayRecordModel, err error) {
+	span := r.start("GetByPayIdMust", Attr_pay_id.String(payId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetByPayIdMust(payId)
+}
+
+func (r tracedPayRecordRepository) GetByOrderId(orderId string) (models PayRecordModels, err error) {
+	span := r.start("GetByOrderId", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetByOrderId(orderId)
+}
+
+func (r tracedPayRecordRepository) GetHistoryByPayId(payId string) (model PayRecordModel, exists bool, err error) {
+	span := r.start("GetHistoryByPayId", Attr_pay_id.String(payId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetHistoryByPayId(payId)
+}
+
+func (r tracedPayRecordRepository) GetHistoryByOrderId(orderId string) (models PayRecordModels, err error) {
+	span := r.start("GetHistoryByOrderId", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetHistoryByOrderId(orderId)
+}
+
+func (r tracedPayRecordRepository) DeleteByOrderId(orderId string, reason string) (err error) {
+	span := r.start("DeleteByOrderId", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.DeleteByOrderId(orderId, reason)
+}
+
+func (r tracedPayRecordRepository) RestoreByOrderId(orderId string) (err error) {
+	span := r.start("RestoreByOrderId", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.RestoreByOrderId(orderId)
+}
+
+func (r tracedPayRecordRepository) GetDeletedByOrderId(orderId string) (models PayRecordModels, err error) {
+	span := r.start("GetDeletedByOrderId", Attr_order_id.String(orderId))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetDeletedByOrderId(orderId)
+}
+
+func (r tracedPayRecordRepository) GetByProviderTradeNo(providerTradeNo string) (models PayRecordModels, err error) {
+	span := r.start("GetByProviderTradeNo")
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetByProviderTradeNo(providerTradeNo)
+}
+
+func (r tracedPayRecordRepository) GetByPaymentAccount(paymentAccount string) (models PayRecordModels, err error) {
+	span := r.start("GetByPaymentAccount")
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetByPaymentAccount(paymentAccount)
+}
+
+func (r tracedPayRecordRepository) GetAllPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModels PayRecordModels, err error) {
+	span := r.start("GetAllPayRecordByConditon")
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetAllPayRecordByConditon(whereFs)
+}
+
+func (r tracedPayRecordRepository) GetFirstPayRecordByConditon(whereFs sqlbuilder.Fields) (payRecordModel PayRecordModel, err error) {
+	span := r.start("GetFirstPayRecordByConditon")
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetFirstPayRecordByConditon(whereFs)
+}
+
+func (r tracedPayRecordRepository) CountPayRecordByConditon(whereFs sqlbuilder.Fields) (count int64, err error) {
+	span := r.start("CountPayRecordByConditon")
+	defer func() { EndSpan(span, err) }()
+	return r.repo.CountPayRecordByConditon(whereFs)
+}
+
+func (r tracedPayRecordRepository) GetUserAmountSince(userId string, since time.Time) (amount int, err error) {
+	span := r.start("GetUserAmountSince")
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetUserAmountSince(userId, since)
+}
+
+func (r tracedPayRecordRepository) GetPendingStats() (stats PendingStats, err error) {
+	span := r.start("GetPendingStats")
+	defer func() { EndSpan(span, err) }()
+	return r.repo.GetPendingStats()
+}
+
+func (r tracedPayRecordRepository) UpdateOrderAmount(orderId string, orderAmount int) (err error) {
+	span := r.start("UpdateOrderAmount", Attr_order_id.String(orderId), Attr_pay_amount.Int(orderAmount))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.UpdateOrderAmount(orderId, orderAmount)
+}
+
+func (r tracedPayRecordRepository) SetRefundAmount(payId string, refundAmount int) (err error) {
+	span := r.start("SetRefundAmount", Attr_pay_id.String(payId), Attr_pay_amount.Int(refundAmount))
+	defer func() { EndSpan(span, err) }()
+	return r.repo.SetRefundAmount(payId, refundAmount)
+}
+
+func (r tracedPayRecordRepository) CanAsErr(state string, event string) (err error) {
+	return r.repo.CanAsErr(state, event)
+}
+
+func (r tracedPayRecordRepository) Transform(event string, srcState string, payId string, extraFs ...*sqlbuilder.Field) (err error) {
+	span := r.start("Transform", Attr_pay_id.String(payId), Attr_event.String(event))
+	defer func() { EndSpan(span, err) }()
+	err = r.repo.Transform(event, srcState, payId, extraFs...)
+	if err != nil {
+		return err
+	}
+	AddTransitionEvent(r.ctx, event, srcState, Attr_pay_id.String(payId))
+	return nil
+}
+
+func (r tracedPayRecordRepository) TransformByIdentity(event string, payId string, extraFs ...*sqlbuilder.Field) (err error) {
+	span := r.start("TransformByIdentity", Attr_pay_id.String(payId), Attr_event.String(event))
+	defer func() { EndSpan(span, err) }()
+	err = r.repo.TransformByIdentity(event, payId, extraFs...)
+	if err != nil {
+		return err
+	}
+	AddTransitionEvent(r.ctx, event, "", Attr_pay_id.String(payId))
+	return nil
+}
@@ -0,0 +1,54 @@
+package risk
+
+import (
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/sqlbuilder"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+Config 风控规则配置，示例:
+
+	rules:
+	  - type: userDailyAmount
+	    limit: 500000
+	  - type: ipVelocity
+	    window: 10m
+	    limit: 20
+	    action: review
+	  - type: blacklist
+	    paymentAccounts: ["6222000000000000"]
+	    clientIps: ["10.0.0.1"]
+	  - type: userPendingLimit
+	    limit: 5
+*/
+type Config struct {
+	Rules []RuleConfig `yaml:"rules"`
+}
+
+// ParseConfig 解析 YAML 规则配置
+func ParseConfig(data []byte) (rules Rules, err error) {
+	var config Config
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		err = errors.WithMessage(err, "风控规则配置格式有误")
+		return nil, err
+	}
+	for _, ruleConfig := range config.Rules {
+		rule, err := ruleConfig.Rule()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// NewEngineFromYAML 按 YAML 规则配置创建风控引擎
+func NewEngineFromYAML(handler sqlbuilder.Handler, data []byte) (engine *Engine, err error) {
+	rules, err := ParseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewEngine(handler, rules...), nil
+}
@@ -0,0 +1,89 @@
+package risk
+
+import (
+	"github.com/suifengpiao14/commonlanguage"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+func NewId(id int) *sqlbuilder.Field {
+	return commonlanguage.NewId(id).SetMaximum(sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_autoIncrement)
+}
+
+func NewMerchantId(merchantId string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(merchantId, "merchantId", "商户ID", 64)
+}
+
+const (
+	Stage_create = "create" // 创建支付记录
+	Stage_pay    = "pay"    // 支付回调
+)
+
+func NewStage(stage string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(stage, "stage", "风控环节", 15).AppendEnum(
+		sqlbuilder.Enum{
+			Key:   Stage_create,
+			Title: "创建支付",
+		},
+		sqlbuilder.Enum{
+			Key:   Stage_pay,
+			Title: "支付回调",
+		},
+	)
+}
+
+func NewPayId(payId string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(payId, "payNo", "支付流水号", 64)
+}
+
+func NewOrderId(orderId string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(orderId, "orderId", "订单号", 64)
+}
+
+func NewUserId(userId string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(userId, "userId", "用户ID", 64)
+}
+
+func NewClientIp(clientIp string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(clientIp, "clientIp", "客户端IP地址", 64)
+}
+
+func NewClientIpBlindIndex(blindIndex string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(blindIndex, "clientIpBlindIndex", "客户端IP盲索引", 64)
+}
+
+func NewPayAmount(payAmount int) *sqlbuilder.Field {
+	return sqlbuilder.NewIntField(payAmount, "paymentAmount", "支付金额，单位分", sqlbuilder.Int_maximum_bigint).SetTag(sqlbuilder.Tag_unsigned)
+}
+
+const (
+	Decision_allow  = "allow"  // 放行
+	Decision_review = "review" // 放行，待人工复核
+	Decision_deny   = "deny"   // 拒绝
+)
+
+func NewDecision(decision string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(decision, "decision", "风控决策", 15).AppendEnum(
+		sqlbuilder.Enum{
+			Key:   Decision_allow,
+			Title: "放行",
+		},
+		sqlbuilder.Enum{
+			Key:   Decision_review,
+			Title: "待复核",
+		},
+		sqlbuilder.Enum{
+			Key:   Decision_deny,
+			Title: "拒绝",
+		},
+	)
+}
+
+func NewRule(rule string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(rule, "rule", "命中规则", 64)
+}
+
+func NewReason(reason string) *sqlbuilder.Field {
+	return sqlbuilder.NewStringField(reason, "reason", "决策原因", 255)
+}
+
+var NewCreatedAt = commonlanguage.NewCreatedAt
@@ -0,0 +1,267 @@
+package risk
+
+import (
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/pkg/errors"
+	"github.com/suifengpiao14/paymentrecord/encryption"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+/*
+风控：创建支付记录、支付回调时按规则评估，决策为放行、待复核(放行)、拒绝，每次决策均记录到 risk_decision。
+用户日累计金额、待支付记录数由调用方按支付记录统计，IP 频次基于决策记录统计；支付回调时渠道已扣款，命中拒绝规则时只标记待复核
+*/
+
+var table_risk_decision = sqlbuilder.NewTableConfig("risk_decision").AddColumns(
+	sqlbuilder.NewColumn("Fid", sqlbuilder.GetField(NewId)),
+	sqlbuilder.NewColumn("Fmerchant_id", sqlbuilder.GetField(NewMerchantId)),
+	sqlbuilder.NewColumn("Fstage", sqlbuilder.GetField(NewStage)),
+	sqlbuilder.NewColumn("Fpay_id", sqlbuilder.GetField(NewPayId)),
+	sqlbuilder.NewColumn("Forder_id", sqlbuilder.GetField(NewOrderId)),
+	sqlbuilder.NewColumn("Fuser_id", sqlbuilder.GetField(NewUserId)),
+	sqlbuilder.NewColumn("Fclient_ip", sqlbuilder.GetField(NewClientIp)),
+	sqlbuilder.NewColumn("Fclient_ip_bidx", sqlbuilder.GetField(NewClientIpBlindIndex)),
+	sqlbuilder.NewColumn("Fpay_amount", sqlbuilder.GetField(NewPayAmount)),
+	sqlbuilder.NewColumn("Fdecision", sqlbuilder.GetField(NewDecision)),
+	sqlbuilder.NewColumn("Frule", sqlbuilder.GetField(NewRule)),
+	sqlbuilder.NewColumn("Freason", sqlbuilder.GetField(NewReason)),
+	sqlbuilder.NewColumn("Fcreated_at", sqlbuilder.GetField(NewCreatedAt)),
+).AddIndexs(
+	sqlbuilder.Index{
+		IsPrimary: true,
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewId))}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewUserId)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewCreatedAt)),
+			}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewClientIp)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewCreatedAt)),
+			}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewMerchantId)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewClientIpBlindIndex)),
+				table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewCreatedAt)),
+			}
+		},
+	},
+	sqlbuilder.Index{
+		ColumnNames: func(table sqlbuilder.TableConfig) (columnNames []string) {
+			return []string{table.GetDBNameByFieldNameMust(sqlbuilder.GetFieldName(NewPayId))}
+		},
+	},
+).WithComment("风控决策记录")
+
+// Tables 风控表定义，数据库迁移据此生成建表语句
+func Tables() sqlbuilder.TableConfigs {
+	return sqlbuilder.TableConfigs{table_risk_decision}
+}
+
+type DecisionModel struct {
+	Id                 int64  `gorm:"column:Fid" json:"id"`
+	MerchantId         string `gorm:"column:Fmerchant_id" json:"merchantId"`
+	Stage              string `gorm:"column:Fstage" json:"stage"`
+	PayId              string `gorm:"column:Fpay_id" json:"payId"`
+	OrderId            string `gorm:"column:Forder_id" json:"orderId"`
+	UserId             string `gorm:"column:Fuser_id" json:"userId"`
+	ClientIp           string `gorm:"column:Fclient_ip" json:"clientIp"` // 启用加密(WithCipher)时为空
+	ClientIpBlindIndex string `gorm:"column:Fclient_ip_bidx" json:"-"`   // 启用加密时写入，用于 IP 频次统计
+	PayAmount          int    `gorm:"column:Fpay_amount" json:"payAmount"`
+	Decision           string `gorm:"column:Fdecision" json:"decision"`
+	Rule               string `gorm:"column:Frule" json:"rule"`
+	Reason             string `gorm:"column:Freason" json:"reason"`
+	CreatedAt          string `gorm:"column:Fcreated_at" json:"createdAt"`
+}
+
+type DecisionModels []DecisionModel
+
+// Input 待评估的支付记录
+type Input struct {
+	Stage          string `json:"stage"` // 取值见 Stage_xxx
+	MerchantId     string `json:"merchantId"`
+	PayId          string `json:"payId"`
+	OrderId        string `json:"orderId"`
+	UserId         string `json:"userId"`
+	ClientIp       string `json:"clientIp"`
+	PaymentAccount string `json:"-"` // 只用于黑名单匹配，不记录
+	PayAmount      int    `json:"payAmount"`
+	// CountPending 用户当前的待支付记录数，由调用方按支付记录仓库提供，规则需要时才调用
+	CountPending func() (count int, err error) `json:"-"`
+	// AmountSince 用户自 since 起创建的待支付、已支付金额合计，由调用方按支付记录仓库提供，不含本次评估的金额
+	AmountSince func(since time.Time) (amount int, err error) `json:"-"`
+}
+
+// Result 评估结果，未命中规则时为放行
+type Result struct {
+	Decision string `json:"decision"`
+	Rule     string `json:"rule"`
+	Reason   string `json:"reason"`
+}
+
+func (r Result) IsDenied() bool {
+	return r.Decision == Decision_deny
+}
+
+// severity 决策的严重程度，多条规则命中时取最严重的决策
+func severity(decision string) int {
+	switch decision {
+	case Decision_deny:
+		return 2
+	case Decision_review:
+		return 1
+	}
+	return 0
+}
+
+// Engine 风控引擎，按顺序评估适用当前环节的规则
+type Engine struct {
+	rules      Rules
+	repository sqlbuilder.Repository
+	cipher     *encryption.Cipher
+}
+
+func NewEngine(handler sqlbuilder.Handler, rules ...Rule) *Engine {
+	return &Engine{
+		rules:      rules,
+		repository: sqlbuilder.NewRepository(table_risk_decision.WithHandler(handler)),
+	}
+}
+
+// WithCipher 客户端IP只记录 cipher 生成的盲索引，nil 时明文记录；同一库的引擎须使用相同的 cipher，否则 IP 频次统计不到
+func (e Engine) WithCipher(cipher *encryption.Cipher) *Engine {
+	e.cipher = cipher
+	return &e
+}
+
+// Evaluate 评估并记录决策。决策记录不参与业务事务，被拒绝的请求同样留痕
+func (e Engine) Evaluate(in Input) (result Result, err error) {
+	result, err = e.Check(in)
+	if err != nil {
+		return result, err
+	}
+	err = e.Record(in, result)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Check 评估但不记录决策，业务事务内评估时使用，事务结束后调用 Record 记录。
+// 支付回调环节渠道已扣款，命中拒绝规则时降级为待复核，由人工处理
+func (e Engine) Check(in Input) (result Result, err error) {
+	result.Decision = Decision_allow
+	for _, rule := range e.rules {
+		if !rule.AppliesTo(in.Stage) {
+			continue
+		}
+		hit, reason, err := rule.Check(e, in)
+		if err != nil {
+			return result, errors.WithMessagef(err, "风控规则-%s", rule.Name())
+		}
+		if !hit || severity(rule.Action()) <= severity(result.Decision) {
+			continue
+		}
+		result = Result{Decision: rule.Action(), Rule: rule.Name(), Reason: reason}
+	}
+	if in.Stage == Stage_pay && result.IsDenied() {
+		result.Decision = Decision_review
+	}
+	return result, nil
+}
+
+// Record 记录决策
+func (e Engine) Record(in Input, result Result) (err error) {
+	clientIp, clientIpBlindIndex, err := e.clientIp(in.ClientIp)
+	if err != nil {
+		return err
+	}
+	fs := sqlbuilder.Fields{
+		NewMerchantId(in.MerchantId),
+		NewStage(in.Stage).SetRequired(true),
+		NewPayId(in.PayId),
+		NewOrderId(in.OrderId),
+		NewUserId(in.UserId),
+		NewClientIp(clientIp),
+		NewClientIpBlindIndex(clientIpBlindIndex),
+		NewPayAmount(in.PayAmount),
+		NewDecision(result.Decision).SetRequired(true),
+		NewRule(result.Rule),
+		NewReason(result.Reason),
+		NewCreatedAt(time.Now().Format(time.DateTime)),
+	}
+	return e.repository.Insert(fs)
+}
+
+// GetByPayId 支付记录的风控决策，按评估顺序排列
+func (e Engine) GetByPayId(payId string) (models DecisionModels, err error) {
+	fs := sqlbuilder.Fields{
+		NewPayId(payId).SetRequired(true).AppendWhereFn(sqlbuilder.ValueFnForward),
+	}
+	err = e.repository.All(&models, fs)
+	if err != nil {
+		return models, err
+	}
+	return models, nil
+}
+
+// clientIp 决策记录中的客户端IP，启用加密时只记录盲索引
+func (e Engine) clientIp(clientIp string) (plaintext string, blindIndex string, err error) {
+	if e.cipher == nil {
+		return clientIp, "", nil
+	}
+	blindIndex, err = e.cipher.BlindIndex(clientIp)
+	if err != nil {
+		return "", "", err
+	}
+	return "", blindIndex, nil
+}
+
+// IpCountSince 同一 IP 自 since 起创建支付记录的请求次数，被拒绝的请求同样计入
+func (e Engine) IpCountSince(merchantId string, clientIp string, since time.Time) (count int, err error) {
+	table := e.repository.GetTable()
+	handler := table.GetHandler()
+	col := func(fieldName string) string {
+		return table.GetDBNameByFieldNameMust(fieldName)
+	}
+	plaintext, blindIndex, err := e.clientIp(clientIp)
+	if err != nil {
+		return 0, err
+	}
+	ipWhere := goqu.C(col(sqlbuilder.GetFieldName(NewClientIp))).Eq(plaintext)
+	if e.cipher != nil {
+		ipWhere = goqu.C(col(sqlbuilder.GetFieldName(NewClientIpBlindIndex))).Eq(blindIndex)
+	}
+	sql, _, err := sqlbuilder.Driver(handler.GetDialector()).GoquDialect().From(table.DBName.Name).
+		Select(goqu.COUNT(goqu.Star())).
+		Where(
+			goqu.C(col(sqlbuilder.GetFieldName(NewMerchantId))).Eq(merchantId),
+			ipWhere,
+			goqu.C(col(sqlbuilder.GetFieldName(NewCreatedAt))).Gte(since.Format(time.DateTime)),
+			goqu.C(col(sqlbuilder.GetFieldName(NewStage))).Eq(Stage_create),
+		).ToSQL()
+	if err != nil {
+		return 0, err
+	}
+	total, err := handler.Count(sql)
+	if err != nil {
+		return 0, err
+	}
+	return int(total), nil
+}
@@ -0,0 +1,196 @@
+package risk
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Stats 规则依赖的历史数据，由 Engine 按决策记录统计
+type Stats interface {
+	IpCountSince(merchantId string, clientIp string, since time.Time) (count int, err error)
+}
+
+// Rule 风控规则，命中时按 Action 决策
+type Rule interface {
+	Name() string
+	Action() string
+	AppliesTo(stage string) bool
+	Check(stats Stats, in Input) (hit bool, reason string, err error)
+}
+
+type Rules []Rule
+
+// 内置规则类型，配置文件中 type 取值
+const (
+	RuleType_user_daily_amount  = "userDailyAmount"  // 用户日累计金额上限
+	RuleType_ip_velocity        = "ipVelocity"       // IP 时间窗口内请求次数上限
+	RuleType_blacklist          = "blacklist"        // 付款人账号、IP 黑名单
+	RuleType_user_pending_limit = "userPendingLimit" // 用户待支付记录数上限
+)
+
+// ruleBase 规则的公共配置
+type ruleBase struct {
+	name   string
+	action string
+	stages []string
+}
+
+func (r ruleBase) Name() string {
+	return r.name
+}
+
+func (r ruleBase) Action() string {
+	return r.action
+}
+
+func (r ruleBase) AppliesTo(stage string) bool {
+	return slices.Contains(r.stages, stage)
+}
+
+// UserDailyAmountRule 用户当天(本地时间)累计创建的支付金额超过上限
+type UserDailyAmountRule struct {
+	ruleBase
+	Limit int // 单位分
+}
+
+func (r UserDailyAmountRule) Check(stats Stats, in Input) (hit bool, reason string, err error) {
+	if in.UserId == "" || in.AmountSince == nil {
+		return false, "", nil
+	}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	amount, err := in.AmountSince(today)
+	if err != nil {
+		return false, "", err
+	}
+	if amount+in.PayAmount <= r.Limit {
+		return false, "", nil
+	}
+	reason = fmt.Sprintf("用户当日累计金额%d超过上限%d", amount+in.PayAmount, r.Limit)
+	return true, reason, nil
+}
+
+// IpVelocityRule 同一 IP 在时间窗口内创建支付记录的次数超过上限
+type IpVelocityRule struct {
+	ruleBase
+	Window time.Duration
+	Limit  int
+}
+
+func (r IpVelocityRule) Check(stats Stats, in Input) (hit bool, reason string, err error) {
+	if in.ClientIp == "" {
+		return false, "", nil
+	}
+	count, err := stats.IpCountSince(in.MerchantId, in.ClientIp, time.Now().Add(-r.Window))
+	if err != nil {
+		return false, "", err
+	}
+	if count+1 <= r.Limit {
+		return false, "", nil
+	}
+	reason = fmt.Sprintf("同一IP在%s内请求%d次，超过上限%d", r.Window, count+1, r.Limit) // 原因明文存储，不含IP
+	return true, reason, nil
+}
+
+// BlacklistRule 付款人账号或客户端 IP 在黑名单中
+type BlacklistRule struct {
+	ruleBase
+	PaymentAccounts []string
+	ClientIps       []string
+}
+
+func (r BlacklistRule) Check(stats Stats, in Input) (hit bool, reason string, err error) {
+	if in.PaymentAccount != "" && slices.Contains(r.PaymentAccounts, in.PaymentAccount) {
+		return true, "付款人账号在黑名单中", nil
+	}
+	if in.ClientIp != "" && slices.Contains(r.ClientIps, in.ClientIp) {
+		return true, "客户端IP在黑名单中", nil
+	}
+	return false, "", nil
+}
+
+// UserPendingLimitRule 用户待支付记录数达到上限后不能继续创建
+type UserPendingLimitRule struct {
+	ruleBase
+	Limit int
+}
+
+func (r UserPendingLimitRule) Check(stats Stats, in Input) (hit bool, reason string, err error) {
+	if in.UserId == "" || in.CountPending == nil {
+		return false, "", nil
+	}
+	count, err := in.CountPending()
+	if err != nil {
+		return false, "", err
+	}
+	if count+1 <= r.Limit {
+		return false, "", nil
+	}
+	reason = fmt.Sprintf("用户待支付记录%d条，已达上限%d", count, r.Limit)
+	return true, reason, nil
+}
+
+// RuleConfig 规则配置，字段按规则类型取用
+type RuleConfig struct {
+	Type            string        `yaml:"type"`
+	Name            string        `yaml:"name"`   // 规则名称，记录在决策中，默认为规则类型
+	Action          string        `yaml:"action"` // 命中时的决策 deny、review，默认 deny
+	Stages          []string      `yaml:"stages"` // 生效环节 create、pay，黑名单默认两者，其余规则默认 create；pay 环节命中时只标记待复核
+	Limit           int           `yaml:"limit"`
+	Window          time.Duration `yaml:"window"` // 如 10m
+	PaymentAccounts []string      `yaml:"paymentAccounts"`
+	ClientIps       []string      `yaml:"clientIps"`
+}
+
+// Rule 按配置生成规则
+func (c RuleConfig) Rule() (rule Rule, err error) {
+	base := ruleBase{name: c.Name, action: c.Action, stages: c.Stages}
+	if base.name == "" {
+		base.name = c.Type
+	}
+	if base.action == "" {
+		base.action = Decision_deny
+	}
+	if base.action != Decision_deny && base.action != Decision_review {
+		err = errors.Errorf("规则%s的决策只能为 deny、review:%s", base.name, base.action)
+		return nil, err
+	}
+	if len(base.stages) == 0 {
+		base.stages = []string{Stage_create}
+		if c.Type == RuleType_blacklist {
+			base.stages = []string{Stage_create, Stage_pay}
+		}
+	}
+	for _, stage := range base.stages {
+		if stage != Stage_create && stage != Stage_pay {
+			err = errors.Errorf("规则%s的生效环节只能为 create、pay:%s", base.name, stage)
+			return nil, err
+		}
+	}
+	switch c.Type {
+	case RuleType_user_daily_amount, RuleType_user_pending_limit, RuleType_ip_velocity:
+		if c.Limit <= 0 {
+			err = errors.Errorf("规则%s的 limit 必须大于0", base.name)
+			return nil, err
+		}
+	}
+	switch c.Type {
+	case RuleType_user_daily_amount:
+		return UserDailyAmountRule{ruleBase: base, Limit: c.Limit}, nil
+	case RuleType_ip_velocity:
+		if c.Window <= 0 {
+			err = errors.Errorf("规则%s的 window 必须大于0", base.name)
+			return nil, err
+		}
+		return IpVelocityRule{ruleBase: base, Window: c.Window, Limit: c.Limit}, nil
+	case RuleType_blacklist:
+		return BlacklistRule{ruleBase: base, PaymentAccounts: c.PaymentAccounts, ClientIps: c.ClientIps}, nil
+	case RuleType_user_pending_limit:
+		return UserPendingLimitRule{ruleBase: base, Limit: c.Limit}, nil
+	}
+	err = errors.Errorf("未定义的风控规则类型:%s", c.Type)
+	return nil, err
+}
@@ -0,0 +1,67 @@
+// Package sqlite 使用 sqlite 作为收款单、支付记录存储，适用于小型部署及边缘设备内嵌
+package sqlite
+
+import (
+	"database/sql"
+	"net/url"
+	"strconv"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/suifengpiao14/paymentrecord/migration"
+	"github.com/suifengpiao14/sqlbuilder"
+)
+
+const (
+	Memory         = ":memory:"
+	BusyTimeout_ms = 5000 // 等待写锁的时间，单位毫秒
+)
+
+// DSN 生成连接串。
+// sqlite 默认事务(deferred)先读后写时，并发事务升级写锁会直接返回 database is locked，
+// TransactionForMutiTable 中先查询支付记录再变更状态正是这种场景，因此事务一律以 immediate 方式开启，开启时即获取写锁，其余事务按 busy_timeout 等待
+func DSN(file string) string {
+	params := url.Values{}
+	params.Set("_txlock", "immediate")
+	params.Set("_busy_timeout", strconv.Itoa(BusyTimeout_ms))
+	if file != Memory {
+		params.Set("_journal_mode", "WAL") // 读写互不阻塞
+	}
+	sep := "?"
+	if strings.Contains(file, "?") {
+		sep = "&"
+	}
+	return "file:" + file + sep + params.Encode()
+}
+
+// Open 打开数据库，file 为 Memory 时使用内存库
+func Open(file string) (db *sql.DB, err error) {
+	db, err = sql.Open("sqlite3", DSN(file))
+	if err != nil {
+		return nil, err
+	}
+	if file == Memory { // 内存库每个连接相互独立，只能使用单连接
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+// NewHandler 基于 gorm 的 handler，支持查询结果映射到结构体
+func NewHandler(db *sql.DB) sqlbuilder.Handler {
+	return sqlbuilder.NewGormHandler(sqlbuilder.DB2Gorm(func() *sql.DB { return db }, nil))
+}
+
+// OpenAndMigrate 打开数据库并执行建表、迁移，返回可直接用于 paymentrecord.NewPayRecordService 的 handler
+func OpenAndMigrate(file string) (db *sql.DB, handler sqlbuilder.Handler, err error) {
+	db, err = Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	handler = NewHandler(db)
+	err = migration.Migrate(handler)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, handler, nil
+}